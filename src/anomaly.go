@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/notify"
+	"github.com/charmbracelet/log"
+)
+
+const historyFile = "history.json"
+
+const (
+	// historyDays is how many days of document counts are kept
+	historyDays = 56
+	// minHistory is the fewest comparable days needed to judge a count
+	minHistory = 5
+	// minNormal is the smallest daily norm for which a quiet day (i.e. no
+	// AFEs at all) is reported; types loaded a few times a day are often
+	// quiet
+	minNormal = 10
+)
+
+// runHistory is the number of documents of each type loaded each day (UTC),
+// kept in STATE_DIR for spotting anomalies.
+type runHistory struct {
+	Days    map[string]map[string]int `json:"days"`
+	Checked string                    `json:"checked,omitempty"` // last day whose totals were checked
+}
+
+// anomalyError is returned by a sync whose document counts were anomalous,
+// with ANOMALY_ACTION=fail.
+type anomalyError struct {
+	anomalies []string
+}
+
+func (e anomalyError) Error() string {
+	return "anomalous document counts: " + strings.Join(e.anomalies, "; ")
+}
+
+// checkAnomalies adds a run's document counts to the history and reports
+// counts wildly outside the norm for their type: a run loading more than
+// ANOMALY_FACTOR times as many documents as a normal day (i.e. a forced
+// refresh nobody meant), or a finished day with that many times more or fewer
+// than normal (i.e. no AFEs on a weekday).  Weekdays are compared with
+// weekdays and weekends with weekends.
+func checkAnomalies(cfg config.Config, source string, counts map[string]int) error {
+	if cfg.AnomalyFactor <= 0 {
+		return nil
+	}
+	h := loadHistory(cfg.StateDir)
+	now := time.Now().UTC()
+	today := now.Format(time.DateOnly)
+	factor := float64(cfg.AnomalyFactor)
+
+	var anomalies []string
+	for docType, count := range counts {
+		normal, ok := h.normal(docType, today)
+		if ok && normal > 0 && float64(count) > factor*normal {
+			anomalies = append(anomalies, fmt.Sprintf("%d %s documents in one run, %.0f times a normal day (%.0f)", count, docType, float64(count)/normal, normal))
+		}
+	}
+
+	// The previous day is checked by the first run after it finishes
+	if day := h.lastDay(today); day != "" && day > h.Checked {
+		for _, docType := range h.types() {
+			normal, ok := h.normal(docType, day)
+			total := h.Days[day][docType]
+			switch {
+			case !ok:
+			case normal >= minNormal && float64(total) < normal/factor:
+				anomalies = append(anomalies, fmt.Sprintf("only %d %s documents on %s, against %.0f on a normal day", total, docType, day, normal))
+			case normal > 0 && float64(total) > factor*normal:
+				anomalies = append(anomalies, fmt.Sprintf("%d %s documents on %s, %.0f times a normal day (%.0f)", total, docType, day, float64(total)/normal, normal))
+			}
+		}
+		h.Checked = day
+	}
+
+	if h.Days[today] == nil {
+		h.Days[today] = map[string]int{}
+	}
+	for docType, count := range counts {
+		h.Days[today][docType] += count
+	}
+	h.trim(now)
+	h.save(cfg.StateDir)
+
+	if len(anomalies) == 0 {
+		return nil
+	}
+	sort.Strings(anomalies)
+	for _, anomaly := range anomalies {
+		if source != "" {
+			log.Warn("Anomalous document count", "source", source, "anomaly", anomaly)
+		} else {
+			log.Warn("Anomalous document count", "anomaly", anomaly)
+		}
+	}
+	notifyAnomalies(cfg, source, anomalies)
+	if cfg.AnomalyAction == "fail" {
+		return anomalyError{anomalies}
+	}
+	return nil
+}
+
+// notifyAnomalies sends anomalies through DIGEST_URL and/or SMTP, when
+// they're configured.
+func notifyAnomalies(cfg config.Config, source string, anomalies []string) {
+	notifier, err := newNotifier(cfg)
+	if notifier == nil || err != nil {
+		return
+	}
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("execute-sync on %s: anomalous document counts", host)
+	if source != "" {
+		subject += " from " + source
+	}
+	text := subject + "\n" + strings.Join(anomalies, "\n")
+	if err := notifier.Notify(notify.Message{Kind: notify.Anomaly, Subject: subject, Text: text, Data: map[string]interface{}{"source": source, "anomalies": anomalies}}); err != nil {
+		log.Warnf("Error sending anomaly notification: %v", err)
+	}
+}
+
+// normal returns the median number of documents of a type loaded on days
+// like day (weekdays, or weekends) before it, and whether there's enough
+// history to say.
+func (h *runHistory) normal(docType string, day string) (float64, bool) {
+	weekend := isWeekend(day)
+	var counts []int
+	for d, types := range h.Days {
+		if d < day && isWeekend(d) == weekend {
+			counts = append(counts, types[docType])
+		}
+	}
+	if len(counts) < minHistory {
+		return 0, false
+	}
+	sort.Ints(counts)
+	middle := len(counts) / 2
+	if len(counts)%2 == 0 {
+		return float64(counts[middle-1]+counts[middle]) / 2, true
+	}
+	return float64(counts[middle]), true
+}
+
+// lastDay returns the most recent day before today with history.
+func (h *runHistory) lastDay(today string) string {
+	last := ""
+	for day := range h.Days {
+		if day < today && day > last {
+			last = day
+		}
+	}
+	return last
+}
+
+// types returns every document type in the history.
+func (h *runHistory) types() []string {
+	seen := map[string]bool{}
+	for _, types := range h.Days {
+		for docType := range types {
+			seen[docType] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// trim forgets days older than historyDays.
+func (h *runHistory) trim(now time.Time) {
+	cutoff := now.AddDate(0, 0, -historyDays).Format(time.DateOnly)
+	for day := range h.Days {
+		if day < cutoff {
+			delete(h.Days, day)
+		}
+	}
+}
+
+func isWeekend(day string) bool {
+	date, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return false
+	}
+	return date.Weekday() == time.Saturday || date.Weekday() == time.Sunday
+}
+
+func loadHistory(basePath string) *runHistory {
+	h := &runHistory{}
+	if data, err := os.ReadFile(filepath.Join(basePath, historyFile)); err == nil {
+		if err := json.Unmarshal(data, h); err != nil {
+			log.Warnf("Ignoring unreadable run history: %v", err)
+		}
+	}
+	if h.Days == nil {
+		h.Days = map[string]map[string]int{}
+	}
+	return h
+}
+
+func (h *runHistory) save(basePath string) {
+	data, _ := json.MarshalIndent(h, "", "  ")
+	if err := os.WriteFile(filepath.Join(basePath, historyFile), data, 0644); err != nil {
+		log.Warnf("Error saving run history: %v", err)
+	}
+}
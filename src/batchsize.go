@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/charmbracelet/log"
+)
+
+// probeDocuments is how many documents are fetched while the average document
+// size is still unknown, when batches are sized by BATCH_SIZE.
+const probeDocuments = 500
+
+// recoverAfter is how many fetches must succeed before a limit reduced after a
+// timeout is doubled again.
+const recoverAfter = 5
+
+// batchSizer picks the number of documents to request from Execute.  With
+// BATCH_SIZE set, it aims each batch at that many MB based on the size of the
+// documents seen so far, with MAX_DOCUMENTS as the ceiling; otherwise it
+// always requests MAX_DOCUMENTS.  Fetches which time out are retried with
+// half as many documents, working back up once they succeed.
+type batchSizer struct {
+	target  int64   // bytes per batch, 0 to size by MAX_DOCUMENTS alone
+	max     int     // MAX_DOCUMENTS
+	average float64 // bytes per document, 0 until a batch has been seen
+	ceiling int     // reduced limit after a timeout, 0 when there's none
+	streak  int     // successful fetches since the limit was last changed
+}
+
+func newBatchSizer(cfg config.Config) *batchSizer {
+	return &batchSizer{
+		target: int64(cfg.BatchSize) * 1024 * 1024,
+		max:    cfg.MaxDocuments,
+	}
+}
+
+// Limit returns the number of documents to request next.
+func (b *batchSizer) Limit() int {
+	limit := b.sized()
+	if b.ceiling > 0 {
+		limit = min(limit, b.ceiling)
+	}
+	return limit
+}
+
+// sized returns the limit before any reduction after timeouts.
+func (b *batchSizer) sized() int {
+	if b.target <= 0 {
+		return b.max
+	}
+	if b.average == 0 {
+		return min(probeDocuments, b.max)
+	}
+	return max(1, min(int(float64(b.target)/b.average), b.max))
+}
+
+// Backoff halves the limit after a fetch timed out.  It returns false if the
+// limit is already a single document, so there's nothing left to try.
+func (b *batchSizer) Backoff() bool {
+	limit := b.Limit()
+	if limit <= 1 {
+		return false
+	}
+	b.ceiling = limit / 2
+	b.streak = 0
+	return true
+}
+
+// Recover notes a successful fetch.  A limit reduced by Backoff is doubled
+// after every few successes, until it's back to normal.
+func (b *batchSizer) Recover() {
+	if b.ceiling == 0 {
+		return
+	}
+	if b.streak++; b.streak < recoverAfter {
+		return
+	}
+	b.streak = 0
+	b.ceiling *= 2
+	if b.ceiling >= b.sized() {
+		b.ceiling = 0
+	}
+	log.Debug("Increasing batch size after timeout", "next_limit", b.Limit())
+}
+
+// Observe records the documents and bytes of a batch just fetched.  Recent
+// batches weigh more than older ones, so the limit follows changes in the mix
+// of document types.
+func (b *batchSizer) Observe(docs int, bytes int64) {
+	if b.target <= 0 || docs == 0 {
+		return
+	}
+	average := float64(bytes) / float64(docs)
+	if b.average == 0 {
+		b.average = average
+	} else {
+		b.average = (b.average + average) / 2
+	}
+	log.Debug("Sizing batches", "average_bytes", int64(b.average), "next_limit", b.Limit())
+}
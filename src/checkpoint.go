@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Phases of a sync iteration recorded in the checkpoint.
+const (
+	phaseFetching  = "fetching"  // nothing has been loaded yet
+	phaseUploading = "uploading" // the batch may be partially loaded
+	phaseSaved     = "saved"     // loaded, and the highwater mark stored
+)
+
+// checkpoint records how far a sync run has got, so that a run which crashed
+// or was killed can be recognised and recovered from by the next one.  It's
+// removed when the run finishes.
+type checkpoint struct {
+	RunID     string    `json:"run_id"`
+	PID       int       `json:"pid"`
+	BatchDate string    `json:"batch_date,omitempty"`
+	Iteration int       `json:"iteration"`
+	Phase     string    `json:"phase"`
+	Before    string    `json:"before"`          // highwater mark the iteration started from
+	After     string    `json:"after,omitempty"` // highwater mark once it's loaded
+	Spool     []string  `json:"spool,omitempty"` // downloaded batches (SPOOL_FETCH)
+	Updated   time.Time `json:"updated"`
+}
+
+const checkpointFile = "checkpoint.json"
+
+// save writes the checkpoint atomically, so that a crash leaves either the
+// previous phase or the new one behind.
+func (c *checkpoint) save(basePath string, phase string) {
+	c.Phase = phase
+	c.Updated = time.Now().UTC()
+	data, _ := json.MarshalIndent(c, "", "  ")
+	path := filepath.Join(basePath, checkpointFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		log.Warnf("Error saving checkpoint: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Warnf("Error saving checkpoint: %v", err)
+	}
+}
+
+func clearCheckpoint(basePath string) {
+	if err := os.Remove(filepath.Join(basePath, checkpointFile)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Error removing checkpoint: %v", err)
+	}
+}
+
+func loadCheckpoint(basePath string) *checkpoint {
+	data, err := os.ReadFile(filepath.Join(basePath, checkpointFile))
+	if err != nil {
+		return nil
+	}
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Warnf("Ignoring unreadable checkpoint: %v", err)
+		return nil
+	}
+	return &c
+}
+
+// recoverCheckpoint looks for runs which didn't finish, in the workspaces
+// under STATE_DIR/runs (or STATE_DIR itself, where earlier releases kept the
+// checkpoint), and explains what happens to them.  The highwater mark is only stored once a batch has loaded,
+// so the interrupted batch is always fetched and loaded again (from the
+// downloaded copy, with SPOOL_FETCH); what's left to decide is whether the
+// warehouse holds part of it.
+func recoverCheckpoint(stateDir string, lastSyncDate string) {
+	dirs := []string{stateDir}
+	if matches, err := filepath.Glob(filepath.Join(stateDir, runsDir, "*", checkpointFile)); err == nil {
+		for _, match := range matches {
+			dirs = append(dirs, filepath.Dir(match))
+		}
+	}
+	for _, dir := range dirs {
+		recoverRun(dir, lastSyncDate)
+	}
+}
+
+func recoverRun(basePath string, lastSyncDate string) {
+	c := loadCheckpoint(basePath)
+	if c == nil {
+		return
+	}
+	defer clearCheckpoint(basePath)
+	defer markInterrupted(basePath)
+
+	attrs := []interface{}{"run", c.RunID, "batch", c.BatchDate, "iteration", c.Iteration, "stopped", c.Updated.Format(time.RFC3339)}
+	switch {
+	case c.Phase == phaseSaved || (c.After != "" && c.After == lastSyncDate):
+		log.Info("Previous run stopped after loading its last batch, nothing to recover", attrs...)
+	case c.Phase == phaseFetching:
+		log.Info("Previous run stopped while fetching, nothing was loaded; resuming", attrs...)
+	case c.Phase == phaseUploading:
+		log.Warn("Previous run stopped while loading; its batch may be partially loaded and will be loaded again.  Reconcile will report the partial batch, and prune removes its rows once they're superseded", attrs...)
+		if len(c.Spool) > 0 {
+			log.Info("Resuming from the downloaded batch", "files", c.Spool)
+		}
+	default:
+		log.Warn("Ignoring checkpoint in an unknown phase", append(attrs, "phase", c.Phase)...)
+	}
+}
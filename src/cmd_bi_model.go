@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/bimodel"
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func BIModelCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "bi-model",
+		Usage:       "Generate a Power BI or Tableau model of the helper views",
+		Description: "Write a Power BI semantic model (TMDL) or Tableau data sources (.tds) reading the helper views, with their columns typed and the views related through their DOCUMENT_IDs",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "powerbi or tableau",
+				Value: "powerbi",
+			},
+			&cli.StringFlag{
+				Name:  "dir",
+				Usage: "Directory to write the model to",
+				Value: "bi",
+			},
+			&cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "Connection setting for the model, as NAME=VALUE (i.e. Server=xy12345.snowflakecomputing.com)",
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			cfg := config.ResolveConfig(cCtx, config.NeedsExecute)
+
+			params := map[string]string{}
+			for _, setting := range cCtx.StringSlice("set") {
+				name, value, ok := strings.Cut(setting, "=")
+				if !ok {
+					return fmt.Errorf("--set must be NAME=VALUE: %q", setting)
+				}
+				params[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+
+			views, err := execute.FetchSchema(cfg)
+			if err != nil {
+				return err
+			}
+			model, err := bimodel.New(cfg.DatabaseType, sqlgen.NewOptions(cfg), views, params)
+			if err != nil {
+				return err
+			}
+
+			dir := cCtx.String("dir")
+			switch strings.ToLower(cCtx.String("format")) {
+			case "powerbi":
+				err = model.WritePowerBI(dir)
+			case "tableau":
+				err = model.WriteTableau(dir)
+			default:
+				return fmt.Errorf("--format must be powerbi or tableau")
+			}
+			if err != nil {
+				return err
+			}
+			log.Info("BI Model Written!", "dir", dir, "views", len(model.Views), "relationships", len(model.Relationships))
+			return nil
+		},
+	}
+}
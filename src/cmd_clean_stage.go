@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func CleanStageCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "clean-stage",
+		Usage:       "Remove old staged files",
+		Description: "Remove files left in the warehouse's load stage (Snowflake) without pruning the documents table",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "older-than",
+				Usage: "Only remove files staged longer ago than this",
+				Value: 7 * 24 * time.Hour,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				cleaner, ok := db.(warehouses.StageCleaner)
+				if !ok {
+					return fmt.Errorf("%s targets don't use a stage", cfg.DatabaseType)
+				}
+
+				removed, err := cleaner.CleanStage(cCtx.Duration("older-than"))
+				if err != nil {
+					return err
+				}
+
+				log.Info("Stage Cleaned!", "files", removed)
+				return nil
+			})
+		},
+	}
+}
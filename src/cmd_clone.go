@@ -20,7 +20,7 @@ func CloneCommand() *cli.Command {
 				if err != nil {
 					return err
 				}
-				err = db.CreateViews(views)
+				err = createViews(cfg, db, views, true)
 				if err != nil {
 					return err
 				}
@@ -34,6 +34,11 @@ func CloneCommand() *cli.Command {
 				}
 				log.Info("Sync Completed")
 
+				// The schema may have changed during a long sync
+				if err = refreshViews(cfg, db); err != nil {
+					return err
+				}
+
 				return nil
 			})
 		},
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func CompareCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "compare",
+		Usage:       "Compare the documents loaded into two warehouses",
+		Description: "Compare the configured warehouse with another fed from the same Execute instance (i.e. Snowflake and a local SQLite copy): documents and versions per document type, and which documents either is missing or holds an older version of.  Fails if they differ",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "other-type", Usage: "DATABASE_TYPE of the warehouse to compare with", Required: true},
+			&cli.StringFlag{Name: "other-dsn", Usage: "DATABASE_DSN of the warehouse to compare with"},
+			&cli.StringFlag{Name: "other-schema", Usage: "DATABASE_SCHEMA of the warehouse to compare with"},
+			&cli.BoolFlag{Name: "counts", Usage: "Only compare counts, rather than every document's latest version"},
+			&cli.IntFlag{Name: "show", Value: 5, Usage: "Log up to this many differing document IDs per type"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			// Comparing only reads, so don't create anything in either warehouse
+			readonly.Enabled = true
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				otherCfg := cfg
+				otherCfg.DatabaseType = strings.ToUpper(cCtx.String("other-type"))
+				otherCfg.DatabaseDSN = cCtx.String("other-dsn")
+				otherCfg.DatabaseSchema = cCtx.String("other-schema")
+				if (otherCfg.DatabaseType == "SQLITE" || otherCfg.DatabaseType == "GOSQLITE") && otherCfg.DatabaseDSN == "" {
+					otherCfg.DatabaseDSN = filepath.Join(cfg.StateDir, "execute.sqlite")
+				}
+				other, err := openDatabase(otherCfg, cCtx.Command.Name)
+				if err != nil {
+					return fmt.Errorf("failed to initialize %s: %v", otherCfg.DatabaseType, err)
+				}
+
+				log.Info("Comparing warehouses", "here", cfg.DatabaseType, "other", otherCfg.DatabaseType)
+				diffs, err := compare(db, other, !cCtx.Bool("counts"))
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+				fmt.Fprintf(w, "TYPE\tDOCUMENTS\tOTHER DOCUMENTS\tVERSIONS\tOTHER VERSIONS\tMISSING\tOTHER MISSING\tOLDER\tOTHER OLDER\t\n")
+				diverged := 0
+				for _, d := range diffs {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", d.Type,
+						d.Here.Documents, d.Other.Documents, d.Here.Versions, d.Other.Versions,
+						len(d.Missing), len(d.OtherMissing), len(d.Older), len(d.OtherOlder))
+					if d.Diverged() {
+						diverged++
+					}
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+
+				for _, d := range diffs {
+					for _, ids := range []struct {
+						problem string
+						ids     []string
+					}{
+						{"Missing here", d.Missing},
+						{"Missing from the other", d.OtherMissing},
+						{"Older here", d.Older},
+						{"Older in the other", d.OtherOlder},
+					} {
+						if len(ids.ids) > 0 {
+							log.Warn(ids.problem, "type", d.Type, "documents", len(ids.ids), "ids", strings.Join(ids.ids[:min(len(ids.ids), cCtx.Int("show"))], ","))
+						}
+					}
+				}
+
+				if diverged > 0 {
+					return fmt.Errorf("warehouses differ in %d of %d document types", diverged, len(diffs))
+				}
+				log.Info("Warehouses match", "types", len(diffs))
+				return nil
+			})
+		},
+	}
+}
+
+// typeDiff is how two warehouses differ for one document type.
+type typeDiff struct {
+	Type  string
+	Here  documents.TypeStats
+	Other documents.TypeStats
+	// Document IDs missing from (or with an older latest version in) each
+	// warehouse; only known when comparing versions
+	Missing, OtherMissing []string
+	Older, OtherOlder     []string
+}
+
+// Diverged reports whether the warehouses differ.
+func (d typeDiff) Diverged() bool {
+	return d.Here.Documents != d.Other.Documents || d.Here.Versions != d.Other.Versions ||
+		len(d.Missing) > 0 || len(d.OtherMissing) > 0 || len(d.Older) > 0 || len(d.OtherOlder) > 0
+}
+
+// compare reports on every document type in either warehouse.  With versions
+// it also compares the latest version of every document, which means reading
+// every document ID from both.
+func compare(here warehouses.Database, other warehouses.Database, versions bool) ([]typeDiff, error) {
+	hereStats, err := reportByType(here)
+	if err != nil {
+		return nil, err
+	}
+	otherStats, err := reportByType(other)
+	if err != nil {
+		return nil, err
+	}
+
+	var types []string
+	for docType := range hereStats {
+		types = append(types, docType)
+	}
+	for docType := range otherStats {
+		if _, ok := hereStats[docType]; !ok {
+			types = append(types, docType)
+		}
+	}
+	sort.Strings(types)
+
+	var hereLister, otherLister warehouses.VersionLister
+	if versions {
+		var ok bool
+		if hereLister, ok = here.(warehouses.VersionLister); !ok {
+			return nil, fmt.Errorf("%T can't list its documents, compare --counts instead", here)
+		}
+		if otherLister, ok = other.(warehouses.VersionLister); !ok {
+			return nil, fmt.Errorf("%T can't list its documents, compare --counts instead", other)
+		}
+	}
+
+	var diffs []typeDiff
+	for _, docType := range types {
+		d := typeDiff{Type: docType, Here: hereStats[docType], Other: otherStats[docType]}
+		if versions {
+			hereVersions, err := hereLister.LatestVersions(docType)
+			if err != nil {
+				return nil, err
+			}
+			otherVersions, err := otherLister.LatestVersions(docType)
+			if err != nil {
+				return nil, err
+			}
+			d.Missing, d.Older = behind(hereVersions, otherVersions)
+			d.OtherMissing, d.OtherOlder = behind(otherVersions, hereVersions)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// reportByType returns a warehouse's Report by document type.
+func reportByType(db warehouses.Database) (map[string]documents.TypeStats, error) {
+	reporter, ok := db.(warehouses.Reporter)
+	if !ok {
+		return nil, fmt.Errorf("%T can't be reported on", db)
+	}
+	stats, err := reporter.Report()
+	if err != nil {
+		return nil, err
+	}
+	byType := map[string]documents.TypeStats{}
+	for _, s := range stats {
+		byType[s.Type] = s
+	}
+	return byType, nil
+}
+
+// behind returns the documents in other which versions is missing, and those
+// whose latest version in versions is older, in order.
+func behind(versions map[string]int64, other map[string]int64) (missing []string, older []string) {
+	for id, version := range other {
+		if latest, ok := versions[id]; !ok {
+			missing = append(missing, id)
+		} else if latest < version {
+			older = append(older, id)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(older)
+	return missing, older
+}
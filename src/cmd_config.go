@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"reflect"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/afenav/execute-sync/src/internal/config"
 	"github.com/charmbracelet/log"
@@ -18,21 +21,134 @@ func ConfigCommand() *cli.Command {
 		Action: func(cCtx *cli.Context) error {
 			cfg := config.ResolveConfig(cCtx)
 			fmt.Printf("======== Configuration ========\n")
-			cfgVal := reflect.ValueOf(cfg)
-			cfgType := cfgVal.Type()
-			for i := 0; i < cfgVal.NumField(); i++ {
-				field := cfgType.Field(i)
-				name := field.Name
-				value := cfgVal.Field(i).Interface()
+			for _, s := range config.Settings(cfg) {
 				// Mask secrets
-				if name == "ExecuteKeySecret" || name == "DatabaseDSN" {
-					value = "***REDACTED***"
-				}
-				fmt.Printf("%-18s: %v\n", name, value)
+				fmt.Printf("%-18s: %v\n", s.Name, s.Masked())
 			}
 			// Show runtime log info
 			fmt.Printf("%-18s: %s\n", "Log Level (min)", log.GetLevel().String())
 			return nil
 		},
+		Subcommands: []*cli.Command{
+			{
+				Name:        "export",
+				Usage:       "Export the effective configuration",
+				Description: "Write the effective configuration as a .env file, YAML, JSON or a Kubernetes ConfigMap and Secret, i.e. to promote it to another environment",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format: env, yaml, json or k8s",
+						Value: "env",
+					},
+					&cli.BoolFlag{
+						Name:  "show-secrets",
+						Usage: "Include secrets instead of masking them",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Name of the Kubernetes ConfigMap and Secret",
+						Value: "execute-sync",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					settings := config.Settings(config.ResolveConfig(cCtx))
+					if !cCtx.Bool("show-secrets") {
+						for i := range settings {
+							settings[i].Value = settings[i].Masked()
+						}
+					}
+
+					switch strings.ToLower(cCtx.String("format")) {
+					case "env":
+						return exportEnv(os.Stdout, settings)
+					case "yaml":
+						return exportYAML(os.Stdout, settings, "")
+					case "json":
+						return exportJSON(os.Stdout, settings)
+					case "k8s", "kubernetes":
+						return exportKubernetes(os.Stdout, settings, cCtx.String("name"))
+					}
+					return fmt.Errorf("unknown format %q: expected env, yaml, json or k8s", cCtx.String("format"))
+				},
+			},
+			{
+				Name:        "sample",
+				Usage:       "Print a commented configuration template",
+				Description: "Print a .env template documenting every configuration parameter, with required parameters left to fill in and the rest commented out at their defaults",
+				Action: func(cCtx *cli.Context) error {
+					for _, s := range config.Settings(config.Config{}) {
+						fmt.Printf("# %s", s.Usage)
+						if s.Required {
+							fmt.Printf(" (required)\n%s=\n\n", s.Env)
+							continue
+						}
+						fmt.Printf("\n# %s=%s\n\n", s.Env, s.Default)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// exportEnv writes settings as a .env file, quoting values where needed.
+func exportEnv(w io.Writer, settings []config.Setting) error {
+	for _, s := range settings {
+		value := fmt.Sprint(s.Value)
+		if strings.ContainsAny(value, " \t#\"'$=\\") {
+			value = quoteValue(value)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", s.Env, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportYAML writes settings as a YAML mapping of strings, indented by indent.
+func exportYAML(w io.Writer, settings []config.Setting, indent string) error {
+	for _, s := range settings {
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, s.Env, quoteValue(fmt.Sprint(s.Value))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportJSON writes settings as a JSON object, keeping numbers and booleans.
+func exportJSON(w io.Writer, settings []config.Setting) error {
+	values := map[string]interface{}{}
+	for _, s := range settings {
+		values[s.Env] = s.Value
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
+
+// exportKubernetes writes settings as a ConfigMap, with the secrets split out
+// into a Secret of the same name.
+func exportKubernetes(w io.Writer, settings []config.Setting, name string) error {
+	var plain, secrets []config.Setting
+	for _, s := range settings {
+		if s.Secret {
+			secrets = append(secrets, s)
+		} else {
+			plain = append(plain, s)
+		}
 	}
+
+	fmt.Fprintf(w, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\ndata:\n", name)
+	if err := exportYAML(w, plain, "  "); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\ntype: Opaque\nstringData:\n", name)
+	return exportYAML(w, secrets, "  ")
+}
+
+// quoteValue double quotes a value, which is valid in both .env files and
+// YAML.
+func quoteValue(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
 }
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/afenav/execute-sync/src/internal/config"
 	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/failure"
 	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v2"
 )
 
@@ -11,15 +19,113 @@ func CreateViewsCommand() *cli.Command {
 	return &cli.Command{
 		Name:        "create_views",
 		Usage:       "Create helper views",
-		Description: "Create helper views which make querying data much easier",
+		Description: "Create helper views which make querying data much easier.  Only document types whose schema changed since the views were last created are rebuilt, unless --all is given.  Every view is then read from to check it works, and the command fails if any view is broken",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "all", Usage: "Rebuild the views of every document type"},
+		},
 		Action: func(cCtx *cli.Context) error {
 			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
 				views, err := execute.FetchSchema(cfg)
 				if err != nil {
 					return err
 				}
-				return db.CreateViews(views)
+				if err := createViews(cfg, db, views, cCtx.Bool("all")); err != nil {
+					return err
+				}
+				return validateViews(db, views)
 			})
 		},
 	}
 }
+
+// createViews creates the helper views for the document types whose schema
+// has changed since views were last created (or all of them), then records
+// the schema they were built from.
+func createViews(cfg config.Config, db warehouses.Database, views execute.RootSchema, all bool) error {
+	fingerprints := execute.Fingerprints(views)
+	built := loadViewFingerprints(cfg.StateDir)
+
+	changed := views
+	if !all && built != nil {
+		changed = execute.RootSchema{}
+		var names []string
+		for docType, fingerprint := range fingerprints {
+			if built[docType] != fingerprint {
+				changed[docType] = views[docType]
+				names = append(names, docType)
+			}
+		}
+		sort.Strings(names)
+		log.Info("Rebuilding views for changed document types", "changed", len(changed), "unchanged", len(views)-len(changed), "types", names)
+	}
+
+	if err := db.CreateViews(changed); err != nil {
+		return err
+	}
+	if creator, ok := db.(warehouses.RelationshipCreator); ok {
+		if err := creator.CreateRelationships(views); err != nil {
+			return err
+		}
+	}
+	saveViewFingerprints(cfg.StateDir, fingerprints)
+	return nil
+}
+
+// validateViews reads a row from every view, so that views broken by a bad
+// cast or JSON path are reported now, and fail the command, rather than
+// being found by whoever next queries them.
+func validateViews(db warehouses.Database, views execute.RootSchema) error {
+	validator, ok := db.(warehouses.ViewValidator)
+	if !ok {
+		return nil
+	}
+	broken, err := validator.ValidateViews(views)
+	if err != nil {
+		return failure.Wrap(failure.WarehouseLoad, err)
+	}
+	names := make([]string, 0, len(broken))
+	for name, err := range broken {
+		log.Error("View is broken", "view", name, "error", err)
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return failure.Errorf(failure.WarehouseLoad, "%d broken views: %s", len(names), strings.Join(names, ", "))
+	}
+	log.Info("Views validated")
+	return nil
+}
+
+// refreshViews fetches the schema again and rebuilds the views of the
+// document types whose schema changed while a full sync was loading them.
+// Deployments which have never created views are left without them.
+func refreshViews(cfg config.Config, db warehouses.Database) error {
+	if loadViewFingerprints(cfg.StateDir) == nil {
+		return nil
+	}
+	views, err := execute.FetchSchema(cfg)
+	if err != nil {
+		return err
+	}
+	return createViews(cfg, db, views, false)
+}
+
+func loadViewFingerprints(basePath string) map[string]string {
+	data, err := os.ReadFile(filepath.Join(basePath, "view_fingerprints.json"))
+	if err != nil {
+		return nil
+	}
+	var fingerprints map[string]string
+	if err := json.Unmarshal(data, &fingerprints); err != nil {
+		log.Warnf("Ignoring unreadable view fingerprints: %v", err)
+		return nil
+	}
+	return fingerprints
+}
+
+func saveViewFingerprints(basePath string, fingerprints map[string]string) {
+	data, _ := json.MarshalIndent(fingerprints, "", "  ")
+	if err := os.WriteFile(filepath.Join(basePath, "view_fingerprints.json"), data, 0644); err != nil {
+		log.Warnf("Error saving view fingerprints: %v", err)
+	}
+}
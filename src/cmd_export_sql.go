@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func ExportSQLCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "export-sql",
+		Usage:       "Export object definitions",
+		Description: "Write the definitions of the documents table and views in the warehouse to a directory of .sql files (one per object), i.e. for tracking in source control",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "dir",
+				Usage: "Directory to write the .sql files to",
+				Value: "sql",
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				exporter, ok := db.(warehouses.Exporter)
+				if !ok {
+					return fmt.Errorf("%s targets can't export their definitions", cfg.DatabaseType)
+				}
+
+				views, err := execute.FetchSchema(cfg)
+				if err != nil {
+					return err
+				}
+				definitions, err := exporter.Definitions(views)
+				if err != nil {
+					return err
+				}
+
+				dir := cCtx.String("dir")
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return fmt.Errorf("error creating %s: %v", dir, err)
+				}
+
+				// Remove the files of objects which no longer exist, so that
+				// drops show up in diffs too
+				existing, _ := filepath.Glob(filepath.Join(dir, "*.sql"))
+				for _, path := range existing {
+					if _, ok := definitions[strings.TrimSuffix(filepath.Base(path), ".sql")]; !ok {
+						if err := os.Remove(path); err != nil {
+							return err
+						}
+					}
+				}
+
+				for name, definition := range definitions {
+					if err := os.WriteFile(filepath.Join(dir, name+".sql"), []byte(definition+"\n"), 0644); err != nil {
+						return err
+					}
+				}
+
+				log.Info("Definitions Exported!", "objects", len(definitions), "dir", dir)
+				return nil
+			})
+		},
+	}
+}
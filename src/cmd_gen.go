@@ -7,7 +7,10 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"strings"
 
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v2"
 )
@@ -17,6 +20,9 @@ func GenCommand() *cli.Command {
 		Name:        "gen",
 		Usage:       "Snowflake Generate Keypair",
 		Description: "Generate RSA Keypair for Snowflake's JWT Auth",
+		Subcommands: []*cli.Command{
+			GenBootstrapSQLCommand(),
+		},
 		Action: func(cCtx *cli.Context) error {
 			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 			if err != nil {
@@ -43,7 +49,7 @@ func GenCommand() *cli.Command {
 			})
 
 			fmt.Println("============================================================")
-			fmt.Println("Snowflake Key in PEM format:")
+			fmt.Println("Snowflake Key in PEM format, to save as EXECUTESYNC_SNOWFLAKE_PRIVATE_KEY_PATH:")
 			fmt.Println("============================================================")
 			fmt.Println(string(privatePEM))
 			fmt.Println()
@@ -64,3 +70,28 @@ func GenCommand() *cli.Command {
 		},
 	}
 }
+
+func GenBootstrapSQLCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "bootstrap-sql",
+		Usage:       "Generate the SQL creating the warehouse's objects",
+		Description: "Print the DDL execute-sync would run to create the documents table and the objects loading it (Snowflake's file format, stage and pipe, and the stats table) for the configured DATABASE_TYPE, so a DBA can create them ahead of time.  Run with --no-bootstrap afterwards.  The helper views are created by create_views",
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				bootstrapper, ok := db.(warehouses.Bootstrapper)
+				var statements []string
+				if ok {
+					statements = bootstrapper.BootstrapSQL()
+				}
+				if len(statements) == 0 {
+					return fmt.Errorf("%s has no objects to create", cfg.DatabaseType)
+				}
+				fmt.Printf("-- Objects created by execute-sync %s for %s\n\n", version, cfg.DatabaseType)
+				for _, statement := range statements {
+					fmt.Printf("%s;\n\n", strings.TrimSpace(statement))
+				}
+				return nil
+			})
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+// lineageManifest is the JSON written by the lineage command.
+type lineageManifest struct {
+	GeneratedAt string               `json:"generated_at"`
+	ExecuteURL  string               `json:"execute_url"`
+	Table       string               `json:"table"`
+	Views       []sqlgen.ViewLineage `json:"views"`
+}
+
+func LineageCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "lineage",
+		Usage:       "Write a column lineage manifest",
+		Description: "Write a JSON manifest mapping each helper view column back to its Execute document type, field and JSON path, for catalog tools such as DataHub or Collibra",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "File to write the manifest to (defaults to STDOUT)",
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			cfg := config.ResolveConfig(cCtx, config.NeedsExecute)
+			views, err := execute.FetchSchema(cfg)
+			if err != nil {
+				return err
+			}
+
+			manifest, _ := json.MarshalIndent(lineageManifest{
+				GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+				ExecuteURL:  cfg.ExecuteURL,
+				Table:       "EXECUTE_DOCUMENTS",
+				Views:       sqlgen.NewOptions(cfg).Lineage("EXECUTE_DOCUMENTS", views),
+			}, "", "  ")
+
+			output := cCtx.String("output")
+			if output == "" {
+				fmt.Println(string(manifest))
+				return nil
+			}
+			if err := os.WriteFile(output, append(manifest, '\n'), 0644); err != nil {
+				return err
+			}
+			log.Info("Lineage Written!", "file", output)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/afenav/execute-sync/src/internal/warehouses/archive"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func LoadCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "load",
+		Usage:       "Load archived batches into the warehouse",
+		Description: "Load the batches archived by a sync with DATABASE_TYPE=ARCHIVE (on a host which can reach Execute) from ARCHIVE_DIR into the warehouse, removing each once it's loaded",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "follow", Usage: "Keep loading new batches every WAIT seconds"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				if cfg.ArchiveDir == "" {
+					return fmt.Errorf("ARCHIVE_DIR is required")
+				}
+				for {
+					count, err := loadArchive(cfg, db)
+					if err != nil {
+						return err
+					}
+					if count > 0 {
+						log.Infof("Load Complete: %d Documents", count)
+					}
+					if !cCtx.Bool("follow") || cfg.Wait == 0 {
+						return nil
+					}
+					time.Sleep(time.Duration(cfg.Wait) * time.Second)
+				}
+			})
+		},
+	}
+}
+
+// loadArchive loads every pending archived batch, oldest first.  Batches keep
+// the batch date they were fetched with, unless it's already been loaded
+// (i.e. by a load that was interrupted), and are closed with control records
+// like any other sync.
+func loadArchive(cfg config.Config, db warehouses.Database) (int, error) {
+	return newArchiveLoader(cfg, db).load(cfg.ArchiveDir, newRunID())
+}
+
+// archiveLoader loads archived batches into a warehouse.  It remembers the
+// batch date each archived batch date was loaded as, so the parts of a batch
+// archived (or loaded) separately still load as one batch.
+type archiveLoader struct {
+	db        warehouses.Database
+	warehouse string // see warehouseKey
+	chunkSize int
+	workers   int
+	dates     map[string]string // archived batch date => batch date loaded as
+	parts     map[string]int
+}
+
+func newArchiveLoader(cfg config.Config, db warehouses.Database) *archiveLoader {
+	return &archiveLoader{
+		db:        db,
+		warehouse: warehouseKey(cfg),
+		chunkSize: cfg.ChunkSize,
+		workers:   cfg.ChunkWorkers,
+		dates:     map[string]string{},
+		parts:     map[string]int{},
+	}
+}
+
+// load loads the batches pending in dir, removing each once it's loaded.
+func (l *archiveLoader) load(dir string, runID string) (int, error) {
+	batches, err := archive.Pending(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, batch := range batches {
+		batch_date, ok := l.dates[batch.BatchDate]
+		if !ok {
+			batch_date = batch.BatchDate
+			if reconciler, ok := l.db.(warehouses.Reconciler); ok {
+				if batch_date, err = uniqueBatchDate(reconciler, l.warehouse, batch_date); err != nil {
+					return total, err
+				}
+			}
+			l.dates[batch.BatchDate] = batch_date
+		}
+		l.parts[batch_date]++
+
+		nextRecord, closeBatch, err := batch.Open()
+		if err != nil {
+			return total, err
+		}
+		cnt, err := upload(l.db, batch_date, runID, l.parts[batch_date], l.chunkSize, l.workers, nextRecord)
+		closeBatch()
+		if err != nil {
+			return total, fmt.Errorf("loading %s: %v", batch.File, err)
+		}
+		if err := batch.Remove(); err != nil {
+			return total, fmt.Errorf("removing %s: %v", batch.File, err)
+		}
+		log.Info("Loaded archived batch", "file", batch.File, "batch", batch_date, "documents", cnt)
+		total += cnt
+	}
+	return total, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/docindex"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func OrphansCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "orphans",
+		Usage:       "Find documents in the warehouse which Execute no longer has",
+		Description: "List every document in Execute (a full fetch, though nothing is loaded) and compare it with the documents synced: those in the DOCUMENT_INDEX, or in the warehouse when there's no index.  Documents hard deleted in Execute are never reported by the fetch API, so stay in the warehouse until they're found here.  Fails if there are any, unless --mark-deleted loads a deleted copy of each",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "mark-deleted", Usage: "Load a deleted copy of each orphan's latest version, so the helper views show it as deleted"},
+			&cli.IntFlag{Name: "show", Value: 5, Usage: "Log up to this many orphaned document IDs per type"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				synced, err := syncedDocuments(cfg, db)
+				if err != nil {
+					return err
+				}
+				if len(synced) == 0 {
+					log.Info("No documents have been synced")
+					return nil
+				}
+				log.Info("Listing every document in Execute")
+				present, err := executeDocuments(cfg, slices.Collect(maps.Keys(synced)))
+				if err != nil {
+					return err
+				}
+
+				orphans := map[string]map[string]int64{}
+				var types []string
+				for docType, versions := range synced {
+					types = append(types, docType)
+					for id, version := range versions {
+						if !present[docType][id] {
+							if orphans[docType] == nil {
+								orphans[docType] = map[string]int64{}
+							}
+							orphans[docType][id] = version
+						}
+					}
+				}
+				sort.Strings(types)
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+				fmt.Fprintf(w, "TYPE\tSYNCED\tIN EXECUTE\tORPHANS\t\n")
+				total := 0
+				for _, docType := range types {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", docType, len(synced[docType]), len(present[docType]), len(orphans[docType]))
+					total += len(orphans[docType])
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				for _, docType := range types {
+					if len(orphans[docType]) == 0 {
+						continue
+					}
+					ids := slices.Sorted(maps.Keys(orphans[docType]))
+					log.Warn("Orphaned documents", "type", docType, "documents", len(ids), "ids", strings.Join(ids[:min(len(ids), cCtx.Int("show"))], ","))
+				}
+
+				if total == 0 {
+					log.Info("No orphaned documents", "types", len(types))
+					return nil
+				}
+				if !cCtx.Bool("mark-deleted") {
+					return fmt.Errorf("%d documents are no longer in Execute (see orphans --mark-deleted)", total)
+				}
+				return markDeleted(cfg, db, orphans)
+			})
+		},
+	}
+}
+
+// syncedDocuments returns the latest version of every document synced, by
+// type then ID: from the document index when there is one, otherwise from
+// the warehouse.
+func syncedDocuments(cfg config.Config, db warehouses.Database) (map[string]map[string]int64, error) {
+	synced := map[string]map[string]int64{}
+	wanted := documentTypes(cfg.DocumentTypes)
+	if docindex.Exists(cfg.StateDir) {
+		index, err := docindex.Open(cfg.StateDir)
+		if err != nil {
+			return nil, err
+		}
+		defer index.Close()
+		types, err := index.Types()
+		if err != nil {
+			return nil, err
+		}
+		for _, docType := range types {
+			if len(wanted) > 0 && !slices.Contains(wanted, docType) {
+				continue
+			}
+			if synced[docType], err = index.Live(docType); err != nil {
+				return nil, err
+			}
+		}
+		log.Info("Comparing with the document index", "types", len(synced))
+		return synced, nil
+	}
+
+	lister, ok := db.(warehouses.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("%s targets can't list their documents, set DOCUMENT_INDEX and sync to build an index instead", cfg.DatabaseType)
+	}
+	stats, err := reportByType(db)
+	if err != nil {
+		return nil, err
+	}
+	for docType := range stats {
+		if docType == documents.ControlType || (len(wanted) > 0 && !slices.Contains(wanted, docType)) {
+			continue
+		}
+		if synced[docType], err = lister.LatestVersions(docType); err != nil {
+			return nil, err
+		}
+	}
+	log.Info("Comparing with the warehouse", "types", len(synced))
+	return synced, nil
+}
+
+// executeDocuments fetches every document of the given types from Execute,
+// deleted or not, and returns their IDs by type.  Nothing but the type and ID
+// of each is kept.
+func executeDocuments(cfg config.Config, types []string) (map[string]map[string]bool, error) {
+	client, err := execute.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sizer := newBatchSizer(cfg)
+	present := map[string]map[string]bool{}
+	for _, docType := range types {
+		present[docType] = map[string]bool{}
+	}
+
+	since, cursor := "1900-01-01", ""
+	for {
+		var resp *execute.FetchResponse
+		for {
+			resp, err = client.Fetch(execute.FetchRequest{Since: since, Cursor: cursor, Limit: sizer.Limit(), Types: types})
+			if err == nil || !execute.Retryable(err) || !sizer.Backoff() {
+				break
+			}
+			log.Warn("Fetch timed out, retrying with fewer documents", "error", err, "limit", sizer.Limit())
+		}
+		if err != nil {
+			return nil, err
+		}
+		sizer.Recover()
+
+		count := 0
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				var document struct {
+					Type string `json:"$TYPE"`
+					ID   string `json:"DOCUMENT_ID"`
+				}
+				if jsonErr := json.Unmarshal(line, &document); jsonErr != nil {
+					resp.Body.Close()
+					return nil, fmt.Errorf("parsing document: %v", jsonErr)
+				}
+				if ids, ok := present[document.Type]; ok {
+					ids[document.ID] = true
+				}
+				count++
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		resp.Body.Close()
+		log.Debug("Listed documents", "documents", count, "highwater", resp.Highwater)
+
+		if !resp.Truncated {
+			return present, nil
+		}
+		since, cursor = resp.Highwater, resp.Cursor
+	}
+}
+
+// markDeleted loads a deleted copy of the latest version of every orphan, in
+// a batch of its own, and records them as deleted in the document index.
+func markDeleted(cfg config.Config, db warehouses.Database, orphans map[string]map[string]int64) error {
+	batch_date := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if reconciler, ok := db.(warehouses.Reconciler); ok {
+		var err error
+		if batch_date, err = uniqueBatchDate(reconciler, warehouseKey(cfg), batch_date); err != nil {
+			return err
+		}
+	}
+
+	var tombstones []map[string]interface{}
+	for docType, versions := range orphans {
+		for id, version := range versions {
+			tombstones = append(tombstones, map[string]interface{}{
+				"$TYPE":       docType,
+				"DOCUMENT_ID": id,
+				"$VERSION":    version,
+				"$DELETED":    true,
+				"$DATE":       batch_date,
+			})
+		}
+	}
+	next := 0
+	cnt, err := upload(db, batch_date, newRunID(), 1, cfg.ChunkSize, cfg.ChunkWorkers, func() (map[string]interface{}, error) {
+		if next == len(tombstones) {
+			return nil, io.EOF
+		}
+		next++
+		return tombstones[next-1], nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Marked orphaned documents deleted", "documents", cnt, "batch", batch_date)
+
+	if docindex.Exists(cfg.StateDir) {
+		index, err := docindex.Open(cfg.StateDir)
+		if err != nil {
+			return err
+		}
+		defer index.Close()
+		for _, tombstone := range tombstones {
+			if err := index.Add(tombstone["$TYPE"].(string), tombstone["DOCUMENT_ID"].(string), tombstone["$VERSION"].(int64), true); err != nil {
+				return err
+			}
+		}
+		return index.Commit()
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func PreflightCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "preflight",
+		Usage:       "Check the warehouse privileges",
+		Description: "Connect to the warehouse and check that it isn't being accessed with more privileges than execute-sync needs, i.e. an administrative account",
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				preflighter, ok := db.(warehouses.Preflighter)
+				if !ok {
+					return fmt.Errorf("%s targets can't check their privileges", cfg.DatabaseType)
+				}
+
+				problems, err := preflighter.Preflight()
+				if err != nil {
+					return err
+				}
+				for _, problem := range problems {
+					log.Warn("Excess privileges", "problem", problem)
+				}
+				if len(problems) > 0 {
+					return fmt.Errorf("connected with more privileges than needed")
+				}
+
+				log.Info("Preflight OK!")
+				return nil
+			})
+		},
+	}
+}
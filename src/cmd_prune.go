@@ -1,9 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/afenav/execute-sync/src/internal/config"
 	"github.com/afenav/execute-sync/src/internal/warehouses"
 	"github.com/charmbracelet/log"
+	"github.com/robfig/cron/v3"
 	"github.com/urfave/cli/v2"
 )
 
@@ -12,9 +20,44 @@ func PruneCommand() *cli.Command {
 		Name:        "prune",
 		Usage:       "Prune unused data",
 		Description: "Prune unused/temporary data from warehouse",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "table-only", Usage: "Only remove superseded rows from the documents table"},
+			&cli.BoolFlag{Name: "stage-only", Usage: "Only remove staged files (Snowflake)"},
+			&cli.StringFlag{Name: "older-than", Usage: "Also remove batches loaded longer ago than this (i.e. 13mo, 400d or 2y), and only staged files older than it (defaults to PRUNE_OLDER_THAN)"},
+		},
 		Action: func(cCtx *cli.Context) error {
 			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
-				if err := db.Prune(); err != nil {
+				tableOnly, stageOnly := cCtx.Bool("table-only"), cCtx.Bool("stage-only")
+				scoped, ok := db.(warehouses.ScopedPruner)
+				olderThan := cfg.PruneOlderThan
+				if cCtx.IsSet("older-than") {
+					olderThan = cCtx.String("older-than")
+				}
+				var cutoff time.Time
+				if olderThan != "" {
+					var err error
+					if cutoff, err = retentionCutoff("--older-than", olderThan, time.Now()); err != nil {
+						return err
+					}
+				}
+
+				var err error
+				switch {
+				case tableOnly && stageOnly:
+					return fmt.Errorf("--table-only and --stage-only can't be combined")
+				case stageOnly && !ok:
+					return fmt.Errorf("%s targets don't use a stage", cfg.DatabaseType)
+				case !cutoff.IsZero():
+					err = pruneOlderThan(db, cutoff, !stageOnly, !tableOnly)
+				case stageOnly:
+					err = scoped.PruneStage()
+				case tableOnly && ok:
+					err = scoped.PruneTable()
+				default:
+					// Either everything, or the table on warehouses whose Prune only touches that
+					err = db.Prune()
+				}
+				if err != nil {
 					return err
 				}
 
@@ -24,3 +67,168 @@ func PruneCommand() *cli.Command {
 		},
 	}
 }
+
+// pruneSchedule decides when sync should prune automatically, either every
+// PRUNE_EVERY (a duration such as 24h, or a cron expression) or after every
+// PRUNE_EVERY_BATCHES batches.  The last prune is remembered in STATE_DIR so
+// restarting the daemon doesn't reset the clock.
+type pruneSchedule struct {
+	stateDir string
+	every    time.Duration
+	cron     cron.Schedule
+	batches  int
+	pending  int    // batches loaded since the last prune
+	maxAge   string // PRUNE_OLDER_THAN
+}
+
+// newPruneSchedule returns the configured schedule, or nil when automatic
+// pruning is disabled.
+func newPruneSchedule(cfg config.Config) (*pruneSchedule, error) {
+	if cfg.PruneEvery == "" && cfg.PruneEveryBatches <= 0 {
+		return nil, nil
+	}
+	p := &pruneSchedule{stateDir: cfg.StateDir, batches: cfg.PruneEveryBatches, maxAge: cfg.PruneOlderThan}
+	if cfg.PruneEvery != "" {
+		var err error
+		if p.every, p.cron, err = parseEvery("PRUNE_EVERY", cfg.PruneEvery); err != nil {
+			return nil, err
+		}
+	}
+	if p.maxAge != "" {
+		if _, err := retentionCutoff("PRUNE_OLDER_THAN", p.maxAge, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// retentionCutoff returns the time before which batches are older than age: a
+// number of years (y), months (mo), weeks (w) or days (d), i.e. 13mo, or a
+// duration such as 720h.
+func retentionCutoff(name string, age string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(age); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	units := []struct {
+		suffix              string
+		years, months, days int
+	}{
+		{"y", 1, 0, 0},
+		{"mo", 0, 1, 0},
+		{"w", 0, 0, 7},
+		{"d", 0, 0, 1},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(age, unit.suffix); ok {
+			if n, err := strconv.Atoi(number); err == nil && n > 0 {
+				return now.AddDate(-n*unit.years, -n*unit.months, -n*unit.days), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s must be an age such as 13mo, 400d or 2y: %q", name, age)
+}
+
+// pruneOlderThan prunes for a retention schedule.  With table, superseded
+// rows are removed and then every batch loaded before cutoff; with stage,
+// the files staged before cutoff are removed rather than emptying the stage.
+func pruneOlderThan(db warehouses.Database, cutoff time.Time, table bool, stage bool) error {
+	if table {
+		var err error
+		if scoped, ok := db.(warehouses.ScopedPruner); ok {
+			err = scoped.PruneTable()
+		} else {
+			err = db.Prune()
+		}
+		if err != nil {
+			return err
+		}
+		pruner, ok := db.(warehouses.RetentionPruner)
+		if !ok {
+			return fmt.Errorf("%T can't remove batches by age", db)
+		}
+		log.Info("Removing old batches", "before", cutoff.UTC().Format(time.RFC3339))
+		if err := pruner.PruneBefore(cutoff); err != nil {
+			return err
+		}
+	}
+	if cleaner, ok := db.(warehouses.StageCleaner); ok && stage {
+		removed, err := cleaner.CleanStage(time.Since(cutoff))
+		if err != nil {
+			return err
+		}
+		log.Info("Removed old staged files", "files", removed)
+	}
+	return nil
+}
+
+// parseEvery parses a setting which is either a duration (i.e. 24h) or a cron
+// expression.
+func parseEvery(name string, value string) (time.Duration, cron.Schedule, error) {
+	if every, err := time.ParseDuration(value); err == nil {
+		return every, nil, nil
+	}
+	if schedule, err := cron.ParseStandard(value); err == nil {
+		return 0, schedule, nil
+	}
+	return 0, nil, fmt.Errorf("%s must be a duration (i.e. 24h) or a cron expression: %q", name, value)
+}
+
+// due reports whether a prune is due after a batch was (or wasn't) loaded.
+func (p *pruneSchedule) due(loaded bool) bool {
+	if loaded {
+		p.pending++
+	}
+	if p.batches > 0 && p.pending >= p.batches {
+		return true
+	}
+
+	last := loadLastPrune(p.stateDir)
+	if last.IsZero() {
+		// Start the clock rather than pruning as soon as the daemon starts
+		saveLastPrune(p.stateDir, time.Now())
+		return false
+	}
+	switch {
+	case p.every > 0:
+		return time.Since(last) >= p.every
+	case p.cron != nil:
+		return !p.cron.Next(last).After(time.Now())
+	}
+	return false
+}
+
+// run prunes the warehouse and restarts the schedule.
+func (p *pruneSchedule) run(db warehouses.Database) error {
+	log.Info("Starting Scheduled Prune")
+	var err error
+	if p.maxAge != "" {
+		// Checked by newPruneSchedule
+		cutoff, _ := retentionCutoff("PRUNE_OLDER_THAN", p.maxAge, time.Now())
+		err = pruneOlderThan(db, cutoff, true, true)
+	} else {
+		err = db.Prune()
+	}
+	if err != nil {
+		log.Errorf("Scheduled Prune Failed: %v", err)
+		return err
+	}
+	p.pending = 0
+	saveLastPrune(p.stateDir, time.Now())
+	log.Info("Scheduled Prune Completed")
+	return nil
+}
+
+func loadLastPrune(basePath string) time.Time {
+	data, err := os.ReadFile(filepath.Join(basePath, "last_prune.txt"))
+	if err != nil {
+		return time.Time{}
+	}
+	last, _ := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	return last
+}
+
+func saveLastPrune(basePath string, last time.Time) {
+	if err := os.WriteFile(filepath.Join(basePath, "last_prune.txt"), []byte(last.UTC().Format(time.RFC3339)), 0644); err != nil {
+		log.Warnf("Error saving last prune time: %v", err)
+	}
+}
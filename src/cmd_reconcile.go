@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func ReconcileCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "reconcile",
+		Usage:       "Check recent batches loaded completely",
+		Description: "Compare each recent batch's control record against the documents and chunks actually loaded, flagging any discrepancies",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "batches",
+				Usage: "Number of recent batches to check",
+				Value: 10,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				reconciler, ok := db.(warehouses.Reconciler)
+				if !ok {
+					return fmt.Errorf("%s targets can't be reconciled", cfg.DatabaseType)
+				}
+
+				batches, err := reconciler.Reconcile(cCtx.Int("batches"))
+				if err != nil {
+					return err
+				}
+
+				mismatched := 0
+				for _, batch := range batches {
+					if batch.OK() {
+						log.Info("Batch OK", "batch", batch.BatchDate, "documents", batch.Documents, "chunks", batch.Chunks)
+						continue
+					}
+					mismatched++
+					log.Warn("Batch incomplete", "batch", batch.BatchDate,
+						"documents", batch.Documents, "expected_documents", batch.ExpectedDocuments,
+						"chunks", batch.Chunks, "expected_chunks", batch.ExpectedChunks)
+				}
+
+				if mismatched > 0 {
+					return fmt.Errorf("%d of %d batches didn't load completely", mismatched, len(batches))
+				}
+				log.Info("Reconciliation Completed!", "batches", len(batches))
+				return nil
+			})
+		},
+	}
+}
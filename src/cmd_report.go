@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/urfave/cli/v2"
+)
+
+func ReportCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "report",
+		Usage:       "Report row counts and storage",
+		Description: "Summarise the documents table by document type: documents, versions, rows, chunks and approximate storage, along with the rows loaded by the two most recent batches",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "stats", Usage: "Report from the stats table kept by COLLECT_STATS, rather than scanning the documents table"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				if cCtx.Bool("stats") {
+					return reportStats(db, cfg)
+				}
+				reporter, ok := db.(warehouses.Reporter)
+				if !ok {
+					return fmt.Errorf("%s targets can't be reported on", cfg.DatabaseType)
+				}
+
+				stats, err := reporter.Report()
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+				fmt.Fprintln(w, "TYPE\tDOCUMENTS\tVERSIONS\tROWS\tCHUNKS\tSIZE (MB)\tLATEST BATCH\tPREVIOUS BATCH\t")
+				var total int64
+				for _, s := range stats {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f\t%d\t%d\t\n", s.Type, s.Documents, s.Versions, s.Rows, s.Chunks, float64(s.Bytes)/(1024*1024), s.Latest, s.Previous)
+					total += s.Bytes
+				}
+				fmt.Fprintf(w, "TOTAL\t\t\t\t\t%.1f\t\t\t\n", float64(total)/(1024*1024))
+				return w.Flush()
+			})
+		},
+	}
+}
+
+// reportStats prints the stats table: cheap to query, but it counts every row
+// loaded rather than what's left after pruning, and doesn't know how many
+// distinct documents or versions there are.
+func reportStats(db warehouses.Database, cfg config.Config) error {
+	collector, ok := db.(warehouses.StatsCollector)
+	if !ok {
+		return fmt.Errorf("%s targets don't collect stats", cfg.DatabaseType)
+	}
+	stats, err := collector.Stats()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "TYPE\tROWS LOADED\tCHUNKS\tLATEST BATCH\tPREVIOUS BATCH\tLAST LOADED\tNEWEST DOCUMENT\t")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t\n", s.Type, s.Rows, s.Chunks, s.Latest, s.Previous, s.LastLoaded, s.Newest)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+func SelftestCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "selftest",
+		Usage:       "Check loading and views end to end in a disposable schema",
+		Description: "Create a temporary schema in the warehouse, load a few built-in documents into it, build their view, check what it returns and drop the schema again, i.e. to check a new deployment's credentials and privileges before its first sync",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "keep", Usage: "Leave the schema in place afterwards, for investigating a failure"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
+				tester, ok := db.(warehouses.SelfTester)
+				if !ok {
+					return fmt.Errorf("%s targets can't run selftest", cfg.DatabaseType)
+				}
+				// The schema is new, so its objects have to be created
+				readonly.NoBootstrap = false
+
+				runID := newRunID()
+				name := "EXECUTESYNC_SELFTEST_" + runID[:8]
+				scratch, err := tester.Scratch(name)
+				if err != nil {
+					return fmt.Errorf("error creating schema: %v", err)
+				}
+				log.Info("Created schema", "schema", name)
+				defer func() {
+					if cCtx.Bool("keep") {
+						log.Info("Keeping schema", "schema", name)
+						return
+					}
+					if err := scratch.Drop(); err != nil {
+						log.Error("Failed to drop schema", "schema", name, "error", err)
+						return
+					}
+					log.Info("Dropped schema", "schema", name)
+				}()
+
+				if err := selftest(scratch, runID, cfg.ChunkSize, cfg.ChunkWorkers, sqlgen.NewOptions(cfg)); err != nil {
+					return fmt.Errorf("selftest failed: %v", err)
+				}
+				log.Info("Selftest OK!")
+				return nil
+			})
+		},
+	}
+}
+
+// selftestType is the document type of the built-in fixtures.
+const selftestType = "SELFTEST"
+
+// selftestSchema describes the fixtures, as Execute's schema would.
+var selftestSchema = execute.RootSchema{
+	selftestType: {
+		"DOCUMENT_ID": {Name: "Document ID", Active: true, Type: "GUID"},
+		"NAME":        {Name: "Name", Active: true, Type: "TEXT", Nullable: true},
+	},
+}
+
+// selftestDocuments returns the fixtures: a document with two versions, of
+// which only the second should be in the view, and one whose name needs
+// quoting and escaping in every load format.
+func selftestDocuments() []map[string]interface{} {
+	document := func(id string, version int64, name string) map[string]interface{} {
+		return map[string]interface{}{
+			"$TYPE":       selftestType,
+			"DOCUMENT_ID": id,
+			"$VERSION":    version,
+			"$DATE":       "2024-01-01T00:00:00Z",
+			"$DELETED":    false,
+			"$AUTHOR_ID":  "selftest",
+			"NAME":        name,
+		}
+	}
+	return []map[string]interface{}{
+		document("00000000-0000-0000-0000-000000000001", 1, "First"),
+		document("00000000-0000-0000-0000-000000000001", 2, "Second"),
+		document("00000000-0000-0000-0000-000000000002", 1, "Quote ' \"double\", comma\\ newline\n café"),
+	}
+}
+
+// selftestExpected returns the view's expected DOCUMENT_ID, _VERSION and NAME.
+func selftestExpected() [][]string {
+	return [][]string{
+		{"00000000-0000-0000-0000-000000000001", "2", "Second"},
+		{"00000000-0000-0000-0000-000000000002", "1", "Quote ' \"double\", comma\\ newline\n café"},
+	}
+}
+
+// selftest loads the fixtures into scratch, builds their view and checks it.
+// The options must be those the scratch's views are built with.
+func selftest(scratch warehouses.Scratch, runID string, chunkSize int, workers int, options sqlgen.Options) error {
+	fixtures := selftestDocuments()
+	batch_date := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	next := 0
+	count, err := upload(scratch, batch_date, runID, 1, chunkSize, workers, func() (map[string]interface{}, error) {
+		if next == len(fixtures) {
+			return nil, io.EOF
+		}
+		next++
+		return fixtures[next-1], nil
+	})
+	if err != nil {
+		return fmt.Errorf("error loading documents: %v", err)
+	}
+	if count != len(fixtures) {
+		return fmt.Errorf("loaded %d of %d documents", count, len(fixtures))
+	}
+	log.Info("Loaded documents", "batch", batch_date, "documents", count)
+
+	if reconciler, ok := scratch.(warehouses.Reconciler); ok {
+		batches, err := reconciler.Reconcile(1)
+		if err != nil {
+			return fmt.Errorf("error reconciling: %v", err)
+		}
+		if len(batches) != 1 || !batches[0].OK() {
+			return fmt.Errorf("batch didn't reconcile: %+v", batches)
+		}
+		log.Info("Reconciled batch", "batch", batch_date)
+	}
+
+	if err := scratch.CreateViews(selftestSchema); err != nil {
+		return fmt.Errorf("error creating views: %v", err)
+	}
+	view := options.Views(selftestSchema)[0]
+	rows, err := scratch.Rows(view.Name, options.ColumnName("DOCUMENT_ID"), options.ColumnName("_VERSION"), view.Fields[0].Column)
+	if err != nil {
+		return err
+	}
+	if expected := selftestExpected(); !reflect.DeepEqual(rows, expected) {
+		return fmt.Errorf("%s returned %q, expected %q", view.Name, rows, expected)
+	}
+	log.Info("Checked view", "view", view.Name, "rows", len(rows))
+	return nil
+}
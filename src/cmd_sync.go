@@ -2,21 +2,30 @@ package main
 
 import (
 	"bufio"
-	"encoding/base64"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/docindex"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/memory"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/timing"
+	"github.com/afenav/execute-sync/src/internal/transform"
 	"github.com/afenav/execute-sync/src/internal/warehouses"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
 )
 
 func SyncCommand() *cli.Command {
@@ -42,12 +51,26 @@ func PushCommand() *cli.Command {
 		Aliases: []string{"p"},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{Name: "force", Usage: "Force a complete data refresh", EnvVars: []string{"EXECUTESYNC_FORCE"}, DefaultText: "false", Aliases: []string{"f"}},
+			&cli.IntFlag{Name: "sample", Usage: "Load no more than this many documents of each type, for a development warehouse", EnvVars: []string{"EXECUTESYNC_SAMPLE"}, DefaultText: "0"},
+			&cli.StringFlag{Name: "sample-rate", Usage: "Load this fraction of each type's documents (i.e. 0.01), for a development warehouse", EnvVars: []string{"EXECUTESYNC_SAMPLE_RATE"}},
 		},
 		Usage:       "Onetime push of new updates to warehouse",
 		Description: "Pushes a set of updates to warehouse and terminates",
 		Action: func(cCtx *cli.Context) error {
 			return withDatabase(cCtx, func(db warehouses.Database, cfg config.Config) error {
-				return sync(cfg, db, true)
+				if err := sync(cfg, db, true); err != nil {
+					return err
+				}
+				// A full refresh may load types, or nested structures, whose
+				// views are out of date
+				if cfg.Force {
+					deployDB, err := openDeployDatabase(cfg, db, "create_views")
+					if err != nil {
+						return err
+					}
+					return refreshViews(cfg, deployDB)
+				}
+				return nil
 			})
 		},
 	}
@@ -55,137 +78,719 @@ func PushCommand() *cli.Command {
 
 func sync(cfg config.Config, db warehouses.Database, onetime bool) error {
 
+	prune, err := newPruneSchedule(cfg)
+	if err != nil {
+		return failure.Wrap(failure.Config, err)
+	}
+	targets, err := syncTargets(cfg, db)
+	if err != nil {
+		return err
+	}
+	if cfg.LoadFormat == "parquet" && cfg.DatabaseType != "DATABRICKS" {
+		log.Warn("LOAD_FORMAT=parquet isn't supported by this warehouse, ignoring it", "type", cfg.DatabaseType)
+	}
+	if _, ok := db.(warehouses.StatsCollector); cfg.CollectStats && !ok && cfg.DatabaseTargets == "" {
+		log.Warn("COLLECT_STATS isn't supported by this warehouse, ignoring it", "type", cfg.DatabaseType)
+	}
+	maintenance, err := loadOutage(cfg)
+	if err != nil {
+		return failure.Wrap(failure.Config, err)
+	}
+	var digest *digestSchedule
+	if !onetime {
+		if digest, err = newDigestSchedule(cfg); err != nil {
+			return failure.Wrap(failure.Config, err)
+		}
+		if cfg.DebugAddr != "" {
+			if err := startDebugServer(cfg.DebugAddr); err != nil {
+				return fmt.Errorf("starting debug server: %w", err)
+			}
+		}
+	}
+
 	for {
 		log.Info("Starting Sync")
-		count, err := fetchAndProcessDocuments(cfg, db)
-		if err != nil {
-			log.Infof("Sync Failed: %v", err)
+		count, err := syncAll(cfg, targets)
+		if _, unavailable := execute.Unavailable(err); unavailable {
+			log.Warnf("Sync Skipped: Execute Unavailable (%v)", err)
+		} else if err != nil {
+			log.Info("Sync Failed: "+err.Error(), "code", failure.KindOf(err))
 		} else if count == 0 {
 			log.Info("Sync Complete: No Updated Documents")
 		} else {
 			log.Infof("Sync Complete: %d Updated Documents", count)
 		}
+		maintenance.record(err)
+		if digest != nil {
+			digest.recordSync(count, err)
+		}
+		if prune != nil && err == nil && prune.due(count > 0) {
+			pruneDB, pruneErr := openDeployDatabase(cfg, db, "prune")
+			if pruneErr == nil {
+				pruneErr = prune.run(pruneDB)
+			} else {
+				log.Errorf("Scheduled Prune Failed: %v", pruneErr)
+			}
+			if digest != nil {
+				digest.recordPrune(pruneErr)
+			}
+		}
+		if digest != nil {
+			digest.sendIfDue()
+		}
 		if cfg.Wait == 0 || onetime {
+			// A failed push fails, with the exit code of its failure, so
+			// that whatever scheduled it notices and can tell why.  Execute
+			// being down for maintenance isn't a failure.
+			if _, unavailable := execute.Unavailable(err); !unavailable {
+				return err
+			}
 			break
 		}
-		log.Infof("Sleeping %d seconds", cfg.Wait)
-		time.Sleep(time.Duration(cfg.Wait) * time.Second)
+		// Execute being down for maintenance is waited out for longer
+		wait := maintenance.wait(err, time.Duration(cfg.Wait)*time.Second)
+		log.Infof("Sleeping %d seconds", int(wait.Seconds()))
+		time.Sleep(wait)
 	}
 	return nil
 }
 
-func fetchAndProcessDocuments(cfg config.Config, db warehouses.Database) (int, error) {
+// syncTarget is an Execute instance (or one of its ENDPOINTS) to sync, along
+// with the warehouse connection it's loaded through.
+type syncTarget struct {
+	source   string // SOURCES label and/or ENDPOINTS table, empty when there's only one
+	cfg      config.Config
+	db       warehouses.Database
+	sizer    *batchSizer
+	endpoint config.Endpoint // zero for /fetch/document/
+}
+
+// syncTargets returns the Execute instances to sync: the configured one, or
+// each of the SOURCES, followed by each instance's ENDPOINTS.  Each source
+// and endpoint gets a warehouse connection of its own, tagged with its label,
+// so that they can be loaded in parallel.
+func syncTargets(cfg config.Config, db warehouses.Database) ([]*syncTarget, error) {
+	sources, err := config.Sources(cfg)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := config.Endpoints(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 && len(endpoints) == 0 {
+		return []*syncTarget{{cfg: cfg, db: db, sizer: newBatchSizer(cfg)}}, nil
+	}
+
+	var targets []*syncTarget
+	add := func(label string, targetCfg config.Config, endpoint config.Endpoint, tags ...string) error {
+		if err := os.MkdirAll(targetCfg.StateDir, 0755); err != nil {
+			return failure.Errorf(failure.State, "creating state directory for %s: %v", label, err)
+		}
+		targetDB, err := openDatabase(targetCfg, "sync", tags...)
+		if err != nil {
+			return fmt.Errorf("connecting for %s: %v", label, err)
+		}
+		targets = append(targets, &syncTarget{source: label, cfg: targetCfg, db: targetDB, sizer: newBatchSizer(targetCfg), endpoint: endpoint})
+		return nil
+	}
+	if len(sources) == 0 {
+		targets = append(targets, &syncTarget{cfg: cfg, db: db, sizer: newBatchSizer(cfg)})
+		for _, e := range endpoints {
+			if err := add(e.Table, e.Apply(cfg), e, "endpoint", e.Table); err != nil {
+				return nil, err
+			}
+		}
+		return targets, nil
+	}
+	for _, source := range sources {
+		sourceCfg := source.Apply(cfg)
+		if err := add(source.Label, sourceCfg, config.Endpoint{}, "source", source.Label); err != nil {
+			return nil, err
+		}
+		for _, e := range endpoints {
+			if err := add(source.Label+"/"+e.Table, e.Apply(sourceCfg), e, "source", source.Label, "endpoint", e.Table); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return targets, nil
+}
+
+// syncAll syncs each target, one after another or (with SOURCES_PARALLEL) at
+// the same time, and returns the total number of documents loaded.  A source
+// which fails doesn't stop the others; the first error is returned.
+func syncAll(cfg config.Config, targets []*syncTarget) (int, error) {
+	if len(targets) == 1 {
+		t := targets[0]
+		return fetchAndProcessDocuments(t, true)
+	}
+
+	// SQLite only allows one writer at a time
+	parallel := cfg.SourcesParallel && !strings.HasSuffix(cfg.DatabaseType, "SQLITE")
+	counts := make([]int, len(targets))
+	errs := make([]error, len(targets))
+	var g errgroup.Group
+	if !parallel {
+		g.SetLimit(1)
+	}
+	for i, t := range targets {
+		g.Go(func() error {
+			counts[i], errs[i] = fetchAndProcessDocuments(t, !parallel)
+			if errs[i] != nil {
+				log.Warn("Source failed", "source", t.source, "error", errs[i], "code", failure.KindOf(errs[i]))
+			} else {
+				log.Info("Source synced", "source", t.source, "documents", counts[i])
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	total := 0
+	for i := range targets {
+		total += counts[i]
+		if errs[i] != nil {
+			return total, fmt.Errorf("%s: %w", targets[i].source, errs[i])
+		}
+	}
+	return total, nil
+}
+
+// fetchAndProcessDocuments runs a sync of t in its own workspace under
+// STATE_DIR.  Its spool files are kept there too, unless other sources are
+// being synced at the same time (spool files share one directory).
+func fetchAndProcessDocuments(t *syncTarget, ownSpool bool) (int, error) {
+	cfg, db, source := t.cfg, t.db, t.source
+	runID := newRunID()
+	log.Debug("Starting run", "run", runID)
+	ws, err := openWorkspace(cfg.StateDir, runID, source)
+	if err != nil {
+		return 0, err
+	}
+	if ownSpool {
+		spool.SetDir(ws.dir)
+		defer spool.SetDir("")
+	}
+	count, err := syncRun(cfg, db, t.sizer, t.endpoint, ws)
+	// A target which is behind fails the run, though its batches are safely
+	// queued for the next one
+	if f, ok := db.(*fanOut); ok && err == nil {
+		err = f.backlogged()
+	}
+	if err == nil {
+		err = checkAnomalies(cfg, source, ws.manifest.Types)
+	}
+	ws.finish(err)
+	return count, err
+}
 
-	batch_date := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+func syncRun(cfg config.Config, db warehouses.Database, sizer *batchSizer, endpoint config.Endpoint, ws *workspace) (int, error) {
+
+	// The batch_date is taken from Execute's clock once we've heard from it
+	batch_date := ""
+	runID := ws.manifest.RunID
+	if tagger, ok := db.(warehouses.QueryTagger); ok {
+		if err := tagger.SetQueryTag("run", runID); err != nil {
+			return 0, err
+		}
+	}
 
 	// Keep track of document count
 	document_count := 0
 
+	// Keep track of how many times we've uploaded to this batch
+	part := 0
+
 	// Fetch the data of the last successful sync
 	lastSyncDate := loadLastSyncDate(cfg.StateDir)
 
 	// If we have no last sync date, or we're forcing a full refresh, pick a date way in the past
-	if cfg.Force || lastSyncDate == "" {
+	fromScratch := cfg.Force || lastSyncDate == ""
+	if fromScratch {
 		lastSyncDate = "1900-01-01"
 	}
 
+	// A previous run may have found the highwater mark going backwards, and
+	// be skipping documents the warehouse already holds until it catches up
+	catchup, err := loadCatchUp(cfg, db)
+	if err != nil {
+		return 0, err
+	}
+
+	// A development warehouse may only be loaded with a sample of each type
+	sample, err := newSampler(cfg, fromScratch)
+	if err != nil {
+		return 0, err
+	}
+
+	// Documents are added to the index as they're read, and committed along
+	// with the highwater mark
+	var index *docindex.Index
+	if cfg.DocumentIndex {
+		if index, err = docindex.Open(cfg.StateDir); err != nil {
+			return 0, failure.Wrap(failure.State, err)
+		}
+		defer index.Close()
+	}
+
+	// Record our progress so that if we're interrupted, the next run knows
+	// what state we left the warehouse in
+	recoverCheckpoint(cfg.StateDir, lastSyncDate)
+	pruneWorkspaces(cfg.StateDir, cfg.KeepFailedRuns)
+	progress := &checkpoint{RunID: runID, PID: os.Getpid()}
+
+	// Where the run's time went is logged at the end, and kept in the
+	// manifest of a failed run
+	timingsBefore := timing.Snapshot()
+	defer func() {
+		ws.manifest.Timings = timing.Snapshot().Sub(timingsBefore)
+		log.Info("Run timings", ws.manifest.Timings.LogArgs()...)
+	}()
+
+	client, err := execute.NewClient(cfg)
+	if err != nil {
+		return 0, err
+	}
+	types := documentTypes(cfg.DocumentTypes)
+	cursor := ""
+
+	// Documents are reshaped by the TRANSFORM expression and cleaned of
+	// characters which trip up warehouse loaders, then have their ANONYMIZE
+	// fields pseudonymized.  If reshaping or cleaning fails, the upload is cut
+	// short and its highwater mark isn't stored, so the documents are fetched
+	// again next time.
+	transformer, err := transform.New(cfg.Transform)
+	if err != nil {
+		return 0, failure.Wrap(failure.Config, err)
+	}
+	sanitizer, err := documents.NewSanitizer(cfg.Sanitize)
+	if err != nil {
+		return 0, failure.Wrap(failure.Config, err)
+	}
+	attributes, err := documents.ParseAttributes(cfg.Attributes)
+	if err != nil {
+		return 0, failure.Wrap(failure.Config, err)
+	}
+	anonymizer, err := documents.NewAnonymizer(cfg.Anonymize, cfg.AnonymizeKey, cfg.AnonymizeJitter)
+	if err != nil {
+		return 0, failure.Wrap(failure.Config, err)
+	}
+	// recordErr cuts an upload short (see above); readErr is Execute's
+	// response failing part way, which the upload fails with
+	var recordErr, readErr error
+
+	// Document types can be uploaded in parallel, except to SQLite which only
+	// allows one writer at a time
+	streams := cfg.UploadStreams
+	if streams > 1 && strings.HasSuffix(cfg.DatabaseType, "SQLITE") {
+		log.Warn("SQLite can't load document types in parallel, ignoring UPLOAD_STREAMS")
+		streams = 1
+	}
+	if streams > 1 && cfg.DatabaseTargets != "" {
+		log.Warn("DATABASE_TARGETS loads each batch through a backlog, ignoring UPLOAD_STREAMS")
+		streams = 1
+	}
+	defer func() {
+		if sanitizer.Documents > 0 {
+			log.Info("Sanitized documents", "mode", cfg.Sanitize, "documents", sanitizer.Documents, "values", sanitizer.Values)
+		}
+		if anonymizer != nil {
+			log.Info("Anonymized documents", "documents", anonymizer.Documents, "values", anonymizer.Values)
+		}
+	}()
+
+	// The next page may be downloaded while the current one is loading.
+	// SPOOL_FETCH pages are cleared once loaded, so can't be fetched ahead.
+	prefetching := cfg.Prefetch
+	if prefetching && cfg.SpoolFetch {
+		log.Warn("SPOOL_FETCH downloads each page as it's loaded, ignoring PREFETCH")
+		prefetching = false
+	}
+	// prefetch is the next page being downloaded, and prefetched the page
+	// being loaded if it was downloaded ahead; each is discarded once done with
+	var prefetch, prefetched *execute.Prefetch
+	defer func() {
+		for _, p := range []*execute.Prefetch{prefetch, prefetched} {
+			if p != nil {
+				p.Discard()
+			}
+		}
+	}()
+
 	// Depending on the number of documents and batch sizes, we may have to perform several iterations before
 	// We can slurp down all the documents
 	for {
+		progress.Iteration++
+		progress.Before = lastSyncDate
+		progress.After = ""
+		progress.Spool = nil
+		progress.save(ws.dir, phaseFetching)
 
-		// Perform the GET request
-		client := &http.Client{}
+		// Use the page downloaded ahead, if it's the one we're after
+		var resp *execute.FetchResponse
+		if prefetched != nil {
+			prefetched.Discard()
+		}
+		prefetched, prefetch = prefetch, nil
+		if prefetched != nil {
+			resp = waitForPrefetch(prefetched, lastSyncDate, cursor)
+		}
 
-		// Parse the base URL
-		parsedURL, err := url.Parse(cfg.ExecuteURL)
+		// Fetch the data, asking for fewer documents if Execute times out
+		for resp == nil {
+			request := execute.FetchRequest{
+				Path:         endpoint.Path,
+				Since:        lastSyncDate,
+				Cursor:       cursor,
+				Limit:        sizer.Limit(),
+				Types:        types,
+				IncludeCalcs: cfg.IncludeCalcs,
+			}
+			fetchStart := time.Now()
+			if cfg.SpoolFetch {
+				resp, err = client.FetchSpooled(request, cfg.StateDir)
+			} else {
+				resp, err = client.Fetch(request)
+			}
+			timing.Since(timing.Fetch, fetchStart)
+			if err == nil || !execute.Retryable(err) || !sizer.Backoff() {
+				break
+			}
+			log.Warn("Fetch timed out, retrying with fewer documents", "error", err, "limit", sizer.Limit())
+		}
 		if err != nil {
-			return 0, fmt.Errorf("parsing execute URL: %v", err)
+			return 0, failure.Wrap(failure.SourceFetch, err)
 		}
+		sizer.Recover()
+		defer resp.Body.Close()
 
-		// Appends the Fetch API to the BASE URI
-		parsedURL = parsedURL.JoinPath("/fetch/document/")
-
-		// Add query string parameters to the URL
-		query := parsedURL.Query()
-		query.Set("limit", fmt.Sprint(cfg.MaxDocuments))
-		query.Set("since", lastSyncDate)
-		if cfg.IncludeCalcs {
-			query.Set("calc", "true")
+		// The page after this one is downloaded while this one loads
+		if prefetching && resp.Truncated {
+			prefetch = client.Prefetch(execute.FetchRequest{
+				Path:         endpoint.Path,
+				Since:        resp.Highwater,
+				Cursor:       resp.Cursor,
+				Limit:        sizer.Limit(),
+				Types:        types,
+				IncludeCalcs: cfg.IncludeCalcs,
+			})
 		}
-		parsedURL.RawQuery = query.Encode()
 
-		// Fetch the data
-		req, err := http.NewRequest("GET", parsedURL.String(), nil)
-		if err != nil {
-			return 0, fmt.Errorf("creating request: %v", err)
+		if !fromScratch && catchup == nil {
+			if catchup, err = checkHighwater(cfg, db, ws.manifest.Source, lastSyncDate, resp.Highwater); err != nil {
+				return 0, err
+			}
 		}
 
-		// Add credentials to the request (Execute uses BASIC Auth)
-		auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", cfg.ExecuteKeyId, cfg.ExecuteKeySecret)))
-		req.Header.Set("Authorization", "Basic "+auth)
+		if batch_date == "" {
+			batch_date = batchDate(cfg, resp.Date)
 
-		log.Debug("Pulling batch from Execute")
-		resp, err := client.Do(req)
-		if err != nil {
-			return 0, fmt.Errorf("performing request: %v", err)
+			// Another process (or a retry within the same second) may already
+			// have loaded this batch_date.  Rather than collide with it, move
+			// ours along a second at a time until it's unique.
+			if reconciler, ok := db.(warehouses.Reconciler); ok {
+				if batch_date, err = uniqueBatchDate(reconciler, warehouseKey(cfg), batch_date); err != nil {
+					return 0, failure.Wrap(failure.WarehouseLoad, err)
+				}
+			}
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Debugf("HTTP error response - Status: %d, Body: %s, Headers: %v", resp.StatusCode, string(body), resp.Header)
-			return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		progress.BatchDate = batch_date
+		ws.manifest.BatchDate = batch_date
+		progress.After = resp.Highwater
+		if resp.Spool != "" {
+			progress.Spool = []string{resp.Spool}
 		}
+		progress.save(ws.dir, phaseUploading)
 
-		reader := bufio.NewReader(resp.Body)
+		body := timing.Reader(timing.Fetch, resp.Body)
+		reader := bufio.NewReader(body)
+		fetchedDocs, fetchedBytes := 0, int64(0)
 
 		// Helper function to read the next record from the reader.  Records
 		// are newline delimited
 		nextRecord := func() (map[string]interface{}, error) {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
+			// Give the garbage collector a chance to catch up before reading
+			// further when we're over MAX_MEMORY
+			memory.Check()
+			// This runs on the upload's reading goroutine while others chunk
+			// and write the documents before, so only its own fetching is
+			// taken off rather than everything recorded in the meantime
+			decodeStart, fetched := time.Now(), body.Elapsed()
+			defer func() {
+				timing.Add(timing.Decode, max(time.Since(decodeStart)-(body.Elapsed()-fetched), 0))
+			}()
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					if err == io.EOF {
+						return nil, io.EOF
+					}
+					readErr = err
+					return nil, err
+				}
+				fetchedDocs++
+				fetchedBytes += int64(len(line))
+
+				// Numbers are kept as json.Number so that large versions
+				// (and values in DATA) don't lose precision
+				var record map[string]interface{}
+				decoder := json.NewDecoder(strings.NewReader(line))
+				decoder.UseNumber()
+				if err := decoder.Decode(&record); err != nil {
+					log.Infof("Error parsing JSON: %v", err)
+					return nil, nil
+				}
+				// An endpoint's documents are all loaded as its table
+				if endpoint.Table != "" {
+					record["$TYPE"] = endpoint.Table
+				}
+				documents.AddAttributes(record, attributes)
+				if transformer != nil {
+					if record, err = transformer.Apply(record); err != nil {
+						recordErr = err
+						return nil, io.EOF
+					}
+					if record == nil {
+						continue
+					}
+				}
+				if err := documents.ParseVersion(record); err != nil {
+					log.Warn("Skipping document", "type", record["$TYPE"], "id", record["DOCUMENT_ID"], "error", err)
+					continue
+				}
+				if err := sanitizer.Sanitize(record); err != nil {
+					recordErr = err
 					return nil, io.EOF
 				}
-				return nil, err
-			}
+				if anonymizer != nil {
+					anonymizer.Anonymize(record)
+				}
 
-			var record map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &record); err != nil {
-				log.Infof("Error parsing JSON: %v", err)
-				return nil, nil
+				// Older servers can't filter by type, so skip unwanted types here
+				if !resp.Filtered {
+					if docType, _ := record["$TYPE"].(string); !slices.Contains(types, docType) {
+						continue
+					}
+				}
+				if catchup != nil {
+					loaded, err := catchup.loaded(record)
+					if err != nil {
+						recordErr = err
+						return nil, io.EOF
+					}
+					if loaded {
+						continue
+					}
+				}
+				if sample != nil && !sample.keep(record) {
+					continue
+				}
+				if docType, ok := record["$TYPE"].(string); ok {
+					ws.manifest.Types[docType]++
+				}
+				if index != nil {
+					docType, _ := record["$TYPE"].(string)
+					id, _ := record["DOCUMENT_ID"].(string)
+					deleted, _ := record["$DELETED"].(bool)
+					if err := index.Add(docType, id, documents.Version(record), deleted); err != nil {
+						recordErr = failure.Errorf(failure.State, "indexing document %s: %v", id, err)
+						return nil, io.EOF
+					}
+				}
+				return record, nil
 			}
-			return record, nil
 		}
 
-		// Upload all documents in this batch.  Note that we're passing in a
-		// reader callback so that we're not assembling all these documents in
-		// memory since this can easily become very large.
+		// Warehouses which can be reconciled get a control record closing
+		// each upload, noting how many documents and chunks were sent
 		log.Debug("Uploading batch to warehouse")
-		cnt, err := db.Upload(batch_date, nextRecord)
+		var cnt int
+		uploadStart, uploadBefore := time.Now(), timing.Snapshot()
+		if streams > 1 {
+			cnt, err = uploadByType(db, batch_date, runID, &part, cfg.ChunkSize, cfg.ChunkWorkers, streams, nextRecord)
+		} else {
+			part++
+			cnt, err = upload(db, batch_date, runID, part, cfg.ChunkSize, cfg.ChunkWorkers, nextRecord)
+		}
+		timing.Remainder(timing.Upload, uploadStart, uploadBefore)
+		if err != nil && readErr != nil {
+			return 0, failure.Wrap(failure.SourceFetch, err)
+		}
 		if err != nil {
-			return 0, err
+			return 0, failure.Wrap(failure.WarehouseLoad, err)
+		}
+		if recordErr != nil {
+			return 0, recordErr
 		}
 
+		sizer.Observe(fetchedDocs, fetchedBytes)
+
 		// Increase our global document count
 		document_count += cnt
 
 		// Assuming we made it this far, lets store the returned sync highwater
 		// mark so that we can avoid these records on future syncs
-		lastSyncDate = resp.Header.Get("X-Sync-Highwater-Mark")
+		lastSyncDate = resp.Highwater
 		log.Debugf("Storing last sync date = %s", lastSyncDate)
 		saveLastSyncDate(cfg.StateDir, lastSyncDate)
+		progress.save(ws.dir, phaseSaved)
+		if sample != nil {
+			sample.save()
+		}
+		if index != nil {
+			if err := index.Commit(); err != nil {
+				log.Warn("Unable to update the document index", "error", err)
+			}
+		}
+		if cfg.SpoolFetch {
+			execute.ClearSpooled(cfg.StateDir)
+		}
+		if catchup != nil {
+			if catchup.caughtUp(lastSyncDate) {
+				log.Info("Caught up with the highwater mark", "until", catchup.Until, "skipped", catchup.Skipped)
+				catchup.clear(cfg.StateDir)
+				catchup = nil
+			} else {
+				catchup.save(cfg.StateDir)
+			}
+		}
 
 		// If we the result set we pulled is complete, we can break and avoid further iterations
-		if strings.ToUpper(resp.Header.Get("X-Sync-Truncated")) == "FALSE" {
+		if !resp.Truncated {
 			break
 		}
+
+		// Servers supporting cursors tell us exactly where to carry on from
+		cursor = resp.Cursor
+	}
+
+	if sample != nil {
+		log.Info("Sampled documents", "kept", sample.kept, "skipped", sample.skipped)
+	}
+
+	// Stats are collected once the whole batch has loaded, a failure only
+	// costs the report its figures
+	if collector, ok := db.(warehouses.StatsCollector); ok && cfg.CollectStats && document_count > 0 {
+		if err := collector.CollectStats(); err != nil {
+			log.Warn("Unable to collect stats", "error", err)
+		}
 	}
 
+	// A run which fails leaves its checkpoint (in its workspace) for the next
+	// one to recover
+	clearCheckpoint(ws.dir)
+
 	// Return the number of documents successfully processed
 	return document_count, nil
 }
 
+// waitForPrefetch returns the page p downloaded ahead, if it's the one
+// starting at since (or cursor) and its download succeeded; otherwise the
+// page is fetched as usual.  Only the time spent waiting on the download
+// counts as fetching, as the rest overlapped the upload before.
+func waitForPrefetch(p *execute.Prefetch, since string, cursor string) *execute.FetchResponse {
+	if p.Request.Since != since || p.Request.Cursor != cursor {
+		return nil
+	}
+	start := time.Now()
+	resp, err := p.Wait()
+	timing.Since(timing.Fetch, start)
+	if err != nil {
+		log.Debug("Prefetch failed, fetching the page again", "error", err)
+		return nil
+	}
+	return resp
+}
+
+// batchDate returns the batch_date for a run.  Execute's clock (from the Date
+// header of its response) is preferred so that batch dates line up with the
+// highwater marks it hands out, falling back to the local clock.  A warning is
+// logged when the two disagree by more than CLOCK_SKEW_WARNING seconds.
+func batchDate(cfg config.Config, serverDate time.Time) string {
+	now := time.Now().UTC()
+	if serverDate.IsZero() {
+		log.Debug("Execute didn't send a Date header, using the local clock for the batch date")
+		return now.Format("2006-01-02T15:04:05Z")
+	}
+
+	skew := now.Sub(serverDate).Round(time.Second)
+	if cfg.ClockSkewWarning > 0 && skew.Abs() > time.Duration(cfg.ClockSkewWarning)*time.Second {
+		log.Warn("Local clock differs from Execute's", "skew", skew, "local", now.Format(time.RFC3339), "execute", serverDate.UTC().Format(time.RFC3339))
+	}
+	return serverDate.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// maxBatchDateShift bounds how far uniqueBatchDate will move a batch_date.
+const maxBatchDateShift = 60
+
+// claimedBatchDates are the batch dates this process has used in each
+// warehouse (see warehouseKey).  Sources syncing in parallel can't see each
+// other's batches in the warehouse until they've loaded.  The channel holds
+// the map, and so acts as its lock.
+var claimedBatchDates = func() chan map[string]bool {
+	c := make(chan map[string]bool, 1)
+	c <- map[string]bool{}
+	return c
+}()
+
+// uniqueBatchDate returns the first batch_date, starting at batch_date and
+// counting up a second at a time, which hasn't already been loaded into the
+// warehouse.
+func uniqueBatchDate(reconciler warehouses.Reconciler, warehouse string, batch_date string) (string, error) {
+	date, err := time.Parse("2006-01-02T15:04:05Z", batch_date)
+	if err != nil {
+		return "", err
+	}
+	claimed := <-claimedBatchDates
+	defer func() { claimedBatchDates <- claimed }()
+	for i := 0; i < maxBatchDateShift; i++ {
+		candidate := date.Add(time.Duration(i) * time.Second).Format("2006-01-02T15:04:05Z")
+		exists := claimed[warehouse+" "+candidate]
+		if !exists {
+			if exists, err = reconciler.BatchExists(candidate); err != nil {
+				return "", err
+			}
+		}
+		if !exists {
+			claimed[warehouse+" "+candidate] = true
+			if i > 0 {
+				log.Warn("Batch already loaded, using a later batch date", "batch", batch_date, "using", candidate)
+			}
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("batch %s and the following %d seconds have already been loaded", batch_date, maxBatchDateShift-1)
+}
+
+// warehouseKey identifies the warehouse a configuration loads, for telling
+// apart the batch dates claimed in each of the DATABASE_TARGETS.
+func warehouseKey(cfg config.Config) string {
+	return cfg.DatabaseType + " " + cfg.DatabaseDSN + " " + cfg.DatabaseSchema
+}
+
+// newRunID returns a random identifier for a sync run, recorded in each
+// batch's control record.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// documentTypes parses the comma separated DOCUMENT_TYPES setting.
+func documentTypes(setting string) []string {
+	var types []string
+	for _, t := range strings.Split(setting, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
 func loadLastSyncDate(basePath string) string {
 	filePath := filepath.Join(basePath, "last_sync_date.txt")
 	data, err := os.ReadFile(filePath)
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/afenav/execute-sync/src/internal/timing"
+	"github.com/charmbracelet/log"
+)
+
+func init() {
+	// The process's totals; each run's share is in its "Run timings" log
+	expvar.Publish("timings", expvar.Func(func() any {
+		return timing.Snapshot()
+	}))
+}
+
+// startDebugServer serves pprof and expvar on addr (DEBUG_ADDR) for the life
+// of a sync daemon, so that a slow one can be profiled where it runs.  They're
+// served from a mux of their own rather than http.DefaultServeMux, and should
+// be bound to localhost or an otherwise private address.
+func startDebugServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Info("Serving pprof and expvar", "address", "http://"+listener.Addr().String()+"/debug/")
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Warn("Debug server stopped", "error", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/notify"
+	"github.com/charmbracelet/log"
+	"github.com/robfig/cron/v3"
+)
+
+const digestFile = "digest.json"
+
+// digest rolls up the sync daemon's iterations between notifications.  It's
+// kept in STATE_DIR so that a restart doesn't lose the day's figures.
+type digest struct {
+	Since         time.Time     `json:"since"`
+	Runs          int           `json:"runs"`
+	Failures      int           `json:"failures"`
+	Unavailable   int           `json:"unavailable"` // runs which found Execute down for maintenance
+	Documents     int           `json:"documents"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastErrorCode failure.Kind  `json:"last_error_code,omitempty"`
+	LastSuccess   time.Time     `json:"last_success,omitzero"`
+	MaxLag        time.Duration `json:"max_lag_ns"` // longest time without a successful sync
+	Prunes        int           `json:"prunes"`
+	PruneFailures int           `json:"prune_failures"`
+}
+
+// lag returns how long it's been, as of now, since the last successful sync
+// (or the start of the digest).
+func (d *digest) lag(now time.Time) time.Duration {
+	if d.LastSuccess.After(d.Since) {
+		return now.Sub(d.LastSuccess)
+	}
+	return now.Sub(d.Since)
+}
+
+// digestSchedule sends a digest of the sync daemon's progress every
+// DIGEST_EVERY (a duration, or a cron expression) to DIGEST_URL and/or by
+// email, so that people hear how it's going once a day rather than never, or
+// on every iteration.  With NOTIFY_FAILURES, the first of a run of failed
+// syncs is also notified straight away.
+type digestSchedule struct {
+	stateDir      string
+	every         time.Duration
+	cron          cron.Schedule
+	notifier      notify.Notifier
+	alertFailures bool
+	failing       bool
+	digest        digest
+}
+
+// newNotifier returns the configured webhook and/or email notifiers, or nil
+// when there are none.
+func newNotifier(cfg config.Config) (notify.Notifier, error) {
+	var notifiers notify.Multi
+	if cfg.DigestURL != "" {
+		notifiers = append(notifiers, notify.NewWebhook(cfg.DigestURL))
+	}
+	if cfg.SMTPHost != "" {
+		var to []string
+		for _, addr := range strings.Split(cfg.SMTPTo, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		smtp, err := notify.NewSMTP(notify.SMTPOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			TLS:      cfg.SMTPTLS,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			To:       to,
+			Subject:  cfg.SMTPSubject,
+			Body:     cfg.SMTPBody,
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, smtp)
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	return notifiers, nil
+}
+
+// newDigestSchedule returns the configured schedule, or nil when there's
+// nowhere to send notifications.
+func newDigestSchedule(cfg config.Config) (*digestSchedule, error) {
+	notifier, err := newNotifier(cfg)
+	if notifier == nil || err != nil {
+		return nil, err
+	}
+	every, schedule, err := parseEvery("DIGEST_EVERY", cfg.DigestEvery)
+	if err != nil {
+		return nil, err
+	}
+	s := &digestSchedule{
+		stateDir:      cfg.StateDir,
+		every:         every,
+		cron:          schedule,
+		notifier:      notifier,
+		alertFailures: cfg.NotifyFailures,
+	}
+	if data, err := os.ReadFile(filepath.Join(cfg.StateDir, digestFile)); err == nil {
+		if err := json.Unmarshal(data, &s.digest); err != nil {
+			log.Warnf("Ignoring unreadable digest: %v", err)
+		}
+	}
+	if s.digest.Since.IsZero() {
+		s.digest = digest{Since: time.Now().UTC()}
+		s.save()
+	}
+	return s, nil
+}
+
+// recordSync adds a sync iteration to the digest.
+func (s *digestSchedule) recordSync(documents int, err error) {
+	now := time.Now().UTC()
+	s.digest.Runs++
+	if _, unavailable := execute.Unavailable(err); unavailable {
+		// Maintenance isn't a failure; outages are notified by themselves
+		s.digest.Unavailable++
+	} else if err != nil {
+		s.digest.Failures++
+		s.digest.LastError = err.Error()
+		s.digest.LastErrorCode = failure.KindOf(err)
+		if s.alertFailures && !s.failing {
+			s.alert(err)
+		}
+		s.failing = true
+	} else {
+		s.failing = false
+		s.digest.MaxLag = max(s.digest.MaxLag, s.digest.lag(now))
+		s.digest.Documents += documents
+		s.digest.LastSuccess = now
+	}
+	s.save()
+}
+
+// recordPrune adds a scheduled prune to the digest.
+func (s *digestSchedule) recordPrune(err error) {
+	s.digest.Prunes++
+	if err != nil {
+		s.digest.PruneFailures++
+	}
+	s.save()
+}
+
+// alert notifies the failure of a sync.
+func (s *digestSchedule) alert(err error) {
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("execute-sync on %s: sync failed", host)
+	code := failure.KindOf(err)
+	text := fmt.Sprintf("%s\n%s (%s)\nFurther failures are reported in the digest until a sync succeeds.", subject, err, code)
+	if err := s.notifier.Notify(notify.Message{Kind: notify.Failure, Subject: subject, Text: text, Data: map[string]string{"error": err.Error(), "code": string(code)}}); err != nil {
+		log.Warnf("Error sending failure notification: %v", err)
+	}
+}
+
+// sendIfDue sends the digest if it's due, then starts the next one.  A digest
+// which can't be sent is kept, and sent along with the next one.
+func (s *digestSchedule) sendIfDue() {
+	now := time.Now().UTC()
+	switch {
+	case s.every == 0 && s.cron == nil:
+		return // DIGEST_EVERY=0 only sends failure notifications
+	case s.every > 0 && now.Sub(s.digest.Since) < s.every:
+		return
+	case s.cron != nil && s.cron.Next(s.digest.Since).After(now):
+		return
+	}
+
+	d := s.digest
+	d.MaxLag = max(d.MaxLag, d.lag(now))
+	if err := s.notifier.Notify(d.message(now)); err != nil {
+		log.Warnf("Error sending digest: %v", err)
+		return
+	}
+	log.Info("Sent digest", "runs", d.Runs, "failures", d.Failures, "documents", d.Documents)
+	s.digest = digest{Since: now, LastSuccess: d.LastSuccess}
+	s.save()
+}
+
+func (s *digestSchedule) save() {
+	data, _ := json.MarshalIndent(s.digest, "", "  ")
+	if err := os.WriteFile(filepath.Join(s.stateDir, digestFile), data, 0644); err != nil {
+		log.Warnf("Error saving digest: %v", err)
+	}
+}
+
+// message summarises the digest for people.
+func (d digest) message(now time.Time) notify.Message {
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("execute-sync on %s: %d runs, %d failed, %d documents", host, d.Runs, d.Failures, d.Documents)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", subject)
+	fmt.Fprintf(&b, "Period: %s to %s\n", d.Since.Format(time.RFC3339), now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Longest without a successful sync: %s\n", d.MaxLag.Round(time.Second))
+	if !d.LastSuccess.IsZero() {
+		fmt.Fprintf(&b, "Last successful sync: %s\n", d.LastSuccess.Format(time.RFC3339))
+	}
+	if d.Unavailable > 0 {
+		fmt.Fprintf(&b, "Runs finding Execute unavailable: %d\n", d.Unavailable)
+	}
+	if d.Prunes > 0 {
+		fmt.Fprintf(&b, "Scheduled prunes: %d, %d failed\n", d.Prunes, d.PruneFailures)
+	}
+	if d.LastError != "" {
+		fmt.Fprintf(&b, "Last error: %s\n", d.LastError)
+	}
+	return notify.Message{Kind: notify.Digest, Subject: subject, Text: strings.TrimSpace(b.String()), Data: d}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/afenav/execute-sync/src/internal/warehouses/archive"
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// fanOutCommands load, or maintain, every one of the DATABASE_TARGETS.  The
+// other commands only use the first.
+var fanOutCommands = map[string]bool{
+	"sync":         true,
+	"push":         true,
+	"clone":        true,
+	"load":         true,
+	"create_views": true,
+	"prune":        true,
+}
+
+// fanOut loads every batch into each of the DATABASE_TARGETS.  A batch is
+// archived once, then queued for each target in a backlog directory of its
+// own under STATE_DIR and removed from that backlog once it's loaded there.
+// A target which fails keeps its backlog, which the next sync loads ahead of
+// newer batches, so an outage in one warehouse neither holds up the others
+// nor loses any documents.
+type fanOut struct {
+	staging string
+	targets []*fanOutTarget
+	runID   string
+}
+
+// fanOutTarget is one of the warehouses a fanOut loads.
+type fanOutTarget struct {
+	label   string
+	cfg     config.Config
+	db      warehouses.Database
+	backlog string
+	loader  *archiveLoader
+	err     error // of its last load
+}
+
+// openFanOut connects to each of the DATABASE_TARGETS, with its deploy DSN
+// for the commands which use one.
+func openFanOut(cfg config.Config, command string, tags ...string) (*fanOut, error) {
+	targets, err := config.Targets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	f := &fanOut{staging: filepath.Join(cfg.StateDir, "targets", ".staging")}
+	for _, target := range targets {
+		targetCfg := target.Apply(cfg)
+		if deployCommands[command] && targetCfg.DatabaseDeployDSN != "" {
+			targetCfg.DatabaseDSN = targetCfg.DatabaseDeployDSN
+		}
+		db, err := openDatabase(targetCfg, command, append(tags, "target", target.Label)...)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %v", target.Label, err)
+		}
+		f.targets = append(f.targets, &fanOutTarget{
+			label:   target.Label,
+			cfg:     targetCfg,
+			db:      db,
+			backlog: filepath.Join(cfg.StateDir, "targets", strings.ToLower(target.Label)),
+			loader:  newArchiveLoader(targetCfg, db),
+		})
+	}
+	return f, nil
+}
+
+// Upload archives the batch, queues it for every target and then loads each
+// target's backlog.  It only fails if the batch couldn't be queued; targets
+// which fail to load are left for backlogged to report once the run is over,
+// so that the highwater mark still moves on for the others.
+func (f *fanOut) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	// Anything left in staging is from an upload which failed before it was
+	// queued, and so will be fetched again
+	if err := os.RemoveAll(f.staging); err != nil {
+		return 0, err
+	}
+	staging, err := archive.NewArchive(f.staging)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := staging.Upload(batch_date, stream)
+	if err != nil {
+		return 0, err
+	}
+	batches, err := archive.Pending(f.staging)
+	if err != nil {
+		return 0, err
+	}
+	for _, batch := range batches {
+		for _, t := range f.targets {
+			if err := batch.CopyTo(t.backlog); err != nil {
+				return 0, fmt.Errorf("queueing %s for %s: %v", batch.File, t.label, err)
+			}
+		}
+		if err := batch.Remove(); err != nil {
+			return 0, err
+		}
+	}
+
+	f.load()
+	return cnt, nil
+}
+
+// load loads every target's backlog at the same time.
+func (f *fanOut) load() {
+	runID := f.runID
+	if runID == "" {
+		runID = newRunID()
+	}
+	var g errgroup.Group
+	for _, t := range f.targets {
+		g.Go(func() error {
+			var cnt int
+			cnt, t.err = t.loader.load(t.backlog, runID)
+			if t.err != nil {
+				log.Warn("Target failed, its backlog will be loaded by the next sync", "target", t.label, "error", t.err)
+				return nil
+			}
+			// Stats are collected once the target has caught up
+			if collector, ok := t.db.(warehouses.StatsCollector); ok && t.cfg.CollectStats && cnt > 0 {
+				if err := collector.CollectStats(); err != nil {
+					log.Warn("Unable to collect stats", "target", t.label, "error", err)
+				}
+			}
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// backlogged returns an error naming the targets with batches still waiting
+// to be loaded, or nil once every target has caught up.
+func (f *fanOut) backlogged() error {
+	var problems []string
+	for _, t := range f.targets {
+		pending, err := archive.Pending(t.backlog)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		problem := fmt.Sprintf("%s has %d batches waiting to load", t.label, len(pending))
+		if t.err != nil {
+			problem += fmt.Sprintf(" (%v)", t.err)
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// SetQueryTag tags each target's statements, and notes the sync's run ID for
+// the control records of the batches it loads.
+func (f *fanOut) SetQueryTag(name string, value string) error {
+	if name == "run" {
+		f.runID = value
+	}
+	for _, t := range f.targets {
+		if tagger, ok := t.db.(warehouses.QueryTagger); ok {
+			if err := tagger.SetQueryTag(name, value); err != nil {
+				return fmt.Errorf("%s: %v", t.label, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Prune prunes every target, carrying on past any which fail.
+func (f *fanOut) Prune() error {
+	return f.each(func(t *fanOutTarget) error {
+		return t.db.Prune()
+	})
+}
+
+// PruneBefore removes old batches from every target, carrying on past any
+// which fail.
+func (f *fanOut) PruneBefore(cutoff time.Time) error {
+	return f.each(func(t *fanOutTarget) error {
+		pruner, ok := t.db.(warehouses.RetentionPruner)
+		if !ok {
+			return fmt.Errorf("%s targets can't remove batches by age", t.cfg.DatabaseType)
+		}
+		return pruner.PruneBefore(cutoff)
+	})
+}
+
+// CreateViews creates the views in every target, carrying on past any which
+// fail.
+func (f *fanOut) CreateViews(root execute.RootSchema) error {
+	return f.each(func(t *fanOutTarget) error {
+		return t.db.CreateViews(root)
+	})
+}
+
+// CreateRelationships creates the relationships view in every target which
+// has one.
+func (f *fanOut) CreateRelationships(root execute.RootSchema) error {
+	return f.each(func(t *fanOutTarget) error {
+		if creator, ok := t.db.(warehouses.RelationshipCreator); ok {
+			return creator.CreateRelationships(root)
+		}
+		return nil
+	})
+}
+
+// ValidateViews validates the views of every target which can, naming each
+// broken view after its target.
+func (f *fanOut) ValidateViews(root execute.RootSchema) (map[string]error, error) {
+	broken := map[string]error{}
+	err := f.each(func(t *fanOutTarget) error {
+		validator, ok := t.db.(warehouses.ViewValidator)
+		if !ok {
+			return nil
+		}
+		views, err := validator.ValidateViews(root)
+		for name, viewErr := range views {
+			broken[t.label+": "+name] = viewErr
+		}
+		return err
+	})
+	return broken, err
+}
+
+// each runs action on every target in turn, and returns their errors.
+func (f *fanOut) each(action func(t *fanOutTarget) error) error {
+	var errs []error
+	for _, t := range f.targets {
+		if err := action(t); err != nil {
+			log.Error("Target failed", "target", t.label, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", t.label, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/archive"
+)
+
+// recordingTarget is a warehouse which remembers the batches loaded into it,
+// or fails every upload while down.
+type recordingTarget struct {
+	down    bool
+	batches []string // the document IDs of each batch, in the order loaded
+}
+
+func (r *recordingTarget) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	docs := stream.Prepare(0)
+	defer docs.Close()
+	var ids []string
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, doc.Data["DOCUMENT_ID"].(string))
+	}
+	if r.down {
+		return 0, errors.New("warehouse unavailable")
+	}
+	r.batches = append(r.batches, strings.Join(ids, ","))
+	return len(ids), nil
+}
+
+func (r *recordingTarget) Prune() error                         { return nil }
+func (r *recordingTarget) CreateViews(execute.RootSchema) error { return nil }
+
+// testFanOut returns a fanOut over the targets, keeping its state in a
+// temporary directory.
+func testFanOut(t *testing.T, targets map[string]*recordingTarget) *fanOut {
+	t.Helper()
+	dir := t.TempDir()
+	f := &fanOut{staging: filepath.Join(dir, ".staging"), runID: "test"}
+	for label, db := range targets {
+		f.targets = append(f.targets, &fanOutTarget{
+			label:   label,
+			db:      db,
+			backlog: filepath.Join(dir, strings.ToLower(label)),
+			loader:  newArchiveLoader(config.Config{}, db),
+		})
+	}
+	return f
+}
+
+// batch returns a stream of documents with the given IDs.
+func batch(ids ...string) *documents.Stream {
+	return documents.NewStream(func() (map[string]interface{}, error) {
+		if len(ids) == 0 {
+			return nil, io.EOF
+		}
+		id := ids[0]
+		ids = ids[1:]
+		return map[string]interface{}{"$TYPE": "AFE", "DOCUMENT_ID": id, "$VERSION": int64(1), "$DATE": "2024-01-01T00:00:00Z", "$DELETED": false}, nil
+	}, 1)
+}
+
+func TestFanOutReplaysFailedTargetsBacklog(t *testing.T) {
+	primary, replica := &recordingTarget{}, &recordingTarget{down: true}
+	f := testFanOut(t, map[string]*recordingTarget{"PRIMARY": primary, "REPLICA": replica})
+
+	if _, err := f.Upload("2024-01-01T00:00:00Z", batch("1", "2")); err != nil {
+		t.Fatalf("upload failed although one target succeeded: %v", err)
+	}
+	if !reflect.DeepEqual(primary.batches, []string{"1,2"}) {
+		t.Fatalf("expected the healthy target to load the batch, got %v", primary.batches)
+	}
+	err := f.backlogged()
+	if err == nil || !strings.Contains(err.Error(), "REPLICA has 1 batches waiting") || strings.Contains(err.Error(), "PRIMARY") {
+		t.Fatalf("expected only the failed target to be backlogged, got %v", err)
+	}
+
+	// Once it's back, the failed target catches up in order before the new
+	// batch, while the healthy one only loads the new batch
+	replica.down = false
+	if _, err := f.Upload("2024-01-02T00:00:00Z", batch("3")); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replica.batches, []string{"1,2", "3"}) {
+		t.Fatalf("expected the backlog to be replayed ahead of the new batch, got %v", replica.batches)
+	}
+	if !reflect.DeepEqual(primary.batches, []string{"1,2", "3"}) {
+		t.Fatalf("expected the healthy target to load each batch once, got %v", primary.batches)
+	}
+	if err := f.backlogged(); err != nil {
+		t.Fatalf("expected every target to have caught up, got %v", err)
+	}
+	for _, target := range f.targets {
+		if pending, _ := archive.Pending(target.backlog); len(pending) > 0 {
+			t.Fatalf("%s still has %d batches queued", target.label, len(pending))
+		}
+	}
+}
+
+func TestFanOutKeepsBacklogWhileTargetIsDown(t *testing.T) {
+	replica := &recordingTarget{down: true}
+	f := testFanOut(t, map[string]*recordingTarget{"REPLICA": replica})
+
+	for _, date := range []string{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"} {
+		if _, err := f.Upload(date, batch(date)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := archive.Pending(f.targets[0].backlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].BatchDate != "2024-01-01T00:00:00Z" || pending[1].BatchDate != "2024-01-02T00:00:00Z" {
+		t.Fatalf("expected both batches queued in order, got %+v", pending)
+	}
+	if err := f.backlogged(); err == nil || !strings.Contains(err.Error(), "warehouse unavailable") {
+		t.Fatalf("expected the backlog to be reported with the target's error, got %v", err)
+	}
+}
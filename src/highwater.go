@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/notify"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+)
+
+const catchUpFile = "highwater_catchup.json"
+
+// highwaterError is returned by a sync which Execute handed an earlier
+// highwater mark than it asked from, with HIGHWATER_REGRESSION=fail.
+type highwaterError struct {
+	stored, returned string
+}
+
+func (e highwaterError) Error() string {
+	return fmt.Sprintf("Execute returned highwater mark %s, earlier than the stored %s; the mark was left alone (see HIGHWATER_REGRESSION)", e.returned, e.stored)
+}
+
+// Failure classifies a regression as a state failure: the stored mark
+// disagrees with Execute.
+func (e highwaterError) Failure() failure.Kind {
+	return failure.State
+}
+
+// highwaterLayouts are the forms of highwater mark which can be compared.
+var highwaterLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", time.DateOnly}
+
+// highwaterBefore reports whether highwater mark a is earlier than b.  Marks
+// which aren't timestamps can't be compared, and never are.
+func highwaterBefore(a string, b string) bool {
+	parse := func(mark string) (time.Time, bool) {
+		for _, layout := range highwaterLayouts {
+			if t, err := time.Parse(layout, mark); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	at, aok := parse(a)
+	bt, bok := parse(b)
+	if !aok || !bok {
+		log.Debug("Can't compare highwater marks", "a", a, "b", b)
+		return false
+	}
+	return at.Before(bt)
+}
+
+// checkHighwater looks for Execute handing back an earlier highwater mark than
+// the one a fetch asked from (i.e. after the server was restored from a
+// backup, or its clock was wound back), which would have the next syncs load
+// every document since again.  It's logged and notified, then dealt with as
+// HIGHWATER_REGRESSION says: fail the run without touching the stored mark,
+// warn and carry on, or reconcile, carrying on from the earlier mark but
+// skipping documents the warehouse already holds until the fetch gets back
+// to the stored one.
+func checkHighwater(cfg config.Config, db warehouses.Database, source string, requested string, returned string) (*catchUp, error) {
+	if returned == "" || !highwaterBefore(returned, requested) {
+		return nil, nil
+	}
+	log.Error("Highwater mark went backwards", "stored", requested, "returned", returned, "action", cfg.HighwaterRegression)
+	notifyRegression(cfg, source, requested, returned)
+
+	switch cfg.HighwaterRegression {
+	case "warn":
+		return nil, nil
+	case "reconcile":
+		lister, ok := db.(warehouses.VersionLister)
+		if !ok {
+			return nil, fmt.Errorf("%s targets can't list their documents, so can't reconcile after the highwater mark went backwards", cfg.DatabaseType)
+		}
+		c := &catchUp{Until: requested, Since: returned, Detected: time.Now().UTC().Format(time.RFC3339), lister: lister}
+		c.save(cfg.StateDir)
+		log.Warn("Reconciling with the warehouse until the fetch catches up", "until", requested)
+		return c, nil
+	default:
+		return nil, highwaterError{stored: requested, returned: returned}
+	}
+}
+
+// notifyRegression sends a regressed highwater mark through DIGEST_URL and/or
+// SMTP, when they're configured.
+func notifyRegression(cfg config.Config, source string, stored string, returned string) {
+	notifier, err := newNotifier(cfg)
+	if notifier == nil || err != nil {
+		return
+	}
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("execute-sync on %s: highwater mark went backwards", host)
+	if source != "" {
+		subject += " for " + source
+	}
+	text := fmt.Sprintf("%s\nExecute returned %s, earlier than the stored %s (HIGHWATER_REGRESSION=%s)", subject, returned, stored, cfg.HighwaterRegression)
+	data := map[string]interface{}{"source": source, "stored": stored, "returned": returned, "action": cfg.HighwaterRegression}
+	if err := notifier.Notify(notify.Message{Kind: notify.Regression, Subject: subject, Text: text, Data: data}); err != nil {
+		log.Warnf("Error sending highwater notification: %v", err)
+	}
+}
+
+// catchUp is the reconciliation following a regressed highwater mark
+// (HIGHWATER_REGRESSION=reconcile).  It's kept in STATE_DIR until a fetch's
+// highwater mark passes Until, so that it carries on across runs.
+type catchUp struct {
+	Until    string `json:"until"` // the stored highwater mark when it went backwards
+	Since    string `json:"since"` // the mark Execute returned instead
+	Detected string `json:"detected"`
+	Skipped  int    `json:"skipped"` // documents the warehouse already held
+
+	lister warehouses.VersionLister
+	latest map[string]map[string]int64 // by type, then document ID
+}
+
+// loadCatchUp returns the reconciliation in progress, if there is one.
+func loadCatchUp(cfg config.Config, db warehouses.Database) (*catchUp, error) {
+	data, err := os.ReadFile(filepath.Join(cfg.StateDir, catchUpFile))
+	if err != nil {
+		return nil, nil
+	}
+	c := &catchUp{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, failure.Errorf(failure.State, "reading %s: %v", catchUpFile, err)
+	}
+	var ok bool
+	if c.lister, ok = db.(warehouses.VersionLister); !ok {
+		return nil, fmt.Errorf("%s targets can't list their documents, so can't carry on reconciling (remove %s to give up)", cfg.DatabaseType, catchUpFile)
+	}
+	return c, nil
+}
+
+// loaded reports whether the warehouse already holds this version of a
+// document, or a later one.  The warehouse's versions are read a document
+// type at a time, once per run.
+func (c *catchUp) loaded(record map[string]interface{}) (bool, error) {
+	docType, _ := record["$TYPE"].(string)
+	id, _ := record["DOCUMENT_ID"].(string)
+	if c.latest == nil {
+		c.latest = map[string]map[string]int64{}
+	}
+	versions, ok := c.latest[docType]
+	if !ok {
+		var err error
+		if versions, err = c.lister.LatestVersions(docType); err != nil {
+			return false, err
+		}
+		c.latest[docType] = versions
+	}
+	latest, ok := versions[id]
+	if !ok || documents.Version(record) > latest {
+		return false, nil
+	}
+	c.Skipped++
+	return true, nil
+}
+
+// caughtUp reports whether a fetch returning highwater mark has got back to
+// the mark stored before the regression.
+func (c *catchUp) caughtUp(highwater string) bool {
+	return highwater != "" && !highwaterBefore(highwater, c.Until)
+}
+
+func (c *catchUp) save(basePath string) {
+	data, _ := json.MarshalIndent(c, "", "  ")
+	if err := os.WriteFile(filepath.Join(basePath, catchUpFile), data, 0644); err != nil {
+		log.Warnf("Error saving highwater catch-up: %v", err)
+	}
+}
+
+func (c *catchUp) clear(basePath string) {
+	if err := os.Remove(filepath.Join(basePath, catchUpFile)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Error removing highwater catch-up: %v", err)
+	}
+}
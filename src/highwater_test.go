@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// listingTarget is a warehouse holding the given latest versions of AFEs.
+type listingTarget struct {
+	recordingTarget
+	versions map[string]int64
+}
+
+func (l *listingTarget) LatestVersions(docType string) (map[string]int64, error) {
+	if docType != "AFE" {
+		return map[string]int64{}, nil
+	}
+	return l.versions, nil
+}
+
+func TestHighwaterBefore(t *testing.T) {
+	for _, tc := range []struct {
+		a, b   string
+		before bool
+	}{
+		{"2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z", true},
+		{"2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z", false},
+		{"2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", false},
+		{"2024-01-01 10:00:00.5", "2024-01-01T10:00:01", true},
+		{"2023-12-31", "2024-01-01T00:00:00Z", true},
+		{"17", "2024-01-01T00:00:00Z", false}, // can't be compared
+	} {
+		if got := highwaterBefore(tc.a, tc.b); got != tc.before {
+			t.Errorf("highwaterBefore(%q, %q) = %v, expected %v", tc.a, tc.b, got, tc.before)
+		}
+	}
+}
+
+func TestCheckHighwaterFailsOnRegression(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), HighwaterRegression: "fail"}
+
+	if c, err := checkHighwater(cfg, &recordingTarget{}, "", "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"); c != nil || err != nil {
+		t.Fatalf("expected a later mark to be accepted, got %v, %v", c, err)
+	}
+	if c, err := checkHighwater(cfg, &recordingTarget{}, "", "2024-01-01T00:00:00Z", ""); c != nil || err != nil {
+		t.Fatalf("expected no mark to be accepted, got %v, %v", c, err)
+	}
+
+	_, err := checkHighwater(cfg, &recordingTarget{}, "", "2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z")
+	var regression highwaterError
+	if !errors.As(err, &regression) {
+		t.Fatalf("expected a highwater error, got %v", err)
+	}
+	if failure.KindOf(err) != failure.State {
+		t.Fatalf("expected a regression to be a state failure, got %v", failure.KindOf(err))
+	}
+
+	cfg.HighwaterRegression = "warn"
+	if c, err := checkHighwater(cfg, &recordingTarget{}, "", "2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z"); c != nil || err != nil {
+		t.Fatalf("expected warn to carry on, got %v, %v", c, err)
+	}
+}
+
+func TestCheckHighwaterReconcileNeedsVersionLister(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), HighwaterRegression: "reconcile", DatabaseType: "KAFKA"}
+	if _, err := checkHighwater(cfg, &recordingTarget{}, "", "2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z"); err == nil {
+		t.Fatal("expected reconciling to fail for a target which can't list its documents")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, catchUpFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected no catch-up to be saved, got %v", err)
+	}
+}
+
+func TestCatchUpSkipsLoadedDocumentsAcrossRuns(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), HighwaterRegression: "reconcile"}
+	db := &listingTarget{versions: map[string]int64{"1": 3, "2": 1}}
+
+	c, err := checkHighwater(cfg, db, "", "2024-01-10T00:00:00Z", "2024-01-01T00:00:00Z")
+	if err != nil || c == nil {
+		t.Fatalf("expected a catch-up, got %v, %v", c, err)
+	}
+
+	// The next run carries on with the catch-up saved by this one
+	c, err = loadCatchUp(cfg, db)
+	if err != nil || c == nil {
+		t.Fatalf("expected the catch-up to be reloaded, got %v, %v", c, err)
+	}
+	if c.Until != "2024-01-10T00:00:00Z" || c.Since != "2024-01-01T00:00:00Z" {
+		t.Fatalf("unexpected catch-up %+v", c)
+	}
+
+	for _, tc := range []struct {
+		docType, id string
+		version     int64
+		loaded      bool
+	}{
+		{"AFE", "1", 2, true},  // older than the warehouse's
+		{"AFE", "1", 3, true},  // the warehouse's
+		{"AFE", "1", 4, false}, // newer
+		{"AFE", "3", 1, false}, // not in the warehouse
+		{"WELL", "1", 1, false},
+	} {
+		record := map[string]interface{}{"$TYPE": tc.docType, "DOCUMENT_ID": tc.id, "$VERSION": tc.version}
+		if loaded, err := c.loaded(record); err != nil || loaded != tc.loaded {
+			t.Errorf("loaded(%s %s v%d) = %v, %v; expected %v", tc.docType, tc.id, tc.version, loaded, err, tc.loaded)
+		}
+	}
+	if c.Skipped != 2 {
+		t.Fatalf("expected 2 documents skipped, got %d", c.Skipped)
+	}
+
+	if c.caughtUp("2024-01-05T00:00:00Z") || c.caughtUp("") {
+		t.Fatal("caught up before reaching the stored mark")
+	}
+	if !c.caughtUp("2024-01-10T00:00:00Z") || !c.caughtUp("2024-01-11T00:00:00Z") {
+		t.Fatal("not caught up after reaching the stored mark")
+	}
+	c.clear(cfg.StateDir)
+	if c, err := loadCatchUp(cfg, db); c != nil || err != nil {
+		t.Fatalf("expected the catch-up to be cleared, got %v, %v", c, err)
+	}
+}
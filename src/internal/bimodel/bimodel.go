@@ -0,0 +1,125 @@
+// Package bimodel describes the helper views as a model for BI tools: a Power
+// BI semantic model (TMDL) or Tableau data sources, with the views' columns
+// typed and joined on the DOCUMENT_IDs they share, so report builders don't
+// have to rebuild the joins by hand.
+package bimodel
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// Model is the helper views of a schema, as BI tools see them in one
+// warehouse.
+type Model struct {
+	Views         []sqlgen.ViewLineage
+	Relationships []sqlgen.Relationship
+	warehouse     warehouse
+	options       sqlgen.Options
+	params        map[string]string
+}
+
+// New describes the helper views of root in a DATABASE_TYPE warehouse, as
+// they are built with options.  Params fill in the connection details (i.e. Server or Database), which are
+// otherwise left for the report builder.
+func New(databaseType string, options sqlgen.Options, root execute.RootSchema, params map[string]string) (*Model, error) {
+	w, ok := warehouses[strings.ToUpper(databaseType)]
+	if !ok {
+		return nil, fmt.Errorf("%s targets don't have helper views for BI tools to read", databaseType)
+	}
+	for name := range params {
+		if !w.hasParam(name) {
+			return nil, fmt.Errorf("%s connections don't have a %s setting (they have %s)", databaseType, name, strings.Join(w.params, ", "))
+		}
+	}
+
+	m := &Model{warehouse: w, options: options, params: params}
+	for _, v := range options.Lineage("EXECUTE_DOCUMENTS", root) {
+		v.Name = w.fold(v.Name)
+		for i := range v.Columns {
+			v.Columns[i].Name = w.fold(v.Columns[i].Name)
+		}
+		m.Views = append(m.Views, v)
+	}
+	for _, r := range options.Relationships(root) {
+		r.View, r.Column = w.fold(r.View), w.fold(r.Column)
+		r.ReferencedView, r.ReferencedColumn = w.fold(r.ReferencedView), w.fold(r.ReferencedColumn)
+		m.Relationships = append(m.Relationships, r)
+	}
+	return m, nil
+}
+
+// param returns the value given for a connection setting.
+func (m *Model) param(name string) string {
+	return m.params[name]
+}
+
+// Column types, as BI tools distinguish them.
+const (
+	typeString   = "string"
+	typeInteger  = "integer"
+	typeDecimal  = "decimal"
+	typeBoolean  = "boolean"
+	typeDateTime = "datetime"
+)
+
+// columnType returns the type of a helper view column.
+func columnType(c sqlgen.ColumnLineage) string {
+	switch c.SourceColumn {
+	case "DELETED":
+		return typeBoolean
+	case "VERSION":
+		return typeInteger
+	case "DATE":
+		return typeDateTime
+	}
+	switch c.Type {
+	case "INTEGER":
+		return typeInteger
+	case "DECIMAL":
+		return typeDecimal
+	case "BOOLEAN":
+		return typeBoolean
+	case "DATETIME":
+		return typeDateTime
+	}
+	return typeString
+}
+
+// active returns, for each relationship, whether it can be active.  BI tools
+// only allow one path between two views, so the first relationship joining
+// two groups of views is active and any more (along with views referencing
+// themselves) are left for report builders to use where they need them.
+func active(relationships []sqlgen.Relationship) []bool {
+	group := map[string]string{}
+	var find func(view string) string
+	find = func(view string) string {
+		if parent, ok := group[view]; ok && parent != view {
+			root := find(parent)
+			group[view] = root
+			return root
+		}
+		return view
+	}
+
+	result := make([]bool, len(relationships))
+	for i, r := range relationships {
+		from, to := find(r.View), find(r.ReferencedView)
+		if from != to {
+			group[from] = to
+			result[i] = true
+		}
+	}
+	return result
+}
+
+// id returns a stable GUID-shaped identifier for name, so regenerated models
+// diff cleanly.
+func id(name string) string {
+	sum := sha1.Sum([]byte(name))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
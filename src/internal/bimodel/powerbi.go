@@ -0,0 +1,138 @@
+package bimodel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// plainName matches TMDL names which don't need quoting.
+var plainName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// tmdlName quotes a TMDL object name where it needs it.
+func tmdlName(name string) string {
+	if plainName.MatchString(name) {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// tmdlTypes are the Power BI data types of each column type.
+var tmdlTypes = map[string]string{
+	typeString:   "string",
+	typeInteger:  "int64",
+	typeDecimal:  "double",
+	typeBoolean:  "boolean",
+	typeDateTime: "dateTime",
+}
+
+// WritePowerBI writes the model to dir as a Power BI semantic model, in the
+// TMDL folder layout of a Power BI Project (.pbip).  Each helper view is
+// imported as a table, and the connection settings become parameters.
+func (m *Model) WritePowerBI(dir string) error {
+	files := map[string]string{
+		"definition.pbism":              "{\n  \"version\": \"4.0\",\n  \"settings\": {}\n}\n",
+		"definition/database.tmdl":      "database\n\tcompatibilityLevel: 1567\n",
+		"definition/model.tmdl":         m.tmdlModel(),
+		"definition/expressions.tmdl":   m.tmdlExpressions(),
+		"definition/relationships.tmdl": m.tmdlRelationships(),
+	}
+	for _, v := range m.Views {
+		files[filepath.Join("definition", "tables", v.Name+".tmdl")] = m.tmdlTable(v)
+	}
+
+	// Tables no longer in the schema are removed, so the model matches it
+	stale, _ := filepath.Glob(filepath.Join(dir, "definition", "tables", "*.tmdl"))
+	for _, path := range stale {
+		rel, _ := filepath.Rel(dir, path)
+		if _, ok := files[rel]; !ok {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFiles(dir, files)
+}
+
+func (m *Model) tmdlModel() string {
+	var b strings.Builder
+	b.WriteString("model Model\n\tculture: en-US\n\tdefaultPowerBIDataSourceVersion: powerBI_V3\n\tdiscourageImplicitMeasures\n\n")
+	for _, v := range m.Views {
+		fmt.Fprintf(&b, "ref table %s\n", tmdlName(v.Name))
+	}
+	return b.String()
+}
+
+func (m *Model) tmdlExpressions() string {
+	var b strings.Builder
+	for _, name := range m.warehouse.params {
+		fmt.Fprintf(&b, "expression %s = %s meta [IsParameterQuery=true, Type=\"Text\", IsParameterQueryRequired=true]\n", name, mString(m.param(name)))
+		fmt.Fprintf(&b, "\tlineageTag: %s\n\n", id("parameter:"+name))
+	}
+	return b.String()
+}
+
+func (m *Model) tmdlTable(v sqlgen.ViewLineage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table %s\n", tmdlName(v.Name))
+	fmt.Fprintf(&b, "\tlineageTag: %s\n", id("table:"+v.Name))
+	if v.ListPath != "" {
+		fmt.Fprintf(&b, "\tdescription: Items of %s in %s documents\n", v.ListPath, v.DocumentType)
+	} else {
+		fmt.Fprintf(&b, "\tdescription: %s documents\n", v.DocumentType)
+	}
+
+	for _, c := range v.Columns {
+		dataType := columnType(c)
+		fmt.Fprintf(&b, "\n\tcolumn %s\n", tmdlName(c.Name))
+		fmt.Fprintf(&b, "\t\tdataType: %s\n", tmdlTypes[dataType])
+		if c.SourceColumn == "" && (dataType == typeInteger || dataType == typeDecimal) {
+			b.WriteString("\t\tsummarizeBy: sum\n")
+		} else {
+			b.WriteString("\t\tsummarizeBy: none\n")
+		}
+		fmt.Fprintf(&b, "\t\tsourceColumn: %s\n", c.Name)
+		fmt.Fprintf(&b, "\t\tlineageTag: %s\n", id("column:"+v.Name+"."+c.Name))
+	}
+
+	fmt.Fprintf(&b, "\n\tpartition %s = m\n\t\tmode: import\n\t\tsource =\n", tmdlName(v.Name))
+	for _, line := range strings.Split(m.warehouse.powerBI(v.Name), "\n") {
+		fmt.Fprintf(&b, "\t\t\t\t%s\n", line)
+	}
+	return b.String()
+}
+
+func (m *Model) tmdlRelationships() string {
+	var b strings.Builder
+	isActive := active(m.Relationships)
+	for i, r := range m.Relationships {
+		if r.View == r.ReferencedView {
+			continue
+		}
+		fmt.Fprintf(&b, "relationship %s\n", id("relationship:"+r.View+"."+r.Column))
+		if !isActive[i] {
+			b.WriteString("\tisActive: false\n")
+		}
+		fmt.Fprintf(&b, "\tfromColumn: %s.%s\n", tmdlName(r.View), tmdlName(r.Column))
+		fmt.Fprintf(&b, "\ttoColumn: %s.%s\n\n", tmdlName(r.ReferencedView), tmdlName(r.ReferencedColumn))
+	}
+	return b.String()
+}
+
+// writeFiles writes files, named relative to dir.
+func writeFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package bimodel
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// tableauTypes are the Tableau data types of each column type.
+var tableauTypes = map[string]string{
+	typeString:   "string",
+	typeInteger:  "integer",
+	typeDecimal:  "real",
+	typeBoolean:  "boolean",
+	typeDateTime: "datetime",
+}
+
+// WriteTableau writes the model to dir as Tableau data sources (.tds), one per
+// document type.  Tableau's relationships must form a tree, so each holds the
+// document's view with its record and list views related to it; references
+// to other document types are left to be related where they're needed.
+func (m *Model) WriteTableau(dir string) error {
+	files := map[string]string{}
+	for _, v := range m.Views {
+		if !topLevel(v) {
+			continue
+		}
+		var children []sqlgen.ViewLineage
+		for _, child := range m.Views {
+			if child.DocumentType == v.DocumentType && child.Name != v.Name {
+				children = append(children, child)
+			}
+		}
+		files[v.Name+".tds"] = m.tableauDataSource(v, children)
+	}
+
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.tds"))
+	for _, path := range stale {
+		if _, ok := files[filepath.Base(path)]; !ok {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFiles(dir, files)
+}
+
+// topLevel reports whether v is a document's own view, rather than a record
+// or list view of it.
+func topLevel(v sqlgen.ViewLineage) bool {
+	for _, c := range v.Columns {
+		if c.SourceColumn == "VERSION" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) tableauDataSource(root sqlgen.ViewLineage, children []sqlgen.ViewLineage) string {
+	attr := html.EscapeString
+	views := append([]sqlgen.ViewLineage{root}, children...)
+	objectID := func(v sqlgen.ViewLineage) string { return v.Name + "_" + id(v.Name)[:8] }
+
+	// Tableau names columns after their view when several views share a name
+	seen := map[string]bool{}
+	localNames := map[string]string{}
+	for _, v := range views {
+		for _, c := range v.Columns {
+			name := "[" + c.Name + "]"
+			if seen[c.Name] {
+				name = fmt.Sprintf("[%s (%s)]", c.Name, v.Name)
+			}
+			seen[c.Name] = true
+			localNames[v.Name+"."+c.Name] = name
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<?xml version='1.0' encoding='utf-8' ?>\n")
+	fmt.Fprintf(&b, "<datasource formatted-name='%s' inline='true' version='18.1' xmlns:user='http://www.tableausoftware.com/xml/user'>\n", attr(root.Name))
+	b.WriteString("  <connection class='federated'>\n    <named-connections>\n")
+	fmt.Fprintf(&b, "      <named-connection caption='%s' name='warehouse'>\n", attr(m.param("Server")))
+	fmt.Fprintf(&b, "        <connection class='%s'", m.warehouse.tableau)
+	for _, name := range m.warehouse.params {
+		fmt.Fprintf(&b, " %s='%s'", m.warehouse.tableauAttrs[name], attr(m.param(name)))
+	}
+	b.WriteString(" />\n      </named-connection>\n    </named-connections>\n")
+
+	b.WriteString("    <metadata-records>\n")
+	for _, v := range views {
+		for _, c := range v.Columns {
+			b.WriteString("      <metadata-record class='column'>\n")
+			fmt.Fprintf(&b, "        <remote-name>%s</remote-name>\n", attr(c.Name))
+			fmt.Fprintf(&b, "        <local-name>%s</local-name>\n", attr(localNames[v.Name+"."+c.Name]))
+			fmt.Fprintf(&b, "        <parent-name>[%s]</parent-name>\n", attr(v.Name))
+			fmt.Fprintf(&b, "        <remote-alias>%s</remote-alias>\n", attr(c.Name))
+			fmt.Fprintf(&b, "        <local-type>%s</local-type>\n", tableauTypes[columnType(c)])
+			fmt.Fprintf(&b, "        <object-id>[%s]</object-id>\n", attr(objectID(v)))
+			b.WriteString("      </metadata-record>\n")
+		}
+	}
+	b.WriteString("    </metadata-records>\n  </connection>\n")
+
+	b.WriteString("  <object-graph>\n    <objects>\n")
+	for _, v := range views {
+		fmt.Fprintf(&b, "      <object caption='%s' id='%s'>\n        <properties context=''>\n", attr(v.Name), attr(objectID(v)))
+		fmt.Fprintf(&b, "          <relation connection='warehouse' name='%s' table='%s' type='table' />\n", attr(v.Name), attr(m.tableauTable(v.Name)))
+		b.WriteString("        </properties>\n      </object>\n")
+	}
+	b.WriteString("    </objects>\n    <relationships>\n")
+	documentID := m.warehouse.fold(m.options.ColumnName("DOCUMENT_ID"))
+	for _, child := range children {
+		b.WriteString("      <relationship>\n        <expression op='='>\n")
+		fmt.Fprintf(&b, "          <expression op='%s' />\n", attr(localNames[root.Name+"."+documentID]))
+		fmt.Fprintf(&b, "          <expression op='%s' />\n", attr(localNames[child.Name+"."+documentID]))
+		b.WriteString("        </expression>\n")
+		fmt.Fprintf(&b, "        <first-end-point object-id='%s' />\n", attr(objectID(root)))
+		fmt.Fprintf(&b, "        <second-end-point object-id='%s' />\n", attr(objectID(child)))
+		b.WriteString("      </relationship>\n")
+	}
+	b.WriteString("    </relationships>\n  </object-graph>\n</datasource>\n")
+	return b.String()
+}
+
+// tableauTable returns the bracketed name Tableau reads a view by.
+func (m *Model) tableauTable(view string) string {
+	if schema := m.param("Schema"); schema != "" {
+		return fmt.Sprintf("[%s].[%s]", schema, view)
+	}
+	return "[" + view + "]"
+}
@@ -0,0 +1,131 @@
+package bimodel
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// warehouse describes how BI tools connect to one kind of warehouse.
+type warehouse struct {
+	params []string // connection settings, i.e. Server and Database
+	lower  bool     // the warehouse folds unquoted names to lower case
+
+	// powerBI returns the M expression reading a view, with the params as
+	// Power BI parameters
+	powerBI func(view string) string
+
+	// tableau is the Tableau connection class, and the connection
+	// attribute each param is given as
+	tableau      string
+	tableauAttrs map[string]string
+}
+
+func (w warehouse) hasParam(name string) bool {
+	return slices.Contains(w.params, name)
+}
+
+func (w warehouse) fold(name string) string {
+	if w.lower {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// mString quotes a string for M.
+func mString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// navigate returns M steps walking from a connector through a database (or
+// catalog) and schema to a view.
+func navigate(source string, view string) string {
+	return fmt.Sprintf(`let
+    Source = %s,
+    Db = Source{[Name = Database, Kind = "Database"]}[Data],
+    Sch = Db{[Name = Schema, Kind = "Schema"]}[Data],
+    View = Sch{[Name = %s, Kind = "View"]}[Data]
+in
+    View`, source, mString(view))
+}
+
+// schemaItem returns M steps reading a view from connectors which list a
+// database's tables and views by schema and name.
+func schemaItem(source string, view string) string {
+	return fmt.Sprintf(`let
+    Source = %s,
+    View = Source{[Schema = Schema, Item = %s]}[Data]
+in
+    View`, source, mString(view))
+}
+
+// odbc returns M reading a view through an ODBC data source name.
+func odbc(view string) string {
+	return fmt.Sprintf(`let
+    Source = Odbc.Query("dsn=" & Dsn, %s)
+in
+    Source`, mString(`SELECT * FROM "`+view+`"`))
+}
+
+var (
+	postgres = warehouse{
+		params:       []string{"Server", "Database", "Schema"},
+		lower:        true,
+		powerBI:      func(view string) string { return schemaItem("PostgreSQL.Database(Server, Database)", view) },
+		tableau:      "postgres",
+		tableauAttrs: map[string]string{"Server": "server", "Database": "dbname", "Schema": "schema"},
+	}
+	sqlServer = warehouse{
+		params:       []string{"Server", "Database", "Schema"},
+		powerBI:      func(view string) string { return schemaItem("Sql.Database(Server, Database)", view) },
+		tableau:      "sqlserver",
+		tableauAttrs: map[string]string{"Server": "server", "Database": "dbname", "Schema": "schema"},
+	}
+	odbcSource = warehouse{
+		params:       []string{"Dsn"},
+		powerBI:      odbc,
+		tableau:      "genericodbc",
+		tableauAttrs: map[string]string{"Dsn": "odbc-dsn"},
+	}
+)
+
+// warehouses are the DATABASE_TYPEs with helper views, and how BI tools
+// connect to them.  Those without a connector of their own in Power BI and
+// Tableau are read through ODBC.
+var warehouses = map[string]warehouse{
+	"SNOWFLAKE": {
+		params:       []string{"Server", "Warehouse", "Database", "Schema"},
+		powerBI:      func(view string) string { return navigate("Snowflake.Databases(Server, Warehouse)", view) },
+		tableau:      "snowflake",
+		tableauAttrs: map[string]string{"Server": "server", "Warehouse": "warehouse", "Database": "dbname", "Schema": "schema"},
+	},
+	"DATABRICKS": {
+		params:       []string{"Server", "HttpPath", "Database", "Schema"},
+		lower:        true,
+		powerBI:      func(view string) string { return navigate("Databricks.Catalogs(Server, HttpPath, null)", view) },
+		tableau:      "databricks",
+		tableauAttrs: map[string]string{"Server": "server", "HttpPath": "v-http-path", "Database": "dbname", "Schema": "schema"},
+	},
+	"SQLSERVER":  sqlServer,
+	"MSSQL":      sqlServer,
+	"POSTGRES":   postgres,
+	"POSTGRESQL": postgres,
+	"GREENPLUM":  postgres,
+	"TERADATA": {
+		params:       []string{"Server", "Schema"},
+		powerBI:      func(view string) string { return schemaItem("Teradata.Database(Server)", view) },
+		tableau:      "teradata",
+		tableauAttrs: map[string]string{"Server": "server", "Schema": "schema"},
+	},
+	"FIREBOLT": {
+		params:       odbcSource.params,
+		lower:        true,
+		powerBI:      odbc,
+		tableau:      odbcSource.tableau,
+		tableauAttrs: odbcSource.tableauAttrs,
+	},
+	"SQLITE":     odbcSource,
+	"GOSQLITE":   odbcSource,
+	"DUCKDB":     odbcSource,
+	"MOTHERDUCK": odbcSource,
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// DocumentEndpoint is Execute's own fetch endpoint, which every sync reads.
+const DocumentEndpoint = "/fetch/document/"
+
+// Endpoint is an additional Execute fetch endpoint, such as a customer
+// specific extension or a module's API, configured in ENDPOINTS as
+// TABLE=path.  It's fetched the same way as /fetch/document/ (since, cursor,
+// limit and the X-Sync- headers), and its documents are loaded as the
+// document type TABLE, so they're chunked, loaded and given views like any
+// other type.
+type Endpoint struct {
+	Table string // the $TYPE its documents are loaded as, naming their views
+	Path  string // i.e. /fetch/extension/welltests/
+}
+
+// SchemaPath is where the endpoint's schema is fetched from, alongside it as
+// /fetch/document/schema is alongside /fetch/document/.
+func (e Endpoint) SchemaPath() string {
+	return strings.TrimSuffix(e.Path, "/") + "/schema"
+}
+
+// endpointTable is the form of an ENDPOINTS table name, which ends up in
+// view names.
+var endpointTable = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Endpoints returns the additional fetch endpoints listed by ENDPOINTS, or
+// nil when it's not set.
+func Endpoints(cfg Config) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(cfg.Endpoints, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		table, path, ok := strings.Cut(pair, "=")
+		e := Endpoint{Table: strings.ToUpper(strings.TrimSpace(table)), Path: strings.TrimSpace(path)}
+		if !ok || !endpointTable.MatchString(e.Table) || !strings.HasPrefix(e.Path, "/") {
+			return nil, failure.Errorf(failure.Config, "ENDPOINTS entry %q should be TABLE=/path/", strings.TrimSpace(pair))
+		}
+		if e.Path == DocumentEndpoint {
+			return nil, failure.Errorf(failure.Config, "ENDPOINTS can't include %s, which is always synced", DocumentEndpoint)
+		}
+		if seen[e.Table] {
+			return nil, failure.Errorf(failure.Config, "ENDPOINTS lists %s twice", e.Table)
+		}
+		seen[e.Table] = true
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// Apply returns the configuration for syncing this endpoint: a STATE_DIR of
+// its own, so it has its own highwater mark, and no DOCUMENT_TYPES filter,
+// as its documents all have the one type.
+func (e Endpoint) Apply(cfg Config) Config {
+	cfg.StateDir = filepath.Join(cfg.StateDir, "endpoints", strings.ToLower(e.Table))
+	cfg.DocumentTypes = ""
+	return cfg
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Setting describes one configuration parameter, along with its value.
+type Setting struct {
+	Name     string // field name, i.e. DatabaseDSN
+	Env      string // environment variable, including the EXECUTESYNC_ prefix
+	Usage    string
+	Default  string
+	Required bool // by at least one command
+	Secret   bool // credentials, kept out of plain config (i.e. a ConfigMap)
+	Mask     string
+	Value    interface{}
+}
+
+// How a setting's value is masked for display (the `secret` tag).
+const (
+	MaskFull    = "true"    // entirely
+	MaskDSN     = "dsn"     // only the credentials within a DSN
+	MaskPartial = "partial" // all but the ends, i.e. for key IDs
+)
+
+// Masked returns the setting's value with any credentials masked.
+func (s Setting) Masked() interface{} {
+	value := fmt.Sprint(s.Value)
+	switch s.Mask {
+	case MaskFull:
+		return Redacted
+	case MaskDSN:
+		return MaskDSNCredentials(value)
+	case MaskPartial:
+		return maskPartial(value)
+	}
+	return s.Value
+}
+
+// Settings lists every configuration parameter with its value in cfg, in
+// declaration order.
+func Settings(cfg Config) []Setting {
+	cfgVal := reflect.ValueOf(cfg)
+	cfgType := cfgVal.Type()
+	var settings []Setting
+	for i := 0; i < cfgType.NumField(); i++ {
+		field := cfgType.Field(i)
+		envTag := field.Tag.Get("env")
+		if envTag == "" {
+			continue
+		}
+		settings = append(settings, Setting{
+			Name:     field.Name,
+			Env:      "EXECUTESYNC_" + envTag,
+			Usage:    field.Tag.Get("usage"),
+			Default:  field.Tag.Get("default"),
+			Required: field.Tag.Get("required") != "",
+			Secret:   field.Tag.Get("secret") == MaskFull || field.Tag.Get("secret") == MaskDSN,
+			Mask:     field.Tag.Get("secret"),
+			Value:    cfgVal.Field(i).Interface(),
+		})
+	}
+	return settings
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// SourceAttribute is the attribute which records which Execute instance a
+// document came from, when syncing several.
+const SourceAttribute = "SOURCE"
+
+// Source is one of several Execute instances synced into the same warehouse,
+// configured with EXECUTESYNC_<LABEL>_EXECUTE_URL, _EXECUTE_APIKEY_ID and
+// _EXECUTE_APIKEY_SECRET.
+type Source struct {
+	Label            string
+	ExecuteURL       string
+	ExecuteKeyId     string
+	ExecuteKeySecret string
+}
+
+// Sources returns the Execute instances listed by SOURCES, or nil when it's
+// not set and the EXECUTE_ settings describe the only one.
+func Sources(cfg Config) ([]Source, error) {
+	var sources []Source
+	var missing []string
+	for _, label := range strings.Split(cfg.Sources, ",") {
+		label = strings.ToUpper(strings.TrimSpace(label))
+		if label == "" {
+			continue
+		}
+		s := Source{
+			Label:            label,
+			ExecuteURL:       os.Getenv("EXECUTESYNC_" + label + "_EXECUTE_URL"),
+			ExecuteKeyId:     os.Getenv("EXECUTESYNC_" + label + "_EXECUTE_APIKEY_ID"),
+			ExecuteKeySecret: os.Getenv("EXECUTESYNC_" + label + "_EXECUTE_APIKEY_SECRET"),
+		}
+		settings := []struct{ name, value string }{
+			{"EXECUTE_URL", s.ExecuteURL},
+			{"EXECUTE_APIKEY_ID", s.ExecuteKeyId},
+			{"EXECUTE_APIKEY_SECRET", s.ExecuteKeySecret},
+		}
+		for _, setting := range settings {
+			if setting.value == "" {
+				missing = append(missing, "EXECUTESYNC_"+label+"_"+setting.name)
+			}
+		}
+		sources = append(sources, s)
+	}
+	if len(missing) > 0 {
+		return nil, failure.Errorf(failure.Config, "SOURCES needs %s", strings.Join(missing, ", "))
+	}
+	return sources, nil
+}
+
+// Apply returns the configuration for syncing this source: its own Execute
+// settings, a STATE_DIR of its own (so each source has its own highwater
+// mark) and a SOURCE attribute on every document.
+func (s Source) Apply(cfg Config) Config {
+	cfg.ExecuteURL = s.ExecuteURL
+	cfg.ExecuteKeyId = s.ExecuteKeyId
+	cfg.ExecuteKeySecret = s.ExecuteKeySecret
+	cfg.StateDir = filepath.Join(cfg.StateDir, strings.ToLower(s.Label))
+	cfg.Attributes = withAttribute(cfg.Attributes, SourceAttribute, s.Label)
+	return cfg
+}
+
+// withAttribute sets an attribute in an ATTRIBUTES setting, replacing any
+// existing value.
+func withAttribute(setting string, name string, value string) string {
+	pairs := []string{name + "=" + value}
+	for _, pair := range strings.Split(setting, ",") {
+		key, _, _ := strings.Cut(pair, "=")
+		if strings.TrimSpace(pair) != "" && !strings.EqualFold(strings.TrimSpace(key), name) {
+			pairs = append(pairs, pair)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// Target is one of several warehouses each batch is loaded into, configured
+// with EXECUTESYNC_<LABEL>_DATABASE_TYPE, _DATABASE_DSN and optionally
+// _DATABASE_SCHEMA and _DATABASE_DEPLOY_DSN.
+type Target struct {
+	Label             string
+	DatabaseType      string
+	DatabaseDSN       string
+	DatabaseDeployDSN string
+	DatabaseSchema    string
+}
+
+// Targets returns the warehouses listed by DATABASE_TARGETS, or nil when it's
+// not set and the DATABASE_ settings describe the only one.
+func Targets(cfg Config) ([]Target, error) {
+	var targets []Target
+	var problems []string
+	for _, label := range strings.Split(cfg.DatabaseTargets, ",") {
+		label = strings.ToUpper(strings.TrimSpace(label))
+		if label == "" {
+			continue
+		}
+		t := Target{
+			Label:             label,
+			DatabaseType:      os.Getenv("EXECUTESYNC_" + label + "_DATABASE_TYPE"),
+			DatabaseDSN:       os.Getenv("EXECUTESYNC_" + label + "_DATABASE_DSN"),
+			DatabaseDeployDSN: os.Getenv("EXECUTESYNC_" + label + "_DATABASE_DEPLOY_DSN"),
+			DatabaseSchema:    os.Getenv("EXECUTESYNC_" + label + "_DATABASE_SCHEMA"),
+		}
+		if t.DatabaseType == "" {
+			problems = append(problems, "EXECUTESYNC_"+label+"_DATABASE_TYPE")
+		} else if allowed, ok := Enums["DATABASE_TYPE"]; ok {
+			canonical, ok := matchEnum(t.DatabaseType, allowed)
+			if !ok {
+				return nil, failure.Errorf(failure.Config, "EXECUTESYNC_%s_DATABASE_TYPE %q isn't valid (expected one of %s)", label, t.DatabaseType, strings.Join(allowed, ", "))
+			}
+			t.DatabaseType = canonical
+		}
+		// SQLite defaults to a database of the target's own in STATE_DIR
+		if (t.DatabaseType == "SQLITE" || t.DatabaseType == "GOSQLITE") && t.DatabaseDSN == "" {
+			t.DatabaseDSN = filepath.Join(cfg.StateDir, strings.ToLower(label)+".sqlite")
+		}
+		if t.DatabaseDSN == "" && t.DatabaseType != "" {
+			problems = append(problems, "EXECUTESYNC_"+label+"_DATABASE_DSN")
+		}
+		targets = append(targets, t)
+	}
+	if len(problems) > 0 {
+		return nil, failure.Errorf(failure.Config, "DATABASE_TARGETS needs %s", strings.Join(problems, ", "))
+	}
+	return targets, nil
+}
+
+// Apply returns the configuration for loading this target on its own.
+func (t Target) Apply(cfg Config) Config {
+	cfg.DatabaseTargets = ""
+	cfg.DatabaseType = t.DatabaseType
+	cfg.DatabaseDSN = t.DatabaseDSN
+	cfg.DatabaseDeployDSN = t.DatabaseDeployDSN
+	cfg.DatabaseSchema = t.DatabaseSchema
+	return cfg
+}
@@ -0,0 +1,139 @@
+// Package docindex keeps a compact local index of the documents a sync has
+// loaded: the latest version of every document type and ID, and whether it
+// was deleted, in a SQLite database in STATE_DIR.  Execute's fetch API never
+// reports documents which were hard deleted, so the index is what the
+// warehouse ought to hold, to compare with a full listing from Execute
+// without scanning the warehouse.
+package docindex
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// File is the index's name in STATE_DIR.
+const File = "documents.idx"
+
+// Index is the document index.  Documents are added in a transaction which
+// is committed once their batch has loaded (and its highwater mark has been
+// stored), so the index never gets ahead of the warehouse.
+type Index struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+// Exists reports whether there's an index in stateDir.
+func Exists(stateDir string) bool {
+	_, err := os.Stat(filepath.Join(stateDir, File))
+	return err == nil
+}
+
+// Open opens the index in stateDir, creating it if need be.
+func Open(stateDir string) (*Index, error) {
+	db, err := sql.Open("sqlite", filepath.Join(stateDir, File))
+	if err != nil {
+		return nil, fmt.Errorf("opening document index: %v", err)
+	}
+	// One connection, so a transaction sees its own writes
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS DOCUMENTS (
+	TYPE TEXT NOT NULL,
+	ID TEXT NOT NULL,
+	VERSION INTEGER NOT NULL,
+	DELETED INTEGER NOT NULL,
+	PRIMARY KEY (TYPE, ID)
+) WITHOUT ROWID`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating document index: %v", err)
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index, abandoning any documents not yet committed.
+func (i *Index) Close() error {
+	i.Rollback()
+	return i.db.Close()
+}
+
+// Add records a document, unless the index already holds a later version.
+// The first document added after a Commit (or Rollback) starts a new
+// transaction.
+func (i *Index) Add(docType string, id string, version int64, deleted bool) error {
+	if i.tx == nil {
+		tx, err := i.db.Begin()
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(`INSERT INTO DOCUMENTS (TYPE, ID, VERSION, DELETED) VALUES (?, ?, ?, ?)
+ON CONFLICT (TYPE, ID) DO UPDATE SET VERSION = excluded.VERSION, DELETED = excluded.DELETED
+WHERE excluded.VERSION >= DOCUMENTS.VERSION`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		i.tx, i.stmt = tx, stmt
+	}
+	_, err := i.stmt.Exec(docType, id, version, deleted)
+	return err
+}
+
+// Commit keeps the documents added since the last Commit.
+func (i *Index) Commit() error {
+	if i.tx == nil {
+		return nil
+	}
+	err := i.tx.Commit()
+	i.tx, i.stmt = nil, nil
+	return err
+}
+
+// Rollback forgets the documents added since the last Commit.
+func (i *Index) Rollback() {
+	if i.tx != nil {
+		i.tx.Rollback()
+		i.tx, i.stmt = nil, nil
+	}
+}
+
+// Types returns the document types in the index.
+func (i *Index) Types() ([]string, error) {
+	rows, err := i.db.Query(`SELECT DISTINCT TYPE FROM DOCUMENTS ORDER BY TYPE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var types []string
+	for rows.Next() {
+		var docType string
+		if err := rows.Scan(&docType); err != nil {
+			return nil, err
+		}
+		types = append(types, docType)
+	}
+	return types, rows.Err()
+}
+
+// Live returns the latest version of every document of a type which hasn't
+// been deleted, by ID.
+func (i *Index) Live(docType string) (map[string]int64, error) {
+	rows, err := i.db.Query(`SELECT ID, VERSION FROM DOCUMENTS WHERE TYPE = ? AND DELETED = 0`, docType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var version int64
+		if err := rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		versions[id] = version
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,93 @@
+package docindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexKeepsLatestVersions(t *testing.T) {
+	dir := t.TempDir()
+	if Exists(dir) {
+		t.Fatal("expected no index in an empty directory")
+	}
+	index, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	for _, doc := range []struct {
+		docType, id string
+		version     int64
+		deleted     bool
+	}{
+		{"AFE", "1", 2, false},
+		{"AFE", "1", 1, false}, // an earlier version loaded later
+		{"AFE", "2", 1, false},
+		{"AFE", "2", 2, true},
+		{"WELL", "3", 5, false},
+	} {
+		if err := index.Add(doc.docType, doc.id, doc.version, doc.deleted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := index.Live("AFE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(live, map[string]int64{"1": 2}) {
+		t.Fatalf("expected the latest live AFEs, got %v", live)
+	}
+	types, err := index.Types()
+	if err != nil || !reflect.DeepEqual(types, []string{"AFE", "WELL"}) {
+		t.Fatalf("unexpected types %v, %v", types, err)
+	}
+}
+
+func TestIndexOnlyKeepsCommittedDocuments(t *testing.T) {
+	dir := t.TempDir()
+	index, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add("AFE", "1", 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch which fails to load is forgotten
+	if err := index.Add("AFE", "2", 1, false); err != nil {
+		t.Fatal(err)
+	}
+	index.Rollback()
+
+	// As is one still being loaded when the sync stops
+	if err := index.Add("AFE", "3", 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Exists(dir) {
+		t.Fatal("expected the index to exist")
+	}
+	index, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	live, err := index.Live("AFE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(live, map[string]int64{"1": 1}) {
+		t.Fatalf("expected only the committed document, got %v", live)
+	}
+}
@@ -0,0 +1,194 @@
+package documents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// How an Anonymizer treats a field.
+const (
+	AnonymizePseudonym = "pseudonym" // replace strings with a stable pseudonym
+	AnonymizeJitter    = "jitter"    // move numbers up or down by up to the jitter
+)
+
+// anonymizeRule is one FIELD[=mode] of the ANONYMIZE setting.
+type anonymizeRule struct {
+	pattern string // field name, upper case, which may have * and ? wildcards
+	mode    string
+}
+
+// Anonymizer pseudonymizes the configured fields of documents, so that
+// production-shaped data can be shared without exposing names or figures.
+// Fields are matched by name wherever they're nested.  Everything is derived
+// from an HMAC of the value under the key, so the same name always gets the
+// same pseudonym (and still joins across documents and types) and a document
+// is jittered the same way every time it's loaded, yet neither can be undone
+// without the key.  Document types, IDs and the $ fields are never changed.
+type Anonymizer struct {
+	rules     []anonymizeRule
+	key       []byte
+	jitter    float64 // fraction, i.e. 0.1 for 10%
+	Documents int     // documents with a value anonymized
+	Values    int     // values anonymized
+}
+
+// NewAnonymizer creates an Anonymizer from comma separated FIELD[=mode]
+// rules, where mode is pseudonym (the default) or jitter, or returns nil when
+// there are no rules.  jitter is the most numbers move, in percent.
+func NewAnonymizer(rules string, key string, jitter int) (*Anonymizer, error) {
+	if strings.TrimSpace(rules) == "" {
+		return nil, nil
+	}
+	if key == "" {
+		return nil, fmt.Errorf("ANONYMIZE needs an ANONYMIZE_KEY, without which pseudonyms could be guessed")
+	}
+	if jitter < 0 || jitter >= 100 {
+		return nil, fmt.Errorf("invalid anonymize jitter %d%%: expected 0 to 99", jitter)
+	}
+	a := &Anonymizer{key: []byte(key), jitter: float64(jitter) / 100}
+	for _, rule := range strings.Split(rules, ",") {
+		field, mode, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		field = strings.ToUpper(strings.TrimSpace(field))
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if mode == "" {
+			mode = AnonymizePseudonym
+		}
+		switch {
+		case field == "":
+			continue
+		case mode != AnonymizePseudonym && mode != AnonymizeJitter:
+			return nil, fmt.Errorf("invalid anonymize mode %q for %s: expected pseudonym or jitter", mode, field)
+		case field == "DOCUMENT_ID" || strings.HasPrefix(field, "$"):
+			return nil, fmt.Errorf("%s can't be anonymized, as documents are identified by it", field)
+		}
+		if _, err := path.Match(field, ""); err != nil {
+			return nil, fmt.Errorf("invalid anonymize field %q: %v", field, err)
+		}
+		a.rules = append(a.rules, anonymizeRule{pattern: field, mode: mode})
+	}
+	if len(a.rules) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Anonymize anonymizes a document in place.
+func (a *Anonymizer) Anonymize(data map[string]interface{}) {
+	values := a.Values
+	seed := fmt.Sprintf("%v|%v|", data["$TYPE"], data["DOCUMENT_ID"])
+	for key, value := range data {
+		if key == "DOCUMENT_ID" || strings.HasPrefix(key, "$") {
+			continue
+		}
+		data[key] = a.walk(seed, key, value)
+	}
+	if a.Values != values {
+		a.Documents++
+	}
+}
+
+// walk anonymizes the value of a field, and any fields nested within it.
+func (a *Anonymizer) walk(seed string, key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if !strings.HasPrefix(k, "$") {
+				v[k] = a.walk(seed, k, item)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = a.walk(seed, key, item)
+		}
+		return v
+	}
+	switch a.mode(key) {
+	case AnonymizePseudonym:
+		if s, ok := value.(string); ok && s != "" {
+			a.Values++
+			return a.pseudonym(s)
+		}
+	case AnonymizeJitter:
+		if jittered, ok := a.jitterNumber(seed+key, value); ok {
+			a.Values++
+			return jittered
+		}
+	}
+	return value
+}
+
+// mode returns how a field is anonymized, by the first rule matching it, or
+// "" if it isn't.
+func (a *Anonymizer) mode(key string) string {
+	key = strings.ToUpper(key)
+	for _, rule := range a.rules {
+		if ok, _ := path.Match(rule.pattern, key); ok {
+			return rule.mode
+		}
+	}
+	return ""
+}
+
+// pseudonym returns the pseudonym of a string.  It depends on nothing but the
+// value, so the same name gets the same pseudonym in every field.
+func (a *Anonymizer) pseudonym(value string) string {
+	return "ANON-" + hex.EncodeToString(a.sum(value)[:5])
+}
+
+// jitterNumber moves a number by up to the jitter, keeping its number of
+// decimal places.  The seed (the document and field) is hashed with the value
+// so that equal amounts in different documents move differently.
+func (a *Anonymizer) jitterNumber(seed string, value interface{}) (interface{}, bool) {
+	var n float64
+	decimals := -1
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return value, false
+		}
+		n = f
+		if s := v.String(); !strings.ContainsAny(s, "eE") {
+			decimals = 0
+			if dot := strings.IndexByte(s, '.'); dot >= 0 {
+				decimals = len(s) - dot - 1
+			}
+		}
+	case float64:
+		n = v
+	case int64:
+		n, decimals = float64(v), 0
+	case int:
+		n, decimals = float64(v), 0
+	default:
+		return value, false
+	}
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return value, false
+	}
+
+	u := float64(binary.BigEndian.Uint64(a.sum(seed+"|"+fmt.Sprint(value)))) / math.MaxUint64
+	n *= 1 + a.jitter*(2*u-1)
+	switch value.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		return json.Number(strconv.FormatFloat(n, 'f', decimals, 64)), true
+	}
+	return int64(math.Round(n)), true
+}
+
+func (a *Anonymizer) sum(value string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
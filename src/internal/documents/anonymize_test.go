@@ -0,0 +1,69 @@
+package documents
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func anonymized(t *testing.T, a *Anonymizer, document string) map[string]interface{} {
+	t.Helper()
+	var record map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	a.Anonymize(record)
+	return record
+}
+
+func TestAnonymizeIsDeterministic(t *testing.T) {
+	a, err := NewAnonymizer("NAME,VENDOR*, *_AMOUNT=jitter", "secret", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := `{"$TYPE":"AFE","DOCUMENT_ID":"d1","NAME":"Smith 1H","GROSS_AMOUNT":1000.00,"COSTS":[{"VENDOR_NAME":"Acme","LINE_AMOUNT":250}]}`
+	first := anonymized(t, a, document)
+	second := anonymized(t, a, document)
+	if first["NAME"] != second["NAME"] || first["GROSS_AMOUNT"] != second["GROSS_AMOUNT"] {
+		t.Errorf("anonymized differently: %v and %v", first, second)
+	}
+	if first["NAME"] == "Smith 1H" || first["DOCUMENT_ID"] != "d1" || first["$TYPE"] != "AFE" {
+		t.Errorf("anonymized the wrong fields: %v", first)
+	}
+
+	cost := first["COSTS"].([]interface{})[0].(map[string]interface{})
+	if cost["VENDOR_NAME"] != a.pseudonym("Acme") {
+		t.Errorf("VENDOR_NAME = %v, want %v", cost["VENDOR_NAME"], a.pseudonym("Acme"))
+	}
+	if n, _ := cost["LINE_AMOUNT"].(json.Number).Int64(); n < 225 || n > 275 || strings.Contains(string(cost["LINE_AMOUNT"].(json.Number)), ".") {
+		t.Errorf("LINE_AMOUNT = %v, want an integer within 10%% of 250", cost["LINE_AMOUNT"])
+	}
+	if gross := string(first["GROSS_AMOUNT"].(json.Number)); !strings.Contains(gross, ".") || len(gross)-strings.Index(gross, ".") != 3 {
+		t.Errorf("GROSS_AMOUNT = %v, want 2 decimal places", gross)
+	}
+	if a.Documents != 2 || a.Values != 8 {
+		t.Errorf("counted %d documents and %d values", a.Documents, a.Values)
+	}
+
+	other, _ := NewAnonymizer("NAME", "another secret", 10)
+	if anonymized(t, other, document)["NAME"] == first["NAME"] {
+		t.Error("pseudonym doesn't depend on the key")
+	}
+}
+
+func TestAnonymizerRejectsIdentifiers(t *testing.T) {
+	for _, rules := range []string{"DOCUMENT_ID", "$VERSION", "NAME=scramble", "NAME", "[="} {
+		key := "secret"
+		if rules == "NAME" {
+			key = ""
+		}
+		if _, err := NewAnonymizer(rules, key, 10); err == nil {
+			t.Errorf("NewAnonymizer(%q, %q) didn't fail", rules, key)
+		}
+	}
+	if a, err := NewAnonymizer(" ", "", 10); a != nil || err != nil {
+		t.Errorf("NewAnonymizer without rules = %v, %v", a, err)
+	}
+}
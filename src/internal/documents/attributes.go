@@ -0,0 +1,45 @@
+package documents
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var attributeName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Attribute is a fixed value added to every document, and a column of every
+// document view, i.e. the region or business unit of this deployment.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// ParseAttributes parses a comma separated list of NAME=value pairs.  Values
+// may refer to environment variables, i.e. ENVIRONMENT=$DEPLOY_ENV.
+func ParseAttributes(setting string) ([]Attribute, error) {
+	var attributes []Attribute
+	for _, pair := range strings.Split(setting, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !ok || !attributeName.MatchString(name) {
+			return nil, fmt.Errorf("invalid attribute %q: expected NAME=value", pair)
+		}
+		attributes = append(attributes, Attribute{Name: name, Value: os.ExpandEnv(strings.TrimSpace(value))})
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Name < attributes[j].Name })
+	return attributes, nil
+}
+
+// AddAttributes sets the attributes on a document, replacing any fields of
+// the same name.
+func AddAttributes(data map[string]interface{}, attributes []Attribute) {
+	for _, a := range attributes {
+		data[a.Name] = a.Value
+	}
+}
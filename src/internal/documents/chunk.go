@@ -0,0 +1,57 @@
+// Package documents holds helpers for working with the documents returned by
+// Execute's fetch API that are shared by the warehouse adapters.
+package documents
+
+import (
+	"sort"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/timing"
+)
+
+// Chunk splits a document into one or more chunks so that no single row grows
+// unbounded.  Any top-level list with more than chunkSize items is removed from
+// the document and emitted as a series of extra chunks, each carrying the
+// DOCUMENT_ID and a slice of the list.  The (modified) document itself is
+// always returned as chunk 0.
+//
+// Chunk numbers are stable: the same document and chunkSize always give the
+// same chunks.  Lists are split in key order, and each list's items keep their
+// order, so chunk n holds the same items however many times the document is
+// loaded, and RecordID identifies it across loads.
+func Chunk(data map[string]interface{}, chunkSize int) []map[string]interface{} {
+	defer timing.Since(timing.Chunk, time.Now())
+	var chunks []map[string]interface{}
+
+	// Iterate through the top-level keys, in order
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// Is this a list key?
+		if list, ok := data[key].([]interface{}); ok {
+			// Does this list have #items > chunk size?
+			if len(list) > chunkSize {
+				for i := 0; i < len(list); i += chunkSize {
+					end := i + chunkSize
+					if end > len(list) {
+						end = len(list)
+					}
+
+					chunks = append(chunks, map[string]interface{}{
+						"DOCUMENT_ID": data["DOCUMENT_ID"].(string),
+						key:           list[i:end],
+					})
+				}
+
+				// Remove the large list from the original document
+				delete(data, key)
+			}
+		}
+	}
+
+	// Add the modified original document back to the result
+	return append([]map[string]interface{}{data}, chunks...)
+}
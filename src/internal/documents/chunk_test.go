@@ -0,0 +1,40 @@
+package documents
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkIsStable(t *testing.T) {
+	document := func() map[string]interface{} {
+		list := func(n int) []interface{} {
+			items := make([]interface{}, n)
+			for i := range items {
+				items[i] = i
+			}
+			return items
+		}
+		return map[string]interface{}{
+			"DOCUMENT_ID": "D1",
+			"ZULU":        list(5),
+			"ALPHA":       list(3),
+			"MIKE":        list(1),
+			"NAME":        "Name",
+		}
+	}
+
+	want := []map[string]interface{}{
+		{"DOCUMENT_ID": "D1", "MIKE": []interface{}{0}, "NAME": "Name"},
+		{"DOCUMENT_ID": "D1", "ALPHA": []interface{}{0, 1}},
+		{"DOCUMENT_ID": "D1", "ALPHA": []interface{}{2}},
+		{"DOCUMENT_ID": "D1", "ZULU": []interface{}{0, 1}},
+		{"DOCUMENT_ID": "D1", "ZULU": []interface{}{2, 3}},
+		{"DOCUMENT_ID": "D1", "ZULU": []interface{}{4}},
+	}
+	// Map iteration order varies from run to run, so try a few times
+	for i := 0; i < 20; i++ {
+		if got := Chunk(document(), 2); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Chunk() = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package documents
+
+// ControlType is the $TYPE of the control record closing each upload.  Its
+// DATA holds the number of documents and chunks the upload should have loaded
+// so the warehouse can later be reconciled against it (see Batch).
+const ControlType = "$BATCH"
+
+// Control counts the documents and chunks returned by a nextRecord callback
+// and, once it's exhausted, returns one extra control record describing them.
+type Control struct {
+	batchDate string
+	runID     string
+	part      int
+	chunkSize int
+	next      func() (map[string]interface{}, error)
+	documents int
+	chunks    int
+	sent      bool
+}
+
+// NewControl wraps nextRecord for an upload of batchDate by the run runID.  A
+// batch may be uploaded in several parts; each part's control record carries
+// the part number as its $VERSION so they don't collide.
+func NewControl(batchDate string, runID string, part int, chunkSize int, nextRecord func() (map[string]interface{}, error)) *Control {
+	return &Control{
+		batchDate: batchDate,
+		runID:     runID,
+		part:      part,
+		chunkSize: chunkSize,
+		next:      nextRecord,
+	}
+}
+
+// Next is a drop-in replacement for the wrapped nextRecord callback.
+func (c *Control) Next() (map[string]interface{}, error) {
+	data, err := c.next()
+	if err != nil && err.Error() == "EOF" {
+		// Empty uploads get no control record, as some warehouses skip them
+		if c.sent || c.documents == 0 {
+			return nil, err
+		}
+		c.sent = true
+		return map[string]interface{}{
+			"$TYPE":       ControlType,
+			"DOCUMENT_ID": c.batchDate,
+			"$VERSION":    int64(c.part),
+			"$AUTHOR_ID":  "",
+			"$DATE":       c.batchDate,
+			"$DELETED":    false,
+			"RUN_ID":      c.runID,
+			"DOCUMENTS":   c.documents,
+			"CHUNKS":      c.chunks,
+		}, nil
+	}
+	if data != nil {
+		// Count chunks before the warehouse splits the document up
+		c.documents++
+		c.chunks++
+		for _, value := range data {
+			if list, ok := value.([]interface{}); ok && len(list) > c.chunkSize {
+				c.chunks += (len(list) + c.chunkSize - 1) / c.chunkSize
+			}
+		}
+	}
+	return data, err
+}
+
+// Sent reports whether the control record has been returned, i.e. whether
+// the warehouse's document count includes it.
+func (c *Control) Sent() bool {
+	return c.sent
+}
+
+// Batch compares what a batch's control records expected against what was
+// actually loaded.
+type Batch struct {
+	BatchDate         string
+	ExpectedDocuments int
+	ExpectedChunks    int
+	Documents         int
+	Chunks            int
+}
+
+// OK reports whether everything the batch expected was loaded.
+func (b Batch) OK() bool {
+	return b.Documents == b.ExpectedDocuments && b.Chunks == b.ExpectedChunks
+}
+
+// TypeStats summarises what the documents table holds for one document type.
+type TypeStats struct {
+	Type      string
+	Documents int   // distinct documents
+	Versions  int   // distinct document versions
+	Rows      int   // rows, across every batch and chunk
+	Chunks    int   // extra chunks split from large documents
+	Bytes     int64 // approximate size of the document JSON
+	Latest    int   // rows loaded by the most recent batch
+	Previous  int   // rows loaded by the batch before that
+
+	// Only known from the stats table (see COLLECT_STATS)
+	LastLoaded string // batch date the type was last loaded in
+	Newest     string // newest $DATE loaded
+}
@@ -0,0 +1,65 @@
+package documents
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// FormatInt formats an integer column, i.e. VERSION or CHUNK, for a CSV file
+// or message attribute.
+func FormatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// FormatBool formats a boolean column, i.e. DELETED, as true or false, which
+// every loader accepts.
+func FormatBool(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// FormatFloat formats a number without an exponent, i.e. 1e+21 becomes
+// 1000000000000000000000, so loaders which read exponents as text (or not at
+// all) still see a number.  Integral values print without a decimal point.
+// JSON has no NaN or infinity, so like jq they become null and the largest
+// float64 respectively.
+func FormatFloat(v float64) json.Number {
+	switch {
+	case math.IsNaN(v):
+		return ""
+	case math.IsInf(v, 1):
+		v = math.MaxFloat64
+	case math.IsInf(v, -1):
+		v = -math.MaxFloat64
+	}
+	return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// NormalizeNumbers rewrites the float64 and big.Int values in a document, as
+// computed by a transform, to json.Numbers formatted by FormatFloat.  Numbers
+// decoded from Execute are already json.Numbers and are left as they are.
+func NormalizeNumbers(data map[string]interface{}) {
+	for key, value := range data {
+		data[key] = normalizeNumber(value)
+	}
+}
+
+func normalizeNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if n := FormatFloat(v); n != "" {
+			return n
+		}
+		return nil
+	case *big.Int:
+		return json.Number(v.String())
+	case map[string]interface{}:
+		NormalizeNumbers(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeNumber(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,66 @@
+package documents
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestFormatIntRoundTrips(t *testing.T) {
+	roundTrip := func(n int64) bool {
+		parsed, err := strconv.ParseInt(FormatInt(n), 10, 64)
+		return err == nil && parsed == n
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+	for _, n := range []int64{0, -1, math.MaxInt64, math.MinInt64} {
+		if !roundTrip(n) {
+			t.Errorf("FormatInt(%d) = %q", n, FormatInt(n))
+		}
+	}
+}
+
+func TestFormatFloatHasNoExponent(t *testing.T) {
+	exact := func(v float64) bool {
+		n := FormatFloat(v)
+		parsed, err := n.Float64()
+		return !strings.ContainsAny(string(n), "eE") && err == nil && parsed == v
+	}
+	if err := quick.Check(exact, nil); err != nil {
+		t.Error(err)
+	}
+	for _, v := range []float64{0, 1e21, -1e21, 1e-7, math.MaxFloat64, math.SmallestNonzeroFloat64, 1 << 63} {
+		if !exact(v) {
+			t.Errorf("FormatFloat(%g) = %q", v, FormatFloat(v))
+		}
+	}
+}
+
+func TestNormalizeNumbersMarshalsLoadableJSON(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	data := map[string]interface{}{
+		"DOCUMENT_ID": "a",
+		"TOTAL":       1e21,
+		"NAN":         math.NaN(),
+		"INF":         math.Inf(1),
+		"BIG":         huge,
+		"KEPT":        json.Number("1e3"),
+		"LIST":        []interface{}{map[string]interface{}{"SHARE": 2.5e-7}},
+	}
+	NormalizeNumbers(data)
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshalling: %v", err)
+	}
+	want := `{"BIG":123456789012345678901234567890,"DOCUMENT_ID":"a","INF":` + strconv.FormatFloat(math.MaxFloat64, 'f', -1, 64) +
+		`,"KEPT":1e3,"LIST":[{"SHARE":0.00000025}],"NAN":null,"TOTAL":1000000000000000000000}`
+	if string(out) != want {
+		t.Errorf("got %s\nwant %s", out, want)
+	}
+}
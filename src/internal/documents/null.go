@@ -0,0 +1,36 @@
+package documents
+
+// NullMarker stands for NULL in the CSV files loaded into warehouses, so that
+// loaders never have to guess whether an empty field means NULL or an
+// empty string.  Each warehouse's file format is configured to match.
+const NullMarker = `\N`
+
+// EmptyAsNull loads empty strings, i.e. an empty AUTHOR, as NULL instead of an
+// empty string.  It's the same on every warehouse so views behave consistently.
+var EmptyAsNull bool
+
+// Author returns the document's $AUTHOR_ID and whether it should be loaded as
+// NULL: when it's missing, or empty and EmptyAsNull is set.
+func Author(data map[string]interface{}) (string, bool) {
+	author, ok := data["$AUTHOR_ID"].(string)
+	if !ok {
+		return "", true
+	}
+	return author, author == "" && EmptyAsNull
+}
+
+// CSVField returns a value for a CSV file, with NullMarker for NULL.
+func CSVField(value string, null bool) string {
+	if null {
+		return NullMarker
+	}
+	return value
+}
+
+// SQLValue returns a value for a query parameter, with nil for NULL.
+func SQLValue(value string, null bool) interface{} {
+	if null {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,144 @@
+package documents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// pipelineDepth is how many documents per worker may be waiting to be
+// chunked or written, bounding a Pipeline's memory.
+const pipelineDepth = 4
+
+// Prepared is a document chunked and marshaled, ready to be written.
+type Prepared struct {
+	Data   map[string]interface{}   // the document, without the lists moved to other chunks
+	Chunks []map[string]interface{} // as returned by Chunk
+	JSON   [][]byte                 // each chunk, marshaled
+	err    error
+}
+
+// Pipeline reads documents from a nextRecord callback and chunks and marshals
+// them on a pool of workers, while the warehouse writes the ones before.
+// Fetching (and decoding), chunking and writing each get a core of their own
+// rather than taking turns on one.  Documents come out of Next in the order
+// nextRecord returned them.
+type Pipeline struct {
+	results chan chan *Prepared // in order, each filled in by a worker
+	done    chan struct{}       // closed by Close to stop reading early
+	stopped chan struct{}       // closed once nextRecord is no longer called
+	once    sync.Once
+	err     error // nextRecord's error, other than io.EOF
+}
+
+type pipelineJob struct {
+	data map[string]interface{}
+	out  chan *Prepared
+}
+
+// Stream is the documents of one upload, handed to a warehouse's Upload.
+// The warehouse prepares them once, at its own chunk size, so that however
+// many layers the stream passes through on the way (a fan-out's archive, a
+// control record), each document is only chunked and marshaled once.
+type Stream struct {
+	next    func() (map[string]interface{}, error)
+	workers int
+}
+
+// NewStream wraps nextRecord for an upload, to be prepared on workers
+// goroutines (0 for one per CPU).
+func NewStream(nextRecord func() (map[string]interface{}, error), workers int) *Stream {
+	return &Stream{next: nextRecord, workers: workers}
+}
+
+// Prepare starts a Pipeline over the stream's documents, splitting them into
+// chunks of chunkSize, or leaving them whole (as archives keep them) if it's
+// 0.  A stream can only be prepared once, and the Pipeline must be closed.
+func (s *Stream) Prepare(chunkSize int) *Pipeline {
+	nextRecord := s.next
+	workers := s.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &Pipeline{
+		results: make(chan chan *Prepared, workers*pipelineDepth),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	jobs := make(chan pipelineJob, workers*pipelineDepth)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				chunks := []map[string]interface{}{job.data}
+				if chunkSize > 0 {
+					chunks = Chunk(job.data, chunkSize)
+				}
+				prepared := &Prepared{Data: job.data, Chunks: chunks, JSON: make([][]byte, len(chunks))}
+				for i, chunk := range chunks {
+					if prepared.JSON[i], prepared.err = json.Marshal(chunk); prepared.err != nil {
+						prepared.err = fmt.Errorf("marshaling document %v: %v", job.data["DOCUMENT_ID"], prepared.err)
+						break
+					}
+				}
+				job.out <- prepared
+			}
+		}()
+	}
+
+	go func() {
+		defer close(p.stopped)
+		defer close(p.results)
+		defer close(jobs)
+		for {
+			data, err := nextRecord()
+			if err != nil {
+				if err != io.EOF {
+					p.err = err
+				}
+				return
+			}
+			if data == nil {
+				continue
+			}
+			out := make(chan *Prepared, 1)
+			select {
+			case jobs <- pipelineJob{data: data, out: out}:
+			case <-p.done:
+				return
+			}
+			select {
+			case p.results <- out:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Next returns the next document, or io.EOF once there are no more.  An
+// error from nextRecord is returned once the documents before it have been.
+func (p *Pipeline) Next() (*Prepared, error) {
+	out, ok := <-p.results
+	if !ok {
+		if p.err != nil {
+			return nil, p.err
+		}
+		return nil, io.EOF
+	}
+	prepared := <-out
+	if prepared.err != nil {
+		return nil, prepared.err
+	}
+	return prepared, nil
+}
+
+// Close stops reading documents, waiting for any nextRecord call under way
+// to return so that the caller can't race with it.
+func (p *Pipeline) Close() {
+	p.once.Do(func() { close(p.done) })
+	<-p.stopped
+}
@@ -0,0 +1,78 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// records returns a nextRecord callback over n documents, each with a list of
+// i items, failing with err (if any) once they've all been read.
+func records(n int, err error) func() (map[string]interface{}, error) {
+	i := 0
+	return func() (map[string]interface{}, error) {
+		if i == n {
+			if err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		i++
+		list := make([]interface{}, i%7)
+		for j := range list {
+			list[j] = j
+		}
+		return map[string]interface{}{"$TYPE": "AFE", "DOCUMENT_ID": fmt.Sprint(i), "LIST": list}, nil
+	}
+}
+
+func TestPipelineKeepsOrder(t *testing.T) {
+	p := NewStream(records(1000, nil), 4).Prepare(3)
+	defer p.Close()
+	for i := 1; ; i++ {
+		doc, err := p.Next()
+		if err == io.EOF {
+			if i != 1001 {
+				t.Errorf("got %d documents, want 1000", i-1)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Data["DOCUMENT_ID"] != fmt.Sprint(i) {
+			t.Fatalf("document %d is %v", i, doc.Data["DOCUMENT_ID"])
+		}
+		if want := 1 + (i%7+2)/3; i%7 > 3 && len(doc.JSON) != want {
+			t.Errorf("document %d has %d chunks, want %d", i, len(doc.JSON), want)
+		}
+	}
+}
+
+func TestPipelineReturnsReadErrors(t *testing.T) {
+	failed := errors.New("connection reset")
+	p := NewStream(records(10, failed), 0).Prepare(3)
+	defer p.Close()
+	read := 0
+	for {
+		_, err := p.Next()
+		if err == nil {
+			read++
+			continue
+		}
+		if err != failed || read != 10 {
+			t.Errorf("got %v after %d documents, want %v after 10", err, read, failed)
+		}
+		return
+	}
+}
+
+func TestPipelineCloseStopsReading(t *testing.T) {
+	p := NewStream(records(1_000_000, nil), 0).Prepare(3)
+	if _, err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	p.Close()
+}
@@ -0,0 +1,17 @@
+package documents
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// RecordID returns a deterministic identifier for one chunk of a document
+// version, derived from its type, DOCUMENT_ID, $VERSION and chunk number.
+// The same chunk always gets the same ID, whichever run (or retry) sends it,
+// so consumers of at-least-once targets can use it to discard replays.
+func RecordID(data map[string]interface{}, chunk int) string {
+	key := fmt.Sprintf("%s|%s|%d|%d", data["$TYPE"], data["DOCUMENT_ID"], Version(data), chunk)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,99 @@
+package documents
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// How a Sanitizer treats control characters and invalid UTF-8.
+const (
+	SanitizeOff     = "off"     // load documents as they are
+	SanitizeStrip   = "strip"   // remove offending characters
+	SanitizeReplace = "replace" // replace them with U+FFFD
+	SanitizeFail    = "fail"    // refuse to load the document
+)
+
+// Sanitizer cleans the strings in documents of control characters (other
+// than tabs and line breaks) and invalid UTF-8, which break CSV parsing in
+// some warehouses and NVARCHAR conversion in others.
+type Sanitizer struct {
+	mode      string
+	Documents int // documents which needed cleaning
+	Values    int // strings which needed cleaning
+}
+
+// NewSanitizer creates a Sanitizer for one of the Sanitize modes.
+func NewSanitizer(mode string) (*Sanitizer, error) {
+	switch strings.ToLower(mode) {
+	case "", SanitizeOff:
+		return &Sanitizer{mode: SanitizeOff}, nil
+	case SanitizeStrip, SanitizeReplace, SanitizeFail:
+		return &Sanitizer{mode: strings.ToLower(mode)}, nil
+	}
+	return nil, fmt.Errorf("invalid sanitize mode %q: expected off, strip, replace or fail", mode)
+}
+
+// Sanitize cleans a document in place.  In fail mode it returns an error for
+// documents which need cleaning instead.
+func (s *Sanitizer) Sanitize(data map[string]interface{}) error {
+	if s.mode == SanitizeOff {
+		return nil
+	}
+	values := s.Values
+	s.walk(data)
+	if s.Values == values {
+		return nil
+	}
+	s.Documents++
+	if s.mode == SanitizeFail {
+		return fmt.Errorf("document %v %v contains control characters or invalid UTF-8", data["$TYPE"], data["DOCUMENT_ID"])
+	}
+	return nil
+}
+
+func (s *Sanitizer) walk(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.clean(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = s.walk(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.walk(item)
+		}
+	}
+	return value
+}
+
+// clean returns value without offending characters, counting it if any
+// were found.
+func (s *Sanitizer) clean(value string) string {
+	if utf8.ValidString(value) && strings.IndexFunc(value, isControl) < 0 {
+		return value
+	}
+	s.Values++
+
+	var b strings.Builder
+	for i, r := range value {
+		if (r == utf8.RuneError && !strings.HasPrefix(value[i:], "�")) || isControl(r) {
+			if s.mode == SanitizeReplace {
+				b.WriteRune(utf8.RuneError)
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isControl reports whether r is a C0 or C1 control character other than tab,
+// carriage return or line feed.
+func isControl(r rune) bool {
+	if r == '\t' || r == '\n' || r == '\r' {
+		return false
+	}
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
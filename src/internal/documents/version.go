@@ -0,0 +1,52 @@
+package documents
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseVersion replaces a document's $VERSION with an int64, validating it on
+// the way.  Execute usually sends a number but sometimes a string, and large
+// versions lose precision as float64, so documents should be decoded with
+// json.Decoder.UseNumber (or come from a transform, which keeps integers as
+// int) before being parsed.
+func ParseVersion(data map[string]interface{}) error {
+	var version int64
+	var err error
+	switch v := data["$VERSION"].(type) {
+	case int64:
+		version = v
+	case int:
+		version = int64(v)
+	case json.Number:
+		version, err = v.Int64()
+	case string:
+		version, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			err = fmt.Errorf("not an exact integer")
+		}
+		version = int64(v)
+	case nil:
+		err = fmt.Errorf("missing")
+	default:
+		err = fmt.Errorf("unexpected type %T", v)
+	}
+	if err == nil && version < 0 {
+		err = fmt.Errorf("negative")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid $VERSION %v: %v", data["$VERSION"], err)
+	}
+	data["$VERSION"] = version
+	return nil
+}
+
+// Version returns a document's $VERSION, once ParseVersion has parsed it.
+func Version(data map[string]interface{}) int64 {
+	version, _ := data["$VERSION"].(int64)
+	return version
+}
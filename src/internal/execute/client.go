@@ -0,0 +1,345 @@
+package execute
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/charmbracelet/log"
+)
+
+// Features which newer Execute servers advertise through the info endpoint.
+const (
+	// FeatureCalcs means /fetch/ accepts `calc` to include calculated values.
+	FeatureCalcs = "CALCS"
+	// FeatureCompression means responses can be gzip compressed.
+	FeatureCompression = "GZIP"
+	// FeatureTypeFilter means /fetch/document/ accepts a `type` parameter
+	// restricting the results to the listed document types.
+	FeatureTypeFilter = "TYPE_FILTER"
+	// FeatureCursor means /fetch/document/ returns an X-Sync-Cursor header
+	// which continues a truncated result set exactly where it stopped.
+	FeatureCursor = "CURSOR"
+)
+
+// The range of Execute API versions this release has been tested against.
+// Servers outside it are still used, but with a warning.
+const (
+	MinAPIVersion = 1
+	MaxAPIVersion = 3
+)
+
+// Compatibility lists the features each Execute API version introduced, for
+// servers which report their version but not their features.  Servers
+// predating the info endpoint are treated as API version 0.
+var Compatibility = map[int][]string{
+	0: {FeatureCalcs},
+	1: {FeatureCompression},
+	2: {FeatureTypeFilter},
+	3: {FeatureCursor},
+}
+
+// featuresFor returns every feature available in an API version.
+func featuresFor(apiVersion int) []string {
+	var features []string
+	for version := 0; version <= apiVersion && version <= MaxAPIVersion; version++ {
+		features = append(features, Compatibility[version]...)
+	}
+	return features
+}
+
+// Info describes the Execute server, as reported by /fetch/info.
+type Info struct {
+	Version    string   `json:"VERSION"`
+	APIVersion int      `json:"API_VERSION"`
+	Features   []string `json:"FEATURES"`
+}
+
+// Supports reports whether the server advertises a feature.
+func (i Info) Supports(feature string) bool {
+	return slices.Contains(i.Features, feature)
+}
+
+// Client talks to Execute's fetch API.  Capabilities are detected on first use
+// so that optional parameters are only sent to servers which understand them;
+// older servers without the info endpoint are treated as API version 0.
+type Client struct {
+	baseURL   *url.URL
+	keyID     string
+	keySecret string
+	http      *http.Client
+	info      *Info
+}
+
+// Compression is only requested from servers which support it, so Go's
+// transparent gzip handling is turned off in favour of our own.  The default
+// transport is cloned when it's needed, after the CA bundle and proxy have
+// been applied to it (see netconfig).
+// A FETCH_TIMEOUT bounds how long Execute may take to start responding.
+func newTransport(timeout time.Duration) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableCompression = true
+	t.ResponseHeaderTimeout = timeout
+	return t
+}
+
+// NewClient creates a Client for the configured Execute server.
+func NewClient(cfg config.Config) (*Client, error) {
+	baseURL, err := url.Parse(cfg.ExecuteURL)
+	if err != nil {
+		return nil, failure.Errorf(failure.Config, "parsing execute URL: %v", err)
+	}
+	return &Client{
+		baseURL:   baseURL,
+		keyID:     cfg.ExecuteKeyId,
+		keySecret: cfg.ExecuteKeySecret,
+		http:      &http.Client{Transport: newTransport(time.Duration(cfg.FetchTimeout) * time.Second)},
+	}, nil
+}
+
+// get performs an authenticated GET against the fetch API, adding any extra
+// headers given.  The caller must close the response body.
+func (c *Client) get(path string, query url.Values, header http.Header) (*http.Response, error) {
+	// Appends the Fetch API to the BASE URI
+	parsedURL := c.baseURL.JoinPath(path)
+	parsedURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", parsedURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	// Add credentials to the request (Execute uses BASIC Auth)
+	req.SetBasicAuth(c.keyID, c.keySecret)
+	if c.info != nil && c.info.Supports(FeatureCompression) {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, failure.Errorf(failure.SourceFetch, "performing request: %w", err)
+	}
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, failure.Errorf(failure.SourceFetch, "decompressing response: %v", err)
+		}
+		resp.Body = gzipBody{gz, resp.Body}
+	}
+	return resp, nil
+}
+
+// gzipBody decompresses a response body, closing the underlying body too.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g gzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// Info returns the server's version and capabilities.  The first call
+// performs the handshake: the server's version is logged, a warning issued if
+// this release hasn't been tested against it, and the features of servers
+// which don't list their own are looked up in the Compatibility matrix.
+func (c *Client) Info() (Info, error) {
+	if c.info != nil {
+		return *c.info, nil
+	}
+
+	resp, err := c.get("/fetch/info", url.Values{}, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+
+	var info Info
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return Info{}, failure.Errorf(failure.SourceFetch, "parsing server info: %v", err)
+		}
+	case http.StatusNotFound:
+		// Servers predating the info endpoint get the API version 0 features
+		log.Debug("Execute server doesn't report its capabilities")
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		log.Debugf("Execute API info error response - Status: %d, Body: %s", resp.StatusCode, string(body))
+		return Info{}, statusError(resp)
+	}
+
+	if info.Features == nil {
+		info.Features = featuresFor(info.APIVersion)
+	}
+	log.Debug("Execute server info", "version", info.Version, "api", info.APIVersion, "features", info.Features)
+	if info.APIVersion != 0 && (info.APIVersion < MinAPIVersion || info.APIVersion > MaxAPIVersion) {
+		log.Warn("Execute API version not tested with this release of execute-sync", "version", info.Version, "api", info.APIVersion, "supported", fmt.Sprintf("%d-%d", MinAPIVersion, MaxAPIVersion))
+	}
+	c.info = &info
+	return info, nil
+}
+
+// FetchRequest selects the documents to fetch.
+type FetchRequest struct {
+	Path         string   // the fetch endpoint (/fetch/document/ when empty), see config.Endpoint
+	Since        string   // return documents updated after this highwater mark
+	Cursor       string   // continue a truncated result set (overrides Since)
+	Limit        int      // maximum number of documents to return
+	Types        []string // only return these document types (all when empty)
+	IncludeCalcs bool     // include calculated values
+}
+
+// FetchResponse is a page of newline delimited documents.  The caller must
+// close Body.
+type FetchResponse struct {
+	Body      io.ReadCloser
+	Highwater string // X-Sync-Highwater-Mark, to store for the next sync
+	Truncated bool   // more documents remain
+	Cursor    string // continues the result set, when the server supports it
+	// Filtered is true when the server has already applied the type filter.
+	// Otherwise the caller must skip unwanted types itself.
+	Filtered bool
+	// Date is the server's clock when it responded, zero if not reported.
+	Date time.Time
+	// Spool is the file the page was downloaded to by FetchSpooled.
+	Spool string
+}
+
+// StatusError is returned when a request fails with an unexpected HTTP
+// status.
+type StatusError struct {
+	Code int
+	// RetryAfter is how long the server asked to be left alone for, zero if
+	// it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+// Failure classifies the error: Execute rejecting the API key, or failing
+// the request.
+func (e StatusError) Failure() failure.Kind {
+	if e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden {
+		return failure.SourceAuth
+	}
+	return failure.SourceFetch
+}
+
+// statusError returns the error for an unexpected response, with its
+// Retry-After header (a number of seconds, or a date).
+func statusError(resp *http.Response) StatusError {
+	e := StatusError{Code: resp.StatusCode}
+	retryAfter := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		e.RetryAfter = max(time.Until(date), 0)
+	}
+	return e
+}
+
+// Retryable reports whether a fetch failed in a way that a smaller one might
+// not, i.e. the server (or a gateway in front of it) timed out.
+func Retryable(err error) bool {
+	var status StatusError
+	if errors.As(err, &status) {
+		switch status.Code {
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Unavailable reports whether a request failed because Execute is down for
+// maintenance (a 503 Service Unavailable, as Execute and IIS respond while
+// they're being patched), along with how long the server asked to be left
+// alone for, if it said.  Retrying sooner, or with fewer documents, won't help.
+func Unavailable(err error) (time.Duration, bool) {
+	var status StatusError
+	if errors.As(err, &status) && status.Code == http.StatusServiceUnavailable {
+		return status.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Fetch retrieves a page of documents.  Types and Cursor are only sent to
+// servers advertising support for them.
+func (c *Client) Fetch(r FetchRequest) (*FetchResponse, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("limit", fmt.Sprint(r.Limit))
+	if r.Cursor != "" && info.Supports(FeatureCursor) {
+		query.Set("cursor", r.Cursor)
+	} else {
+		query.Set("since", r.Since)
+	}
+	if r.IncludeCalcs {
+		if info.Supports(FeatureCalcs) {
+			query.Set("calc", "true")
+		} else {
+			log.Warn("Execute server doesn't support calculated values, ignoring INCLUDE_CALCS")
+		}
+	}
+	filtered := len(r.Types) == 0
+	if !filtered && info.Supports(FeatureTypeFilter) {
+		query.Set("type", strings.Join(r.Types, ","))
+		filtered = true
+	}
+
+	path := r.Path
+	if path == "" {
+		path = config.DocumentEndpoint
+	}
+	log.Debug("Pulling batch from Execute", "path", path)
+	resp, err := c.get(path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		log.Debugf("HTTP error response - Status: %d, Body: %s, Headers: %v", resp.StatusCode, string(body), resp.Header)
+		return nil, statusError(resp)
+	}
+
+	result := &FetchResponse{
+		Body:      resp.Body,
+		Highwater: resp.Header.Get("X-Sync-Highwater-Mark"),
+		Truncated: strings.ToUpper(resp.Header.Get("X-Sync-Truncated")) != "FALSE",
+		Filtered:  filtered,
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		result.Date = date
+	}
+	if info.Supports(FeatureCursor) {
+		result.Cursor = resp.Header.Get("X-Sync-Cursor")
+	}
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package execute
+
+import (
+	"io"
+	"sync"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/spool"
+)
+
+// Prefetch is a page being fetched in the background (see Client.Prefetch).
+type Prefetch struct {
+	Request FetchRequest
+	done    chan struct{}
+	mu      sync.Mutex
+	body    io.Closer // the response being downloaded, closed to abort it
+	aborted bool
+	file    *spool.File
+	resp    *FetchResponse
+	err     error
+}
+
+// Prefetch starts fetching r while the caller is busy with the page before,
+// i.e. loading it into the warehouse.  The whole page is downloaded to a
+// spool file, so that Execute's connection isn't held open waiting for the
+// caller to start reading.  The caller must either Wait for the page, or
+// Discard it.
+func (c *Client) Prefetch(r FetchRequest) *Prefetch {
+	p := &Prefetch{Request: r, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		resp, err := c.Fetch(r)
+		if err != nil {
+			p.err = err
+			return
+		}
+		defer resp.Body.Close()
+		p.mu.Lock()
+		p.body = resp.Body
+		aborted := p.aborted
+		p.mu.Unlock()
+		if aborted {
+			p.err = io.ErrClosedPipe
+			return
+		}
+
+		if p.file, p.err = spool.New("prefetch_*.ndjson"); p.err != nil {
+			return
+		}
+		if _, err := io.Copy(p.file, resp.Body); err != nil {
+			p.err = failure.Errorf(failure.SourceFetch, "downloading batch: %w", err)
+			return
+		}
+		reader, err := p.file.Reader()
+		if err != nil {
+			p.err = err
+			return
+		}
+		page := *resp
+		page.Body = io.NopCloser(reader)
+		p.resp = &page
+	}()
+	return p
+}
+
+// Wait returns the page once it's been downloaded.  Its Body is only valid
+// until the Prefetch is discarded.
+func (p *Prefetch) Wait() (*FetchResponse, error) {
+	<-p.done
+	return p.resp, p.err
+}
+
+// Discard stops the download, if it's still under way, and removes the
+// spool file.
+func (p *Prefetch) Discard() {
+	p.mu.Lock()
+	p.aborted = true
+	if p.body != nil {
+		p.body.Close()
+	}
+	p.mu.Unlock()
+	<-p.done
+	if p.file != nil {
+		p.file.Close()
+	}
+}
@@ -1,14 +1,19 @@
 package execute
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/failure"
 	"github.com/charmbracelet/log"
 )
 
@@ -35,62 +40,197 @@ type RootSchema map[string]DocumentSchema
 // It takes a configuration object `cfg` containing the API endpoint and credentials.
 // The function returns a `RootSchema` representing the document schema and an error if any occurs.
 func FetchSchema(cfg config.Config) (RootSchema, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// Parse the base URL
-	parsedURL, err := url.Parse(cfg.ExecuteURL)
+	client, err := NewClient(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("parsing execute URL: %v", err)
+		return nil, err
 	}
-
-	// Appends the Fetch API to the BASE URI
-	parsedURL = parsedURL.JoinPath("/fetch/document/schema")
+	client.http.Timeout = 30 * time.Second
 
 	// Add query string parameters to the URL
-	query := parsedURL.Query()
-	if cfg.IncludeCalcs {
+	info, err := client.Info()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	if cfg.IncludeCalcs && info.Supports(FeatureCalcs) {
 		query.Set("calc", "true")
 	}
-	parsedURL.RawQuery = query.Encode()
 
-	// Fetch the data
-	req, err := http.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %v", err)
+	// Ask Execute to skip sending the schema if our cached copy is current
+	header := http.Header{}
+	cache, cached := loadSchemaCache(cfg)
+	if cache != nil {
+		if cache.ETag != "" {
+			header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			header.Set("If-Modified-Since", cache.LastModified)
+		}
 	}
 
-	// Add credentials to the request (Execute uses BASIC Auth)
-	req.SetBasicAuth(cfg.ExecuteKeyId, cfg.ExecuteKeySecret)
-
 	log.Debug("Pulling schema from Execute")
-	resp, err := client.Do(req)
+	resp, err := client.get("/fetch/document/schema", query, header)
 	if err != nil {
-		return nil, fmt.Errorf("performing request: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cache != nil {
+		log.Debug("Schema unchanged, using cached copy", "fetched", cache.FetchedAt)
+		if err := addEndpointSchemas(cfg, client, query, cached); err != nil {
+			return nil, err
+		}
+		return prepareSchema(cfg, cached)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		log.Debugf("Execute API schema error response - Status: %d, Body: %s, Headers: %v", resp.StatusCode, string(body), resp.Header)
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, statusError(resp)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body: %v", err)
+		return nil, failure.Errorf(failure.SourceFetch, "reading response body: %v", err)
 	}
 
 	// Parse the retrieve document as JSON so that we can extract metadata fields
 	var data RootSchema
 	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		return nil, fmt.Errorf("parsing schema: %v", err)
+		return nil, failure.Errorf(failure.SourceFetch, "parsing schema: %v", err)
+	}
+
+	// Cache the (unfiltered) schema when Execute gave us a way to validate it
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag != "" || lastModified != "" {
+		saveSchemaCache(cfg, schemaCache{
+			URL:          cfg.ExecuteURL,
+			IncludeCalcs: cfg.IncludeCalcs,
+			ETag:         etag,
+			LastModified: lastModified,
+			FetchedAt:    time.Now().UTC(),
+			Schema:       bodyBytes,
+		})
+	}
+
+	if err := addEndpointSchemas(cfg, client, query, data); err != nil {
+		return nil, err
+	}
+	return prepareSchema(cfg, data)
+}
+
+// addEndpointSchemas adds the schema of each of the ENDPOINTS to schema,
+// under its table.  An endpoint's schema is fetched from its SchemaPath, in
+// the same form as /fetch/document/schema; the fields of all the types it
+// describes are merged, as the endpoint's documents are loaded as one type.
+// An endpoint without a schema still gets a view, of the standard columns.
+// These schemas are small, so they're fetched every time rather than cached.
+func addEndpointSchemas(cfg config.Config, client *Client, query url.Values, schema RootSchema) error {
+	endpoints, err := config.Endpoints(cfg)
+	if err != nil {
+		return err
+	}
+	for _, e := range endpoints {
+		if _, ok := schema[e.Table]; ok {
+			return failure.Errorf(failure.Config, "ENDPOINTS table %s is already an Execute document type", e.Table)
+		}
+		resp, err := client.get(e.SchemaPath(), query, nil)
+		if err != nil {
+			return err
+		}
+		var endpointSchema RootSchema
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&endpointSchema)
+		case http.StatusNotFound:
+			log.Warn("Endpoint has no schema, its view will only have the standard columns", "table", e.Table, "path", e.SchemaPath())
+		default:
+			err = statusError(resp)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return failure.Errorf(failure.SourceFetch, "fetching schema of %s: %v", e.Table, err)
+		}
+
+		fields := DocumentSchema{}
+		for _, docSchema := range endpointSchema {
+			for name, field := range docSchema {
+				fields[name] = field
+			}
+		}
+		schema[e.Table] = fields
 	}
+	return nil
+}
 
+// prepareSchema applies the configuration to a schema fetched from Execute:
+// hiding inactive fields, and adding the ATTRIBUTES to every document type.
+func prepareSchema(cfg config.Config, schema RootSchema) (RootSchema, error) {
 	if cfg.HideInactiveFields {
-		filterInactiveFields(data)
+		filterInactiveFields(schema)
+	}
+
+	attributes, err := documents.ParseAttributes(cfg.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	for _, docSchema := range schema {
+		for _, a := range attributes {
+			docSchema[a.Name] = FieldMetadata{Name: a.Name, Active: true, Type: "TEXT", Nullable: true}
+		}
+	}
+	return schema, nil
+}
+
+// schemaCacheFile is the file in STATE_DIR holding the last schema fetched.
+const schemaCacheFile = "schema_cache.json"
+
+// schemaCache is the last schema fetched from Execute, along with the
+// validators needed to ask Execute whether it has changed since.
+type schemaCache struct {
+	URL          string          `json:"url"`
+	IncludeCalcs bool            `json:"include_calcs"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	Schema       json.RawMessage `json:"schema"`
+}
+
+// loadSchemaCache returns the cache along with the parsed schema, or nil if
+// there isn't a cache matching the configuration (or REFRESH_SCHEMA is set).
+func loadSchemaCache(cfg config.Config) (*schemaCache, RootSchema) {
+	if cfg.RefreshSchema {
+		return nil, nil
 	}
+	bytes, err := os.ReadFile(filepath.Join(cfg.StateDir, schemaCacheFile))
+	if err != nil {
+		return nil, nil
+	}
+	var cache schemaCache
+	var schema RootSchema
+	if err = json.Unmarshal(bytes, &cache); err == nil {
+		err = json.Unmarshal(cache.Schema, &schema)
+	}
+	if err != nil {
+		log.Debugf("Ignoring unreadable schema cache: %v", err)
+		return nil, nil
+	}
+	if cache.URL != cfg.ExecuteURL || cache.IncludeCalcs != cfg.IncludeCalcs {
+		return nil, nil
+	}
+	return &cache, schema
+}
 
-	return data, nil
+// saveSchemaCache writes the schema cache.  Failing to is only worth a warning
+// as the schema will simply be fetched again next time.
+func saveSchemaCache(cfg config.Config, cache schemaCache) {
+	bytes, err := json.Marshal(cache)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(cfg.StateDir, schemaCacheFile), bytes, 0644)
+	}
+	if err != nil {
+		log.Warnf("Error caching schema: %v", err)
+	}
 }
 
 func filterInactiveFields(schema RootSchema) {
@@ -117,3 +257,16 @@ func filterInactiveDocumentFields(fields map[string]FieldMetadata) {
 		fields[fieldName] = field
 	}
 }
+
+// Fingerprints returns a hash of each document type's schema, so that callers
+// can tell which types have changed between two schemas.
+func Fingerprints(schema RootSchema) map[string]string {
+	fingerprints := map[string]string{}
+	for docType, docSchema := range schema {
+		// Maps are marshalled with sorted keys, so equal schemas hash equally
+		bytes, _ := json.Marshal(docSchema)
+		sum := sha256.Sum256(bytes)
+		fingerprints[docType] = hex.EncodeToString(sum[:])
+	}
+	return fingerprints
+}
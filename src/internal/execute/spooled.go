@@ -0,0 +1,156 @@
+package execute
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/charmbracelet/log"
+)
+
+// downloadAttempts is how many times FetchSpooled tries to download a page
+// before giving up.
+const downloadAttempts = 3
+
+// spooledPage records a page downloaded by FetchSpooled, alongside the page
+// itself, so that it can be reused by a later run.
+type spooledPage struct {
+	Highwater string
+	Truncated bool
+	Cursor    string
+	Filtered  bool
+	Date      time.Time
+	Checksum  string // SHA-256 of the downloaded page
+}
+
+// spoolKey identifies the page a request would return.  The limit isn't part
+// of it: any page starting from the same place is as good as another.
+func spoolKey(r FetchRequest) string {
+	key := fmt.Sprintf("%s|%s|%s|%t", r.Since, r.Cursor, strings.Join(r.Types, ","), r.IncludeCalcs)
+	if r.Path != "" {
+		key += "|" + r.Path
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// FetchSpooled is like Fetch, but downloads the whole page to dir before
+// returning it.  A dropped connection then only costs a fresh download, and
+// the page stays in dir until ClearSpooled is called once it's been loaded:
+// if loading fails, the next run with the same request reuses it (after
+// verifying its checksum) rather than fetching it again.
+func (c *Client) FetchSpooled(r FetchRequest, dir string) (*FetchResponse, error) {
+	base := filepath.Join(dir, "fetch_"+spoolKey(r))
+	if resp, err := openSpooled(base); err == nil {
+		log.Info("Reusing previously downloaded batch", "file", base+".ndjson")
+		return resp, nil
+	} else if !os.IsNotExist(err) {
+		log.Warn("Downloading batch again", "reason", err)
+	}
+
+	// Only a dropped download is retried here; a fetch which fails outright
+	// is left to the caller
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		resp, fetchErr := c.Fetch(r)
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		if err = download(resp, base); err == nil {
+			return openSpooled(base)
+		}
+		log.Warn("Batch download failed", "attempt", attempt, "error", err)
+	}
+	return nil, err
+}
+
+// download saves a page into base.ndjson, describing it in base.json.
+func download(resp *FetchResponse, base string) error {
+	defer resp.Body.Close()
+
+	file, err := os.Create(base + ".ndjson")
+	if err != nil {
+		return failure.Errorf(failure.Spool, "creating spooled batch: %v", err)
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), resp.Body); err != nil {
+		os.Remove(file.Name())
+		return failure.Errorf(failure.SourceFetch, "downloading batch: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return failure.Errorf(failure.Spool, "writing spooled batch: %v", err)
+	}
+
+	meta, _ := json.Marshal(spooledPage{
+		Highwater: resp.Highwater,
+		Truncated: resp.Truncated,
+		Cursor:    resp.Cursor,
+		Filtered:  resp.Filtered,
+		Date:      resp.Date,
+		Checksum:  hex.EncodeToString(hasher.Sum(nil)),
+	})
+	// The description is written last, and atomically, so a page is only
+	// reused once it's been downloaded completely
+	if err := os.WriteFile(base+".json.tmp", meta, 0644); err != nil {
+		return failure.Errorf(failure.Spool, "writing spooled batch: %v", err)
+	}
+	return failure.Wrap(failure.Spool, os.Rename(base+".json.tmp", base+".json"))
+}
+
+// openSpooled returns a downloaded page, if its checksum still matches.
+func openSpooled(base string) (*FetchResponse, error) {
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var page spooledPage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", base+".json", err)
+	}
+
+	file, err := os.Open(base + ".ndjson")
+	if err != nil {
+		return nil, fmt.Errorf("opening spooled batch: %v", err)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading spooled batch: %v", err)
+	}
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != page.Checksum {
+		file.Close()
+		return nil, fmt.Errorf("spooled batch %s is corrupt (checksum %s, expected %s)", file.Name(), checksum, page.Checksum)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FetchResponse{
+		Body:      file,
+		Highwater: page.Highwater,
+		Truncated: page.Truncated,
+		Cursor:    page.Cursor,
+		Filtered:  page.Filtered,
+		Date:      page.Date,
+		Spool:     file.Name(),
+	}, nil
+}
+
+// ClearSpooled removes the pages FetchSpooled downloaded to dir, once they've
+// been loaded and the highwater mark moved past them.
+func ClearSpooled(dir string) {
+	for _, pattern := range []string{"fetch_*.ndjson", "fetch_*.json", "fetch_*.json.tmp"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package failure classifies the errors execute-sync fails with, so that
+// monitoring can tell a bad password from a full disk without matching on
+// messages.  Each Kind has a stable code, logged and recorded in run
+// manifests and notifications, and an exit code of its own.
+package failure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind is a class of failure.  Its value is the stable code reported for it.
+type Kind string
+
+// Kinds of failure, and their codes.
+const (
+	Config        Kind = "CONFIG"         // settings are missing or invalid
+	SourceAuth    Kind = "SOURCE_AUTH"    // Execute rejected the API key
+	SourceFetch   Kind = "SOURCE_FETCH"   // Execute couldn't be reached or failed a request
+	Spool         Kind = "SPOOL"          // a spool file couldn't be written or read back
+	WarehouseAuth Kind = "WAREHOUSE_AUTH" // the warehouse couldn't be connected or logged in to
+	WarehouseLoad Kind = "WAREHOUSE_LOAD" // a statement or load failed in the warehouse
+	State         Kind = "STATE"          // STATE_DIR couldn't be read or written, or disagrees with Execute
+	Unknown       Kind = "UNKNOWN"        // anything not classified
+)
+
+// exitCodes are the process exit codes of each kind.  1 is left for Unknown,
+// as it's what the CLI exits with for usage errors too.
+var exitCodes = map[Kind]int{
+	Unknown:       1,
+	Config:        2,
+	SourceAuth:    3,
+	SourceFetch:   4,
+	Spool:         5,
+	WarehouseAuth: 6,
+	WarehouseLoad: 7,
+	State:         8,
+}
+
+// ExitCode returns the exit code execute-sync fails with for the kind.
+func (k Kind) ExitCode() int {
+	if code, ok := exitCodes[k]; ok {
+		return code
+	}
+	return exitCodes[Unknown]
+}
+
+// Error is an error classified as a Kind.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Failure returns the kind the error was classified as.
+func (e *Error) Failure() Kind {
+	return e.Kind
+}
+
+// Classifier is implemented by errors which know their own kind (Error
+// included).
+type Classifier interface {
+	Failure() Kind
+}
+
+// Wrap classifies err as kind, unless it's nil or already classified: the
+// classification made closest to the failure is the one kept.
+func Wrap(kind Kind, err error) error {
+	if err == nil || Classified(err) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf formats an error (as fmt.Errorf does) classified as kind.
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return Wrap(kind, fmt.Errorf(format, args...))
+}
+
+// Classified reports whether err, or an error it wraps, has been classified.
+func Classified(err error) bool {
+	var classifier Classifier
+	return errors.As(err, &classifier)
+}
+
+// KindOf returns the kind err was classified as, Unknown if it wasn't, or ""
+// for a nil error.
+func KindOf(err error) Kind {
+	if err == nil {
+		return ""
+	}
+	var classifier Classifier
+	if errors.As(err, &classifier) {
+		return classifier.Failure()
+	}
+	return Unknown
+}
@@ -0,0 +1,49 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{"nil", nil, ""},
+		{"unclassified", cause, Unknown},
+		{"wrapped", Wrap(WarehouseAuth, cause), WarehouseAuth},
+		{"wrapped with %w", fmt.Errorf("error bootstrapping database: %w", Wrap(WarehouseAuth, cause)), WarehouseAuth},
+		{"wrapped with %v", fmt.Errorf("error bootstrapping database: %v", Wrap(WarehouseAuth, cause)), Unknown},
+		{"first classification kept", Wrap(WarehouseLoad, fmt.Errorf("uploading: %w", Wrap(Spool, cause))), Spool},
+		{"classifier", fmt.Errorf("fetching: %w", classified{}), SourceAuth},
+		{"errorf", Errorf(Config, "bad setting %q", "X"), Config},
+	}
+	for _, tt := range tests {
+		if got := KindOf(tt.err); got != tt.want {
+			t.Errorf("%s: KindOf() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	seen := map[int]Kind{}
+	for _, kind := range []Kind{Config, SourceAuth, SourceFetch, Spool, WarehouseAuth, WarehouseLoad, State, Unknown} {
+		code := kind.ExitCode()
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s both exit %d", kind, other, code)
+		}
+		seen[code] = kind
+	}
+	if got := Kind("NEW").ExitCode(); got != Unknown.ExitCode() {
+		t.Errorf("unlisted kind exits %d, want %d", got, Unknown.ExitCode())
+	}
+}
+
+type classified struct{}
+
+func (classified) Error() string { return "unauthorized" }
+func (classified) Failure() Kind { return SourceAuth }
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package logsink
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openEventLog() (backend, error) {
+	return nil, fmt.Errorf("the Windows Event Log isn't available on %s", runtime.GOOS)
+}
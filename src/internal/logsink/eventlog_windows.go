@@ -0,0 +1,38 @@
+//go:build windows
+
+package logsink
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventID is the ID of every entry; the Event Log distinguishes them by type.
+const eventID = 1
+
+type eventLogBackend struct {
+	*eventlog.Log
+}
+
+func openEventLog() (backend, error) {
+	// Registering the source needs administrator rights the first time; when
+	// it fails (or is already registered) entries are still logged, but the
+	// Event Viewer may not format them as nicely
+	_ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return eventLogBackend{l}, nil
+}
+
+func (e eventLogBackend) write(level string, message string) error {
+	switch level {
+	case "WARN":
+		return e.Warning(eventID, message)
+	case "ERRO", "FATA":
+		return e.Error(eventID, message)
+	default:
+		// The Event Log has no debug level
+		return e.Info(eventID, message)
+	}
+}
@@ -0,0 +1,76 @@
+// Package logsink forwards log entries to the operating system's own log: the
+// Windows Event Log or syslog (which journald collects on systemd hosts).
+package logsink
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sinks supported by Open, besides "none".
+const (
+	Syslog   = "syslog"
+	EventLog = "eventlog"
+)
+
+// source identifies execute-sync's entries in the OS log.
+const source = "execute-sync"
+
+// backend writes a message to the OS log at one of the logger's levels.
+type backend interface {
+	write(level string, message string) error
+	Close() error
+}
+
+// levels are the logger's abbreviated level names.
+var levels = map[string]bool{"DEBU": true, "INFO": true, "WARN": true, "ERRO": true, "FATA": true}
+
+// Sink is an io.Writer for the logger which forwards each entry to the OS log
+// at its original severity.
+type Sink struct {
+	backend backend
+}
+
+// Open connects to the named OS log.
+func Open(name string) (*Sink, error) {
+	var b backend
+	var err error
+	switch strings.ToLower(name) {
+	case Syslog:
+		b, err = openSyslog()
+	case EventLog:
+		b, err = openEventLog()
+	default:
+		return nil, fmt.Errorf("unknown log sink %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{backend: b}, nil
+}
+
+// Write receives one formatted log entry, i.e. "2024/05/01 09:30:00 WARN msg
+// key=value".  The OS log keeps its own timestamp, so only the level and what
+// follows it are forwarded.
+func (s *Sink) Write(p []byte) (int, error) {
+	entry := strings.TrimSpace(string(p))
+	level, message := "INFO", entry
+	// The level follows the timestamp, if there is one
+	fields := strings.Fields(entry)
+	for i := 0; i < len(fields) && i < 3; i++ {
+		if levels[fields[i]] {
+			level = fields[i]
+			message = strings.TrimSpace(entry[strings.Index(entry, level)+len(level):])
+			break
+		}
+	}
+	if err := s.backend.write(level, message); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close disconnects from the OS log.
+func (s *Sink) Close() error {
+	return s.backend.Close()
+}
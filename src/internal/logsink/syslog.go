@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"log/syslog"
+)
+
+type syslogBackend struct {
+	*syslog.Writer
+}
+
+func openSyslog() (backend, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, source)
+	if err != nil {
+		return nil, err
+	}
+	return syslogBackend{w}, nil
+}
+
+func (s syslogBackend) write(level string, message string) error {
+	switch level {
+	case "DEBU":
+		return s.Debug(message)
+	case "WARN":
+		return s.Warning(message)
+	case "ERRO":
+		return s.Err(message)
+	case "FATA":
+		return s.Crit(message)
+	default:
+		return s.Info(message)
+	}
+}
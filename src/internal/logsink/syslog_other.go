@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package logsink
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openSyslog() (backend, error) {
+	return nil, fmt.Errorf("syslog isn't available on %s", runtime.GOOS)
+}
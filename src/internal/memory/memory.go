@@ -0,0 +1,70 @@
+// Package memory keeps execute-sync within the MAX_MEMORY budget, i.e. when
+// running in a small container.  The budget is applied as the Go runtime's
+// soft memory limit, bounds the buffers warehouses hold in memory, and is
+// checked by the record pipeline between records.
+package memory
+
+import (
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+
+	"github.com/charmbracelet/log"
+)
+
+// Max is the memory budget in bytes.  Zero (the default) means unlimited.
+var Max int64
+
+// checkEvery is how many records pass between checks of the heap size.
+const checkEvery = 100
+
+var (
+	records int
+	warned  bool
+)
+
+// SetMax sets the memory budget, and the runtime's soft memory limit with it
+// so the garbage collector works harder as the budget is approached.
+func SetMax(bytes int64) {
+	Max = bytes
+	if bytes > 0 {
+		debug.SetMemoryLimit(bytes)
+	}
+}
+
+// Budget returns a fraction of the budget for one buffer, or fallback when
+// there's no budget or fallback is smaller anyway.
+func Budget(fraction float64, fallback int64) int64 {
+	if Max <= 0 {
+		return fallback
+	}
+	return min(int64(float64(Max)*fraction), fallback)
+}
+
+// Check applies backpressure to the record pipeline.  Every so often it looks
+// at the heap and, if it has grown past the budget, collects garbage and
+// returns memory to the OS before the next record is read.
+func Check() {
+	if Max <= 0 {
+		return
+	}
+	records++
+	if records%checkEvery != 0 || heap() < Max {
+		return
+	}
+
+	log.Debug("Over memory budget, collecting garbage", "heap", heap(), "max", Max)
+	runtime.GC()
+	debug.FreeOSMemory()
+	if heap() >= Max && !warned {
+		log.Warn("Memory use is over MAX_MEMORY, consider lowering MAX_DOCUMENTS or CHUNK_SIZE", "heap_mb", heap()/(1024*1024), "max_mb", Max/(1024*1024))
+		warned = true
+	}
+}
+
+// heap returns the bytes occupied by heap objects.
+func heap() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return int64(sample[0].Value.Uint64())
+}
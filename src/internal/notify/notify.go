@@ -0,0 +1,85 @@
+// Package notify tells people how syncs are going, through a webhook such as
+// a Slack or Teams incoming webhook, or by email.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Kinds of message.
+const (
+	Digest      = "digest"
+	Failure     = "failure"
+	Anomaly     = "anomaly"
+	Regression  = "regression"
+	Unavailable = "unavailable"
+)
+
+// Message is a notification.  Text is what people read; Data is passed along
+// to webhooks for anything that wants to process the details.
+type Message struct {
+	Kind    string
+	Subject string
+	Text    string
+	Data    interface{}
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Notify(m Message) error
+}
+
+// Multi delivers messages through several notifiers, carrying on past any
+// which fail.
+type Multi []Notifier
+
+func (n Multi) Notify(m Message) error {
+	var errs []error
+	for _, notifier := range n {
+		if err := notifier.Notify(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Webhook posts messages as JSON.  The text is sent as "text", which is all
+// Slack and Teams incoming webhooks need; other receivers can use "kind",
+// "subject" and "data".
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (w *Webhook) Notify(m Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"kind":    m.Kind,
+		"text":    m.Text,
+		"subject": m.Subject,
+		"data":    m.Data,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("posting notification: status %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
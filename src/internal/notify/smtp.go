@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/netconfig"
+)
+
+// TLS modes for SMTP relays.
+const (
+	StartTLS = "starttls" // upgrade a plain connection (usually port 587)
+	TLS      = "tls"      // connect over TLS (usually port 465)
+	NoTLS    = "none"     // internal relays without TLS
+)
+
+// SMTPOptions configure an SMTP notifier.  Subject and Body are text/template
+// templates executed against the Message, defaulting to its Subject and Text.
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+	To       []string
+	Subject  string
+	Body     string
+}
+
+// SMTP emails messages through a mail relay.
+type SMTP struct {
+	opts    SMTPOptions
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewSMTP creates an SMTP notifier, checking its templates.
+func NewSMTP(opts SMTPOptions) (*SMTP, error) {
+	if opts.From == "" || len(opts.To) == 0 {
+		return nil, fmt.Errorf("SMTP notifications need a sender and at least one recipient")
+	}
+	if opts.Subject == "" {
+		opts.Subject = "{{.Subject}}"
+	}
+	if opts.Body == "" {
+		opts.Body = "{{.Text}}"
+	}
+	subject, err := template.New("subject").Parse(opts.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SMTP subject template: %v", err)
+	}
+	body, err := template.New("body").Parse(opts.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SMTP body template: %v", err)
+	}
+	return &SMTP{opts: opts, subject: subject, body: body}, nil
+}
+
+func (s *SMTP) Notify(m Message) error {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, m); err != nil {
+		return fmt.Errorf("rendering email subject: %v", err)
+	}
+	if err := s.body.Execute(&body, m); err != nil {
+		return fmt.Errorf("rendering email body: %v", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.opts.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.opts.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	if err := s.send(msg.Bytes()); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
+
+// send delivers an email to every recipient over a single connection.
+func (s *SMTP) send(msg []byte) error {
+	addr := net.JoinHostPort(s.opts.Host, fmt.Sprint(s.opts.Port))
+	tlsConfig := netconfig.TLSConfig(s.opts.Host)
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if s.opts.TLS == TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	c, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.opts.TLS == StartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if s.opts.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if err := c.Mail(s.opts.From); err != nil {
+		return err
+	}
+	for _, to := range s.opts.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,166 @@
+package spool
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/timing"
+)
+
+// MemoryLimit is the number of bytes a spool File may hold in memory before
+// spilling to disk.  Zero (the default) always spools to disk.
+var MemoryLimit int64
+
+// File is a spool file which is held in memory while it's smaller than
+// MemoryLimit, and spills to a temp file on disk once it grows past it.
+// Small batches can then be uploaded straight from memory, without needing
+// a writable temp directory.
+type File struct {
+	pattern string
+	name    string
+	buf     bytes.Buffer
+	disk    *os.File
+	size    int64
+	hash    hash.Hash
+}
+
+// New creates a spool File.  The pattern names the file (see os.CreateTemp)
+// whether it ends up on disk or not.
+func New(pattern string) (*File, error) {
+	f := &File{
+		pattern: pattern,
+		name:    strings.Replace(pattern, "*", fmt.Sprint(time.Now().UnixNano()), 1),
+		hash:    sha256.New(),
+	}
+	if MemoryLimit <= 0 {
+		if err := f.spill(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// spill moves the file's contents from memory to a temp file on disk.
+func (f *File) spill() error {
+	disk, err := Create(f.pattern)
+	if err != nil {
+		return err
+	}
+	if _, err := disk.Write(f.buf.Bytes()); err != nil {
+		Remove(disk)
+		return failure.Wrap(failure.Spool, err)
+	}
+	f.buf = bytes.Buffer{}
+	f.disk = disk
+	f.name = filepath.Base(disk.Name())
+	return nil
+}
+
+// Write appends to the file, spilling to disk if it outgrows MemoryLimit.
+func (f *File) Write(p []byte) (int, error) {
+	defer timing.Since(timing.Spool, time.Now())
+	if f.disk == nil && f.size+int64(len(p)) > MemoryLimit {
+		if err := f.spill(); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if f.disk != nil {
+		n, err = f.disk.Write(p)
+	} else {
+		n, err = f.buf.Write(p)
+	}
+	f.size += int64(n)
+	f.hash.Write(p[:n])
+	return n, failure.Wrap(failure.Spool, err)
+}
+
+// Name returns the file's base name.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Size returns the number of bytes written.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Checksum returns the hex SHA-256 of everything written, as it was written.
+func (f *File) Checksum() string {
+	return hex.EncodeToString(f.hash.Sum(nil))
+}
+
+// Verify re-reads the file and checks it still matches its Checksum, i.e. that
+// the disk hasn't corrupted it before it's uploaded.
+func (f *File) Verify() error {
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	sum, err := Checksum(r)
+	if err != nil {
+		return failure.Wrap(failure.Spool, err)
+	}
+	if sum != f.Checksum() {
+		return failure.Errorf(failure.Spool, "spool file %s is corrupt: SHA-256 %s, written as %s", f.name, sum, f.Checksum())
+	}
+	return nil
+}
+
+// Checksum returns the hex SHA-256 of everything read from r.
+func Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// InMemory reports whether the file is still held in memory.
+func (f *File) InMemory() bool {
+	return f.disk == nil
+}
+
+// Reader returns a reader over everything written so far.
+func (f *File) Reader() (io.Reader, error) {
+	if f.disk == nil {
+		return bytes.NewReader(f.buf.Bytes()), nil
+	}
+	if _, err := f.disk.Seek(0, io.SeekStart); err != nil {
+		return nil, failure.Wrap(failure.Spool, err)
+	}
+	return f.disk, nil
+}
+
+// Path returns the path of the file on disk, spilling it there first if
+// needed, for consumers which can only read from a file.
+func (f *File) Path() (string, error) {
+	if f.disk == nil {
+		if err := f.spill(); err != nil {
+			return "", err
+		}
+	}
+	if err := f.disk.Sync(); err != nil {
+		return "", failure.Wrap(failure.Spool, err)
+	}
+	return f.disk.Name(), nil
+}
+
+// Close releases the file, deleting it from disk if it was spilled.
+func (f *File) Close() {
+	if f.disk != nil {
+		Remove(f.disk)
+		f.disk = nil
+	}
+	f.buf = bytes.Buffer{}
+}
@@ -0,0 +1,116 @@
+// Package spool manages the temporary files that batches are written to
+// before being loaded into a warehouse.  Spool files can grow to several
+// gigabytes, so they're tracked while in use and removed if the process is
+// interrupted, and any left behind by a crash are swept up at startup.
+package spool
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/charmbracelet/log"
+)
+
+// Patterns are the names (within the temp directory) of spool files.
+var Patterns = []string{"documents_*.csv", "documents_*.csv.gz", "prefetch_*.ndjson", "documents_*.ndjson", "documents_*.parquet", "documents_*.ndjson.gz", "manifest_*.json"}
+
+// VerifyUploads has warehouses read staged files back after uploading them
+// and compare their SHA-256 with the spool file's before loading them, to
+// catch corruption in transit.
+var VerifyUploads bool
+
+var (
+	mu    sync.Mutex
+	files = map[string]*os.File{}
+	dir   string
+)
+
+// SetDir creates spool files in dir (i.e. a sync run's workspace) rather than
+// the temp directory.  An empty dir restores the temp directory.
+func SetDir(d string) {
+	mu.Lock()
+	dir = d
+	mu.Unlock()
+}
+
+// Create creates a new spool file in the spool directory (see os.CreateTemp)
+// and tracks it until it's removed.
+func Create(pattern string) (*os.File, error) {
+	mu.Lock()
+	d := dir
+	mu.Unlock()
+	f, err := os.CreateTemp(d, pattern)
+	if err != nil {
+		return nil, failure.Wrap(failure.Spool, err)
+	}
+	mu.Lock()
+	files[f.Name()] = f
+	mu.Unlock()
+	return f, nil
+}
+
+// Remove closes and deletes a spool file.
+func Remove(f *os.File) {
+	mu.Lock()
+	delete(files, f.Name())
+	mu.Unlock()
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// Cleanup removes every spool file that's still in use.
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+	for name, f := range files {
+		f.Close()
+		os.Remove(name)
+		delete(files, name)
+	}
+}
+
+// HandleSignals removes in-use spool files when the process is interrupted
+// or terminated, then exits.
+func HandleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		log.Warn("Interrupted, removing spool files", "signal", sig)
+		Cleanup()
+		os.Exit(1)
+	}()
+}
+
+// Sweep removes spool files in the temp directory which haven't been
+// modified for maxAge, returning the number removed.  Files in use by another
+// running instance are continually written to, so they're never old enough
+// to be swept.
+func Sweep(maxAge time.Duration) int {
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, pattern := range Patterns {
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+		if err != nil {
+			continue
+		}
+		for _, name := range matches {
+			info, err := os.Stat(name)
+			if err != nil || info.IsDir() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(name); err != nil {
+				log.Warn("Unable to remove stale spool file", "file", name, "error", err)
+				continue
+			}
+			log.Debug("Removed stale spool file", "file", name)
+			removed += 1
+		}
+	}
+	return removed
+}
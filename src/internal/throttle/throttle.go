@@ -0,0 +1,48 @@
+// Package throttle caps the bandwidth used when uploading batches, so that
+// large backfills don't saturate shared or metered links.
+package throttle
+
+import (
+	"io"
+	"time"
+)
+
+// Limit is the upload bandwidth cap in bytes per second.  Zero (the default)
+// means unlimited.
+var Limit int64
+
+// Reader wraps r so that reading from it, and so sending whatever is read,
+// is paced to Limit.  r is returned as is when there's no limit.
+func Reader(r io.Reader) io.Reader {
+	if Limit <= 0 {
+		return r
+	}
+	return &reader{r: r, limit: Limit}
+}
+
+type reader struct {
+	r     io.Reader
+	limit int64
+	start time.Time
+	read  int64
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// Read at most a tenth of a second's allowance at a time so the pacing
+	// is smooth rather than bursty
+	if step := t.limit/10 + 1; int64(len(p)) > step {
+		p = p[:step]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	due := time.Duration(float64(t.read) / float64(t.limit) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+// Package timing adds up where a sync's time goes: fetching from Execute,
+// decoding documents, chunking them, writing spool files and uploading them,
+// so that performance regressions can be diagnosed in the field without an
+// instrumented build.  Totals are kept for the whole process; a run's share
+// is the difference between Snapshots taken before and after it.
+package timing
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a stage of the sync pipeline.
+type Phase int
+
+const (
+	Fetch  Phase = iota // waiting on Execute
+	Decode              // parsing, transforming and sanitizing documents
+	Chunk               // splitting documents into chunks
+	Spool               // writing spool files
+	Upload              // everything else the warehouse adapter does
+	phases
+)
+
+var totals [phases]atomic.Int64
+
+// Add records d spent in p.
+func Add(p Phase, d time.Duration) {
+	totals[p].Add(int64(d))
+}
+
+// Since records the time since start as spent in p.
+func Since(p Phase, start time.Time) {
+	Add(p, time.Since(start))
+}
+
+// Remainder records the time since start as spent in p, less whatever the
+// other phases recorded since before was taken, i.e. for an upload which
+// calls back for (and so fetches and decodes) its documents.  Phases running
+// in parallel can add up to more than the elapsed time, so it's never
+// negative.
+func Remainder(p Phase, start time.Time, before Breakdown) {
+	elapsed := time.Since(start)
+	nested := Snapshot().Sub(before)
+	nested.set(p, 0)
+	Add(p, max(elapsed-nested.Total(), 0))
+}
+
+// Breakdown is the time spent in each phase.
+type Breakdown struct {
+	Fetch  time.Duration `json:"fetch_ns"`
+	Decode time.Duration `json:"decode_ns"`
+	Chunk  time.Duration `json:"chunk_ns"`
+	Spool  time.Duration `json:"spool_ns"`
+	Upload time.Duration `json:"upload_ns"`
+}
+
+// Snapshot returns the process's totals so far.
+func Snapshot() Breakdown {
+	var b Breakdown
+	for p := Phase(0); p < phases; p++ {
+		b.set(p, time.Duration(totals[p].Load()))
+	}
+	return b
+}
+
+func (b *Breakdown) field(p Phase) *time.Duration {
+	return [phases]*time.Duration{&b.Fetch, &b.Decode, &b.Chunk, &b.Spool, &b.Upload}[p]
+}
+
+func (b *Breakdown) set(p Phase, d time.Duration) {
+	*b.field(p) = d
+}
+
+// Sub returns the time spent in each phase since earlier.
+func (b Breakdown) Sub(earlier Breakdown) Breakdown {
+	for p := Phase(0); p < phases; p++ {
+		*b.field(p) -= *earlier.field(p)
+	}
+	return b
+}
+
+// Total returns the time spent in all phases.
+func (b Breakdown) Total() time.Duration {
+	return b.Fetch + b.Decode + b.Chunk + b.Spool + b.Upload
+}
+
+// LogArgs returns the breakdown as key/value pairs for logging, rounded to
+// the millisecond.
+func (b Breakdown) LogArgs() []interface{} {
+	names := [phases]string{"fetch", "decode", "chunk", "spool", "upload"}
+	var args []interface{}
+	for p := Phase(0); p < phases; p++ {
+		args = append(args, names[p], b.field(p).Round(time.Millisecond))
+	}
+	return args
+}
+
+// Reader wraps r so that the time spent in its Reads is recorded as p.
+func Reader(p Phase, r io.Reader) *TimedReader {
+	return &TimedReader{r: r, phase: p}
+}
+
+// TimedReader records the time spent in its Reads (see Reader).
+type TimedReader struct {
+	r       io.Reader
+	phase   Phase
+	elapsed time.Duration
+}
+
+func (t *TimedReader) Read(buf []byte) (int, error) {
+	start := time.Now()
+	defer func() {
+		d := time.Since(start)
+		t.elapsed += d
+		Add(t.phase, d)
+	}()
+	return t.r.Read(buf)
+}
+
+// Elapsed returns the time spent in this reader's own Reads.  Unlike the
+// process's totals, other goroutines don't add to it, so the goroutine
+// reading can take it off its own time while others work alongside.  It
+// must only be called from that goroutine.
+func (t *TimedReader) Elapsed() time.Duration {
+	return t.elapsed
+}
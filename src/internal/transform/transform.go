@@ -0,0 +1,76 @@
+// Package transform reshapes documents with a jq expression before they're
+// chunked and loaded, i.e. to rename fields, derive a business key or drop
+// noisy arrays once rather than in every warehouse.
+package transform
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/itchyny/gojq"
+)
+
+// Transform is a compiled jq expression.
+type Transform struct {
+	expr string
+	code *gojq.Code
+}
+
+// New compiles a jq expression.  Expressions starting with @ are read from
+// the named file instead.  An empty expression returns a nil Transform.
+func New(expr string) (*Transform, error) {
+	if strings.HasPrefix(expr, "@") {
+		data, err := os.ReadFile(expr[1:])
+		if err != nil {
+			return nil, fmt.Errorf("reading transform: %v", err)
+		}
+		expr = string(data)
+	}
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing transform: %v", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("compiling transform: %v", err)
+	}
+	return &Transform{expr: expr, code: code}, nil
+}
+
+// Apply runs the expression against a document and returns the result.  An
+// expression producing null (or nothing, i.e. with `select`) drops the
+// document by returning nil.  Otherwise it must produce a single object which
+// keeps the document's $TYPE and DOCUMENT_ID.
+func (t *Transform) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	iter := t.code.Run(data)
+	value, ok := iter.Next()
+	if !ok || value == nil {
+		return nil, nil
+	}
+	if err, ok := value.(error); ok {
+		return nil, fmt.Errorf("transforming document %v %v: %v", data["$TYPE"], data["DOCUMENT_ID"], err)
+	}
+	if _, more := iter.Next(); more {
+		return nil, fmt.Errorf("transforming document %v %v: expression produced more than one value", data["$TYPE"], data["DOCUMENT_ID"])
+	}
+
+	result, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("transforming document %v %v: expression produced %T, not an object", data["$TYPE"], data["DOCUMENT_ID"], value)
+	}
+	for _, key := range []string{"$TYPE", "DOCUMENT_ID"} {
+		if _, ok := result[key].(string); !ok {
+			return nil, fmt.Errorf("transforming document %v %v: result is missing %s", data["$TYPE"], data["DOCUMENT_ID"], key)
+		}
+	}
+	// jq computes with float64, which would otherwise be marshalled with an
+	// exponent once large enough
+	documents.NormalizeNumbers(result)
+	return result, nil
+}
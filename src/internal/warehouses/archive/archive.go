@@ -0,0 +1,266 @@
+// Package archive splits a sync in two, for networks where no host can reach
+// both Execute and the warehouse.  An ARCHIVE "warehouse" stores each batch,
+// as fetched, in a directory (i.e. a mounted bucket or file share); the load
+// command later loads the archived batches into the real warehouse.
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/charmbracelet/log"
+)
+
+// Manifest describes an archived batch.  It's written once the batch's data
+// file is complete, so only batches with a manifest are loaded.
+type Manifest struct {
+	BatchDate string `json:"batch_date"`
+	File      string `json:"file"`
+	Documents int    `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Archive writes batches to a directory rather than loading them.
+type Archive struct {
+	dir string
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"ARCHIVE"},
+		Description: "Archives batches to a directory, for the load command to load elsewhere",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewArchive(cfg.DatabaseDSN)
+		},
+	})
+}
+
+// NewArchive creates an Archive writing to dir, which is created if needed.
+func NewArchive(dir string) (*Archive, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("ARCHIVE needs DATABASE_DSN set to the archive directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %v", err)
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// Prune is a no-op; archived batches are removed as they're loaded.
+func (a *Archive) Prune() error {
+	log.Info("Nothing to prune for archive targets")
+	return nil
+}
+
+// CreateViews is a no-op; views belong to the warehouse the archive is
+// loaded into.
+func (a *Archive) CreateViews(data execute.RootSchema) error {
+	log.Info("Helper views are created by the host loading the archive")
+	return nil
+}
+
+// Upload archives a batch as newline delimited documents, exactly as they'll
+// be loaded (after any transform and attributes have been applied).
+func (a *Archive) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
+	baseName := fmt.Sprintf("batch_%s_%d", safeBatchDate, time.Now().UnixNano())
+	dataPath := filepath.Join(a.dir, baseName+".ndjson")
+
+	file, err := os.Create(dataPath + ".tmp")
+	if err != nil {
+		return 0, fmt.Errorf("error creating archive file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hasher := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(file, hasher))
+	size := int64(0)
+	document_count := 0
+	docs := stream.Prepare(0)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, err := out.Write(append(doc.JSON[0], '\n'))
+		if err != nil {
+			return 0, fmt.Errorf("error writing archive file: %v", err)
+		}
+		size += int64(n)
+		document_count += 1
+	}
+	if document_count == 0 {
+		return 0, nil
+	}
+	if err := out.Flush(); err != nil {
+		return 0, fmt.Errorf("error writing archive file: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("error writing archive file: %v", err)
+	}
+	if err := os.Rename(file.Name(), dataPath); err != nil {
+		return 0, fmt.Errorf("error writing archive file: %v", err)
+	}
+
+	manifest, _ := json.MarshalIndent(Manifest{
+		BatchDate: batch_date,
+		File:      baseName + ".ndjson",
+		Documents: document_count,
+		Bytes:     size,
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	manifestPath := filepath.Join(a.dir, baseName+".json")
+	if err := os.WriteFile(manifestPath+".tmp", manifest, 0644); err != nil {
+		return 0, fmt.Errorf("error writing archive manifest: %v", err)
+	}
+	if err := os.Rename(manifestPath+".tmp", manifestPath); err != nil {
+		return 0, fmt.Errorf("error writing archive manifest: %v", err)
+	}
+	log.Debug("Archived batch", "file", dataPath, "documents", document_count)
+	return document_count, nil
+}
+
+// Batch is an archived batch waiting to be loaded.
+type Batch struct {
+	Manifest
+	manifestPath string
+}
+
+// Pending returns the complete batches in an archive directory, oldest
+// first.
+func Pending(dir string) ([]Batch, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "batch_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var batches []Batch
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		batches = append(batches, Batch{Manifest: m, manifestPath: path})
+	}
+	return batches, nil
+}
+
+// Open verifies the batch's checksum and returns a reader over its documents,
+// in the form of a nextRecord callback.  The caller must call close.
+func (b Batch) Open() (nextRecord func() (map[string]interface{}, error), close func(), err error) {
+	file, err := os.Open(filepath.Join(filepath.Dir(b.manifestPath), b.File))
+	if err != nil {
+		return nil, nil, err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != b.SHA256 {
+		file.Close()
+		return nil, nil, fmt.Errorf("archived batch %s is corrupt (checksum %s, expected %s)", b.File, checksum, b.SHA256)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	nextRecord = func() (map[string]interface{}, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, io.EOF
+		}
+		var record map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			log.Infof("Error parsing JSON: %v", err)
+			return nil, nil
+		}
+		// Documents were checked before they were archived
+		documents.ParseVersion(record)
+		return record, nil
+	}
+	return nextRecord, func() { file.Close() }, nil
+}
+
+// Remove deletes a loaded batch from the archive.
+func (b Batch) Remove() error {
+	if err := os.Remove(b.manifestPath); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(filepath.Dir(b.manifestPath), b.File))
+}
+
+// CopyTo adds the batch to another archive directory, linking its data file
+// where the filesystem allows and copying it otherwise.  The manifest is
+// written last, as Upload does, so the copy is only pending once complete.
+func (b Batch) CopyTo(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	src := filepath.Join(filepath.Dir(b.manifestPath), b.File)
+	dst := filepath.Join(dir, b.File)
+	if err := os.Link(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	manifestPath := filepath.Join(dir, filepath.Base(b.manifestPath))
+	manifest, _ := json.MarshalIndent(b.Manifest, "", "  ")
+	if err := os.WriteFile(manifestPath+".tmp", manifest, 0644); err != nil {
+		return err
+	}
+	return os.Rename(manifestPath+".tmp", manifestPath)
+}
+
+// copyFile copies src to dst by way of a temporary file.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
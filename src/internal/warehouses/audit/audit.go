@@ -0,0 +1,231 @@
+// Package audit records every SQL statement execute-sync issues to an audit
+// log, i.e. as change-management evidence of the DDL run in production.
+// Warehouses open their connections through Open (or OpenDB), which wraps
+// the driver so that statements are logged before they're run, and failures
+// to connect are classified as such.  Parameters are never logged and long
+// string literals are redacted, so document data doesn't end up in the log.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// Command is recorded with each statement, set to the command being run.
+var Command string
+
+var (
+	mu   sync.Mutex
+	file *os.File
+)
+
+// Start appends statements to the audit log at path.  An empty path disables
+// auditing.
+func Start(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %v", err)
+	}
+	file = f
+	return nil
+}
+
+// Stop closes the audit log.
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
+
+// Enabled reports whether statements are being audited.
+func Enabled() bool {
+	return file != nil
+}
+
+// literal matches SQL string literals long enough to hold data rather than
+// an option or identifier.
+var literal = regexp.MustCompile(`'(?:[^']|''){64,}'`)
+
+// entry is one line of the audit log.
+type entry struct {
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command,omitempty"`
+	Statement string    `json:"statement"`
+	Prepared  bool      `json:"prepared,omitempty"`
+}
+
+// Log records a statement, for warehouses which don't connect through
+// database/sql.
+func Log(statement string) {
+	log(statement, false)
+}
+
+func log(statement string, prepared bool) {
+	if file == nil {
+		return
+	}
+	redacted := literal.ReplaceAllStringFunc(strings.TrimSpace(statement), func(s string) string {
+		return fmt.Sprintf("'<redacted %d bytes>'", len(s)-2)
+	})
+	line, _ := json.Marshal(entry{Time: time.Now().UTC(), Command: Command, Statement: redacted, Prepared: prepared})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Write(append(line, '\n'))
+	}
+}
+
+// Open is a drop-in replacement for sql.Open which audits the connection's
+// statements when auditing is enabled.
+func Open(driverName string, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	db.Close()
+
+	if dc, ok := drv.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return OpenDB(c), nil
+	}
+	return OpenDB(dsnConnector{dsn: dsn, driver: drv}), nil
+}
+
+// OpenDB is a drop-in replacement for sql.OpenDB which audits the
+// connection's statements when auditing is enabled.  Either way, failing to
+// connect is classified as a warehouse auth failure.
+func OpenDB(c driver.Connector) *sql.DB {
+	return sql.OpenDB(connector{Connector: c, audit: Enabled()})
+}
+
+// dsnConnector connects drivers which don't provide a Connector of their own.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+// connector classifies connection failures, and wraps each connection in an
+// auditing conn when auditing.
+type connector struct {
+	driver.Connector
+	audit bool
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, failure.Wrap(failure.WarehouseAuth, err)
+	}
+	if !c.audit {
+		return conn, nil
+	}
+	return &auditConn{conn}, nil
+}
+
+// Close closes the driver's connector, for those which hold resources of
+// their own.
+func (c connector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// auditConn logs statements as they're prepared or executed, passing
+// everything through to the driver's connection.  Prepared statements are
+// logged once, not on each execution.
+type auditConn struct {
+	driver.Conn
+}
+
+func (c *auditConn) Prepare(query string) (driver.Stmt, error) {
+	log(query, true)
+	return c.Conn.Prepare(query)
+}
+
+func (c *auditConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	log(query, true)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *auditConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql falls back to preparing the statement
+		return nil, driver.ErrSkip
+	}
+	log(query, false)
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *auditConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	log(query, false)
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *auditConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *auditConn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func (c *auditConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *auditConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *auditConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
@@ -1,42 +1,59 @@
 package databricks
 
 import (
-	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/csv"
-	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
 	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/netconfig"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/columnar"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
 	"github.com/charmbracelet/log"
 	dbsql "github.com/databricks/databricks-sql-go"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/databricks/databricks-sql-go/auth/oauth/m2m"
+	"github.com/databricks/databricks-sql-go/auth/pat"
 )
 
 type Config struct {
-	DSN      string
-	Host     string
-	HttpPath string
-	Token    string
-	Catalog  string // optional
-	Schema   string // optional
+	DSN          string
+	Host         string
+	HttpPath     string
+	Token        string
+	ClientID     string // service principal, instead of Token
+	ClientSecret string
+	Catalog      string // optional
+	Schema       string // optional
 }
 
 const TableName = "EXECUTE_DOCUMENTS"
 
 type Databricks struct {
-	cfg       Config
-	client    *sql.DB
-	chunkSize int
+	sqlgen.Warehouse
+	cfg            Config
+	client         *sql.DB
+	auth           auth.Authenticator // shared by the SQL connection and REST calls
+	volume         string             // DATABRICKS_VOLUME batches are staged in, or "" for DBFS
+	chunkSize      int
+	timeTravelDays int
+	format         string // LOAD_FORMAT: csv or parquet
+	queryTags      map[string]string
 }
 
 // fullObjectName returns the fully-qualified name for any table/view given its simple identifier.
@@ -78,114 +95,241 @@ func parseDatabricksDSN(dsn string) (Config, error) {
 	cfg.HttpPath = q.Get("http_path")
 	cfg.Catalog = q.Get("catalog")
 	cfg.Schema = q.Get("schema")
+	cfg.ClientID = q.Get("client_id")
+	cfg.ClientSecret = q.Get("client_secret")
+	if (cfg.ClientID == "") != (cfg.ClientSecret == "") {
+		return cfg, fmt.Errorf("client_id and client_secret must be given together")
+	}
 
 	return cfg, nil
 }
 
-func NewDatabricks(dsn string, chunkSize int) (*Databricks, error) {
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"DATABRICKS"},
+		Description: "Databricks SQL warehouse (Delta tables)",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewDatabricks(cfg.DatabaseDSN, cfg.ChunkSize, cfg.TimeTravelDays, cfg.LoadFormat, cfg.DatabricksVolume, sqlgen.NewOptions(cfg))
+		},
+	})
+}
+
+func NewDatabricks(dsn string, chunkSize int, timeTravelDays int, format string, volume string, options sqlgen.Options) (*Databricks, error) {
 	cfg, err := parseDatabricksDSN(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("invalid Databricks DSN: %w", err)
 	}
+	volume = strings.TrimSuffix(volume, "/")
+	if volume != "" {
+		if err := validVolume(volume); err != nil {
+			return nil, err
+		}
+	}
+	d := &Databricks{cfg: cfg, chunkSize: chunkSize, timeTravelDays: timeTravelDays, format: format, volume: volume, queryTags: map[string]string{"app": "execute-sync"}}
+	d.Warehouse = sqlgen.Warehouse{Dialect: dialect{d, options}, Table: TableName, Size: "CAST(length(data) AS BIGINT)", Connect: d.session, Shared: true}
+	if cfg.ClientID != "" {
+		// OAuth machine-to-machine: the token is fetched when first needed
+		// and fetched again shortly before it expires
+		d.auth = m2m.NewAuthenticator(cfg.ClientID, cfg.ClientSecret, cfg.Host)
+	} else {
+		d.auth = &pat.PATAuth{AccessToken: cfg.Token}
+	}
+	if err := d.connect(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// connect (re)creates the client, tagging the session's statements with the
+// current query tags.
+func (d *Databricks) connect() error {
 	port := 443
-	host := cfg.Host
-	if colon := strings.LastIndex(cfg.Host, ":"); colon != -1 {
-		hostOnly := cfg.Host[:colon]
-		portStr := cfg.Host[colon+1:]
+	host := d.cfg.Host
+	if colon := strings.LastIndex(d.cfg.Host, ":"); colon != -1 {
+		hostOnly := d.cfg.Host[:colon]
+		portStr := d.cfg.Host[colon+1:]
 		if p, err := strconv.Atoi(portStr); err == nil {
 			port = p
 			host = hostOnly
 		}
 	}
-	connector, err := dbsql.NewConnector(
+
+	var tags []string
+	for name, value := range d.queryTags {
+		tags = append(tags, name+":"+value)
+	}
+	sort.Strings(tags)
+
+	options := []dbsql.ConnOption{
 		dbsql.WithServerHostname(host),
-		dbsql.WithHTTPPath(cfg.HttpPath),
-		dbsql.WithAccessToken(cfg.Token),
+		dbsql.WithHTTPPath(d.cfg.HttpPath),
+		dbsql.WithAuthenticator(d.auth),
 		dbsql.WithPort(port),
-	)
+		dbsql.WithUserAgentEntry("execute-sync"),
+		dbsql.WithSessionParams(map[string]string{"QUERY_TAGS": strings.Join(tags, ",")}),
+	}
+	if netconfig.Configured() {
+		// Use the CA bundle and proxy rather than the driver's own transport
+		options = append(options, dbsql.WithTransport(http.DefaultTransport))
+	}
+	connector, err := dbsql.NewConnector(options...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Databricks connector: %w", err)
+		return fmt.Errorf("failed to create Databricks connector: %w", err)
+	}
+	if d.client != nil {
+		d.client.Close()
 	}
-	db := sql.OpenDB(connector)
-	return &Databricks{cfg: cfg, client: db, chunkSize: chunkSize}, nil
+	d.client = audit.OpenDB(connector)
+	return nil
+}
+
+// SetQueryTag sets one of the QUERY_TAGS given to every statement, so that
+// Databricks' query history attributes their cost to execute-sync.
+func (d *Databricks) SetQueryTag(name string, value string) error {
+	d.queryTags[name] = value
+	return d.connect()
+}
+
+// bootstrapSQL returns the statement creating the documents table.
+func (d *Databricks) bootstrapSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	batch_date TIMESTAMP,
+	type STRING,
+	id STRING,
+	version BIGINT,
+	chunk INT,
+	author STRING,
+	date TIMESTAMP,
+	deleted BOOLEAN,
+	data STRING
+) USING DELTA`, d.fullObjectName(TableName))
+}
+
+// BootstrapSQL returns the statements creating the warehouse's objects, for
+// creating them ahead of time.
+func (d *Databricks) BootstrapSQL() []string {
+	return []string{d.bootstrapSQL(), sqlgen.CreateStatsTable(d.Dialect, TableName)}
+}
+
+// session returns the client, bootstrapping the documents table first if
+// bootstrap is set.
+func (d *Databricks) session(bootstrap bool) (*sql.DB, error) {
+	if bootstrap {
+		if err := d.bootstrap(); err != nil {
+			return nil, fmt.Errorf("error bootstrapping database: %w", err)
+		}
+	}
+	return d.client, nil
 }
 
 func (d *Databricks) bootstrap() error {
-	tableName := d.fullObjectName(TableName)
-	log.Debug("Bootstraping table", "table", tableName)
-	createTableSQL := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
-		batch_date TIMESTAMP,
-		type STRING,
-		id STRING,
-		version INT,
-		chunk INT,
-		author STRING,
-		date TIMESTAMP,
-		deleted BOOLEAN,
-		data STRING
-	) USING DELTA`, tableName)
-	_, err := d.client.ExecContext(context.Background(), createTableSQL)
+	return readonly.Bootstrap("databricks:"+d.cfg.DSN, func() error {
+		log.Debug("Bootstraping table", "table", d.fullObjectName(TableName))
+		if _, err := d.client.ExecContext(context.Background(), d.bootstrapSQL()); err != nil {
+			return fmt.Errorf("error creating %s table: %w", d.fullObjectName(TableName), err)
+		}
+		return d.widenVersion()
+	})
+}
+
+// widenVersion upgrades tables created before versions were 64-bit, whose
+// version column is an INT, with Delta's type widening.
+func (d *Databricks) widenVersion() error {
+	table := d.fullObjectName(TableName)
+	rows, err := d.client.QueryContext(context.Background(), fmt.Sprintf("DESCRIBE TABLE %s", table))
 	if err != nil {
-		return fmt.Errorf("error creating %s table: %w", tableName, err)
+		return fmt.Errorf("error describing %s table: %w", table, err)
+	}
+	defer rows.Close()
+	narrow := false
+	for rows.Next() {
+		var column, dataType string
+		var comment sql.NullString
+		if err := rows.Scan(&column, &dataType, &comment); err != nil {
+			return fmt.Errorf("error describing %s table: %w", table, err)
+		}
+		if strings.EqualFold(column, "version") && strings.EqualFold(dataType, "int") {
+			narrow = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error describing %s table: %w", table, err)
+	}
+	if !narrow {
+		return nil
+	}
+
+	log.Info("Widening the version column to BIGINT", "table", table)
+	for _, query := range []string{
+		fmt.Sprintf(`ALTER TABLE %s SET TBLPROPERTIES ('delta.enableTypeWidening' = 'true')`, table),
+		fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN version TYPE BIGINT`, table),
+	} {
+		if _, err := d.client.ExecContext(context.Background(), query); err != nil {
+			return fmt.Errorf("error widening %s version column: %w", table, err)
+		}
 	}
 	return nil
 }
 
-// Upload implements the Database interface. It serializes records to CSV (like Snowflake), uploads to DBFS, and loads into the Databricks table.
-func (d *Databricks) Upload(batch_date string, nextRecord func() (map[string]interface{}, error)) (int, error) {
+// Upload implements the Database interface. It serializes records to CSV (like Snowflake), or Parquet with LOAD_FORMAT=parquet, stages it in a volume or DBFS, and loads into the Databricks table.
+func (d *Databricks) Upload(batch_date string, stream *documents.Stream) (int, error) {
 	tableName := d.fullObjectName(TableName)
 	// Ensure table exists
 	if err := d.bootstrap(); err != nil {
 		return 0, err
 	}
-	tempDir := os.TempDir()
 	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
-	tmpFile, err := os.CreateTemp(tempDir, fmt.Sprintf("documents_%s*.csv", safeBatchDate))
+	ext := "csv.gz"
+	if d.format == "parquet" {
+		ext = "parquet"
+	}
+	tmpFile, err := spool.New(fmt.Sprintf("documents_%s*.%s", safeBatchDate, ext))
 	if err != nil {
-		return 0, fmt.Errorf("error creating temporary file: %v", err)
+		return 0, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	// Parquet's columns are built directly from the documents, skipping the
+	// CSV writer altogether.  CSV is gzipped as it's written, which COPY INTO
+	// reads natively, rather than uploading it uncompressed.
+	var columns *columnar.Writer
+	var gz *gzip.Writer
+	var csvWriter *csv.Writer
+	batchTime, _ := time.Parse("2006-01-02T15:04:05Z", batch_date)
+	if d.format == "parquet" {
+		if columns, err = columnar.NewWriter(tmpFile, columnar.Layout{Table: true}); err != nil {
+			return 0, fmt.Errorf("error creating Parquet file: %v", err)
+		}
+		defer columns.Release()
+	} else {
+		gz = gzip.NewWriter(tmpFile)
+		csvWriter = csv.NewWriter(gz)
+		csvWriter.Comma = '\t' // use TAB delimiter to avoid comma conflicts
+		// No header row; COPY INTO will provide column list
 	}
-	defer func() {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-	}()
 
 	log.Debug("Writing to temporary file", "filename", tmpFile.Name())
-	csvWriter := csv.NewWriter(tmpFile)
-	csvWriter.Comma = '\t' // use TAB delimiter to avoid comma conflicts
-	// No header row; COPY INTO will provide column list
 	document_count := 0
 	empty_batch := true
+	docs := stream.Prepare(d.chunkSize)
+	defer docs.Close()
 	for {
-		data, err := nextRecord()
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
 		}
-		if data == nil {
-			continue
+		if err != nil {
+			return 0, err
 		}
-		var chunks []map[string]interface{}
-		for key, value := range data {
-			if list, ok := value.([]interface{}); ok {
-				if len(list) > d.chunkSize {
-					for i := 0; i < len(list); i += d.chunkSize {
-						end := i + d.chunkSize
-						if end > len(list) {
-							end = len(list)
-						}
-						chunks = append(chunks, map[string]interface{}{
-							"DOCUMENT_ID": data["DOCUMENT_ID"].(string),
-							key:           list[i:end],
-						})
-					}
-					delete(data, key)
+		data := doc.Data
+		for i, chunkBytes := range doc.JSON {
+			if columns != nil {
+				if err := columns.Write(batchTime, data, i, chunkBytes); err != nil {
+					return 0, fmt.Errorf("error writing Parquet file: %v", err)
 				}
+				continue
 			}
-		}
-		chunks = append([]map[string]interface{}{data}, chunks...)
-		for i := 0; i < len(chunks); i++ {
-			chunkBytes, _ := json.Marshal(chunks[i])
 
 			// batch_date column comes from function argument
 			batchDateStr := batch_date
@@ -215,11 +359,11 @@ func (d *Databricks) Upload(batch_date string, nextRecord func() (map[string]int
 				batchDateStr,
 				fmt.Sprintf("%v", data["$TYPE"].(string)),
 				fmt.Sprintf("%v", data["DOCUMENT_ID"].(string)),
-				fmt.Sprintf("%d", int(data["$VERSION"].(float64))),
-				fmt.Sprintf("%d", i),
-				fmt.Sprintf("%v", data["$AUTHOR_ID"].(string)),
+				documents.FormatInt(documents.Version(data)),
+				documents.FormatInt(int64(i)),
+				documents.CSVField(documents.Author(data)),
 				dateStr,
-				fmt.Sprintf("%t", data["$DELETED"].(bool)),
+				documents.FormatBool(data["$DELETED"].(bool)),
 				string(chunkBytes),
 			}
 			if err := csvWriter.Write(csvRecord); err != nil {
@@ -229,31 +373,95 @@ func (d *Databricks) Upload(batch_date string, nextRecord func() (map[string]int
 		document_count += 1
 		empty_batch = false
 	}
-	csvWriter.Flush()
-	if err := csvWriter.Error(); err != nil {
-		return 0, fmt.Errorf("error finalizing CSV file: %v", err)
+	if columns != nil {
+		if err := columns.Close(); err != nil {
+			return 0, fmt.Errorf("error finalizing Parquet file: %v", err)
+		}
+	} else {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return 0, fmt.Errorf("error finalizing CSV file: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			return 0, fmt.Errorf("error finalizing CSV file: %v", err)
+		}
 	}
 	if !empty_batch {
-		dbfsPath := fmt.Sprintf("/tmp/%s_%s-%d.csv", TableName, safeBatchDate, time.Now().UnixNano())
-		if err := d.uploadToDBFS(tmpFile.Name(), dbfsPath); err != nil {
-			return 0, fmt.Errorf("upload to DBFS failed: %w", err)
+		name := fmt.Sprintf("%s_%s-%d.%s", TableName, safeBatchDate, time.Now().UnixNano(), ext)
+		if spool.VerifyUploads {
+			if err := tmpFile.Verify(); err != nil {
+				return 0, err
+			}
 		}
-		log.Debug("Uploading batch to Databricks", "table", tableName, "dbfsPath", dbfsPath)
+		location, err := d.stage(tmpFile, name)
+		if err != nil {
+			return 0, err
+		}
+		log.Debug("Uploading batch to Databricks", "table", tableName, "location", location)
+		// NULLs are written as documents.NullMarker, so empty fields load as ''
 		query := fmt.Sprintf(`COPY INTO %s (batch_date, type, id, version, chunk, author, date, deleted, data)
-		FROM 'dbfs:%s'
+		FROM '%s'
 		FILEFORMAT = CSV
-		FORMAT_OPTIONS('header' = 'false', 'delimiter' = '\t', 'timestampFormat' = 'yyyy-MM-dd HH:mm:ss', 'quote' = '"', 'escape' = '"', 'nullValue' = 'NULL')`, tableName, dbfsPath)
+		FORMAT_OPTIONS('header' = 'false', 'delimiter' = '\t', 'timestampFormat' = 'yyyy-MM-dd HH:mm:ss', 'quote' = '"', 'escape' = '"', 'nullValue' = '\\N', 'emptyValue' = '')`, tableName, location)
+		if columns != nil {
+			// The file's chunk numbers are 64 bit, the table's 32
+			query = fmt.Sprintf(`COPY INTO %s
+			FROM (SELECT batch_date, type, id, version, CAST(chunk AS INT) AS chunk, author, date, deleted, data FROM '%s')
+			FILEFORMAT = PARQUET`, tableName, location)
+		}
 		if _, err := d.client.ExecContext(context.Background(), query); err != nil {
 			return 0, fmt.Errorf("COPY INTO failed: %w", err)
 		}
-		// Clean up DBFS file after successful ingestion
-		if err := d.deleteFromDBFS(dbfsPath); err != nil {
-			log.Warn("Failed to cleanup DBFS file", "path", dbfsPath, "error", err)
-		}
+		// Clean up the staged file after successful ingestion
+		d.unstage(name)
 	}
 	return document_count, nil
 }
 
+// stage uploads a batch's file to DATABRICKS_VOLUME, or to DBFS without one,
+// and checks it arrived intact, returning the location COPY INTO loads it
+// from.  A file failing the check is removed.
+func (d *Databricks) stage(file *spool.File, name string) (string, error) {
+	if d.volume != "" {
+		path := d.volume + "/" + name
+		if err := d.uploadToVolume(file, path); err != nil {
+			return "", fmt.Errorf("upload to volume failed: %w", err)
+		}
+		if err := d.verifyVolume(path, file.Size(), file.Checksum()); err != nil {
+			d.unstage(name)
+			return "", fmt.Errorf("upload to volume failed verification: %w", err)
+		}
+		return path, nil
+	}
+
+	dbfsPath := "/tmp/" + name
+	reader, err := file.Reader()
+	if err != nil {
+		return "", fmt.Errorf("error reading temporary file: %v", err)
+	}
+	if err := d.uploadToDBFS(reader, file.Size(), dbfsPath); err != nil {
+		return "", fmt.Errorf("upload to DBFS failed: %w", err)
+	}
+	if err := d.verifyDBFS(dbfsPath, file.Size(), file.Checksum()); err != nil {
+		d.unstage(name)
+		return "", fmt.Errorf("upload to DBFS failed verification: %w", err)
+	}
+	return "dbfs:" + dbfsPath, nil
+}
+
+// unstage removes a staged file, logging rather than failing if it can't.
+func (d *Databricks) unstage(name string) {
+	if d.volume != "" {
+		if err := d.deleteFromVolume(d.volume + "/" + name); err != nil {
+			log.Warn("Failed to cleanup staged file", "path", d.volume+"/"+name, "error", err)
+		}
+		return
+	}
+	if err := d.deleteFromDBFS("/tmp/" + name); err != nil {
+		log.Warn("Failed to cleanup DBFS file", "path", "/tmp/"+name, "error", err)
+	}
+}
+
 func (d *Databricks) Prune() error {
 	if err := d.bootstrap(); err != nil {
 		return err
@@ -276,224 +484,58 @@ WHERE EXISTS (
 	return err
 }
 
-func (d *Databricks) CreateViews(data execute.RootSchema) error {
+// PruneBefore removes every row loaded in a batch before cutoff.
+func (d *Databricks) PruneBefore(cutoff time.Time) error {
 	if err := d.bootstrap(); err != nil {
-		return fmt.Errorf("error bootstrapping database: %v", err)
-	}
-
-	// Build fully qualified base table and view names
-	baseTable := d.fullObjectName(TableName)
-	viewAllVersions := d.fullObjectName(TableName + "_LATEST_ALL_VERSIONS")
-	viewLatest := d.fullObjectName(TableName + "_LATEST")
-
-	ctx := context.Background()
-
-	// _LATEST_ALL_VERSIONS view – latest batch for every (type,id,version)
-	log.Debug("Creating view", "view", viewAllVersions)
-	queryAll := fmt.Sprintf(`CREATE OR REPLACE VIEW %s AS
-SELECT ed.*
-FROM %s ed
-INNER JOIN (
-  SELECT type, id, version, MAX(batch_date) AS batch_date
-  FROM %s
-  GROUP BY type, id, version
-) latest
-ON ed.type = latest.type
- AND ed.id = latest.id
- AND ed.version = latest.version
- AND ed.batch_date = latest.batch_date`, viewAllVersions, baseTable, baseTable)
-	if _, err := d.client.ExecContext(ctx, queryAll); err != nil {
-		return fmt.Errorf("error creating %s view: %w", viewAllVersions, err)
-	}
-
-	// _LATEST view – latest version per (type,id)
-	log.Debug("Creating view", "view", viewLatest)
-	queryLatest := fmt.Sprintf(`CREATE OR REPLACE VIEW %s AS
-SELECT ed.*, from_json(ed.data, 'map<string, string>') as parsed_json
-FROM %s ed
-INNER JOIN (
-  SELECT type, id, MAX(version) AS version
-  FROM %s
-  GROUP BY type, id
-) latest
-ON ed.type = latest.type
- AND ed.id = latest.id
- AND ed.version = latest.version`, viewLatest, viewAllVersions, baseTable)
-	if _, err := d.client.ExecContext(ctx, queryLatest); err != nil {
-		return fmt.Errorf("error creating %s view: %w", viewLatest, err)
-	}
-	for key, value := range data {
-		log.Infof("Creating Helper Views for `%s`", key)
-		d.create_view(key, key, "", value, "data", "$", "")
-	}
-
-	return nil
-}
-
-func (d *Databricks) create_view(docType string, viewName string, parentTable string, record execute.DocumentSchema, root string, path string, flatten string) {
-
-	var columns []string
-
-	columns = append(columns, "id as DOCUMENT_ID")
-
-	if root == "value" && path != "$" {
-		// special case to pull out the listitem_id for child custom records on list
-		columns = append(columns, "CAST(get_json_object(value, '$.LISTITEM_ID') AS string) AS LISTITEM_ID")
-	}
-
-	// Add special meta-data fields on top-level document table
-	var jsonParseClause string
-	var parsedDataRef string
-	if parentTable == "" {
-		columns = append(columns, "deleted as _DELETED")
-		columns = append(columns, "author as _AUTHOR")
-		columns = append(columns, "version as _VERSION")
-		columns = append(columns, "date as _DATE")
-
-		// Use pre-parsed JSON from EXECUTE_DOCUMENTS_LATEST for top-level fields
-		jsonParseClause = "parsed_json"
-		parsedDataRef = "parsed_json"
-	} else {
-		jsonParseClause = fmt.Sprintf("from_json(get_json_object(%s, '%s'), 'map<string, string>') as parsed_data", root, path)
-		parsedDataRef = "parsed_data"
-	}
-
-	for field, metadata := range record {
-		if field == "DOCUMENT_ID" {
-			continue
-		}
-		switch metadata.Type {
-		case "TEXT", "GUID", "UWI":
-			columns = append(columns, fmt.Sprintf("CAST(%s['%s'] AS string) AS %s", parsedDataRef, field, field))
-		case "INTEGER":
-			columns = append(columns, fmt.Sprintf("CAST(%s['%s'] AS int) AS %s", parsedDataRef, field, field))
-		case "DECIMAL":
-			columns = append(columns, fmt.Sprintf("CAST(%s['%s'] AS float) AS %s", parsedDataRef, field, field))
-		case "BOOLEAN":
-			columns = append(columns, fmt.Sprintf("CAST(%s['%s'] AS boolean) AS %s", parsedDataRef, field, field))
-		case "DATETIME":
-			columns = append(columns, fmt.Sprintf("CAST(%s['%s'] AS date) AS %s", parsedDataRef, field, field))
-		case "DOCUMENT":
-			// For document references, we need to parse the nested object
-			columns = append(columns, fmt.Sprintf("CAST(get_json_object(%s['%s'], '$.DOCUMENT_ID') AS string) AS %s /* References %s.DOCUMENT_ID */", parsedDataRef, field, field, *metadata.DocumentType))
-		case "RECORD":
-			d.create_view(docType, fmt.Sprintf("%s_%s", viewName, field), viewName, metadata.RecordType, root, fmt.Sprintf("%s.%s", path, field), flatten)
-		case "RECORD LIST":
-			// Don't support LIST in LIST
-			if root != "data" {
-				continue
-			}
-			// Use parsed_json directly since it's available at table level
-			explodeClause := fmt.Sprintf(" lateral view explode(from_json(parsed_json['%s'], 'array<string>')) AS value", field)
-			d.create_view(docType, fmt.Sprintf("%s_%s", viewName, field), viewName, metadata.RecordType, "value", "$", explodeClause)
-		default:
-			log.Infof("Skipping %s:%s of unknown type %s", viewName, field, metadata.Type)
-		}
-	}
-
-	extraClause := ""
-	if flatten == "" {
-		extraClause = " and chunk=0"
-	}
-
-	// Build the final SQL command
-	var cmd string
-	if parentTable == "" {
-		// For root level, parsed_json is already available from EXECUTE_DOCUMENTS_LATEST
-		cmd = fmt.Sprintf(`create or replace view %s as 
-	select %s 
-	from %s_LATEST%s 
-	where type='%s'%s`,
-			d.fullObjectName(viewName),
-			strings.Join(columns, ", "),
-			d.fullObjectName(TableName),
-			flatten,
-			docType,
-			extraClause)
-	} else {
-		// For nested paths, we need to parse JSON in subquery
-		cmd = fmt.Sprintf(`create or replace view %s as 
-	select %s 
-	from (
-		select id, deleted, author, version, date, %s, %s
-		from %s_LATEST%s 
-		where type='%s'%s
-	)`,
-			d.fullObjectName(viewName),
-			strings.Join(columns, ", "),
-			root,
-			jsonParseClause,
-			d.fullObjectName(TableName),
-			flatten,
-			docType,
-			extraClause)
-	}
-
-	log.Debug("Creating view", "view", viewName)
-	_, err := d.client.ExecContext(context.Background(), cmd)
-	if err != nil {
-		log.Errorf("Error creating %s: %v", viewName, err)
-		log.Debug(cmd)
-	}
-}
-
-// uploadToDBFS uploads a local file to DBFS via Databricks REST API.
-func (d *Databricks) uploadToDBFS(localPath, dbfsPath string) error {
-	log.Debug("Uploading to DBFS", "path", dbfsPath)
-	file, err := os.Open(localPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	url := fmt.Sprintf("https://%s/api/2.0/dbfs/put", d.cfg.Host)
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	_ = writer.WriteField("path", dbfsPath)
-	_ = writer.WriteField("overwrite", "true")
-	part, _ := writer.CreateFormFile("file", filepath.Base(localPath))
-	if _, err := io.Copy(part, file); err != nil {
 		return err
 	}
-	writer.Close()
+	_, err := d.client.ExecContext(context.Background(), fmt.Sprintf(`DELETE FROM %s WHERE batch_date < TIMESTAMP '%s'`,
+		d.fullObjectName(TableName), cutoff.UTC().Format("2006-01-02 15:04:05")))
+	return err
+}
 
-	req, err := http.NewRequest("POST", url, body)
-	if err != nil {
+// CreateViews creates the views, followed by the time travel function when
+// TIME_TRAVEL_DAYS is set.
+func (d *Databricks) CreateViews(data execute.RootSchema) error {
+	if err := d.Warehouse.CreateViews(data); err != nil || d.timeTravelDays <= 0 {
 		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+d.cfg.Token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("dbfs put failed: %s", string(b))
+	log.Infof("Creating %s", d.fullObjectName(TableName+"_AS_OF"))
+	for _, query := range d.timeTravelStatements(d.timeTravelDays) {
+		log.Debug("Executing", "query", query)
+		if _, err := d.client.ExecContext(context.Background(), query); err != nil {
+			return fmt.Errorf("error setting up time travel: %w", err)
+		}
 	}
 	return nil
 }
 
-func (d *Databricks) deleteFromDBFS(dbfsPath string) error {
-	log.Debug("Deleting from DBFS", "path", dbfsPath)
-	url := fmt.Sprintf("https://%s/api/2.0/dbfs/delete", d.cfg.Host)
-	req, err := http.NewRequest("POST", url, strings.NewReader(fmt.Sprintf(`{"path": "%s"}`, dbfsPath)))
-	if err != nil {
-		return err
+// Definitions returns the DDL of the documents table and its views, as
+// reported by SHOW CREATE TABLE.  Objects which don't exist are skipped.
+func (d *Databricks) Definitions(root execute.RootSchema) (map[string]string, error) {
+	definitions := map[string]string{}
+	for _, name := range d.Dialect.Options().ObjectNames(TableName, root) {
+		var ddl string
+		err := d.client.QueryRowContext(context.Background(), fmt.Sprintf("SHOW CREATE TABLE %s", d.fullObjectName(name))).Scan(&ddl)
+		if err != nil {
+			log.Debug("Skipping object", "name", name, "err", err)
+			continue
+		}
+		definitions[name] = strings.TrimSpace(ddl)
 	}
-	req.Header.Set("Authorization", "Bearer "+d.cfg.Token)
-	req.Header.Set("Content-Type", "application/json")
+	return definitions, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+// Preflight warns when connected as a workspace admin, which has far more
+// privileges than execute-sync needs.
+func (d *Databricks) Preflight() ([]string, error) {
+	var admin bool
+	if err := d.client.QueryRowContext(context.Background(), `SELECT is_account_group_member('admins')`).Scan(&admin); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("dbfs delete failed: %s", string(b))
+	if admin {
+		return []string{"connected as a member of the admins group"}, nil
 	}
-	return nil
+	return nil, nil
 }
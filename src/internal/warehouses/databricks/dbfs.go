@@ -0,0 +1,261 @@
+package databricks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/throttle"
+	"github.com/charmbracelet/log"
+)
+
+// DBFS accepts at most 1MB per add-block call.
+const dbfsBlockSize = 1 << 20
+
+// Each DBFS or Files API call is retried this many times, waiting twice as
+// long after each failure, before the upload is abandoned.
+const (
+	apiAttempts = 5
+	apiBackoff  = 2 * time.Second
+)
+
+// Uploads log their progress roughly this often.
+const progressEvery = 128 << 20
+
+// apiError is a DBFS or Files API call rejected by Databricks.  Client errors
+// (other than throttling) aren't retried, as they won't succeed the second
+// time either.
+type apiError struct {
+	api      string
+	endpoint string
+	status   int
+	body     string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("%s %s failed (%d): %s", e.api, e.endpoint, e.status, e.body)
+}
+
+func (e apiError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// dbfsCall POSTs a request to a DBFS endpoint, decoding its response into out
+// (if given).
+func (d *Databricks) dbfsCall(endpoint string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://%s/api/2.0/dbfs/%s", d.cfg.Host, endpoint)
+	req, err := http.NewRequest("POST", url, throttle.Reader(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	if err := d.auth.Authenticate(req); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return apiError{api: "dbfs", endpoint: endpoint, status: resp.StatusCode, body: string(b)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dbfsRetry calls a DBFS endpoint, retrying as withRetries does.
+func (d *Databricks) dbfsRetry(endpoint string, in any, out any) error {
+	return withRetries("DBFS", endpoint, func() error {
+		return d.dbfsCall(endpoint, in, out)
+	})
+}
+
+// withRetries makes a REST call, retrying network errors, throttling and
+// server errors with exponential backoff.
+func withRetries(api string, endpoint string, call func() error) error {
+	wait := apiBackoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		var apiErr apiError
+		if err == nil || attempt == apiAttempts || (errors.As(err, &apiErr) && !apiErr.retryable()) {
+			return err
+		}
+		log.Warn(api+" call failed, retrying", "endpoint", endpoint, "attempt", attempt, "wait", wait, "error", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// uploadToDBFS streams a file to DBFS in 1MB blocks through the create,
+// add-block and close calls, as a single put fails intermittently for large
+// files.  A block whose add-block call failed may still have been appended, so
+// before it's retried the file's size is checked to resume from the right
+// place rather than append it twice.
+func (d *Databricks) uploadToDBFS(file io.Reader, size int64, dbfsPath string) error {
+	log.Debug("Uploading to DBFS", "path", dbfsPath, "bytes", size)
+
+	var created struct {
+		Handle int64 `json:"handle"`
+	}
+	if err := d.dbfsRetry("create", map[string]any{"path": dbfsPath, "overwrite": true}, &created); err != nil {
+		return err
+	}
+	handle := created.Handle
+
+	block := make([]byte, dbfsBlockSize)
+	var offset int64
+	nextProgress := int64(progressEvery)
+	for {
+		n, err := io.ReadFull(file, block)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		data := base64.StdEncoding.EncodeToString(block[:n])
+		if err := d.addBlock(dbfsPath, handle, data, offset, int64(n)); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if offset >= nextProgress {
+			log.Info("Uploading to DBFS", "path", dbfsPath, "uploaded_mb", offset>>20, "total_mb", size>>20)
+			nextProgress += progressEvery
+		}
+	}
+
+	if err := d.dbfsRetry("close", map[string]any{"handle": handle}, nil); err != nil {
+		return err
+	}
+	if size > 0 && offset != size {
+		return fmt.Errorf("dbfs upload of %s sent %d bytes, expected %d", dbfsPath, offset, size)
+	}
+	return nil
+}
+
+// addBlock appends one block to an open DBFS handle, retrying failures unless
+// the file's size shows the block was appended after all.
+func (d *Databricks) addBlock(dbfsPath string, handle int64, data string, offset int64, n int64) error {
+	wait := apiBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.dbfsCall("add-block", map[string]any{"handle": handle, "data": data}, nil)
+		if err == nil {
+			return nil
+		}
+		var apiErr apiError
+		if attempt == apiAttempts || (errors.As(err, &apiErr) && !apiErr.retryable()) {
+			return err
+		}
+		log.Warn("DBFS block upload failed, retrying", "path", dbfsPath, "offset", offset, "attempt", attempt, "wait", wait, "error", err)
+		time.Sleep(wait)
+		wait *= 2
+
+		written, statErr := d.dbfsSize(dbfsPath)
+		switch {
+		case statErr != nil:
+			// Can't tell whether it landed, so the upload can't safely go on
+			return fmt.Errorf("dbfs add-block failed (%v) and the file's size couldn't be checked: %w", err, statErr)
+		case written == offset+n:
+			log.Debug("DBFS block was appended despite the error", "path", dbfsPath, "offset", offset)
+			return nil
+		case written != offset:
+			return fmt.Errorf("dbfs add-block failed (%v) leaving %s at %d bytes, expected %d", err, dbfsPath, written, offset)
+		}
+	}
+}
+
+// dbfsGet calls a read-only DBFS endpoint, decoding its response into out.
+func (d *Databricks) dbfsGet(endpoint string, query neturl.Values, out any) error {
+	url := fmt.Sprintf("https://%s/api/2.0/dbfs/%s?%s", d.cfg.Host, endpoint, query.Encode())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if err := d.auth.Authenticate(req); err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return apiError{api: "dbfs", endpoint: endpoint, status: resp.StatusCode, body: string(b)}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dbfsSize returns the size of a DBFS file, as written so far.
+func (d *Databricks) dbfsSize(dbfsPath string) (int64, error) {
+	var status struct {
+		FileSize int64 `json:"file_size"`
+	}
+	err := d.dbfsGet("get-status", neturl.Values{"path": {dbfsPath}}, &status)
+	return status.FileSize, err
+}
+
+// verifyDBFS checks a staged file's size and, with VERIFY_UPLOADS, reads it
+// back to compare its SHA-256 against the spool file's before it's loaded.
+func (d *Databricks) verifyDBFS(dbfsPath string, size int64, checksum string) error {
+	staged, err := d.dbfsSize(dbfsPath)
+	if err != nil {
+		return fmt.Errorf("checking staged file: %w", err)
+	}
+	if staged != size {
+		return fmt.Errorf("staged file %s is %d bytes, expected %d", dbfsPath, staged, size)
+	}
+	if !spool.VerifyUploads {
+		return nil
+	}
+
+	h := sha256.New()
+	for offset := int64(0); offset < size; {
+		var block struct {
+			BytesRead int64  `json:"bytes_read"`
+			Data      string `json:"data"`
+		}
+		query := neturl.Values{"path": {dbfsPath}, "offset": {fmt.Sprint(offset)}, "length": {fmt.Sprint(dbfsBlockSize)}}
+		if err := d.dbfsGet("read", query, &block); err != nil {
+			return fmt.Errorf("reading back staged file: %w", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(block.Data)
+		if err != nil {
+			return fmt.Errorf("reading back staged file: %w", err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		h.Write(data)
+		offset += int64(len(data))
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != checksum {
+		return fmt.Errorf("staged file %s is corrupt: SHA-256 %s, expected %s", dbfsPath, sum, checksum)
+	}
+	log.Debug("Verified staged file", "path", dbfsPath, "sha256", checksum)
+	return nil
+}
+
+func (d *Databricks) deleteFromDBFS(dbfsPath string) error {
+	log.Debug("Deleting from DBFS", "path", dbfsPath)
+	return d.dbfsRetry("delete", map[string]any{"path": dbfsPath}, nil)
+}
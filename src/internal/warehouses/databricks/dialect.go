@@ -0,0 +1,89 @@
+package databricks
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates Databricks SQL.  The DATA column is a JSON string, so
+// fields are read with get_json_object and record lists are exploded from an
+// array of JSON strings.
+type dialect struct {
+	d       *Databricks
+	options sqlgen.Options
+}
+
+func (x dialect) Options() sqlgen.Options {
+	return x.options
+}
+
+func (x dialect) Object(name string) string {
+	return x.d.fullObjectName(name)
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf("`%s`", name)
+}
+
+func (x dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE VIEW %s AS %s", x.Object(name), query)}
+}
+
+// LatestViews builds the latest views as LatestViews does, but also parses
+// the DATA of the latest versions into _LATEST's parsed_json map.
+func (x dialect) LatestViews(table string) []string {
+	base := x.Object(table)
+	allVersions := x.Object(table + "_LATEST_ALL_VERSIONS")
+	return append(x.CreateView(table+"_LATEST_ALL_VERSIONS", fmt.Sprintf(`
+SELECT ed.*
+FROM %s ed
+INNER JOIN (
+  SELECT type, id, version, MAX(batch_date) AS batch_date
+  FROM %s
+  GROUP BY type, id, version
+) latest
+ON ed.type = latest.type
+ AND ed.id = latest.id
+ AND ed.version = latest.version
+ AND ed.batch_date = latest.batch_date`, base, base)), x.CreateView(table+"_LATEST", fmt.Sprintf(`
+SELECT ed.*, from_json(ed.data, 'map<string, string>') as parsed_json
+FROM %s ed
+INNER JOIN (
+  SELECT type, id, MAX(version) AS version
+  FROM %s
+  GROUP BY type, id
+) latest
+ON ed.type = latest.type
+ AND ed.id = latest.id
+ AND ed.version = latest.version`, allVersions, base))...)
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE TABLE %s AS %s", name, query)
+}
+
+func (x dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(x, table, v)
+}
+
+func (dialect) Extract(source string, path []string, fieldType string) string {
+	var cast string
+	switch fieldType {
+	case "INTEGER":
+		cast = "int"
+	case "DECIMAL":
+		cast = "float"
+	case "BOOLEAN":
+		cast = "boolean"
+	case "DATETIME":
+		cast = "date"
+	default:
+		cast = "string"
+	}
+	return fmt.Sprintf("CAST(get_json_object(%s, '%s') AS %s)", source, sqlgen.JSONPath(path), cast)
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(" LATERAL VIEW explode(from_json(get_json_object(%s, '%s'), 'array<string>')) list AS value", source, sqlgen.JSONPath(path)), "list.value"
+}
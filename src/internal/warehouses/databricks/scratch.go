@@ -0,0 +1,63 @@
+package databricks
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// scratch keeps its objects in a schema of its own in the DSN's catalog.
+type scratch struct {
+	*Databricks
+}
+
+// Scratch creates an empty schema called name.
+func (d *Databricks) Scratch(name string) (registry.Scratch, error) {
+	u, err := url.Parse(d.cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Databricks URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("schema", name)
+	u.RawQuery = q.Encode()
+
+	scratchDB, err := NewDatabricks(u.String(), d.chunkSize, 0, d.format, d.volume, d.Dialect.Options())
+	if err != nil {
+		return nil, err
+	}
+	for tag, value := range d.queryTags {
+		scratchDB.queryTags[tag] = value
+	}
+	if err := scratchDB.connect(); err != nil {
+		return nil, err
+	}
+
+	schema := name
+	if d.cfg.Catalog != "" {
+		schema = d.cfg.Catalog + "." + name
+	}
+	if _, err := d.client.ExecContext(context.Background(), "CREATE SCHEMA "+schema); err != nil {
+		scratchDB.client.Close()
+		return nil, fmt.Errorf("error creating schema %s: %w", schema, err)
+	}
+	return scratch{scratchDB}, nil
+}
+
+func (s scratch) Rows(name string, columns ...string) ([][]string, error) {
+	return sqlgen.Rows(s.Dialect, s.client, name, columns)
+}
+
+func (s scratch) Drop() error {
+	defer s.client.Close()
+	schema := s.cfg.Schema
+	if s.cfg.Catalog != "" {
+		schema = s.cfg.Catalog + "." + schema
+	}
+	if _, err := s.client.ExecContext(context.Background(), fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)); err != nil {
+		return fmt.Errorf("error dropping schema %s: %w", schema, err)
+	}
+	return nil
+}
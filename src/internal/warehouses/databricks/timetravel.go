@@ -0,0 +1,24 @@
+package databricks
+
+import (
+	"fmt"
+)
+
+// timeTravelStatements keep days of the documents table's Delta history and
+// create execute_documents_as_of, a table function returning the latest
+// version of every document as the table stood at a point in time, even once
+// prune has since removed it.
+func (d *Databricks) timeTravelStatements(days int) []string {
+	table := d.fullObjectName(TableName)
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %s SET TBLPROPERTIES (
+  'delta.logRetentionDuration' = 'interval %d days',
+  'delta.deletedFileRetentionDuration' = 'interval %d days'
+)`, table, days, days),
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s(as_of TIMESTAMP)
+RETURNS TABLE (batch_date TIMESTAMP, type STRING, id STRING, version BIGINT, chunk INT, author STRING, date TIMESTAMP, deleted BOOLEAN, data STRING)
+RETURN SELECT batch_date, type, id, version, chunk, author, date, deleted, data
+FROM %s TIMESTAMP AS OF as_of
+QUALIFY DENSE_RANK() OVER (PARTITION BY type, id ORDER BY version DESC, batch_date DESC) = 1`, d.fullObjectName(TableName+"_AS_OF"), table),
+	}
+}
@@ -0,0 +1,150 @@
+package databricks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/throttle"
+	"github.com/charmbracelet/log"
+)
+
+// Batches are staged in a Unity Catalog Volume through the Files API when
+// DATABRICKS_VOLUME is set, as the DBFS API is deprecated.  Each file is sent
+// in a single PUT, which is retried whole as it overwrites anything a failed
+// attempt left behind.
+
+// validVolume checks a DATABRICKS_VOLUME is a volume's path.
+func validVolume(volume string) error {
+	if parts := strings.Split(strings.Trim(volume, "/"), "/"); len(parts) < 4 || parts[0] != "Volumes" {
+		return fmt.Errorf("invalid DATABRICKS_VOLUME %q: expected /Volumes/<catalog>/<schema>/<volume>", volume)
+	}
+	return nil
+}
+
+// filesCall makes a Files API request for a file in the volume.
+func (d *Databricks) filesCall(method string, path string, query neturl.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := neturl.URL{Scheme: "https", Host: d.cfg.Host, Path: "/api/2.0/fs/files" + path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if err := d.auth.Authenticate(req); err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, apiError{api: "files", endpoint: method, status: resp.StatusCode, body: string(b)}
+	}
+	return resp, nil
+}
+
+// uploadToVolume uploads a spool file to the volume.
+func (d *Databricks) uploadToVolume(file *spool.File, path string) error {
+	log.Debug("Uploading to volume", "path", path, "bytes", file.Size())
+	return withRetries("Files API", "PUT", func() error {
+		reader, err := file.Reader()
+		if err != nil {
+			return err
+		}
+		progress := &progressReader{r: reader, path: path, total: file.Size(), next: progressEvery}
+		resp, err := d.filesCall("PUT", path, neturl.Values{"overwrite": {"true"}}, throttle.Reader(progress), file.Size())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+}
+
+// volumeSize returns the size of a file in the volume.
+func (d *Databricks) volumeSize(path string) (int64, error) {
+	var size int64
+	err := withRetries("Files API", "HEAD", func() error {
+		resp, err := d.filesCall("HEAD", path, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if size = resp.ContentLength; size < 0 {
+			return fmt.Errorf("files HEAD %s returned no Content-Length", path)
+		}
+		return nil
+	})
+	return size, err
+}
+
+// verifyVolume checks a staged file's size and, with VERIFY_UPLOADS, reads it
+// back to compare its SHA-256 against the spool file's before it's loaded.
+func (d *Databricks) verifyVolume(path string, size int64, checksum string) error {
+	staged, err := d.volumeSize(path)
+	if err != nil {
+		return fmt.Errorf("checking staged file: %w", err)
+	}
+	if staged != size {
+		return fmt.Errorf("staged file %s is %d bytes, expected %d", path, staged, size)
+	}
+	if !spool.VerifyUploads {
+		return nil
+	}
+
+	resp, err := d.filesCall("GET", path, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("reading back staged file: %w", err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return fmt.Errorf("reading back staged file: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != checksum {
+		return fmt.Errorf("staged file %s is corrupt: SHA-256 %s, expected %s", path, sum, checksum)
+	}
+	log.Debug("Verified staged file", "path", path, "sha256", checksum)
+	return nil
+}
+
+func (d *Databricks) deleteFromVolume(path string) error {
+	log.Debug("Deleting from volume", "path", path)
+	return withRetries("Files API", "DELETE", func() error {
+		resp, err := d.filesCall("DELETE", path, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+}
+
+// progressReader logs an upload's progress every progressEvery bytes.
+type progressReader struct {
+	r     io.Reader
+	path  string
+	total int64
+	read  int64
+	next  int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.read >= p.next {
+		log.Info("Uploading to volume", "path", p.path, "uploaded_mb", p.read>>20, "total_mb", p.total>>20)
+		p.next += progressEvery
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package duckdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates DuckDB SQL over the JSON DATA column.  Fields are read as
+// text with json_extract_string and cast to their type, while record lists
+// are expanded by unnesting them as a JSON[] list.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (dialect) Object(name string) string {
+	return quoteIdentifier(name)
+}
+
+func (dialect) Column(name string) string {
+	return quoteIdentifier(name)
+}
+
+func (d dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE VIEW %s AS %s", d.Object(name), query)}
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE TABLE %s AS %s", name, query)
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(d, table, v)
+}
+
+func (dialect) Extract(source string, path []string, fieldType string) string {
+	value := fmt.Sprintf("json_extract_string(%s, '%s')", source, sqlgen.JSONPath(path))
+	switch fieldType {
+	case "INTEGER":
+		return fmt.Sprintf("CAST(%s AS BIGINT)", value)
+	case "DECIMAL":
+		return fmt.Sprintf("CAST(%s AS DOUBLE)", value)
+	case "BOOLEAN":
+		return fmt.Sprintf("CAST(%s AS BOOLEAN)", value)
+	case "DATETIME":
+		return fmt.Sprintf("CAST(%s AS TIMESTAMPTZ)", value)
+	default:
+		return value
+	}
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(", unnest(CAST(json_extract(%s, '%s') AS JSON[])) AS list(value)", source, sqlgen.JSONPath(path)), "list.value"
+}
@@ -0,0 +1,8 @@
+//go:build duckdb
+
+package duckdb
+
+// Link DuckDB's database/sql driver.  It bundles DuckDB itself, built with
+// cgo, so it's kept behind the `duckdb` build tag: build with
+// `go build -tags duckdb ./src` to enable DATABASE_TYPE=DUCKDB and MOTHERDUCK.
+import _ "github.com/duckdb/duckdb-go/v2"
@@ -0,0 +1,258 @@
+package duckdb
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/charmbracelet/log"
+)
+
+const TableName string = "EXECUTE_DOCUMENTS"
+
+// driverName is the database/sql driver registered by duckdb-go.  The driver
+// is only linked into builds made with `-tags duckdb` (see driver.go), which
+// keeps cgo out of the default build.
+const driverName = "duckdb"
+
+// motherDuckPrefix starts the DSNs of databases held in MotherDuck, rather
+// than in a local file.
+const motherDuckPrefix = "md:"
+
+type DuckDB struct {
+	sqlgen.Warehouse
+	dsn       string
+	chunkSize int
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"DUCKDB"},
+		Description: "DuckDB database file (only in builds with -tags duckdb)",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewDuckDB(cfg.DatabaseDSN, cfg.ChunkSize, sqlgen.NewOptions(cfg))
+		},
+	})
+	registry.Register(registry.Adapter{
+		Names:       []string{"MOTHERDUCK"},
+		Description: "MotherDuck (only in builds with -tags duckdb)",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewMotherDuck(cfg.DatabaseDSN, cfg.ChunkSize, sqlgen.NewOptions(cfg))
+		},
+	})
+}
+
+// NewDuckDB creates a DuckDB adapter.  The DSN is the path of the database
+// file, with any of DuckDB's settings as parameters, i.e.
+// /data/execute.duckdb?threads=4.
+func NewDuckDB(dsn string, chunkSize int, options sqlgen.Options) (*DuckDB, error) {
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return nil, fmt.Errorf("duckdb support is not included in this build (rebuild with `-tags duckdb`)")
+	}
+	d := &DuckDB{
+		dsn:       dsn,
+		chunkSize: chunkSize,
+	}
+	// The table is bootstrapped whenever the database is opened
+	d.Warehouse = sqlgen.Warehouse{Dialect: dialect{options}, Table: TableName, Size: `strlen(CAST(DATA AS VARCHAR))`, Connect: func(bool) (*sql.DB, error) {
+		return d.open()
+	}}
+	return d, nil
+}
+
+// NewMotherDuck creates an adapter for a MotherDuck database, given as
+// md:DATABASE or just DATABASE.  The token is read from the motherduck_token
+// environment variable, or the DSN's motherduck_token parameter.
+func NewMotherDuck(dsn string, chunkSize int, options sqlgen.Options) (*DuckDB, error) {
+	if !strings.HasPrefix(dsn, motherDuckPrefix) {
+		dsn = motherDuckPrefix + dsn
+	}
+	return NewDuckDB(dsn, chunkSize, options)
+}
+
+// bootstrapSQL returns the statement creating the documents table.
+func bootstrapSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	BATCH_DATE TIMESTAMPTZ NOT NULL,
+	TYPE VARCHAR NOT NULL,
+	ID VARCHAR NOT NULL,
+	VERSION BIGINT NOT NULL,
+	CHUNK INTEGER NOT NULL,
+	AUTHOR VARCHAR,
+	DATE TIMESTAMPTZ NOT NULL,
+	DELETED BOOLEAN NOT NULL,
+	DATA JSON NOT NULL
+)`, quoteIdentifier(TableName))
+}
+
+// BootstrapSQL returns the statements creating the warehouse's objects, for
+// creating them ahead of time.
+func (d *DuckDB) BootstrapSQL() []string {
+	return []string{bootstrapSQL(), sqlgen.CreateStatsTable(d.Dialect, TableName)}
+}
+
+func (d *DuckDB) bootstrap(db *sql.DB) error {
+	return readonly.Bootstrap("duckdb:"+d.dsn, func() error {
+		if _, err := db.Exec(bootstrapSQL()); err != nil {
+			return fmt.Errorf("Error creating table: %v", err)
+		}
+		return nil
+	})
+}
+
+// open connects to the database and creates the documents table if needed.
+func (d *DuckDB) open() (*sql.DB, error) {
+	db, err := audit.Open(driverName, d.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to database: %w", err)
+	}
+	if err = d.bootstrap(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error bootstrapping database: %w", err)
+	}
+	return db, nil
+}
+
+func (d *DuckDB) Prune() error {
+	db, err := d.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Remove the rows superseded by a later copy of the same version
+	_, err = db.Exec(fmt.Sprintf(`
+	DELETE FROM %s ed
+	WHERE EXISTS (
+		SELECT 1
+		FROM %s later
+		WHERE later.TYPE = ed.TYPE
+		   AND later.ID = ed.ID
+		   AND later.VERSION = ed.VERSION
+		   AND later.BATCH_DATE > ed.BATCH_DATE
+	)
+	`, quoteIdentifier(TableName), quoteIdentifier(TableName)))
+	return err
+}
+
+// PruneBefore removes every row loaded in a batch before cutoff.
+func (d *DuckDB) PruneBefore(cutoff time.Time) error {
+	db, err := d.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE BATCH_DATE < ?`, quoteIdentifier(TableName)), cutoff.UTC())
+	return err
+}
+
+// Upload writes the batch to a gzipped CSV file and loads it with COPY, which
+// DuckDB reads in parallel.  MotherDuck reads the file from this machine, so
+// nothing needs staging in the cloud.
+func (d *DuckDB) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	db, err := d.open()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
+	tempFile, err := spool.New(fmt.Sprintf("documents_%s*.csv.gz", safeBatchDate))
+	if err != nil {
+		return 0, fmt.Errorf("Error creating temporary file: %w", err)
+	}
+	defer tempFile.Close()
+
+	gz := gzip.NewWriter(tempFile)
+	csvWriter := csv.NewWriter(gz)
+	if err := csvWriter.Write([]string{"BATCH_DATE", "TYPE", "ID", "VERSION", "CHUNK", "AUTHOR", "DATE", "DELETED", "DATA"}); err != nil {
+		return 0, fmt.Errorf("Error writing CSV headers: %v", err)
+	}
+
+	document_count := 0
+	docs := stream.Prepare(d.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		for i, chunkBytes := range doc.JSON {
+			err := csvWriter.Write([]string{
+				batch_date,
+				data["$TYPE"].(string),
+				data["DOCUMENT_ID"].(string),
+				documents.FormatInt(documents.Version(data)),
+				documents.FormatInt(int64(i)),
+				documents.CSVField(documents.Author(data)),
+				data["$DATE"].(string),
+				documents.FormatBool(data["$DELETED"].(bool)),
+				string(chunkBytes),
+			})
+			if err != nil {
+				return 0, fmt.Errorf("Error writing CSV file: %v", err)
+			}
+		}
+		document_count += 1
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return 0, fmt.Errorf("Error finalizing CSV file: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("Error finalizing CSV file: %v", err)
+	}
+	if document_count == 0 {
+		return 0, nil
+	}
+
+	// DuckDB reads the file itself, so it has to be on disk
+	path, err := tempFile.Path()
+	if err != nil {
+		return 0, err
+	}
+	log.Debug("Loading batch into DuckDB", "file", path)
+	_, err = db.Exec(fmt.Sprintf(`COPY %s FROM '%s' (FORMAT csv, HEADER true, COMPRESSION gzip, QUOTE '"', ESCAPE '"', NULLSTR '%s')`,
+		quoteIdentifier(TableName), strings.ReplaceAll(path, "'", "''"), documents.NullMarker))
+	if err != nil {
+		return 0, fmt.Errorf("COPY failed: %w", err)
+	}
+	return document_count, nil
+}
+
+// Definitions returns the SQL of the documents table and its views.
+func (d *DuckDB) Definitions(root execute.RootSchema) (map[string]string, error) {
+	db, err := d.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return sqlgen.Definitions(d.Dialect, db, `
+	SELECT table_name, sql FROM duckdb_tables() WHERE database_name = current_database() AND schema_name = current_schema()
+	UNION ALL
+	SELECT view_name, sql FROM duckdb_views() WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal
+	`, TableName, root)
+}
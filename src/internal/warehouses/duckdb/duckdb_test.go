@@ -0,0 +1,110 @@
+//go:build duckdb
+
+package duckdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// stream returns an upload of docs.
+func stream(docs ...map[string]interface{}) *documents.Stream {
+	return documents.NewStream(func() (map[string]interface{}, error) {
+		if len(docs) == 0 {
+			return nil, io.EOF
+		}
+		doc := docs[0]
+		docs = docs[1:]
+		return doc, nil
+	}, 1)
+}
+
+func afe(id string, version int64, name string, shares ...float64) map[string]interface{} {
+	var partners []interface{}
+	for i, share := range shares {
+		partners = append(partners, map[string]interface{}{"LISTITEM_ID": id + string(rune('a'+i)), "SHARE": share})
+	}
+	return map[string]interface{}{
+		"$TYPE":       "AFE",
+		"DOCUMENT_ID": id,
+		"$VERSION":    version,
+		"$DATE":       "2024-05-01T12:00:00Z",
+		"$DELETED":    false,
+		"NAME":        name,
+		"PARTNERS":    partners,
+	}
+}
+
+func TestUploadAndViews(t *testing.T) {
+	d, err := NewDuckDB(filepath.Join(t.TempDir(), "execute.duckdb"), 0, sqlgen.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := d.Upload("2024-05-01T12:00:00Z", stream(afe("1", 1, "First", 0.25, 0.75), afe("2", 1, "Second"))); err != nil || n != 2 {
+		t.Fatalf("uploading first batch: %d, %v", n, err)
+	}
+	if n, err := d.Upload("2024-05-02T12:00:00Z", stream(afe("1", 2, "First, renamed", 0.5), afe("2", 1, "Second"))); err != nil || n != 2 {
+		t.Fatalf("uploading second batch: %d, %v", n, err)
+	}
+	if exists, err := d.BatchExists("2024-05-03T12:00:00Z"); err != nil || exists {
+		t.Fatalf("BatchExists reported an unloaded batch: %v, %v", exists, err)
+	}
+
+	var root execute.RootSchema
+	err = json.Unmarshal([]byte(`{"AFE": {
+		"NAME": {"TYPE": "TEXT"},
+		"PARTNERS": {"TYPE": "RECORD LIST", "RECORD_TYPE": {"LISTITEM_ID": {"TYPE": "GUID"}, "SHARE": {"TYPE": "DECIMAL"}}}
+	}}`), &root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateViews(root); err != nil {
+		t.Fatalf("creating views: %v", err)
+	}
+	broken, err := d.ValidateViews(root)
+	if err != nil || len(broken) > 0 {
+		t.Fatalf("broken views: %v, %v", broken, err)
+	}
+
+	db, err := sql.Open(driverName, d.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var name string
+	var version int64
+	if err := db.QueryRow(`SELECT "NAME", "_VERSION" FROM "AFE" WHERE "DOCUMENT_ID" = '1'`).Scan(&name, &version); err != nil {
+		t.Fatal(err)
+	}
+	if name != "First, renamed" || version != 2 {
+		t.Fatalf("expected the latest version in the AFE view, got %q version %d", name, version)
+	}
+	var share float64
+	if err := db.QueryRow(`SELECT SUM("SHARE") FROM "AFE_PARTNERS" WHERE "DOCUMENT_ID" = '1'`).Scan(&share); err != nil {
+		t.Fatal(err)
+	}
+	if share != 0.5 {
+		t.Fatalf("expected the latest version's partners, got a total share of %v", share)
+	}
+
+	// Document 2 was loaded by both batches, and pruning removes the first
+	if err := d.Prune(); err != nil {
+		t.Fatalf("pruning: %v", err)
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "EXECUTE_DOCUMENTS" WHERE ID = '2'`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("expected prune to keep one copy of document 2, found %d", rows)
+	}
+}
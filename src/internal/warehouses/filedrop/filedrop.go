@@ -0,0 +1,370 @@
+package filedrop
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/throttle"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/charmbracelet/log"
+)
+
+// FileDrop writes each batch as a CSV or NDJSON file, followed by a manifest
+// (control) file, into a directory or onto an SFTP server.  This suits legacy
+// warehouses which only ingest via file drops: the manifest is always written
+// last, so its arrival signals that the data file is complete.
+type FileDrop struct {
+	format    string // "csv" or "ndjson"
+	dir       string // local directory (or mounted network share)
+	sftpHost  string // user@host, empty when writing locally
+	sftpPort  string
+	identity  string
+	chunkSize int
+}
+
+// Manifest describes a single data file dropped by FileDrop.
+type Manifest struct {
+	BatchDate string   `json:"batch_date"`
+	File      string   `json:"file"`
+	Format    string   `json:"format"`
+	Columns   []string `json:"columns"`
+	Documents int      `json:"documents"`
+	Rows      int      `json:"rows"`
+	Bytes     int64    `json:"bytes"`
+	SHA256    string   `json:"sha256"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// RECORD_ID (see documents.RecordID) comes last so that readers expecting the
+// warehouse table's columns are unaffected.
+var columns = []string{"BATCH_DATE", "TYPE", "ID", "VERSION", "CHUNK", "AUTHOR", "DATE", "DELETED", "DATA", "RECORD_ID"}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"FILEDROP"},
+		Description: "Drops CSV/NDJSON batch files, with a manifest, into a directory or SFTP server",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewFileDrop(cfg.DatabaseDSN, cfg.ChunkSize)
+		},
+	})
+}
+
+// NewFileDrop creates a file drop target.  The DSN is either a local path
+// (file:///mnt/share/execute or /mnt/share/execute) or an SFTP location
+// (sftp://user@host:22/incoming).  The optional `format` parameter selects
+// csv (default) or ndjson, and `identity` selects an SSH private key for SFTP.
+// SFTP transfers use the system `sftp` client in batch mode, so host keys and
+// credentials are managed through the usual ssh configuration.
+func NewFileDrop(dsn string, chunkSize int) (*FileDrop, error) {
+	f := &FileDrop{format: "csv", chunkSize: chunkSize}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file drop DSN: %w", err)
+	}
+
+	switch u.Scheme {
+	case "sftp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid file drop DSN: missing SFTP host")
+		}
+		f.sftpHost = u.Hostname()
+		if u.User != nil {
+			f.sftpHost = u.User.Username() + "@" + f.sftpHost
+		}
+		f.sftpPort = u.Port()
+		f.identity = u.Query().Get("identity")
+		f.dir = u.Path
+		if f.dir == "" {
+			f.dir = "."
+		}
+		// sftp's batch commands are one per line, with no way to escape a
+		// line break
+		if strings.ContainsAny(f.dir, "\r\n") {
+			return nil, fmt.Errorf("invalid file drop DSN: SFTP directory contains a line break")
+		}
+	case "file":
+		f.dir = u.Path
+	case "":
+		f.dir = u.Path
+	default:
+		return nil, fmt.Errorf("invalid file drop DSN: unsupported scheme %q", u.Scheme)
+	}
+
+	if format := strings.ToLower(u.Query().Get("format")); format != "" {
+		f.format = format
+	}
+	if f.format != "csv" && f.format != "ndjson" {
+		return nil, fmt.Errorf("invalid file drop format %q: expected csv or ndjson", f.format)
+	}
+	if f.dir == "" {
+		return nil, fmt.Errorf("invalid file drop DSN: missing directory")
+	}
+	return f, nil
+}
+
+// Prune is a no-op; dropped files belong to the downstream ingestion process.
+func (f *FileDrop) Prune() error {
+	log.Info("Nothing to prune for file drop targets")
+	return nil
+}
+
+// CreateViews is a no-op; views are the responsibility of the ingesting warehouse.
+func (f *FileDrop) CreateViews(data execute.RootSchema) error {
+	log.Info("Helper views are not supported for file drop targets")
+	return nil
+}
+
+// Upload writes the batch to a data file and then drops it, along with its
+// manifest, into the target directory.
+func (f *FileDrop) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
+	baseName := fmt.Sprintf("documents_%s_%d.%s", safeBatchDate, time.Now().UnixNano(), f.format)
+
+	tempFile, err := spool.New("documents_*." + f.format)
+	if err != nil {
+		return 0, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer tempFile.Close()
+
+	hasher := sha256.New()
+	out := io.MultiWriter(tempFile, hasher)
+	writer := f.newRowWriter(out)
+
+	document_count := 0
+	row_count := 0
+
+	docs := stream.Prepare(f.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		for i, chunkBytes := range doc.JSON {
+			if err := writer.Write(batch_date, data, i, chunkBytes); err != nil {
+				return 0, fmt.Errorf("error writing record: %v", err)
+			}
+			row_count += 1
+		}
+		document_count += 1
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, fmt.Errorf("error finalizing data file: %v", err)
+	}
+
+	// Nothing to drop
+	if document_count == 0 {
+		return 0, nil
+	}
+
+	manifest := Manifest{
+		BatchDate: batch_date,
+		File:      baseName,
+		Format:    f.format,
+		Columns:   columns,
+		Documents: document_count,
+		Rows:      row_count,
+		Bytes:     tempFile.Size(),
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	manifestBytes, _ := json.MarshalIndent(manifest, "", "  ")
+
+	if f.sftpHost != "" {
+		// The sftp client can only upload files from disk
+		var localPath string
+		if localPath, err = tempFile.Path(); err == nil {
+			err = f.dropSFTP(localPath, baseName, manifestBytes)
+		}
+	} else {
+		var src io.Reader
+		if src, err = tempFile.Reader(); err == nil {
+			err = f.dropLocal(src, baseName, manifestBytes)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	log.Debug("Dropped batch file", "file", baseName, "rows", row_count)
+	return document_count, nil
+}
+
+// rowWriter writes document chunks to a data file in a particular format.
+type rowWriter interface {
+	Write(batchDate string, data map[string]interface{}, chunk int, chunkJSON []byte) error
+	Flush() error
+}
+
+func (f *FileDrop) newRowWriter(out io.Writer) rowWriter {
+	if f.format == "ndjson" {
+		return &ndjsonWriter{encoder: json.NewEncoder(out)}
+	}
+	return &csvWriter{writer: csv.NewWriter(out)}
+}
+
+// csvWriter writes chunks using the same column layout as the warehouse tables.
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(batchDate string, data map[string]interface{}, chunk int, chunkJSON []byte) error {
+	if !w.headerWritten {
+		if err := w.writer.Write(columns); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+	return w.writer.Write([]string{
+		batchDate,
+		data["$TYPE"].(string),
+		data["DOCUMENT_ID"].(string),
+		documents.FormatInt(documents.Version(data)),
+		documents.FormatInt(int64(chunk)),
+		documents.CSVField(documents.Author(data)),
+		data["$DATE"].(string),
+		documents.FormatBool(data["$DELETED"].(bool)),
+		string(chunkJSON),
+		documents.RecordID(data, chunk),
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// ndjsonWriter writes one JSON object per chunk, keyed by the table's column names.
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(batchDate string, data map[string]interface{}, chunk int, chunkJSON []byte) error {
+	return w.encoder.Encode(map[string]interface{}{
+		"BATCH_DATE": batchDate,
+		"TYPE":       data["$TYPE"].(string),
+		"ID":         data["DOCUMENT_ID"].(string),
+		"VERSION":    documents.Version(data),
+		"CHUNK":      chunk,
+		"AUTHOR":     documents.SQLValue(documents.Author(data)),
+		"DATE":       data["$DATE"].(string),
+		"DELETED":    data["$DELETED"].(bool),
+		"DATA":       json.RawMessage(chunkJSON),
+		"RECORD_ID":  documents.RecordID(data, chunk),
+	})
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// dropLocal copies the data file into the target directory under a temporary
+// name and renames it into place, then writes the manifest the same way.
+func (f *FileDrop) dropLocal(src io.Reader, baseName string, manifest []byte) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return fmt.Errorf("error creating drop directory: %v", err)
+	}
+
+	target := filepath.Join(f.dir, baseName)
+	dst, err := os.Create(target + ".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating data file: %v", err)
+	}
+	if _, err := io.Copy(dst, throttle.Reader(src)); err != nil {
+		dst.Close()
+		return fmt.Errorf("error writing data file: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("error writing data file: %v", err)
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		return fmt.Errorf("error renaming data file: %v", err)
+	}
+
+	manifestPath := filepath.Join(f.dir, baseName+".manifest.json")
+	if err := os.WriteFile(manifestPath+".tmp", manifest, 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := os.Rename(manifestPath+".tmp", manifestPath); err != nil {
+		return fmt.Errorf("error renaming manifest: %v", err)
+	}
+	return nil
+}
+
+// sftpQuote quotes a path for an sftp batch command, escaping any quotes and
+// backslashes within it.
+func sftpQuote(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
+
+// dropSFTP uploads the data file and manifest using the system sftp client.
+// Files are uploaded under a temporary name and renamed so that consumers
+// never observe partial files.
+func (f *FileDrop) dropSFTP(localPath string, baseName string, manifest []byte) error {
+	manifestFile, err := spool.Create("manifest_*.json")
+	if err != nil {
+		return fmt.Errorf("error creating manifest file: %v", err)
+	}
+	defer spool.Remove(manifestFile)
+	if _, err := manifestFile.Write(manifest); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := manifestFile.Sync(); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+
+	remoteData := path.Join(f.dir, baseName)
+	remoteManifest := remoteData + ".manifest.json"
+
+	var batch strings.Builder
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(localPath), sftpQuote(remoteData+".tmp"))
+	fmt.Fprintf(&batch, "rename %s %s\n", sftpQuote(remoteData+".tmp"), sftpQuote(remoteData))
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(manifestFile.Name()), sftpQuote(remoteManifest+".tmp"))
+	fmt.Fprintf(&batch, "rename %s %s\n", sftpQuote(remoteManifest+".tmp"), sftpQuote(remoteManifest))
+
+	args := []string{"-b", "-"}
+	if f.sftpPort != "" {
+		args = append(args, "-P", f.sftpPort)
+	}
+	if f.identity != "" {
+		args = append(args, "-i", f.identity)
+	}
+	if throttle.Limit > 0 {
+		// sftp takes its bandwidth limit in Kbit/s
+		args = append(args, "-l", fmt.Sprint(max(throttle.Limit*8/1000, 1)))
+	}
+	args = append(args, f.sftpHost)
+
+	log.Debug("Uploading batch via SFTP", "host", f.sftpHost, "file", remoteData)
+	cmd := exec.Command("sftp", args...)
+	cmd.Stdin = strings.NewReader(batch.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Debugf("sftp output: %s", string(output))
+		return fmt.Errorf("sftp upload failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package filedrop
+
+import "testing"
+
+func TestSFTPQuoteEscapesQuotesAndBackslashes(t *testing.T) {
+	if got := sftpQuote(`/in "coming"\drop`); got != `"/in \"coming\"\\drop"` {
+		t.Fatalf("unexpected quoting: %s", got)
+	}
+}
+
+func TestNewFileDropRejectsLineBreaksInSFTPDirectory(t *testing.T) {
+	if _, err := NewFileDrop("sftp://user@host/incoming%0Arm%20-rf", 0); err == nil {
+		t.Fatal("expected a directory with a line break to be rejected")
+	}
+	f, err := NewFileDrop(`sftp://user@host/in%22coming`, 0)
+	if err != nil || f.dir != `/in"coming` {
+		t.Fatalf("expected a quoted directory to be accepted, got %v %v", f, err)
+	}
+}
@@ -0,0 +1,58 @@
+package firebolt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates Firebolt SQL, addressing fields with JSON pointers.
+// Object and column names are lower-cased.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (dialect) Object(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ToLower(name))
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ToLower(name))
+}
+
+func (d dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE VIEW %s AS %s", d.Object(name), query)}
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(d, table, v)
+}
+
+// pointer renders a path as a JSON pointer, i.e. '/RECORD/FIELD'.
+func pointer(path []string) string {
+	return "'/" + strings.Join(path, "/") + "'"
+}
+
+func (dialect) Extract(source string, path []string, fieldType string) string {
+	switch fieldType {
+	case "INTEGER":
+		return fmt.Sprintf("CAST(JSON_VALUE(%s, %s) AS BIGINT)", source, pointer(path))
+	case "DECIMAL":
+		return fmt.Sprintf("CAST(JSON_VALUE(%s, %s) AS DOUBLE PRECISION)", source, pointer(path))
+	case "BOOLEAN":
+		return fmt.Sprintf("CAST(JSON_VALUE(%s, %s) AS BOOLEAN)", source, pointer(path))
+	case "DATETIME":
+		return fmt.Sprintf("CAST(JSON_VALUE(%s, %s) AS TIMESTAMPTZ)", source, pointer(path))
+	default:
+		return fmt.Sprintf("JSON_VALUE(%s, %s)", source, pointer(path))
+	}
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(" CROSS JOIN UNNEST(JSON_EXTRACT_ARRAY(%s, %s)) AS list(value)", source, pointer(path)), "list.value"
+}
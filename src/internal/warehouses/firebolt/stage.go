@@ -0,0 +1,171 @@
+package firebolt
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/charmbracelet/log"
+)
+
+// stage is an S3 location which batches are uploaded to for Firebolt to load
+// with COPY, rather than being sent through the query API as INSERTs.
+type stage struct {
+	bucket string
+	prefix string
+	role   string // IAM role Firebolt assumes to read the bucket
+	client *s3.Client
+}
+
+// newStage creates the stage at location, s3://BUCKET/PREFIX.  Files are
+// uploaded with the usual AWS environment, profile or instance role, while
+// Firebolt reads them by assuming role, so no keys appear in the COPY.
+func newStage(location string, role string, region string) (*stage, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Firebolt stage %q: expected s3://BUCKET/PREFIX", location)
+	}
+	if role == "" {
+		return nil, fmt.Errorf("invalid Firebolt DSN: stage requires stage_role, the IAM role Firebolt reads it with")
+	}
+
+	// Uploads go through the default transport, so the CA bundle and proxy
+	// apply (see netconfig)
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(&http.Client{Transport: http.DefaultTransport})}
+	if region != "" {
+		options = append(options, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	return &stage{bucket: u.Host, prefix: strings.Trim(u.Path, "/"), role: role, client: s3.NewFromConfig(cfg)}, nil
+}
+
+func (s *stage) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// url returns the location Firebolt reads a staged file from.
+func (s *stage) url(name string) string {
+	return "s3://" + s.bucket + "/" + s.key(name)
+}
+
+func (s *stage) put(name string, file *spool.File) error {
+	reader, err := file.Reader()
+	if err != nil {
+		return fmt.Errorf("error reading temporary file: %v", err)
+	}
+	_, err = manager.NewUploader(s.client).Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   reader,
+	})
+	return err
+}
+
+// remove deletes a staged file, logging rather than failing if it can't.
+func (s *stage) remove(name string) {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		log.Warn("Failed to cleanup staged file", "file", s.url(name), "error", err)
+	}
+}
+
+// uploadStaged writes the batch as a gzipped CSV file, uploads it to the
+// stage and loads it with COPY, which is far quicker than INSERTs for large
+// batches.
+func (f *Firebolt) uploadStaged(batch_date string, stream *documents.Stream) (int, error) {
+	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
+	file, err := spool.New(fmt.Sprintf("documents_%s*.csv.gz", safeBatchDate))
+	if err != nil {
+		return 0, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	csvWriter := csv.NewWriter(gz)
+	if err := csvWriter.Write([]string{"batch_date", "type", "id", "version", "chunk", "author", "date", "deleted", "data"}); err != nil {
+		return 0, fmt.Errorf("error writing CSV headers: %v", err)
+	}
+
+	batchTime := strings.TrimSuffix(strings.Replace(batch_date, "T", " ", 1), "Z")
+	document_count := 0
+	docs := stream.Prepare(f.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		for i, chunkBytes := range doc.JSON {
+			err := csvWriter.Write([]string{
+				batchTime,
+				data["$TYPE"].(string),
+				data["DOCUMENT_ID"].(string),
+				documents.FormatInt(documents.Version(data)),
+				documents.FormatInt(int64(i)),
+				documents.CSVField(documents.Author(data)),
+				data["$DATE"].(string),
+				documents.FormatBool(data["$DELETED"].(bool)),
+				string(chunkBytes),
+			})
+			if err != nil {
+				return 0, fmt.Errorf("error writing CSV file: %v", err)
+			}
+		}
+		document_count += 1
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return 0, fmt.Errorf("error finalizing CSV file: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("error finalizing CSV file: %v", err)
+	}
+	if document_count == 0 {
+		return 0, nil
+	}
+
+	if spool.VerifyUploads {
+		if err := file.Verify(); err != nil {
+			return 0, err
+		}
+	}
+	log.Debug("Uploading batch to Firebolt stage", "file", f.stage.url(file.Name()))
+	if err := f.stage.put(file.Name(), file); err != nil {
+		return 0, fmt.Errorf("upload to stage failed: %w", err)
+	}
+	defer f.stage.remove(file.Name())
+
+	// NULLs are written as documents.NullMarker, so empty fields load as ''
+	err = f.exec(fmt.Sprintf(`COPY INTO %s FROM %s WITH
+	CREDENTIALS = (AWS_ROLE_ARN = %s)
+	TYPE = CSV HEADER = TRUE COMPRESSION = GZIP NULL_STRING = %s EMPTY_FIELD_AS_NULL = FALSE`,
+		TableName, quote(f.stage.url(file.Name())), quote(f.stage.role), quote(documents.NullMarker)))
+	if err != nil {
+		return 0, fmt.Errorf("COPY INTO failed: %w", err)
+	}
+	return document_count, nil
+}
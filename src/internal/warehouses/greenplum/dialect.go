@@ -0,0 +1,87 @@
+package greenplum
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/lib/pq"
+)
+
+// dialect generates PostgreSQL SQL over the jsonb DATA column.  Object and
+// column names are lower-cased to follow PostgreSQL conventions.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (dialect) Object(name string) string {
+	return pq.QuoteIdentifier(strings.ToLower(name))
+}
+
+func (dialect) Column(name string) string {
+	return pq.QuoteIdentifier(strings.ToLower(name))
+}
+
+// CreateView replaces the view in place, which keeps the views built on it,
+// whether ours or the user's.  CREATE OR REPLACE can't remove, rename or
+// retype columns, so a view whose columns have changed is dropped and
+// created again instead, along with its own _CHANGED view (which is
+// recreated straight afterwards).  Views users have built on it stop the
+// drop, failing the view rather than being dropped with it.
+func (d dialect) CreateView(name string, query string) []string {
+	view := d.Object(name)
+	return []string{fmt.Sprintf(`DO $create$
+BEGIN
+	EXECUTE %s;
+EXCEPTION WHEN invalid_table_definition THEN
+	EXECUTE %s;
+	EXECUTE %s;
+	EXECUTE %s;
+END
+$create$`,
+		dollarQuote("CREATE OR REPLACE VIEW "+view+" AS "+query),
+		dollarQuote("DROP VIEW IF EXISTS "+d.Object(d.options.ChangedName(sqlgen.View{Name: name}))),
+		dollarQuote("DROP VIEW "+view),
+		dollarQuote("CREATE VIEW "+view+" AS "+query))}
+}
+
+// dollarQuote quotes a statement as a PL/pgSQL string.
+func dollarQuote(statement string) string {
+	return "$view$" + statement + "$view$"
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE TABLE %s AS %s", name, query)
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(d, table, v)
+}
+
+// jsonPath renders a jsonb path literal, i.e. '{RECORD,FIELD}'.
+func jsonPath(path []string) string {
+	return "'{" + strings.Join(path, ",") + "}'"
+}
+
+func (dialect) Extract(source string, path []string, fieldType string) string {
+	switch fieldType {
+	case "INTEGER":
+		return fmt.Sprintf("(%s #>> %s)::bigint", source, jsonPath(path))
+	case "DECIMAL":
+		return fmt.Sprintf("(%s #>> %s)::double precision", source, jsonPath(path))
+	case "BOOLEAN":
+		return fmt.Sprintf("(%s #>> %s)::boolean", source, jsonPath(path))
+	case "DATETIME":
+		return fmt.Sprintf("(%s #>> %s)::timestamptz", source, jsonPath(path))
+	default:
+		return fmt.Sprintf("%s #>> %s", source, jsonPath(path))
+	}
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(", jsonb_array_elements(%s #> %s) AS list(value)", source, jsonPath(path)), "list.value"
+}
@@ -0,0 +1,161 @@
+package greenplum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// catalog stands in for PostgreSQL's views.  As PostgreSQL does, it refuses
+// to drop a view others depend on, or to replace one with different columns.
+type catalog map[string]string
+
+var (
+	viewStatement = regexp.MustCompile(`(?s)^(DROP VIEW IF EXISTS|DROP VIEW|CREATE VIEW|CREATE OR REPLACE VIEW) ("[^"]+")(?: AS (.*))?$`)
+	quoted        = regexp.MustCompile(`(?s)\$view\$(.*?)\$view\$`)
+	viewColumns   = regexp.MustCompile(`AS ("[^"]+")`)
+)
+
+var errInvalidDefinition = errors.New("cannot change name of view column")
+
+func (c catalog) exec(query string) error {
+	query = strings.TrimSpace(query)
+	if strings.HasPrefix(query, "DO ") {
+		// Run the block's statements, falling back to the rest only if
+		// replacing the view fails
+		statements := quoted.FindAllStringSubmatch(query, -1)
+		err := c.exec(statements[0][1])
+		if err != errInvalidDefinition {
+			return err
+		}
+		for _, statement := range statements[1:] {
+			if err := c.exec(statement[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	m := viewStatement.FindStringSubmatch(query)
+	if m == nil {
+		return fmt.Errorf("unexpected statement %q", query)
+	}
+	name, definition := m[2], m[3]
+	_, exists := c[name]
+	switch m[1] {
+	case "DROP VIEW IF EXISTS", "DROP VIEW":
+		if !exists {
+			if m[1] == "DROP VIEW" {
+				return fmt.Errorf("view %s does not exist", name)
+			}
+			return nil
+		}
+		for view, query := range c {
+			if strings.Contains(query, name) {
+				return fmt.Errorf("cannot drop view %s because view %s depends on it", name, view)
+			}
+		}
+		delete(c, name)
+	case "CREATE VIEW":
+		if exists {
+			return fmt.Errorf("relation %s already exists", name)
+		}
+		c[name] = definition
+	default:
+		if exists && !reflect.DeepEqual(viewColumns.FindAllString(c[name], -1), viewColumns.FindAllString(definition, -1)) {
+			return errInvalidDefinition
+		}
+		c[name] = definition
+	}
+	return nil
+}
+
+// schema returns the types' schemas, each with any extra fields given.
+func schema(t *testing.T, fields string, types ...string) execute.RootSchema {
+	t.Helper()
+	root := execute.RootSchema{}
+	for _, docType := range types {
+		var record execute.DocumentSchema
+		err := json.Unmarshal([]byte(`{
+			`+fields+`
+			"NAME": {"TYPE": "TEXT"},
+			"PARTNERS": {"TYPE": "RECORD LIST", "RECORD_TYPE": {"LISTITEM_ID": {"TYPE": "GUID"}, "SHARE": {"TYPE": "DECIMAL"}}}
+		}`), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root[docType] = record
+	}
+	return root
+}
+
+func TestSelectiveRebuildKeepsUnchangedTypesViews(t *testing.T) {
+	views := catalog{}
+	if err := sqlgen.CreateViews(dialect{}, TableName, schema(t, "", "AFE", "WELL"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 6 {
+		t.Fatalf("expected the latest views and 4 helper views, got %d", len(views))
+	}
+
+	// Only AFE's schema changed, so only its views are rebuilt
+	if err := sqlgen.CreateViews(dialect{}, TableName, schema(t, "", "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"execute_documents_latest_all_versions", "execute_documents_latest", "afe", "afe_partners", "well", "well_partners"} {
+		if _, ok := views[`"`+name+`"`]; !ok {
+			t.Errorf("view %s is missing after rebuilding AFE's views", name)
+		}
+	}
+}
+
+func TestRebuildKeepsViewsBuiltOnOurs(t *testing.T) {
+	views := catalog{}
+	if err := sqlgen.CreateViews(dialect{}, TableName, schema(t, "", "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	views[`"afe_report"`] = `SELECT * FROM "afe"`
+
+	if err := sqlgen.CreateViews(dialect{}, TableName, schema(t, "", "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := views[`"afe_report"`]; !ok {
+		t.Fatal("the user's view was dropped by the rebuild")
+	}
+
+	// Changing the columns needs the view dropped, which the user's stops
+	if err := sqlgen.CreateViews(dialect{}, TableName, schema(t, `"ACTIVE": {"TYPE": "BOOLEAN"},`, "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := views[`"afe_report"`]; !ok {
+		t.Fatal("the user's view was dropped to change AFE's columns")
+	}
+	if strings.Contains(views[`"afe"`], `"active"`) {
+		t.Fatal("expected AFE's view to be left as it was")
+	}
+}
+
+func TestChangedColumnsRecreateView(t *testing.T) {
+	d := dialect{sqlgen.Options{ChangedViews: true}}
+	views := catalog{}
+	if err := sqlgen.CreateViews(d, TableName, schema(t, "", "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	// ACTIVE sorts first, so replacing the view would rename its columns
+	if err := sqlgen.CreateViews(d, TableName, schema(t, `"ACTIVE": {"TYPE": "BOOLEAN"},`, "AFE"), views.exec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(views[`"afe"`], `"active"`) {
+		t.Fatal("expected AFE's view to be recreated with its new column")
+	}
+	if _, ok := views[`"afe_changed"`]; !ok {
+		t.Fatal("expected AFE's _CHANGED view to be recreated")
+	}
+}
@@ -0,0 +1,78 @@
+package greenplum
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// scratch connects with its schema first on the search_path, so that the
+// (unqualified) documents table and views are created there.
+type scratch struct {
+	*Greenplum
+	schema string
+	admin  string // the DSN the schema was created through
+}
+
+// Scratch creates an empty schema called name.
+func (g *Greenplum) Scratch(name string) (registry.Scratch, error) {
+	name = strings.ToLower(name)
+	dsn, err := withSearchPath(g.dsn, name)
+	if err != nil {
+		return nil, err
+	}
+	db, err := g.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE SCHEMA " + name); err != nil {
+		return nil, fmt.Errorf("error creating schema %s: %v", name, err)
+	}
+	scratchDB := newGreenplum(dsn, g.chunkSize, g.distributed, g.Dialect.Options())
+	return scratch{Greenplum: scratchDB, schema: name, admin: g.dsn}, nil
+}
+
+// withSearchPath adds search_path, which lib/pq passes on as a run-time
+// parameter, to either form of connection string.
+func withSearchPath(dsn string, schema string) (string, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid DSN: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (s scratch) Rows(name string, columns ...string) ([][]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return sqlgen.Rows(s.Dialect, db, name, columns)
+}
+
+func (s scratch) Drop() error {
+	db, err := audit.Open("postgres", s.admin)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", s.schema)); err != nil {
+		return fmt.Errorf("error dropping schema %s: %v", s.schema, err)
+	}
+	return nil
+}
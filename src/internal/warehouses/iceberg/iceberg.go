@@ -0,0 +1,255 @@
+// Package iceberg appends batches to an Apache Iceberg table through a REST
+// or AWS Glue catalog, so documents land in a lakehouse without a warehouse
+// to load them.  The Iceberg writer lays out the Parquet files, partitions
+// them and commits each batch as a snapshot of its own.
+package iceberg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/columnar"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	ice "github.com/apache/iceberg-go"
+	"github.com/apache/iceberg-go/catalog"
+	"github.com/apache/iceberg-go/catalog/glue"
+	_ "github.com/apache/iceberg-go/catalog/rest"
+	"github.com/apache/iceberg-go/table"
+	"github.com/charmbracelet/log"
+)
+
+// defaultTable is the table written when the DSN doesn't name one.
+const defaultTable = "execute.documents"
+
+// BatchDateProperty records the batch each snapshot loaded in its summary.
+const BatchDateProperty = "execute-sync.batch-date"
+
+// The table has the documents table's columns, plus record_id for engines
+// which merge on a single key.  Document fields stay JSON text in data, so
+// new Execute fields never need the table's schema to change.
+var layout = columnar.Layout{Table: true, RecordID: true}
+
+// Iceberg appends batches to one Iceberg table, which is created (partitioned
+// by type and batch day) the first time it's needed.
+type Iceberg struct {
+	props     ice.Properties // the catalog's, i.e. type, uri and warehouse
+	table     table.Identifier
+	chunkSize int
+
+	catalog catalog.Catalog // loaded by open
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"ICEBERG"},
+		Description: "Appends batches to an Apache Iceberg table through a REST or Glue catalog",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewIceberg(cfg.DatabaseDSN, cfg.ChunkSize)
+		},
+	})
+}
+
+// NewIceberg creates an Iceberg target from a DSN naming its catalog: the
+// URI of a REST catalog (https://catalog.example.com/api/catalog) or
+// glue://ACCOUNT_ID for AWS Glue, the account being optional.  The `table`
+// parameter names the table as NAMESPACE.TABLE (execute.documents by
+// default), and `region` sets the AWS region of Glue and S3.  The other
+// parameters are passed to the catalog as properties, i.e. warehouse,
+// credential or token for REST catalogs, or s3.endpoint.
+func NewIceberg(dsn string, chunkSize int) (*Iceberg, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Iceberg DSN: %w", err)
+	}
+
+	props := ice.Properties{}
+	for key, values := range u.Query() {
+		props[key] = values[0]
+	}
+	name := props.Get("table", defaultTable)
+	delete(props, "table")
+	if region, ok := props["region"]; ok {
+		props[glue.Region] = region
+		props["s3.region"] = region
+		delete(props, "region")
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		props["type"] = "rest"
+		u.RawQuery = ""
+		props["uri"] = u.String()
+	case "glue":
+		props["type"] = "glue"
+		if u.Host != "" {
+			props[glue.CatalogIdKey] = u.Host
+		}
+	default:
+		return nil, fmt.Errorf("invalid Iceberg DSN: expected a REST catalog's https:// URI or glue://, not %q", u.Scheme)
+	}
+
+	ident := catalog.ToIdentifier(name)
+	if len(ident) < 2 {
+		return nil, fmt.Errorf("invalid Iceberg table %q: expected NAMESPACE.TABLE", name)
+	}
+	return &Iceberg{props: props, table: ident, chunkSize: chunkSize}, nil
+}
+
+// open connects to the catalog, the first time it's needed.
+func (i *Iceberg) open(ctx context.Context) (catalog.Catalog, error) {
+	if i.catalog == nil {
+		cat, err := catalog.Load(ctx, "execute-sync", i.props)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to Iceberg catalog: %w", err)
+		}
+		i.catalog = cat
+	}
+	return i.catalog, nil
+}
+
+// load returns the table, creating it and its namespace if they don't exist.
+func (i *Iceberg) load(ctx context.Context, schema *arrow.Schema) (*table.Table, error) {
+	cat, err := i.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := cat.CheckTableExists(ctx, i.table); err != nil {
+		return nil, fmt.Errorf("error checking for table %s: %w", strings.Join(i.table, "."), err)
+	} else if exists {
+		return cat.LoadTable(ctx, i.table)
+	}
+
+	namespace := catalog.NamespaceFromIdent(i.table)
+	exists, err := cat.CheckNamespaceExists(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error checking for namespace %s: %w", strings.Join(namespace, "."), err)
+	}
+	if !exists {
+		if err := cat.CreateNamespace(ctx, namespace, nil); err != nil && !errors.Is(err, catalog.ErrNamespaceAlreadyExists) {
+			return nil, fmt.Errorf("error creating namespace %s: %w", strings.Join(namespace, "."), err)
+		}
+	}
+
+	sc, spec, err := tableSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Creating Iceberg table", "table", strings.Join(i.table, "."))
+	tbl, err := cat.CreateTable(ctx, i.table, sc, catalog.WithPartitionSpec(spec))
+	if errors.Is(err, catalog.ErrTableAlreadyExists) {
+		// Created by another sync in the meantime
+		return cat.LoadTable(ctx, i.table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating table %s: %w", strings.Join(i.table, "."), err)
+	}
+	return tbl, nil
+}
+
+// tableSchema returns the Iceberg schema of the Arrow columns written, and
+// the table's partitioning: by type, and by day of batch_date so that a day's
+// batches share files' directories.
+func tableSchema(schema *arrow.Schema) (*ice.Schema, *ice.PartitionSpec, error) {
+	sc, err := table.ArrowSchemaToIcebergWithFreshIDs(schema, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error converting table schema: %w", err)
+	}
+	docType, _ := sc.FindFieldByName("type")
+	batchDate, _ := sc.FindFieldByName("batch_date")
+	spec := ice.NewPartitionSpec(
+		ice.PartitionField{SourceID: docType.ID, FieldID: 1000, Name: "type", Transform: ice.IdentityTransform{}},
+		ice.PartitionField{SourceID: batchDate.ID, FieldID: 1001, Name: "batch_day", Transform: ice.DayTransform{}},
+	)
+	return sc, &spec, nil
+}
+
+// Prune is a no-op; rows are never superseded in place, and old snapshots are
+// expired by the lakehouse's own maintenance.
+func (i *Iceberg) Prune() error {
+	log.Info("Nothing to prune for Iceberg targets; expire snapshots with your query engine")
+	return nil
+}
+
+// CreateViews is a no-op; views over the table belong to the query engine.
+func (i *Iceberg) CreateViews(data execute.RootSchema) error {
+	log.Info("Helper views are not supported for Iceberg targets")
+	return nil
+}
+
+// Upload streams the batch's chunks to the Iceberg writer a row group at a
+// time, and commits them as one snapshot.  A failed batch commits nothing,
+// so a retry doesn't duplicate rows.
+func (i *Iceberg) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	ctx := context.Background()
+	batchTime, err := time.Parse(time.RFC3339, batch_date)
+	if err != nil {
+		return 0, fmt.Errorf("invalid batch date %q: %v", batch_date, err)
+	}
+
+	builder := columnar.NewBuilder(layout)
+	defer builder.Release()
+
+	document_count := 0
+	docs := stream.Prepare(i.chunkSize)
+	defer docs.Close()
+
+	// Read the first document before touching the catalog, so that empty
+	// batches don't commit empty snapshots
+	first, err := docs.Next()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tbl, err := i.load(ctx, builder.Schema())
+	if err != nil {
+		return 0, err
+	}
+
+	records := func(yield func(arrow.RecordBatch, error) bool) {
+		doc := first
+		for {
+			for chunk, chunkBytes := range doc.JSON {
+				if builder.Append(batchTime, doc.Data, chunk, chunkBytes) && !yield(builder.NewRecordBatch(), nil) {
+					return
+				}
+			}
+			document_count += 1
+
+			if doc, err = docs.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		if builder.Len() > 0 {
+			yield(builder.NewRecordBatch(), nil)
+		}
+	}
+	reader := array.ReaderFromIter(builder.Schema(), records)
+	defer reader.Release()
+
+	tx := tbl.NewTransaction()
+	if err := tx.Append(ctx, reader, ice.Properties{BatchDateProperty: batch_date}); err != nil {
+		return 0, fmt.Errorf("error writing to table %s: %w", strings.Join(i.table, "."), err)
+	}
+	log.Debug("Committing Iceberg snapshot", "table", strings.Join(i.table, "."), "rows", builder.Rows())
+	if _, err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing to table %s: %w", strings.Join(i.table, "."), err)
+	}
+	return document_count, nil
+}
@@ -0,0 +1,120 @@
+package iceberg
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	ice "github.com/apache/iceberg-go"
+	"github.com/apache/iceberg-go/catalog/glue"
+	_ "github.com/apache/iceberg-go/catalog/sql"
+	_ "modernc.org/sqlite"
+)
+
+// stream returns an upload of documents with the given IDs.
+func stream(ids ...string) *documents.Stream {
+	return documents.NewStream(func() (map[string]interface{}, error) {
+		if len(ids) == 0 {
+			return nil, io.EOF
+		}
+		id := ids[0]
+		ids = ids[1:]
+		return map[string]interface{}{"$TYPE": "AFE", "DOCUMENT_ID": id, "$VERSION": int64(1), "$DATE": "2024-05-01T12:00:00Z", "$DELETED": false, "NAME": "AFE " + id}, nil
+	}, 1)
+}
+
+// testIceberg returns a target writing to a SQLite catalog and warehouse in a
+// temporary directory, in place of a REST or Glue catalog.
+func testIceberg(t *testing.T) *Iceberg {
+	t.Helper()
+	dir := t.TempDir()
+	target, err := NewIceberg("https://catalog.invalid/api?table=execute.documents", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.props = ice.Properties{
+		"type":                "sql",
+		"sql.driver":          "sqlite",
+		"sql.dialect":         "sqlite",
+		"uri":                 filepath.Join(dir, "catalog.db"),
+		"warehouse":           "file://" + filepath.ToSlash(dir),
+		"init_catalog_tables": "true",
+	}
+	return target
+}
+
+func TestNewIcebergDSN(t *testing.T) {
+	rest, err := NewIceberg("https://catalog.example.com/api/catalog?warehouse=lake&region=us-east-1&table=raw.execute", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest.props["type"] != "rest" || rest.props["uri"] != "https://catalog.example.com/api/catalog" || rest.props["warehouse"] != "lake" {
+		t.Fatalf("unexpected REST catalog properties %v", rest.props)
+	}
+	if rest.props["s3.region"] != "us-east-1" || rest.props["region"] != "" {
+		t.Fatalf("expected region to set the S3 region, got %v", rest.props)
+	}
+	if len(rest.table) != 2 || rest.table[0] != "raw" || rest.table[1] != "execute" {
+		t.Fatalf("unexpected table %v", rest.table)
+	}
+
+	gl, err := NewIceberg("glue://123456789012", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gl.props["type"] != "glue" || gl.props[glue.CatalogIdKey] != "123456789012" {
+		t.Fatalf("unexpected Glue catalog properties %v", gl.props)
+	}
+	if len(gl.table) != 2 || gl.table[0] != "execute" || gl.table[1] != "documents" {
+		t.Fatalf("expected the default table, got %v", gl.table)
+	}
+
+	for _, dsn := range []string{"s3://bucket/table", "glue://?table=documents"} {
+		if _, err := NewIceberg(dsn, 0); err == nil {
+			t.Errorf("expected %q to be rejected", dsn)
+		}
+	}
+}
+
+func TestUploadCommitsSnapshotPerBatch(t *testing.T) {
+	target := testIceberg(t)
+
+	if n, err := target.Upload("2024-05-01T12:00:00Z", stream("1", "2")); err != nil || n != 2 {
+		t.Fatalf("uploading first batch: %d, %v", n, err)
+	}
+	if n, err := target.Upload("2024-05-02T12:00:00Z", stream("3")); err != nil || n != 1 {
+		t.Fatalf("uploading second batch: %d, %v", n, err)
+	}
+	// An empty batch commits nothing
+	if n, err := target.Upload("2024-05-03T12:00:00Z", stream()); err != nil || n != 0 {
+		t.Fatalf("uploading empty batch: %d, %v", n, err)
+	}
+
+	ctx := context.Background()
+	tbl, err := target.catalog.LoadTable(ctx, target.table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := tbl.Spec(); spec.NumFields() != 2 {
+		t.Fatalf("expected the table to be partitioned by type and batch day, got %v", spec)
+	}
+
+	var batches []string
+	for _, snapshot := range tbl.Metadata().Snapshots() {
+		batches = append(batches, snapshot.Summary.Properties[BatchDateProperty])
+	}
+	if len(batches) != 2 || batches[0] != "2024-05-01T12:00:00Z" || batches[1] != "2024-05-02T12:00:00Z" {
+		t.Fatalf("expected a snapshot per non-empty batch, got %v", batches)
+	}
+
+	rows, err := tbl.Scan().ToArrowTable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Release()
+	if rows.NumRows() != 3 {
+		t.Fatalf("expected 3 rows, got %d", rows.NumRows())
+	}
+}
@@ -0,0 +1,205 @@
+// Package lake writes batches as Parquet (or gzipped NDJSON) files into object
+// storage (S3, GCS or Azure Blob Storage) or a local directory, partitioned by
+// document type and batch date, with no database at all.  Query engines such
+// as Athena, Spark or DuckDB read the files where they lie.
+package lake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/throttle"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/charmbracelet/log"
+)
+
+// Lake writes each batch's documents to one file per document type, at
+// PREFIX/type=TYPE/batch_date=BATCH/part-N.parquet (or .ndjson.gz), then a
+// manifest at PREFIX/_batches/BATCH.json listing them.  A batch whose manifest
+// is missing didn't finish, and its files may be written again by a retry.
+type Lake struct {
+	store     store
+	prefix    string
+	format    string // a key of formats
+	chunkSize int
+}
+
+// Manifest lists the files written for a batch.  It's written last, once
+// every file is in place.
+type Manifest struct {
+	BatchDate string         `json:"batch_date"`
+	Format    string         `json:"format"`
+	Files     []string       `json:"files"`
+	Documents int            `json:"documents"`
+	Rows      int            `json:"rows"`
+	Types     map[string]int `json:"types"`
+	CreatedAt string         `json:"created_at"`
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"LAKE"},
+		Description: "Writes batches as Parquet files, partitioned by type and batch date, to S3, GCS or Azure Blob Storage",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewLake(cfg.DatabaseDSN, cfg.ChunkSize)
+		},
+	})
+	registry.Register(registry.Adapter{
+		Names:       []string{"FILE"},
+		Description: "Writes batches to a local directory as Parquet or gzipped NDJSON, i.e. for testing or air-gapped systems",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewFile(cfg.DatabaseDSN, cfg.ChunkSize)
+		},
+	})
+}
+
+// NewLake creates a lake target from a DSN naming its bucket and prefix (see
+// newStore), i.e. s3://analytics/execute?region=eu-west-1.  The optional
+// `format` parameter selects parquet (default) or ndjson.
+func NewLake(dsn string, chunkSize int) (*Lake, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lake DSN: %w", err)
+	}
+	s, prefix, err := newStore(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lake DSN: %w", err)
+	}
+	format := strings.ToLower(u.Query().Get("format"))
+	if format == "" {
+		format = "parquet"
+	}
+	if _, ok := formats[format]; !ok {
+		return nil, fmt.Errorf("invalid lake format %q: expected parquet or ndjson", format)
+	}
+	return &Lake{store: s, prefix: prefix, format: format, chunkSize: chunkSize}, nil
+}
+
+// NewFile creates a lake in a local directory, from a DSN which is its path
+// (file:///data/execute or /data/execute), i.e. /data/execute?format=ndjson.
+func NewFile(dsn string, chunkSize int) (*Lake, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file DSN: %w", err)
+	}
+	if u.Scheme != "" && u.Scheme != "file" {
+		return nil, fmt.Errorf("invalid file DSN: expected a local directory, not %s:// (see LAKE)", u.Scheme)
+	}
+	return NewLake(dsn, chunkSize)
+}
+
+// Prune is a no-op; old files are best expired by the bucket's lifecycle
+// rules.
+func (l *Lake) Prune() error {
+	log.Info("Nothing to prune for lake targets")
+	return nil
+}
+
+// CreateViews is a no-op; tables over the files belong to the query engine.
+func (l *Lake) CreateViews(data execute.RootSchema) error {
+	log.Info("Helper views are not supported for lake targets")
+	return nil
+}
+
+// Upload writes a batch's documents to a spooled file per document type, then
+// uploads them and the batch's manifest.
+func (l *Lake) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
+	writers := map[string]typeWriter{}
+	defer func() {
+		for _, w := range writers {
+			w.close()
+		}
+	}()
+
+	document_count := 0
+	row_count := 0
+	docs := stream.Prepare(l.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		docType := data["$TYPE"].(string)
+		w, ok := writers[docType]
+		if !ok {
+			if w, err = newTypeWriter(l.format); err != nil {
+				return 0, fmt.Errorf("error creating %s file: %v", l.format, err)
+			}
+			writers[docType] = w
+		}
+		for i, chunkBytes := range doc.JSON {
+			if err := w.write(data, i, chunkBytes); err != nil {
+				return 0, fmt.Errorf("error writing %s file: %v", l.format, err)
+			}
+			row_count += 1
+		}
+		document_count += 1
+	}
+
+	// Nothing to write
+	if document_count == 0 {
+		return 0, nil
+	}
+
+	manifest := Manifest{
+		BatchDate: batch_date,
+		Format:    l.format,
+		Documents: document_count,
+		Rows:      row_count,
+		Types:     map[string]int{},
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var types []string
+	for docType := range writers {
+		types = append(types, docType)
+	}
+	sort.Strings(types)
+	part := fmt.Sprintf("part-%d%s", time.Now().UnixNano(), formats[l.format])
+	for _, docType := range types {
+		w := writers[docType]
+		if err := w.finish(); err != nil {
+			return 0, fmt.Errorf("error finalizing %s file: %v", l.format, err)
+		}
+		file, rows := w.spooled()
+		key := join(l.prefix, "type="+partitionValue(docType), "batch_date="+safeBatchDate, part)
+		body, err := file.Reader()
+		if err != nil {
+			return 0, fmt.Errorf("error reading %s file: %v", l.format, err)
+		}
+		log.Debug("Uploading file", "store", l.store, "key", key, "rows", rows, "bytes", file.Size())
+		if err := l.store.put(key, throttle.Reader(body), file.Size()); err != nil {
+			return 0, fmt.Errorf("error uploading %s: %w", key, err)
+		}
+		manifest.Files = append(manifest.Files, key)
+		manifest.Types[docType] = rows
+	}
+
+	manifestBytes, _ := json.MarshalIndent(manifest, "", "  ")
+	manifestKey := join(l.prefix, "_batches", safeBatchDate+".json")
+	if err := l.store.put(manifestKey, strings.NewReader(string(manifestBytes)), int64(len(manifestBytes))); err != nil {
+		return 0, fmt.Errorf("error uploading %s: %w", manifestKey, err)
+	}
+	return document_count, nil
+}
+
+// partitionValue makes a document type safe to use as a partition directory.
+func partitionValue(docType string) string {
+	return strings.NewReplacer("/", "_", "=", "_").Replace(docType)
+}
@@ -0,0 +1,181 @@
+package lake
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// stream returns an upload of the documents, given as TYPE/ID.
+func stream(docs ...[2]string) *documents.Stream {
+	return documents.NewStream(func() (map[string]interface{}, error) {
+		if len(docs) == 0 {
+			return nil, io.EOF
+		}
+		doc := docs[0]
+		docs = docs[1:]
+		return map[string]interface{}{"$TYPE": doc[0], "DOCUMENT_ID": doc[1], "$VERSION": int64(1), "$DATE": "2024-01-01T00:00:00Z", "$DELETED": false, "NAME": doc[1]}, nil
+	}, 1)
+}
+
+// readManifest returns the manifest of a batch written to dir.
+func readManifest(t *testing.T, dir string, batch string) Manifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "_batches", batch+".json"))
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestNewLakeDSN(t *testing.T) {
+	for _, dsn := range []string{"ftp://host/dir", "s3:///prefix", "azure://account", "/data/execute?format=csv"} {
+		if _, err := NewLake(dsn, 0); err == nil {
+			t.Errorf("expected LAKE %q to be rejected", dsn)
+		}
+	}
+	if _, err := NewFile("gs://bucket/prefix", 0); err == nil {
+		t.Error("expected FILE to reject a bucket")
+	}
+
+	l, err := NewLake("azure://account/container/execute/raw?sas=token", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.prefix != "execute/raw" || l.format != "parquet" {
+		t.Fatalf("unexpected lake %+v", l)
+	}
+}
+
+func TestUploadWritesPartitionedNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewFile(dir+"?format=ndjson", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := l.Upload("2024-01-02T03:04:05Z", stream([2]string{"AFE", "1"}, [2]string{"WELL", "2"}, [2]string{"AFE", "3"}))
+	if err != nil || count != 3 {
+		t.Fatalf("upload: %d, %v", count, err)
+	}
+
+	manifest := readManifest(t, dir, "20240102T030405Z")
+	if manifest.Documents != 3 || manifest.Rows != 3 || !reflect.DeepEqual(manifest.Types, map[string]int{"AFE": 2, "WELL": 1}) || manifest.Format != "ndjson" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("expected a file per type, got %v", manifest.Files)
+	}
+	afeFile := manifest.Files[0]
+	if path.Dir(afeFile) != "type=AFE/batch_date=20240102T030405Z" || path.Ext(afeFile) != ".gz" {
+		t.Fatalf("expected a Hive-style partitioned path, got %s", afeFile)
+	}
+
+	f, err := os.Open(filepath.Join(dir, afeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	lines := bufio.NewScanner(gz)
+	for lines.Scan() {
+		var row struct {
+			ID       string                 `json:"id"`
+			Version  int64                  `json:"version"`
+			Data     map[string]interface{} `json:"data"`
+			RecordID string                 `json:"record_id"`
+		}
+		if err := json.Unmarshal(lines.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row.Version != 1 || row.Data["NAME"] != row.ID || row.RecordID == "" {
+			t.Fatalf("unexpected row %s", lines.Bytes())
+		}
+		ids = append(ids, row.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "3"}) {
+		t.Fatalf("expected the AFEs in the AFE file, got %v", ids)
+	}
+}
+
+func TestUploadWritesParquet(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewFile("file://"+filepath.ToSlash(dir), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docs [][2]string
+	for i := 0; i < 10; i++ {
+		docs = append(docs, [2]string{"AFE", fmt.Sprint(i)})
+	}
+	if _, err := l.Upload("2024-01-02T00:00:00Z", stream(docs...)); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := readManifest(t, dir, "20240102T000000Z")
+	if len(manifest.Files) != 1 || path.Ext(manifest.Files[0]) != ".parquet" {
+		t.Fatalf("unexpected files %v", manifest.Files)
+	}
+	reader, err := file.OpenParquetFile(filepath.Join(dir, manifest.Files[0]), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	arrowReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := arrowReader.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+
+	if table.NumRows() != 10 {
+		t.Fatalf("expected 10 rows, got %d", table.NumRows())
+	}
+	var columns []string
+	for _, field := range table.Schema().Fields() {
+		columns = append(columns, field.Name)
+	}
+	sort.Strings(columns)
+	// type and batch_date are partition keys, so aren't repeated in the files
+	if !reflect.DeepEqual(columns, []string{"author", "chunk", "data", "date", "deleted", "id", "record_id", "version"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+}
+
+func TestUploadSkipsEmptyBatch(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewFile(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, err := l.Upload("2024-01-02T00:00:00Z", stream()); err != nil || count != 0 {
+		t.Fatalf("upload: %d, %v", count, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected nothing written for an empty batch, found %d entries", len(entries))
+	}
+}
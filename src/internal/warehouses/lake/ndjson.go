@@ -0,0 +1,80 @@
+package lake
+
+import (
+	"compress/gzip"
+	"encoding/json"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/spool"
+)
+
+// typeWriter writes one document type's rows to a spooled file in one of the
+// formats.
+type typeWriter interface {
+	// write adds one chunk of a document.
+	write(data map[string]interface{}, chunk int, chunkJSON []byte) error
+	// finish completes the file, ready for uploading.
+	finish() error
+	// close releases the writer and its spool file.
+	close()
+	// spooled returns the file written and the number of rows in it.
+	spooled() (*spool.File, int)
+}
+
+// formats are the file formats a lake can be written in, by the extension of
+// their files.
+var formats = map[string]string{
+	"parquet": ".parquet",
+	"ndjson":  ".ndjson.gz",
+}
+
+func newTypeWriter(format string) (typeWriter, error) {
+	if format == "ndjson" {
+		return newNDJSONWriter()
+	}
+	return newParquetWriter()
+}
+
+// ndjsonWriter writes one document type's rows as gzipped NDJSON, one object
+// per chunk with the same columns as the Parquet files.
+type ndjsonWriter struct {
+	file    *spool.File
+	gz      *gzip.Writer
+	encoder *json.Encoder
+	rows    int
+}
+
+func newNDJSONWriter() (*ndjsonWriter, error) {
+	file, err := spool.New("documents_*.ndjson.gz")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	return &ndjsonWriter{file: file, gz: gz, encoder: json.NewEncoder(gz)}, nil
+}
+
+func (w *ndjsonWriter) write(data map[string]interface{}, chunk int, chunkJSON []byte) error {
+	w.rows++
+	return w.encoder.Encode(map[string]interface{}{
+		"id":        data["DOCUMENT_ID"].(string),
+		"version":   documents.Version(data),
+		"chunk":     chunk,
+		"author":    documents.SQLValue(documents.Author(data)),
+		"date":      data["$DATE"].(string),
+		"deleted":   data["$DELETED"].(bool),
+		"data":      json.RawMessage(chunkJSON),
+		"record_id": documents.RecordID(data, chunk),
+	})
+}
+
+func (w *ndjsonWriter) finish() error {
+	return w.gz.Close()
+}
+
+func (w *ndjsonWriter) close() {
+	w.file.Close()
+}
+
+func (w *ndjsonWriter) spooled() (*spool.File, int) {
+	return w.file, w.rows
+}
@@ -0,0 +1,48 @@
+package lake
+
+import (
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/warehouses/columnar"
+)
+
+// The files leave out TYPE and BATCH_DATE, which are partition keys (engines
+// such as Spark refuse files repeating them).
+var layout = columnar.Layout{RecordID: true}
+
+// parquetWriter writes one document type's rows to a spooled Parquet file.
+type parquetWriter struct {
+	file   *spool.File
+	writer *columnar.Writer
+}
+
+func newParquetWriter() (*parquetWriter, error) {
+	file, err := spool.New("documents_*.parquet")
+	if err != nil {
+		return nil, err
+	}
+	writer, err := columnar.NewWriter(file, layout)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &parquetWriter{file: file, writer: writer}, nil
+}
+
+func (w *parquetWriter) write(data map[string]interface{}, chunk int, chunkJSON []byte) error {
+	return w.writer.Write(time.Time{}, data, chunk, chunkJSON)
+}
+
+func (w *parquetWriter) finish() error {
+	return w.writer.Close()
+}
+
+func (w *parquetWriter) close() {
+	w.writer.Release()
+	w.file.Close()
+}
+
+func (w *parquetWriter) spooled() (*spool.File, int) {
+	return w.file, w.writer.Rows()
+}
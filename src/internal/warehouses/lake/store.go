@@ -0,0 +1,230 @@
+package lake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// store is somewhere files can be written to by key, i.e. a bucket.
+type store interface {
+	// put writes a file of size bytes to key, replacing any already there.
+	put(key string, body io.Reader, size int64) error
+	// String describes the store's location, for logging.
+	String() string
+}
+
+// newStore creates the store named by a DSN's URL:
+//
+//	s3://BUCKET/PREFIX?region=REGION&endpoint=URL
+//	gs://BUCKET/PREFIX?token=ACCESS_TOKEN
+//	azure://ACCOUNT/CONTAINER/PREFIX?sas=TOKEN
+//	file:///PATH (or just a path)
+//
+// S3 takes its credentials from the usual AWS environment, profile or
+// instance role; an endpoint selects an S3 compatible service such as MinIO.
+// GCS takes an OAuth access token, defaulting to GOOGLE_OAUTH_ACCESS_TOKEN,
+// and Azure a SAS token or the account key in AZURE_STORAGE_KEY.
+func newStore(u *url.URL) (store, string, error) {
+	prefix := strings.Trim(u.Path, "/")
+	query := u.Query()
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("missing S3 bucket")
+		}
+		s, err := newS3Store(u.Host, query.Get("region"), query.Get("endpoint"))
+		return s, prefix, err
+	case "gs":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("missing GCS bucket")
+		}
+		token := query.Get("token")
+		if token == "" {
+			token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+		}
+		return &gcsStore{bucket: u.Host, token: token, endpoint: "https://storage.googleapis.com"}, prefix, nil
+	case "azure":
+		container, rest, _ := strings.Cut(prefix, "/")
+		if u.Host == "" || container == "" {
+			return nil, "", fmt.Errorf("expected azure://ACCOUNT/CONTAINER/PREFIX")
+		}
+		s, err := newAzureStore(u.Host, container, query.Get("sas"))
+		return s, rest, err
+	case "file", "":
+		if u.Path == "" {
+			return nil, "", fmt.Errorf("missing directory")
+		}
+		return localStore{dir: u.Path}, "", nil
+	}
+	return nil, "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+}
+
+// Uploads go through the default transport, so the CA bundle and proxy apply
+// (see netconfig).
+func httpClient() *http.Client {
+	return &http.Client{Transport: http.DefaultTransport}
+}
+
+// s3Store writes to an S3 bucket.
+type s3Store struct {
+	bucket   string
+	uploader *manager.Uploader
+}
+
+func newS3Store(bucket string, region string, endpoint string) (*s3Store, error) {
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(httpClient())}
+	if region != "" {
+		options = append(options, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Store{bucket: bucket, uploader: manager.NewUploader(client)}, nil
+}
+
+func (s *s3Store) put(key string, body io.Reader, size int64) error {
+	_, err := s.uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.bucket
+}
+
+// gcsStore writes to a Google Cloud Storage bucket through its JSON API.
+type gcsStore struct {
+	bucket   string
+	token    string
+	endpoint string
+}
+
+func (g *gcsStore) put(key string, body io.Reader, size int64) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", g.endpoint, url.PathEscape(g.bucket), url.QueryEscape(key))
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	client := httpClient()
+	client.Timeout = 30 * time.Minute
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GCS upload failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func (g *gcsStore) String() string {
+	return "gs://" + g.bucket
+}
+
+// azureStore writes to an Azure Blob Storage container.
+type azureStore struct {
+	account   string
+	container string
+	client    *azblob.Client
+}
+
+func newAzureStore(account string, container string, sas string) (*azureStore, error) {
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	options := &azblob.ClientOptions{ClientOptions: policy.ClientOptions{Transport: httpClient()}}
+	var client *azblob.Client
+	var err error
+	if sas != "" {
+		client, err = azblob.NewClientWithNoCredential(serviceURL+"?"+strings.TrimPrefix(sas, "?"), options)
+	} else {
+		key := os.Getenv("AZURE_STORAGE_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("Azure needs a sas parameter or AZURE_STORAGE_KEY")
+		}
+		var cred *azblob.SharedKeyCredential
+		if cred, err = azblob.NewSharedKeyCredential(account, key); err == nil {
+			client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, options)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating Azure client: %w", err)
+	}
+	return &azureStore{account: account, container: container, client: client}, nil
+}
+
+func (a *azureStore) put(key string, body io.Reader, size int64) error {
+	_, err := a.client.UploadStream(context.Background(), a.container, key, body, nil)
+	return err
+}
+
+func (a *azureStore) String() string {
+	return "azure://" + a.account + "/" + a.container
+}
+
+// localStore writes to a directory, i.e. a mounted bucket.  Files are
+// written under a temporary name and renamed, so they appear complete.
+type localStore struct {
+	dir string
+}
+
+func (l localStore) put(key string, body io.Reader, size int64) error {
+	target := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func (l localStore) String() string {
+	return l.dir
+}
+
+// join builds a key beneath the DSN's prefix.
+func join(prefix string, parts ...string) string {
+	return strings.TrimPrefix(path.Join(append([]string{prefix}, parts...)...), "/")
+}
@@ -0,0 +1,278 @@
+// Package plugin loads documents through a warehouse adapter shipped as a
+// program of its own, so that adapters for proprietary warehouses don't need
+// a fork of execute-sync.  The program is started once and spoken to with
+// JSON-RPC 1.0 (as Go's net/rpc/jsonrpc speaks it) over its stdin and stdout;
+// whatever it writes to stderr is passed through to execute-sync's.  See the
+// README for the methods it has to serve.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/charmbracelet/log"
+)
+
+// Protocol is the version of the plugin protocol, sent to Configure so a
+// plugin can refuse one it doesn't understand.
+const Protocol = 1
+
+// Rows are written to the plugin in pages of at most this many rows, or this
+// many bytes of chunk data.
+const (
+	maxPageRows  = 1000
+	maxPageBytes = 4 * 1024 * 1024
+)
+
+// Plugin is a warehouse whose adapter is an external program.
+type Plugin struct {
+	command   []string
+	schema    string
+	chunkSize int
+
+	mu     sync.Mutex // a batch's Begin, Writes and Commit go together
+	cmd    *exec.Cmd
+	client *rpc.Client
+}
+
+// ConfigureArgs are sent once the plugin starts.
+type ConfigureArgs struct {
+	Protocol  int    `json:"protocol"`
+	Schema    string `json:"schema,omitempty"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// BeginArgs start a batch.
+type BeginArgs struct {
+	BatchDate string `json:"batch_date"`
+}
+
+// WriteArgs carry a page of the batch's rows.
+type WriteArgs struct {
+	Rows []Row `json:"rows"`
+}
+
+// Row is one chunk of a document, as a row of the documents table.
+type Row struct {
+	BatchDate string                 `json:"batch_date"`
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Version   int64                  `json:"version"`
+	Chunk     int                    `json:"chunk"`
+	Author    *string                `json:"author"`
+	Date      string                 `json:"date"`
+	Deleted   bool                   `json:"deleted"`
+	RecordID  string                 `json:"record_id"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// CreateViewsArgs carry the schema views are built from.
+type CreateViewsArgs struct {
+	Schema execute.RootSchema `json:"schema"`
+}
+
+// Empty is the argument (or result) of methods which don't need one.
+type Empty struct{}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"PLUGIN"},
+		Description: "Loads through an external adapter program speaking JSON-RPC on stdin/stdout",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewPlugin(cfg.DatabaseDSN, cfg.DatabaseSchema, cfg.ChunkSize)
+		},
+	})
+}
+
+// NewPlugin creates a warehouse loaded through the program dsn names, along
+// with any arguments separated by spaces (i.e. /opt/acme/acme-sync --region
+// eu).  The program isn't started until it's first needed.
+func NewPlugin(dsn string, schema string, chunkSize int) (*Plugin, error) {
+	command := strings.Fields(dsn)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("PLUGIN needs DATABASE_DSN set to the adapter program")
+	}
+	return &Plugin{command: command, schema: schema, chunkSize: chunkSize}, nil
+}
+
+// Prune asks the plugin to remove superseded rows.
+func (p *Plugin) Prune() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.call("Warehouse.Prune", Empty{})
+}
+
+// CreateViews asks the plugin to create its helper views.
+func (p *Plugin) CreateViews(root execute.RootSchema) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.call("Warehouse.CreateViews", CreateViewsArgs{Schema: root})
+}
+
+// Upload sends the batch's rows to the plugin a page at a time, between Begin
+// and Commit.  A batch which fails part way is aborted, so the plugin can
+// throw away what it was sent.
+func (p *Plugin) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.call("Warehouse.Begin", BeginArgs{BatchDate: batch_date}); err != nil {
+		return 0, err
+	}
+	document_count, err := p.write(batch_date, stream)
+	if err == nil {
+		err = p.call("Warehouse.Commit", Empty{})
+	}
+	if err != nil {
+		if p.client != nil {
+			if abortErr := p.call("Warehouse.Abort", Empty{}); abortErr != nil {
+				log.Warn("Plugin failed to abort the batch", "error", abortErr)
+			}
+		}
+		return 0, err
+	}
+	return document_count, nil
+}
+
+// write sends every chunk of the batch's documents in pages.
+func (p *Plugin) write(batch_date string, stream *documents.Stream) (int, error) {
+	document_count := 0
+	var page []Row
+	pageBytes := 0
+	docs := stream.Prepare(p.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		for i, chunk := range doc.Chunks {
+			row := Row{
+				BatchDate: batch_date,
+				Type:      data["$TYPE"].(string),
+				ID:        data["DOCUMENT_ID"].(string),
+				Version:   documents.Version(data),
+				Chunk:     i,
+				Deleted:   data["$DELETED"].(bool),
+				RecordID:  documents.RecordID(data, i),
+				Data:      chunk,
+			}
+			row.Date, _ = data["$DATE"].(string)
+			if author, null := documents.Author(data); !null {
+				row.Author = &author
+			}
+			// Only an estimate, as the page is encoded in one go
+			size := doc.JSON[i]
+
+			if len(page) >= maxPageRows || (len(page) > 0 && pageBytes+len(size) > maxPageBytes) {
+				if err := p.call("Warehouse.Write", WriteArgs{Rows: page}); err != nil {
+					return 0, err
+				}
+				page = page[:0]
+				pageBytes = 0
+			}
+			page = append(page, row)
+			pageBytes += len(size)
+		}
+		document_count += 1
+	}
+	if len(page) > 0 {
+		if err := p.call("Warehouse.Write", WriteArgs{Rows: page}); err != nil {
+			return 0, err
+		}
+	}
+	return document_count, nil
+}
+
+// call calls a method of the plugin, starting it first if it isn't running.
+// A plugin which has gone away is started again by the next call.
+func (p *Plugin) call(method string, args interface{}) error {
+	if p.client == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	var reply json.RawMessage
+	err := p.client.Call(method, args, &reply)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		p.stop()
+		return fmt.Errorf("plugin %s exited during %s", p.command[0], method)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", method, err)
+	}
+	return nil
+}
+
+// start runs the plugin and configures it.
+func (p *Plugin) start() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting plugin %s: %v", p.command[0], err)
+	}
+	log.Debug("Started plugin", "command", p.command[0], "pid", cmd.Process.Pid)
+	p.cmd = cmd
+	p.client = jsonrpc.NewClient(pipe{stdout, stdin})
+
+	var reply json.RawMessage
+	if err := p.client.Call("Warehouse.Configure", ConfigureArgs{Protocol: Protocol, Schema: p.schema, ChunkSize: p.chunkSize}, &reply); err != nil {
+		p.stop()
+		return fmt.Errorf("configuring plugin %s: %v", p.command[0], err)
+	}
+	return nil
+}
+
+// stop closes the plugin's stdin, which it should take as its cue to exit,
+// and waits for it.
+func (p *Plugin) stop() {
+	if p.client != nil {
+		p.client.Close()
+	}
+	if p.cmd != nil {
+		if err := p.cmd.Wait(); err != nil {
+			log.Warn("Plugin exited", "command", p.command[0], "error", err)
+		}
+	}
+	p.client, p.cmd = nil, nil
+}
+
+// pipe joins the plugin's stdout and stdin into a connection.
+type pipe struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (c pipe) Close() error {
+	err := c.WriteCloser.Close()
+	if readErr := c.ReadCloser.Close(); err == nil {
+		err = readErr
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+)
+
+// The tests run this binary as the plugin, with PLUGIN_TEST_LOG set to the
+// file each request it receives is appended to.  PLUGIN_TEST_FAIL names a
+// method it replies to with an error, and PLUGIN_TEST_EXIT one it exits on.
+func TestMain(m *testing.M) {
+	if log := os.Getenv("PLUGIN_TEST_LOG"); log != "" {
+		servePlugin(log, os.Getenv("PLUGIN_TEST_FAIL"), os.Getenv("PLUGIN_TEST_EXIT"))
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// request is a JSON-RPC 1.0 request, as a plugin receives it.
+type request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     json.RawMessage   `json:"id"`
+}
+
+// servePlugin answers requests on stdin, one JSON object per line, by hand
+// rather than with net/rpc so that the framing itself is tested.
+func servePlugin(path string, fail string, exit string) {
+	log, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer log.Close()
+	lines := bufio.NewScanner(os.Stdin)
+	lines.Buffer(nil, 64*1024*1024)
+	for lines.Scan() {
+		fmt.Fprintln(log, lines.Text())
+		var req request
+		if err := json.Unmarshal(lines.Bytes(), &req); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+			return
+		}
+		switch req.Method {
+		case exit:
+			return
+		case fail:
+			fmt.Printf(`{"id": %s, "result": null, "error": "%s failed"}`+"\n", req.ID, req.Method)
+		default:
+			fmt.Printf(`{"id": %s, "result": {}, "error": null}`+"\n", req.ID)
+		}
+	}
+}
+
+// testPlugin returns a plugin running this binary, and a function returning
+// the requests it has received so far.
+func testPlugin(t *testing.T) (*Plugin, func() []request) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "requests.log")
+	t.Setenv("PLUGIN_TEST_LOG", path)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(exe, " \t") {
+		t.Skip("test binary's path contains spaces")
+	}
+	p, err := NewPlugin(exe, "EXECUTE", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.stop)
+	return p, func() []request {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var requests []request
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var req request
+			if err := json.Unmarshal([]byte(line), &req); err != nil {
+				t.Fatalf("request isn't one JSON object per line: %q", line)
+			}
+			requests = append(requests, req)
+		}
+		return requests
+	}
+}
+
+func methods(requests []request) []string {
+	var names []string
+	for _, req := range requests {
+		names = append(names, strings.TrimPrefix(req.Method, "Warehouse."))
+	}
+	return names
+}
+
+// stream returns an upload of n documents.
+func stream(n int) *documents.Stream {
+	i := 0
+	return documents.NewStream(func() (map[string]interface{}, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		return map[string]interface{}{"$TYPE": "AFE", "DOCUMENT_ID": fmt.Sprint(i), "$VERSION": int64(2), "$DATE": "2024-05-01T12:00:00Z", "$DELETED": false, "NAME": "AFE"}, nil
+	}, 1)
+}
+
+func TestUploadFraming(t *testing.T) {
+	p, requests := testPlugin(t)
+	if n, err := p.Upload("2024-05-02T00:00:00Z", stream(2)); err != nil || n != 2 {
+		t.Fatalf("upload: %d, %v", n, err)
+	}
+
+	reqs := requests()
+	if got := strings.Join(methods(reqs), ","); got != "Configure,Begin,Write,Commit" {
+		t.Fatalf("unexpected calls %s", got)
+	}
+	for i, req := range reqs {
+		if len(req.Params) != 1 {
+			t.Fatalf("expected %s to have a single parameter, got %d", req.Method, len(req.Params))
+		}
+		if string(req.ID) != fmt.Sprint(i) {
+			t.Fatalf("expected %s to have ID %d, got %s", req.Method, i, req.ID)
+		}
+	}
+
+	var configure ConfigureArgs
+	if err := json.Unmarshal(reqs[0].Params[0], &configure); err != nil || configure.Protocol != Protocol || configure.Schema != "EXECUTE" {
+		t.Fatalf("unexpected Configure %s", reqs[0].Params[0])
+	}
+	var begin BeginArgs
+	if err := json.Unmarshal(reqs[1].Params[0], &begin); err != nil || begin.BatchDate != "2024-05-02T00:00:00Z" {
+		t.Fatalf("unexpected Begin %s", reqs[1].Params[0])
+	}
+	var write WriteArgs
+	if err := json.Unmarshal(reqs[2].Params[0], &write); err != nil || len(write.Rows) != 2 {
+		t.Fatalf("unexpected Write %s", reqs[2].Params[0])
+	}
+	row := write.Rows[0]
+	if row.Type != "AFE" || row.ID != "1" || row.Version != 2 || row.Chunk != 0 || row.BatchDate != "2024-05-02T00:00:00Z" || row.RecordID == "" || row.Author != nil {
+		t.Fatalf("unexpected row %+v", row)
+	}
+	if row.Data["NAME"] != "AFE" {
+		t.Fatalf("expected the chunk's fields in data, got %v", row.Data)
+	}
+}
+
+func TestUploadPagesRows(t *testing.T) {
+	p, requests := testPlugin(t)
+	if _, err := p.Upload("2024-05-02T00:00:00Z", stream(maxPageRows+1)); err != nil {
+		t.Fatal(err)
+	}
+	var pages []int
+	for _, req := range requests() {
+		if req.Method == "Warehouse.Write" {
+			var write WriteArgs
+			if err := json.Unmarshal(req.Params[0], &write); err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, len(write.Rows))
+		}
+	}
+	if len(pages) != 2 || pages[0] != maxPageRows || pages[1] != 1 {
+		t.Fatalf("expected a full page and a page of one, got %v", pages)
+	}
+}
+
+func TestUploadAbortsFailedBatch(t *testing.T) {
+	t.Setenv("PLUGIN_TEST_FAIL", "Warehouse.Commit")
+	p, requests := testPlugin(t)
+	_, err := p.Upload("2024-05-02T00:00:00Z", stream(1))
+	if err == nil || !strings.Contains(err.Error(), "Warehouse.Commit failed") {
+		t.Fatalf("expected the plugin's error, got %v", err)
+	}
+	if got := strings.Join(methods(requests()), ","); got != "Configure,Begin,Write,Commit,Abort" {
+		t.Fatalf("unexpected calls %s", got)
+	}
+}
+
+func TestPluginRestartsAfterExiting(t *testing.T) {
+	t.Setenv("PLUGIN_TEST_EXIT", "Warehouse.Write")
+	p, requests := testPlugin(t)
+	_, err := p.Upload("2024-05-02T00:00:00Z", stream(1))
+	if err == nil || !strings.Contains(err.Error(), "exited during Warehouse.Write") {
+		t.Fatalf("expected the plugin exiting to fail the batch, got %v", err)
+	}
+
+	// The next call starts it again, and configures it first
+	t.Setenv("PLUGIN_TEST_EXIT", "")
+	if err := p.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(methods(requests()), ","); got != "Configure,Begin,Write,Configure,Prune" {
+		t.Fatalf("unexpected calls %s", got)
+	}
+}
@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/charmbracelet/log"
+)
+
+const defaultEndpoint = "https://pubsub.googleapis.com"
+
+// Pub/Sub limits a publish request to 1000 messages and 10MB; stay well under both.
+const (
+	maxBatchMessages = 500
+	maxBatchBytes    = 5 * 1024 * 1024
+)
+
+// PubSub publishes every document chunk as a message on a Google Cloud
+// Pub/Sub topic.  Delivery is at-least-once: Upload only returns successfully
+// once Pub/Sub has acknowledged every message, so the sync highwater mark is
+// never advanced past documents that were not published.
+type PubSub struct {
+	endpoint  string
+	project   string
+	topic     string
+	token     string
+	chunkSize int
+	client    *http.Client
+}
+
+type message struct {
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"PUBSUB"},
+		Description: "Publishes document chunks to a Google Cloud Pub/Sub topic",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewPubSub(cfg.DatabaseDSN, cfg.ChunkSize)
+		},
+	})
+}
+
+// NewPubSub creates a Pub/Sub sink from a DSN of the form
+// pubsub://PROJECT/TOPIC?token=ACCESS_TOKEN.  When no token is provided the
+// GOOGLE_OAUTH_ACCESS_TOKEN environment variable is used, and when
+// PUBSUB_EMULATOR_HOST is set messages are sent to the local emulator.
+func NewPubSub(dsn string, chunkSize int) (*PubSub, error) {
+	if !strings.HasPrefix(dsn, "pubsub://") {
+		return nil, fmt.Errorf("invalid Pub/Sub DSN: must start with 'pubsub://'")
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pub/Sub DSN: %w", err)
+	}
+
+	p := &PubSub{
+		endpoint:  defaultEndpoint,
+		project:   u.Host,
+		topic:     strings.Trim(u.Path, "/"),
+		token:     u.Query().Get("token"),
+		chunkSize: chunkSize,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if p.project == "" || p.topic == "" {
+		return nil, fmt.Errorf("invalid Pub/Sub DSN: expected pubsub://PROJECT/TOPIC")
+	}
+	if p.token == "" {
+		p.token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		p.endpoint = strings.TrimRight(endpoint, "/")
+	} else if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		p.endpoint = "http://" + host
+	}
+	return p, nil
+}
+
+// Prune is a no-op; published messages cannot be retracted.
+func (p *PubSub) Prune() error {
+	log.Info("Nothing to prune for Pub/Sub targets")
+	return nil
+}
+
+// CreateViews is a no-op; views are the responsibility of the topic's consumers.
+func (p *PubSub) CreateViews(data execute.RootSchema) error {
+	log.Info("Helper views are not supported for Pub/Sub targets")
+	return nil
+}
+
+// Upload publishes one message per document chunk.  Messages are sent in
+// batches and any failure aborts the upload so the batch is retried.
+func (p *PubSub) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	document_count := 0
+	var batch []message
+	batchBytes := 0
+
+	docs := stream.Prepare(p.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		for i, chunkBytes := range doc.JSON {
+			msg := message{
+				Data: base64.StdEncoding.EncodeToString(chunkBytes),
+				Attributes: map[string]string{
+					"batch_date":  batch_date,
+					"type":        data["$TYPE"].(string),
+					"document_id": data["DOCUMENT_ID"].(string),
+					"version":     documents.FormatInt(documents.Version(data)),
+					"chunk":       documents.FormatInt(int64(i)),
+					"deleted":     documents.FormatBool(data["$DELETED"].(bool)),
+					"record_id":   documents.RecordID(data, i),
+				},
+			}
+
+			if len(batch) >= maxBatchMessages || (len(batch) > 0 && batchBytes+len(msg.Data) > maxBatchBytes) {
+				if err := p.publish(batch); err != nil {
+					return 0, err
+				}
+				batch = batch[:0]
+				batchBytes = 0
+			}
+			batch = append(batch, msg)
+			batchBytes += len(msg.Data)
+		}
+		document_count += 1
+	}
+
+	if len(batch) > 0 {
+		if err := p.publish(batch); err != nil {
+			return 0, err
+		}
+	}
+	return document_count, nil
+}
+
+// publish sends a set of messages to the topic and waits for acknowledgement.
+func (p *PubSub) publish(messages []message) error {
+	body, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		return fmt.Errorf("encoding messages: %v", err)
+	}
+
+	publishURL := fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish", p.endpoint, url.PathEscape(p.project), url.PathEscape(p.topic))
+	req, err := http.NewRequest("POST", publishURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	log.Debug("Publishing to Pub/Sub", "topic", p.topic, "messages", len(messages))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("publishing to Pub/Sub: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		log.Debugf("Pub/Sub error response - Status: %d, Body: %s", resp.StatusCode, string(b))
+		return fmt.Errorf("pubsub publish failed with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		MessageIds []string `json:"messageIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("parsing publish response: %v", err)
+	}
+	if len(result.MessageIds) != len(messages) {
+		return fmt.Errorf("pubsub acknowledged %d of %d messages", len(result.MessageIds), len(messages))
+	}
+	return nil
+}
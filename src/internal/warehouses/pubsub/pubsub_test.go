@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+)
+
+func TestUploadSendsOversizedMessagesAlone(t *testing.T) {
+	var published []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Messages) == 0 {
+			http.Error(w, "no messages", http.StatusBadRequest)
+			return
+		}
+		published = append(published, len(body.Messages))
+		ids := make([]string, len(body.Messages))
+		json.NewEncoder(w).Encode(map[string][]string{"messageIds": ids})
+	}))
+	defer server.Close()
+
+	p, err := NewPubSub("pubsub://project/topic?endpoint="+server.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{maxBatchBytes + 1, 10, 10}
+	i := 0
+	stream := documents.NewStream(func() (map[string]interface{}, error) {
+		if i == len(sizes) {
+			return nil, io.EOF
+		}
+		i++
+		return map[string]interface{}{
+			"$TYPE": "AFE", "DOCUMENT_ID": fmt.Sprint(i), "$VERSION": 1, "$DATE": "2024-01-01T00:00:00Z", "$DELETED": false,
+			"NAME": strings.Repeat("x", sizes[i-1]),
+		}, nil
+	}, 1)
+
+	count, err := p.Upload("2024-01-01T00:00:00Z", stream)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if count != 3 || !reflect.DeepEqual(published, []int{1, 2}) {
+		t.Fatalf("uploaded %d documents in batches %v, want 3 in [1 2]", count, published)
+	}
+}
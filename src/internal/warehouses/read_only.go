@@ -0,0 +1,119 @@
+package warehouses
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+)
+
+// readOnly wraps a Database in read-only mode, refusing every operation which
+// would change the warehouse and passing the others through.
+type readOnly struct {
+	db Database
+}
+
+func (r readOnly) Prune() error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	return 0, readonly.ErrReadOnly
+}
+
+func (r readOnly) CreateViews(root execute.RootSchema) error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) CreateRelationships(root execute.RootSchema) error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) CleanStage(olderThan time.Duration) (int, error) {
+	return 0, readonly.ErrReadOnly
+}
+
+func (r readOnly) PruneTable() error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) PruneStage() error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) PruneBefore(cutoff time.Time) error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) Reconcile(batches int) ([]documents.Batch, error) {
+	if reconciler, ok := r.db.(Reconciler); ok {
+		return reconciler.Reconcile(batches)
+	}
+	return nil, fmt.Errorf("%T can't be reconciled", r.db)
+}
+
+func (r readOnly) BatchExists(batch_date string) (bool, error) {
+	if reconciler, ok := r.db.(Reconciler); ok {
+		return reconciler.BatchExists(batch_date)
+	}
+	return false, fmt.Errorf("%T can't be reconciled", r.db)
+}
+
+func (r readOnly) Report() ([]documents.TypeStats, error) {
+	if reporter, ok := r.db.(Reporter); ok {
+		return reporter.Report()
+	}
+	return nil, fmt.Errorf("%T can't be reported on", r.db)
+}
+
+func (r readOnly) LatestVersions(docType string) (map[string]int64, error) {
+	if lister, ok := r.db.(VersionLister); ok {
+		return lister.LatestVersions(docType)
+	}
+	return nil, fmt.Errorf("%T can't list its documents", r.db)
+}
+
+func (r readOnly) CollectStats() error {
+	return readonly.ErrReadOnly
+}
+
+func (r readOnly) Stats() ([]documents.TypeStats, error) {
+	if collector, ok := r.db.(StatsCollector); ok {
+		return collector.Stats()
+	}
+	return nil, fmt.Errorf("%T doesn't collect stats", r.db)
+}
+
+func (r readOnly) Definitions(root execute.RootSchema) (map[string]string, error) {
+	if exporter, ok := r.db.(Exporter); ok {
+		return exporter.Definitions(root)
+	}
+	return nil, fmt.Errorf("%T can't export its definitions", r.db)
+}
+
+func (r readOnly) Preflight() ([]string, error) {
+	if preflighter, ok := r.db.(Preflighter); ok {
+		return preflighter.Preflight()
+	}
+	return nil, nil
+}
+
+func (r readOnly) SetQueryTag(name string, value string) error {
+	if tagger, ok := r.db.(QueryTagger); ok {
+		return tagger.SetQueryTag(name, value)
+	}
+	return nil
+}
+
+func (r readOnly) Scratch(name string) (Scratch, error) {
+	return nil, readonly.ErrReadOnly
+}
+
+func (r readOnly) BootstrapSQL() []string {
+	if bootstrapper, ok := r.db.(Bootstrapper); ok {
+		return bootstrapper.BootstrapSQL()
+	}
+	return nil
+}
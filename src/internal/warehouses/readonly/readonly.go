@@ -0,0 +1,57 @@
+// Package readonly holds the --read-only switch.  When it's set, warehouses
+// skip creating their objects on connection, and warehouses.NewDatabase
+// refuses any operation which would change the warehouse, so commands like
+// `report` and `reconcile` are safe to run with an over-privileged DSN.
+package readonly
+
+import (
+	"errors"
+	"sync"
+)
+
+// Enabled forbids any DDL or DML against the warehouse.
+var Enabled bool
+
+// LoadOnly is set when the warehouse's objects belong to a separate deploy
+// DSN (see DATABASE_DEPLOY_DSN) and this process loads data with one which
+// can't create them.  Warehouses then skip creating their objects on
+// connection, as in read-only mode, but may still load data.
+var LoadOnly bool
+
+// NoBootstrap is --no-bootstrap: the warehouse's objects were created ahead of
+// time (see `gen bootstrap-sql`), so warehouses never try to create them.
+var NoBootstrap bool
+
+// SkipBootstrap reports whether warehouses should leave creating their
+// objects to someone else.
+func SkipBootstrap() bool {
+	return Enabled || LoadOnly || NoBootstrap
+}
+
+var (
+	mu           sync.Mutex
+	bootstrapped = map[string]bool{}
+)
+
+// Bootstrap runs create, which creates a warehouse's objects, unless they're
+// skipped or create has already succeeded in this process for key (i.e. the
+// DSN).  Objects only need creating once, so later uploads, prunes and views
+// are spared the round trips.
+func Bootstrap(key string, create func() error) error {
+	if SkipBootstrap() {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if bootstrapped[key] {
+		return nil
+	}
+	if err := create(); err != nil {
+		return err
+	}
+	bootstrapped[key] = true
+	return nil
+}
+
+// ErrReadOnly is returned by operations refused in read-only mode.
+var ErrReadOnly = errors.New("refusing to modify the warehouse in read-only mode")
@@ -0,0 +1,113 @@
+// Package registry holds the warehouse adapters.  Each adapter registers
+// itself from an init function under its DATABASE_TYPE names, so the factory
+// can't fall out of step with the adapters which exist: linking an adapter in
+// is all it takes to make it available.
+//
+// Adapters log through charmbracelet/log's default logger, like the rest of
+// execute-sync, so a program embedding them controls their logging (level,
+// format and destination) with log.SetDefault.
+package registry
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+// Database is implemented by every warehouse (see warehouses.Database).
+type Database interface {
+	Prune() error
+	Upload(batch_date string, stream *documents.Stream) (int, error)
+	CreateViews(root execute.RootSchema) error
+}
+
+// Scratch is a connection to a disposable schema of a warehouse's own (see
+// warehouses.SelfTester).
+type Scratch interface {
+	Database
+	// Rows returns the given columns of every row of a table or view, as
+	// text, ordered by the first column.
+	Rows(name string, columns ...string) ([][]string, error)
+	// Drop removes the schema and everything in it.
+	Drop() error
+}
+
+// Factory creates a warehouse from the configuration.
+type Factory func(cfg config.Config) (Database, error)
+
+// Adapter describes a warehouse and how to create it.
+type Adapter struct {
+	Names       []string // DATABASE_TYPE values, the first being its usual name
+	Description string
+	Requires    []string // settings it can't work without, i.e. DATABASE_DSN
+	New         Factory
+}
+
+var adapters = map[string]Adapter{}
+
+// Register makes an adapter available under each of its names.  Registering
+// a name twice is a programming error, so panics.
+func Register(adapter Adapter) {
+	for _, name := range adapter.Names {
+		if _, ok := adapters[name]; ok {
+			panic(fmt.Sprintf("warehouse %s registered twice", name))
+		}
+		adapters[name] = adapter
+	}
+}
+
+// Adapters returns every registered adapter, sorted by name.
+func Adapters() []Adapter {
+	var list []Adapter
+	for name, adapter := range adapters {
+		if name == adapter.Names[0] {
+			list = append(list, adapter)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Names[0] < list[j].Names[0] })
+	return list
+}
+
+// Names returns every registered DATABASE_TYPE value, sorted.
+func Names() []string {
+	var names []string
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the warehouse registered as cfg.DatabaseType, once the settings
+// it requires are present.
+func New(cfg config.Config) (Database, error) {
+	adapter, ok := adapters[cfg.DatabaseType]
+	if !ok {
+		return nil, failure.Errorf(failure.Config, "unsupported database type %q (expected one of %s)", cfg.DatabaseType, strings.Join(Names(), ", "))
+	}
+	var missing []string
+	for _, setting := range config.Settings(cfg) {
+		name := strings.TrimPrefix(setting.Env, "EXECUTESYNC_")
+		for _, required := range adapter.Requires {
+			if name == required && reflect.ValueOf(setting.Value).IsZero() {
+				missing = append(missing, required)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, failure.Errorf(failure.Config, "%s requires %s", cfg.DatabaseType, strings.Join(missing, ", "))
+	}
+	// Adapters don't connect until they're used, so failing to create one
+	// is down to its settings
+	db, err := adapter.New(cfg)
+	if err != nil {
+		return nil, failure.Wrap(failure.Config, err)
+	}
+	return db, nil
+}
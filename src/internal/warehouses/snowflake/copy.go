@@ -0,0 +1,37 @@
+package snowflake
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// copyStaged loads a staged file with COPY INTO (SNOWFLAKE_LOAD=copy) rather
+// than leaving it to Snowpipe, so a load error fails the upload straight away
+// and the batch is fetched again.  The file is removed once it's loaded
+// (PURGE): Snowpipe keeps load history of its own, and would otherwise load
+// it again when a later upload refreshes the pipe.
+func copyStaged(db *sql.DB, name string) error {
+	rows, err := db.Query(fmt.Sprintf(`COPY INTO %s
+FROM @%s_stage
+FILES = ('%s')
+FILE_FORMAT = (FORMAT_NAME = '%s_FORMAT')
+ON_ERROR = ABORT_STATEMENT
+PURGE = TRUE`, TableName, TableName, name, TableName))
+	if err != nil {
+		return err
+	}
+	results, err := scanResults(rows)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if status := strings.ToUpper(result["status"]); status != "LOADED" {
+			return fmt.Errorf("COPY reported %s for %s: %s", result["status"], result["file"], result["first_error"])
+		}
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("COPY didn't load %s", name)
+	}
+	return nil
+}
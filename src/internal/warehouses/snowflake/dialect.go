@@ -0,0 +1,62 @@
+package snowflake
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates Snowflake SQL, reading fields from the VARIANT column
+// with path notation and casts.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (dialect) Object(name string) string {
+	return name
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf(`"%s"`, name)
+}
+
+func (dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE SECURE VIEW %s AS %s", name, query)}
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE TABLE %s AS %s", name, query)
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(d, table, v)
+}
+
+func (d dialect) Extract(source string, path []string, fieldType string) string {
+	var cast string
+	switch fieldType {
+	case "INTEGER":
+		cast = "int"
+	case "BOOLEAN":
+		cast = "int"
+		if d.options.NativeBooleans {
+			cast = "boolean"
+		}
+	case "DECIMAL":
+		cast = "float"
+	case "DATETIME":
+		cast = "timestamp_tz"
+	default:
+		cast = "string"
+	}
+	return fmt.Sprintf("%s:%s::%s", source, strings.Join(path, ":"), cast)
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(", LATERAL FLATTEN(INPUT => %s:%s) list", source, strings.Join(path, ":")), "list.value"
+}
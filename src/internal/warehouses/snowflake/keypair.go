@@ -0,0 +1,215 @@
+package snowflake
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/pbkdf2"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// KeyPairDSN builds a DSN authenticating with a key pair (SNOWFLAKE_JWT) from
+// the SNOWFLAKE_ settings, so that the private key from `gen` doesn't have to
+// be base64 encoded into a hand-assembled DSN.
+func KeyPairDSN(cfg config.Config) (string, error) {
+	var missing []string
+	for _, setting := range []struct{ name, value string }{
+		{"SNOWFLAKE_ACCOUNT", cfg.SnowflakeAccount},
+		{"SNOWFLAKE_USER", cfg.SnowflakeUser},
+		{"SNOWFLAKE_PRIVATE_KEY_PATH", cfg.SnowflakePrivateKeyPath},
+		{"SNOWFLAKE_DATABASE", cfg.SnowflakeDatabase},
+	} {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Snowflake key-pair auth needs %s (or a DATABASE_DSN)", strings.Join(missing, ", "))
+	}
+
+	key, err := readPrivateKey(cfg.SnowflakePrivateKeyPath, cfg.SnowflakePrivateKeyPassphrase)
+	if err != nil {
+		return "", fmt.Errorf("SNOWFLAKE_PRIVATE_KEY_PATH: %v", err)
+	}
+	dsn, err := gosnowflake.DSN(&gosnowflake.Config{
+		Account:       cfg.SnowflakeAccount,
+		User:          cfg.SnowflakeUser,
+		Database:      cfg.SnowflakeDatabase,
+		Schema:        cfg.SnowflakeSchema,
+		Warehouse:     cfg.SnowflakeWarehouse,
+		Role:          cfg.SnowflakeRole,
+		Authenticator: gosnowflake.AuthTypeJwt,
+		PrivateKey:    key,
+	})
+	if err != nil {
+		return "", fmt.Errorf("building Snowflake DSN: %v", err)
+	}
+	return dsn, nil
+}
+
+// readPrivateKey reads an RSA private key from a PEM file: PKCS#8 (as `gen`
+// writes it), PKCS#1, or PKCS#8 encrypted with a passphrase (as OpenSSL's
+// `pkcs8 -topk8 -v2 aes256` or `-v2 des3`, from Snowflake's documentation,
+// writes it).
+func readPrivateKey(path string, passphrase string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.New("the key is encrypted, set SNOWFLAKE_PRIVATE_KEY_PASSPHRASE")
+		}
+		if der, err = decryptPKCS8(der, passphrase); err != nil {
+			return nil, err
+		}
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Snowflake needs an RSA key, not %T", key)
+	}
+	return rsaKey, nil
+}
+
+// Object identifiers of the PBES2 encryption OpenSSL uses for PKCS#8 keys.
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo protected by PBES2,
+// with PBKDF2 and AES or triple DES in CBC mode.
+func decryptPKCS8(der []byte, passphrase string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("parsing encrypted key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %v (re-encrypt it with openssl pkcs8 -topk8 -v2 aes256)", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("parsing key encryption: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("parsing key derivation: %v", err)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported key derivation hash %v", kdf.PRF.Algorithm)
+	}
+
+	var keyLength int
+	var newCipher func(key []byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLength, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLength, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLength, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLength, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported key cipher %v", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("parsing key cipher: %v", err)
+	}
+
+	key, err := pbkdf2.Key(prf, passphrase, kdf.Salt, kdf.Iterations, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("malformed encrypted key")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// A wrong passphrase shows up as bad padding
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errors.New("wrong SNOWFLAKE_PRIVATE_KEY_PASSPHRASE")
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("wrong SNOWFLAKE_PRIVATE_KEY_PASSPHRASE")
+		}
+	}
+	return plain[:len(plain)-padding], nil
+}
@@ -0,0 +1,73 @@
+package snowflake
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// scratch connects with its schema as the session's schema, so that the
+// (unqualified) documents table, stage and views are created there.
+type scratch struct {
+	*Snowflake
+	schema string
+	admin  *Snowflake // the connection the schema was created through
+}
+
+// Scratch creates an empty schema called name in the DSN's database.  Its
+// uploads are loaded with COPY INTO, as Snowpipe would load them some time
+// after they're checked.
+func (s *Snowflake) Scratch(name string) (registry.Scratch, error) {
+	cfg, err := gosnowflake.ParseDSN(s.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %v", err)
+	}
+	cfg.Schema = name
+	dsn, err := gosnowflake.DSN(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %v", err)
+	}
+
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE SCHEMA " + name); err != nil {
+		return nil, fmt.Errorf("Error creating schema %s: %v", name, err)
+	}
+
+	scratchDB, err := NewSnowflake(dsn, s.chunkSize, false, "copy", 0, s.Dialect.Options())
+	if err != nil {
+		return nil, err
+	}
+	for tag, value := range s.queryTags {
+		scratchDB.SetQueryTag(tag, value)
+	}
+	return scratch{Snowflake: scratchDB, schema: name, admin: s}, nil
+}
+
+func (s scratch) Rows(name string, columns ...string) ([][]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return sqlgen.Rows(s.Dialect, db, name, columns)
+}
+
+func (s scratch) Drop() error {
+	db, err := s.admin.open()
+	if err != nil {
+		return fmt.Errorf("Error connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", s.schema)); err != nil {
+		return fmt.Errorf("Error dropping schema %s: %v", s.schema, err)
+	}
+	return nil
+}
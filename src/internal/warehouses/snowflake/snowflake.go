@@ -1,86 +1,174 @@
 package snowflake
 
 import (
+	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
 	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/throttle"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
 	"github.com/charmbracelet/log"
-	_ "github.com/snowflakedb/gosnowflake"
+	"github.com/snowflakedb/gosnowflake"
 )
 
 const TableName string = "EXECUTE_DOCUMENTS"
 
+// When purging the stage after each upload, we wait up to loadTimeout for
+// Snowpipe to report the staged file as loaded, polling every loadPollInterval.
+// Files which aren't confirmed in time are left for `clean-stage`.
+const (
+	loadTimeout      = 10 * time.Minute
+	loadPollInterval = 10 * time.Second
+)
+
 type Snowflake struct {
-	dsn       string
-	chunkSize int
+	sqlgen.Warehouse
+	dsn            string
+	chunkSize      int
+	purgeStage     bool
+	loadMode       string // SNOWFLAKE_LOAD: pipe or copy
+	timeTravelDays int
+	queryTags      map[string]string
 }
 
-func NewSnowflake(dsn string, chunkSize int) (*Snowflake, error) {
-	return &Snowflake{
-		dsn:       dsn,
-		chunkSize: chunkSize,
-	}, nil
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"SNOWFLAKE"},
+		Description: "Snowflake, loaded through an internal stage (connecting with DATABASE_DSN, or the SNOWFLAKE_ key-pair settings)",
+		New: func(cfg config.Config) (registry.Database, error) {
+			dsn := cfg.DatabaseDSN
+			if dsn == "" {
+				var err error
+				if dsn, err = KeyPairDSN(cfg); err != nil {
+					return nil, err
+				}
+			}
+			return NewSnowflake(dsn, cfg.ChunkSize, cfg.PurgeStage, cfg.SnowflakeLoad, cfg.TimeTravelDays, sqlgen.NewOptions(cfg))
+		},
+	})
 }
 
-func bootstrap(db *sql.DB) error {
-
-	_, err := db.Exec(fmt.Sprintf(`
-	create file format if not exists %s_FORMAT TYPE = CSV SKIP_HEADER=1 TRIM_SPACE=true FIELD_OPTIONALLY_ENCLOSED_BY = '"'
-	`, TableName))
-	if err != nil {
-		return fmt.Errorf("Error creating format: %v", err)
+func NewSnowflake(dsn string, chunkSize int, purgeStage bool, loadMode string, timeTravelDays int, options sqlgen.Options) (*Snowflake, error) {
+	s := &Snowflake{
+		dsn:            dsn,
+		chunkSize:      chunkSize,
+		purgeStage:     purgeStage,
+		loadMode:       loadMode,
+		timeTravelDays: timeTravelDays,
+		queryTags:      map[string]string{"app": "execute-sync"},
 	}
+	s.Warehouse = sqlgen.Warehouse{Dialect: dialect{options}, Table: TableName, Size: `LENGTH(TO_JSON(DATA))`, Connect: s.connect}
+	return s, nil
+}
 
-	_, err = db.Exec(fmt.Sprintf(`
-	create stage if not exists %s_stage file_format = '%s_FORMAT'
-	`, TableName, TableName))
-	if err != nil {
-		return fmt.Errorf("Error creating stage: %v", err)
+// SetQueryTag sets one key of the JSON QUERY_TAG given to every statement,
+// so that Snowflake's query history attributes their cost to execute-sync.
+func (s *Snowflake) SetQueryTag(name string, value string) error {
+	s.queryTags[name] = value
+	return nil
+}
+
+// open connects to Snowflake with the QUERY_TAG set for the session.
+func (s *Snowflake) open() (*sql.DB, error) {
+	tag, _ := json.Marshal(s.queryTags)
+	separator := "?"
+	if strings.Contains(s.dsn, "?") {
+		separator = "&"
 	}
+	return audit.Open("snowflake", s.dsn+separator+"QUERY_TAG="+url.QueryEscape(string(tag)))
+}
 
-	_, err = db.Exec(fmt.Sprintf(`
-	create table if not exists %s (
-		BATCH_DATE TIMESTAMP_NTZ(9) NOT NULL,
-		TYPE VARCHAR(50) NOT NULL,
-		ID VARCHAR(50) NOT NULL,
-		VERSION NUMBER(38,0) NOT NULL,
-		CHUNK NUMBER(38,0) NOT NULL,
-		AUTHOR VARCHAR(50),
-		DATE TIMESTAMP_NTZ(9) NOT NULL,
-		DELETED BOOLEAN NOT NULL,
-		DATA VARIANT NOT NULL,
-		constraint %s_PK primary key (BATCH_DATE, TYPE, ID, VERSION, CHUNK)
-	);
-	`, TableName, TableName))
+// connect opens a session, creating the documents table and its stage first
+// if bootstrap is set.
+func (s *Snowflake) connect(bootstrap bool) (*sql.DB, error) {
+	db, err := s.open()
 	if err != nil {
-		return fmt.Errorf("Error creating table: %v", err)
+		return nil, fmt.Errorf("Error connecting to database: %w", err)
+	}
+	if !bootstrap {
+		return db, nil
 	}
+	if err = s.bootstrap(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error bootstrapping database: %w", err)
+	}
+	return db, nil
+}
 
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE PIPE if not exists %s_pipe
-	AS COPY INTO %s
-	FROM @%s_stage
-	FILE_FORMAT = '%s_FORMAT'
-	`, TableName, TableName, TableName, TableName))
-	if err != nil {
-		return fmt.Errorf("Error creating stage: %v", err)
+// bootstrapSQL returns the statements creating the documents table and the
+// stage, file format and pipe loading it.
+func bootstrapSQL() []string {
+	return []string{
+		fmt.Sprintf(`create file format if not exists %s_FORMAT TYPE = CSV SKIP_HEADER=1 TRIM_SPACE=true FIELD_OPTIONALLY_ENCLOSED_BY = '"'`, TableName),
+		// NULLs are written as documents.NullMarker, so empty fields load as ''.
+		// Formats created by older releases are brought up to date too.
+		fmt.Sprintf(`alter file format %s_FORMAT set NULL_IF = ('\\N') EMPTY_FIELD_AS_NULL = false`, TableName),
+		fmt.Sprintf(`create stage if not exists %s_stage file_format = '%s_FORMAT'`, TableName, TableName),
+		fmt.Sprintf(`create table if not exists %s (
+	BATCH_DATE TIMESTAMP_NTZ(9) NOT NULL,
+	TYPE VARCHAR(50) NOT NULL,
+	ID VARCHAR(50) NOT NULL,
+	VERSION NUMBER(38,0) NOT NULL,
+	CHUNK NUMBER(38,0) NOT NULL,
+	AUTHOR VARCHAR(50),
+	DATE TIMESTAMP_NTZ(9) NOT NULL,
+	DELETED BOOLEAN NOT NULL,
+	DATA VARIANT NOT NULL,
+	constraint %s_PK primary key (BATCH_DATE, TYPE, ID, VERSION, CHUNK)
+)`, TableName, TableName),
+		fmt.Sprintf(`CREATE PIPE if not exists %s_pipe
+AS COPY INTO %s
+FROM @%s_stage
+FILE_FORMAT = '%s_FORMAT'`, TableName, TableName, TableName, TableName),
 	}
-	return nil
 }
 
+// BootstrapSQL returns the statements creating the warehouse's objects, for
+// creating them ahead of time.
+func (s *Snowflake) BootstrapSQL() []string {
+	return append(bootstrapSQL(), sqlgen.CreateStatsTable(s.Dialect, TableName))
+}
+
+func (s *Snowflake) bootstrap(db *sql.DB) error {
+	return readonly.Bootstrap("snowflake:"+s.dsn, func() error {
+		for _, query := range bootstrapSQL() {
+			if _, err := db.Exec(query); err != nil {
+				return fmt.Errorf("Error creating objects: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Prune removes superseded rows from the documents table and then empties
+// the stage.  PruneTable and PruneStage do just one or the other.
 func (s *Snowflake) Prune() error {
-	db, err := sql.Open("snowflake", s.dsn)
-	if err != nil {
-		return fmt.Errorf("Error connecting to database: %v", err)
+	if err := s.PruneTable(); err != nil {
+		return err
 	}
-	if err = bootstrap(db); err != nil {
-		return fmt.Errorf("Error bootstrapping database: %v", err)
+	return s.PruneStage()
+}
+
+// PruneTable removes superseded rows from the documents table, leaving the
+// stage alone.
+func (s *Snowflake) PruneTable() error {
+	db, err := s.connect(true)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
@@ -92,53 +180,62 @@ func (s *Snowflake) Prune() error {
 		GROUP BY TYPE, ID, VERSION
 	)
 	`, TableName, TableName))
+	return err
+}
 
+// PruneStage removes every file from the stage, leaving the documents table
+// alone.
+func (s *Snowflake) PruneStage() error {
+	db, err := s.connect(true)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
 	_, err = db.Exec(fmt.Sprintf(`
 	REMOVE @%s_STAGE
 	`, TableName))
 	if err != nil {
-		log.Fatalf("Error pruning stage: %v", err)
+		return fmt.Errorf("Error pruning stage: %v", err)
 	}
+	return nil
+}
 
+// PruneBefore removes every row loaded in a batch before cutoff from the
+// documents table, leaving the stage alone (see CleanStage).
+func (s *Snowflake) PruneBefore(cutoff time.Time) error {
+	db, err := s.connect(true)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	return nil
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE BATCH_DATE < ?`, TableName), cutoff.UTC().Format("2006-01-02 15:04:05"))
+	return err
 }
 
-func (s *Snowflake) Upload(batch_date string, nextRecord func() (map[string]interface{}, error)) (int, error) {
-	db, err := sql.Open("snowflake", s.dsn)
+func (s *Snowflake) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	db, err := s.connect(true)
 	if err != nil {
-		return 0, fmt.Errorf("Error connecting to database: %v", err)
-	}
-	if err = bootstrap(db); err != nil {
-		return 0, fmt.Errorf("Error bootstrapping database: %v", err)
+		return 0, err
 	}
 	defer db.Close()
 
 	document_count := 0
 
-	tempDir := os.TempDir()
-
 	// Sanitize batch_date to remove ':' and '-'
 	safeBatchDate := strings.ReplaceAll(strings.ReplaceAll(batch_date, ":", ""), "-", "")
 
-	tempFile, err := os.CreateTemp(tempDir, fmt.Sprintf("documents_%s*.csv", safeBatchDate))
+	tempFile, err := spool.New(fmt.Sprintf("documents_%s*.csv.gz", safeBatchDate))
 	if err != nil {
-		return 0, fmt.Errorf("Error creating temporary file: %v", err)
+		return 0, fmt.Errorf("Error creating temporary file: %w", err)
 	}
-	defer func() {
-		tempFile.Close()
-		os.Remove(tempFile.Name()) // Cleanup the temp file after the upload
-	}()
+	defer tempFile.Close() // Cleanup the temp file after the upload
 
-	// Create a CSV writer
-	csvWriter := csv.NewWriter(tempFile)
+	// Create a CSV writer, gzipping as it goes so that large batches take a
+	// fraction of the space to spool and PUT has nothing left to compress
+	gz := gzip.NewWriter(tempFile)
+	csvWriter := csv.NewWriter(gz)
 
 	// Write the CSV headers
 	headers := []string{"BATCH_DATE", "TYPE", "ID", "VERSION", "CHUNK", "AUTHOR", "DATE", "DELETED", "DATA"}
@@ -148,63 +245,29 @@ func (s *Snowflake) Upload(batch_date string, nextRecord func() (map[string]inte
 
 	empty_batch := true
 
+	docs := stream.Prepare(s.chunkSize)
+	defer docs.Close()
 	for {
-		data, err := nextRecord()
-
-		// Terminate at EOF
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
 		}
-
-		// Skip empty records
-		if data == nil {
-			continue
-		}
-
-		// Apply chunking
-		var chunks []map[string]interface{}
-
-		// Iterate through the top-level keys
-		for key, value := range data {
-			// Is this a list key?
-			if list, ok := value.([]interface{}); ok {
-				// Does this list have #items > chunk size?
-				if len(list) > s.chunkSize {
-					for i := 0; i < len(list); i += s.chunkSize {
-						end := i + s.chunkSize
-						if end > len(list) {
-							end = len(list)
-						}
-
-						chunks = append(chunks, map[string]interface{}{
-							"DOCUMENT_ID": data["DOCUMENT_ID"].(string),
-							key:           list[i:end],
-						})
-					}
-
-					// Remove the large list from the original document
-					delete(data, key)
-				}
-			}
+		if err != nil {
+			return 0, err
 		}
+		data := doc.Data
 
-		// Add the modified original document back to the result
-		chunks = append([]map[string]interface{}{data}, chunks...)
-
-		for i := 0; i < len(chunks); i++ {
-			chunkBytes, _ := json.Marshal(chunks[i])
+		for i, chunkBytes := range doc.JSON {
 			// Convert to a CSV row
 			csvRecord := []string{
 				batch_date,
 				data["$TYPE"].(string),
 				data["DOCUMENT_ID"].(string),
-				fmt.Sprintf("%d", int(data["$VERSION"].(float64))),
-				fmt.Sprintf("%d", i),
-				data["$AUTHOR_ID"].(string),
+				documents.FormatInt(documents.Version(data)),
+				documents.FormatInt(int64(i)),
+				documents.CSVField(documents.Author(data)),
 				data["$DATE"].(string),
-				fmt.Sprintf("%t", data["$DELETED"].(bool)),
+				documents.FormatBool(data["$DELETED"].(bool)),
 				string(chunkBytes),
 			}
 
@@ -226,17 +289,59 @@ func (s *Snowflake) Upload(batch_date string, nextRecord func() (map[string]inte
 	if err := csvWriter.Error(); err != nil {
 		return 0, fmt.Errorf("Error finalizing CSV file: %v", err)
 	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("Error finalizing CSV file: %v", err)
+	}
 
 	// Don't push an empty batch to Snowflake.  That's silly
 	if !empty_batch {
 		// Upload the temporary CSV file to the Snowflake stage
 		log.Debug("Uploading CSV to Snowflake Stage")
 
-		putCommand := fmt.Sprintf("PUT '%s' @%s_stage", pathToFileURL(tempFile.Name()), TableName)
-		_, err = db.Exec(putCommand)
+		// gosnowflake uploads with several threads by default.  We can't cap
+		// its bandwidth directly, so when throttled keep it to one.
+		options := " SOURCE_COMPRESSION = GZIP"
+		if throttle.Limit > 0 {
+			options += " PARALLEL = 1"
+		}
+
+		if spool.VerifyUploads {
+			if err := tempFile.Verify(); err != nil {
+				return 0, err
+			}
+		}
+		var rows *sql.Rows
+		if tempFile.InMemory() {
+			// Small batches are streamed straight from memory.  The file in
+			// the PUT command only names the staged file.
+			var reader io.Reader
+			reader, err = tempFile.Reader()
+			if err == nil {
+				ctx := gosnowflake.WithFileStream(context.Background(), reader)
+				rows, err = db.QueryContext(ctx, fmt.Sprintf("PUT 'file://%s' @%s_stage%s", tempFile.Name(), TableName, options))
+			}
+		} else {
+			var path string
+			path, err = tempFile.Path()
+			if err == nil {
+				rows, err = db.Query(fmt.Sprintf("PUT '%s' @%s_stage%s", pathToFileURL(path), TableName, options))
+			}
+		}
 		if err != nil {
 			return 0, fmt.Errorf("Error uploading file to Snowflake stage: %v", err)
 		}
+		if err := verifyUpload(db, rows, tempFile); err != nil {
+			return 0, fmt.Errorf("Staged file failed verification: %v", err)
+		}
+
+		// The file is already compressed, so it's staged under its own name
+		if s.loadMode == "copy" {
+			log.Debug("Copying staged file into table", "file", tempFile.Name())
+			if err := copyStaged(db, tempFile.Name()); err != nil {
+				return 0, fmt.Errorf("Error loading data: %v", err)
+			}
+			return document_count, nil
+		}
 
 		// Merge from Stage into the TableName
 		log.Debug("Refreshing the Snowpipe")
@@ -246,55 +351,128 @@ func (s *Snowflake) Upload(batch_date string, nextRecord func() (map[string]inte
 		if err != nil {
 			return 0, fmt.Errorf("Error ingesting data: %v", err)
 		}
+
+		if s.purgeStage {
+			purgeLoadedFile(db, tempFile.Name())
+		}
 	}
 
 	return document_count, nil
 }
 
+// CreateViews creates the views, followed by the time travel function when
+// TIME_TRAVEL_DAYS is set.
 func (s *Snowflake) CreateViews(data execute.RootSchema) error {
-	db, err := sql.Open("snowflake", s.dsn)
-	if err != nil {
-		return fmt.Errorf("Error connecting to database: %v", err)
+	if err := s.Warehouse.CreateViews(data); err != nil || s.timeTravelDays <= 0 {
+		return err
 	}
-	if err = bootstrap(db); err != nil {
-		return fmt.Errorf("Error bootstrapping database: %v", err)
+	db, err := s.connect(false)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE OR REPLACE SECURE VIEW %s_LATEST_ALL_VERSIONS AS
-	SELECT *
-	FROM %s ed
-	WHERE (ed.TYPE, ed.ID, ed.VERSION, ed.BATCH_DATE) IN (
-		SELECT TYPE, ID, VERSION, MAX(BATCH_DATE)
-		FROM %s 
-		GROUP BY TYPE, ID, VERSION
-	)
-	`, TableName, TableName, TableName))
+	log.Infof("Creating %s_AS_OF", TableName)
+	for _, query := range timeTravelStatements(s.timeTravelDays) {
+		log.Debugf("Executing %s", query)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("error setting up time travel: %v", err)
+		}
+	}
+	return nil
+}
+
+// purgeLoadedFile waits for Snowpipe to load a staged file and then removes it
+// from the stage.  Failures are only logged, since the data itself has been
+// staged successfully and the file can still be removed by `clean-stage`.
+func purgeLoadedFile(db *sql.DB, name string) {
+	deadline := time.Now().Add(loadTimeout)
+	for {
+		var status string
+		err := db.QueryRow(fmt.Sprintf(`
+		SELECT STATUS
+		FROM TABLE(INFORMATION_SCHEMA.COPY_HISTORY(TABLE_NAME => '%s', START_TIME => DATEADD(hours, -1, CURRENT_TIMESTAMP())))
+		WHERE ENDSWITH(FILE_NAME, ?)
+		ORDER BY LAST_LOAD_TIME DESC
+		LIMIT 1
+		`, TableName), name).Scan(&status)
+
+		switch {
+		case err == sql.ErrNoRows:
+			// Not loaded yet
+		case err != nil:
+			log.Warn("Unable to check load status of staged file", "file", name, "error", err)
+			return
+		case strings.EqualFold(status, "Loaded"):
+			log.Debug("Removing loaded file from stage", "file", name)
+			if _, err := db.Exec(fmt.Sprintf("REMOVE @%s_stage/%s", TableName, name)); err != nil {
+				log.Warn("Unable to remove staged file", "file", name, "error", err)
+			}
+			return
+		default:
+			log.Warn("Staged file was not fully loaded, leaving it in the stage", "file", name, "status", status)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			log.Warn("Timed out waiting for staged file to load, leaving it in the stage", "file", name)
+			return
+		}
+		time.Sleep(loadPollInterval)
+	}
+}
+
+// CleanStage removes files staged more than olderThan ago, leaving the
+// documents table untouched.
+func (s *Snowflake) CleanStage(olderThan time.Duration) (int, error) {
+	db, err := s.connect(true)
 	if err != nil {
-		return fmt.Errorf("Error creating batch latest view: %v", err)
+		return 0, err
 	}
+	defer db.Close()
 
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE OR REPLACE SECURE VIEW %s_LATEST AS
-	SELECT *
-	FROM %s_LATEST_ALL_VERSIONS ed
-	WHERE (ed.TYPE, ed.ID, ed.VERSION) IN (
-		SELECT TYPE, ID, MAX(VERSION)
-		FROM %s 
-		GROUP BY TYPE, ID
-	)
-	`, TableName, TableName, TableName))
+	rows, err := db.Query(fmt.Sprintf("LIST @%s_stage", TableName))
 	if err != nil {
-		return fmt.Errorf("Error creating latest view: %v", err)
+		return 0, fmt.Errorf("Error listing stage: %v", err)
 	}
 
-	for key, value := range data {
-		log.Infof("Creating Helper Views for `%s`", key)
-		create_view(db, key, key, "", value, "data", "")
+	// LIST returns name, size, md5 and last_modified, with names prefixed by the stage
+	cutoff := time.Now().Add(-olderThan)
+	var expired []string
+	for rows.Next() {
+		var name, lastModified string
+		var size int64
+		var md5 sql.NullString
+		if err := rows.Scan(&name, &size, &md5, &lastModified); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Error reading stage listing: %v", err)
+		}
+		modified, err := time.Parse("Mon, 2 Jan 2006 15:04:05 MST", lastModified)
+		if err != nil {
+			log.Warn("Skipping staged file with unrecognised date", "file", name, "last_modified", lastModified)
+			continue
+		}
+		if modified.Before(cutoff) {
+			if i := strings.Index(name, "/"); i != -1 {
+				name = name[i+1:]
+			}
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Error listing stage: %v", err)
 	}
 
-	return nil
+	removed := 0
+	for _, name := range expired {
+		log.Debug("Removing staged file", "file", name)
+		if _, err := db.Exec(fmt.Sprintf("REMOVE @%s_stage/%s", TableName, name)); err != nil {
+			return removed, fmt.Errorf("Error removing %s from stage: %v", name, err)
+		}
+		removed += 1
+	}
+	return removed, nil
 }
 
 func pathToFileURL(path string) string {
@@ -311,69 +489,68 @@ func pathToFileURL(path string) string {
 	return u.String()
 }
 
-func create_view(db *sql.DB, docType string, tableName string, parentTable string, record execute.DocumentSchema, root string, flatten string) {
-
-	var columns []string
-
-	columns = append(columns, "id as DOCUMENT_ID")
-
-	if strings.HasPrefix(root, "value:") {
-		// special case to pull out the listitem_id for child custom records on list
-		columns = append(columns, "value:LISTITEM_ID::string as LISTITEM_ID")
+// Definitions returns the DDL of the documents table and its views, as
+// reported by GET_DDL.  Objects which don't exist are skipped.
+func (s *Snowflake) Definitions(root execute.RootSchema) (map[string]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	if parentTable == "" {
-		columns = append(columns, "deleted as \"_DELETED\"")
-		columns = append(columns, "author as \"_AUTHOR\"")
-		columns = append(columns, "version as \"_VERSION\"")
-		columns = append(columns, "date as \"_DATE\"")
+	// GET_DDL needs to know whether it's looking at a table or a view
+	kinds := map[string]string{}
+	rows, err := db.Query(`SELECT TABLE_NAME, TABLE_TYPE FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = CURRENT_SCHEMA()`)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing objects: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, fmt.Errorf("Error listing objects: %v", err)
+		}
+		kinds[name] = kind
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error listing objects: %v", err)
 	}
 
-	for field, metadata := range record {
-		if field == "DOCUMENT_ID" {
+	definitions := map[string]string{}
+	for _, name := range s.Dialect.Options().ObjectNames(TableName, root) {
+		kind, ok := kinds[name]
+		if !ok {
 			continue
 		}
-		switch metadata.Type {
-		case "TEXT", "GUID", "UWI":
-			columns = append(columns, fmt.Sprintf("%s:%s::string as %s", root, field, field))
-		case "INTEGER":
-			columns = append(columns, fmt.Sprintf("%s:%s::int as %s", root, field, field))
-		case "DECIMAL":
-			columns = append(columns, fmt.Sprintf("%s:%s::float as %s", root, field, field))
-		case "BOOLEAN":
-			columns = append(columns, fmt.Sprintf("%s:%s::int as %s", root, field, field))
-		case "DATETIME":
-			columns = append(columns, fmt.Sprintf("%s:%s::timestamp_tz as %s", root, field, field))
-		case "DOCUMENT":
-			columns = append(columns, fmt.Sprintf("%s:%s:DOCUMENT_ID::string as %s /* References %s.DOCUMENT_ID */", root, field, field, *metadata.DocumentType))
-		case "RECORD":
-			create_view(db, docType, fmt.Sprintf("%s_%s", tableName, field), tableName, metadata.RecordType, fmt.Sprintf("%s:%s", root, field), flatten)
-		case "RECORD LIST":
-			// Don't support LIST in LIST
-			if !strings.HasPrefix(root, "data") {
-				continue
-			}
-			create_view(db, docType, fmt.Sprintf("%s_%s", tableName, field), tableName, metadata.RecordType, "value", fmt.Sprintf(", LATERAL FLATTEN( INPUT => %s:%s)", root, field))
-		default:
-			log.Infof("Skipping %s:%s of unknown type %s", tableName, field, metadata.Type)
+		objectType := "TABLE"
+		if kind == "VIEW" {
+			objectType = "VIEW"
 		}
+		var ddl string
+		if err := db.QueryRow(fmt.Sprintf(`SELECT GET_DDL('%s', '%s')`, objectType, name)).Scan(&ddl); err != nil {
+			return nil, fmt.Errorf("Error reading definition of %s: %v", name, err)
+		}
+		definitions[name] = strings.TrimSpace(ddl)
 	}
+	return definitions, nil
+}
 
-	cmd := fmt.Sprintf("create or replace secure view %s as select %s from %s_LATEST%s where type='%s'",
-		tableName,
-		strings.Join(columns, ", "),
-		TableName,
-		flatten,
-		docType)
-
-	if flatten == "" {
-		cmd = cmd + " and chunk=0"
+// Preflight warns when connected with an administrative role, which has far
+// more privileges than execute-sync needs.
+func (s *Snowflake) Preflight() ([]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	_, err := db.Exec(cmd)
-	log.Debugf("Creating view `%s` as %s", tableName, cmd)
-	if err != nil {
-		log.Errorf("Error creating %s: %v", tableName, err)
-		log.Debug(cmd)
+	var role string
+	if err := db.QueryRow(`SELECT CURRENT_ROLE()`).Scan(&role); err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(role) {
+	case "ACCOUNTADMIN", "ORGADMIN", "SECURITYADMIN", "USERADMIN", "SYSADMIN":
+		return []string{fmt.Sprintf("connected with the administrative role %s; use a role limited to the execute-sync schema", role)}, nil
 	}
+	return nil, nil
 }
@@ -0,0 +1,32 @@
+package snowflake
+
+import (
+	"fmt"
+)
+
+// timeTravelStatements keep days of the documents table's history and create
+// EXECUTE_DOCUMENTS_AS_OF, a procedure returning the latest version of every
+// document as the table stood at a point in time, even once prune has since
+// removed it.  Time travel can't be parameterised in a view, so the procedure
+// builds its query.
+func timeTravelStatements(days int) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s SET DATA_RETENTION_TIME_IN_DAYS = %d", TableName, days),
+		fmt.Sprintf(`CREATE OR REPLACE PROCEDURE %s_AS_OF(AS_OF TIMESTAMP_TZ)
+RETURNS TABLE()
+LANGUAGE SQL
+EXECUTE AS CALLER
+AS
+$$
+DECLARE
+	query VARCHAR;
+	res RESULTSET;
+BEGIN
+	query := 'SELECT * FROM %s AT(TIMESTAMP => ''' || TO_VARCHAR(AS_OF, 'YYYY-MM-DD HH24:MI:SS.FF9 TZHTZM') || '''::TIMESTAMP_TZ)
+		QUALIFY DENSE_RANK() OVER (PARTITION BY TYPE, ID ORDER BY VERSION DESC, BATCH_DATE DESC) = 1';
+	res := (EXECUTE IMMEDIATE :query);
+	RETURN TABLE(res);
+END;
+$$`, TableName, TableName),
+	}
+}
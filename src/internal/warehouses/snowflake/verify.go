@@ -0,0 +1,114 @@
+package snowflake
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/charmbracelet/log"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// checkPut checks the rows returned by a PUT: the file must have been
+// uploaded (or already staged with the same digest) and its source size must
+// match the spool file's.
+func checkPut(rows *sql.Rows, size int64) error {
+	results, err := scanResults(rows)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if status := strings.ToUpper(result["status"]); status != "UPLOADED" && status != "SKIPPED" {
+			return fmt.Errorf("PUT reported %s: %s", result["status"], result["message"])
+		}
+		if sourceSize, err := strconv.ParseInt(result["source_size"], 10, 64); err == nil && sourceSize != size {
+			return fmt.Errorf("PUT sent %d bytes, expected %d", sourceSize, size)
+		}
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("PUT didn't report the file it staged")
+	}
+	return nil
+}
+
+// scanResults reads the rows returned by a PUT or COPY, one per file, keyed
+// by their lower case column names.
+func scanResults(rows *sql.Rows) ([]map[string]string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result := map[string]string{}
+		for i, column := range columns {
+			result[strings.ToLower(column)] = values[i].String
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// verifyStaged downloads a staged file and compares its SHA-256 against the
+// spool file's.  The spool file is gzipped already, so PUT stages it byte for
+// byte.  It's streamed straight through rather than written to disk.
+func verifyStaged(db *sql.DB, name string, checksum string) error {
+	pr, pw := io.Pipe()
+	type result struct {
+		sum string
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		sum, err := spool.Checksum(pr)
+		pr.CloseWithError(err)
+		results <- result{sum, err}
+	}()
+
+	ctx := gosnowflake.WithFileGetStream(context.Background(), pw)
+	ctx = gosnowflake.WithFileTransferOptions(ctx, &gosnowflake.SnowflakeFileTransferOptions{GetFileToStream: true, RaisePutGetError: true})
+	_, err := db.ExecContext(ctx, fmt.Sprintf("GET @%s_stage/%s '%s'", TableName, name, pathToFileURL(os.TempDir())))
+	pw.CloseWithError(err)
+	got := <-results
+	if err == nil {
+		err = got.err
+	}
+	if err != nil {
+		return fmt.Errorf("reading back staged file: %w", err)
+	}
+	if got.sum != checksum {
+		return fmt.Errorf("staged file %s is corrupt: SHA-256 %s, expected %s", name, got.sum, checksum)
+	}
+	log.Debug("Verified staged file", "file", name, "sha256", checksum)
+	return nil
+}
+
+// verifyUpload checks a file just PUT into the stage, and removes it if it's
+// wrong so that refreshing the pipe won't load it.
+func verifyUpload(db *sql.DB, rows *sql.Rows, tempFile *spool.File) error {
+	name := tempFile.Name()
+	err := checkPut(rows, tempFile.Size())
+	if err == nil && spool.VerifyUploads {
+		err = verifyStaged(db, name, tempFile.Checksum())
+	}
+	if err != nil {
+		if _, err := db.Exec(fmt.Sprintf("REMOVE @%s_stage/%s", TableName, name)); err != nil {
+			log.Warn("Unable to remove staged file", "file", name, "error", err)
+		}
+	}
+	return err
+}
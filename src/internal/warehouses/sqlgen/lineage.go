@@ -0,0 +1,74 @@
+package sqlgen
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+)
+
+// ColumnLineage maps a helper view column back to where it comes from: either
+// a field of the Execute document, or a column of the documents table.
+type ColumnLineage struct {
+	Name         string `json:"name"`
+	Field        string `json:"field,omitempty"`         // Execute field name
+	Type         string `json:"type,omitempty"`          // Execute field type
+	JSONPath     string `json:"json_path,omitempty"`     // location in the document JSON
+	SourceColumn string `json:"source_column,omitempty"` // documents table column, for metadata columns
+	References   string `json:"references,omitempty"`    // referenced document type, for DOCUMENT fields
+}
+
+// ViewLineage describes where every column of a helper view comes from.
+type ViewLineage struct {
+	Name         string          `json:"name"`
+	DocumentType string          `json:"document_type"`
+	Source       string          `json:"source"`              // the view rows are read from
+	ListPath     string          `json:"list_path,omitempty"` // record list flattened into rows
+	Columns      []ColumnLineage `json:"columns"`
+}
+
+// Lineage returns the column lineage of every helper view described by the
+// schema, sorted by view name.  Names are given as execute-sync generates
+// them; warehouses which fold identifiers to lower case store them that way.
+func (o Options) Lineage(table string, root execute.RootSchema) []ViewLineage {
+	var lineage []ViewLineage
+	for _, v := range o.Views(root) {
+		view := ViewLineage{
+			Name:         v.Name,
+			DocumentType: v.DocType,
+			Source:       table + "_LATEST",
+			Columns: []ColumnLineage{
+				{Name: o.ColumnName("DOCUMENT_ID"), Field: "DOCUMENT_ID", Type: "GUID", JSONPath: "$.DOCUMENT_ID", SourceColumn: "ID"},
+			},
+		}
+
+		prefix := "$"
+		if v.List != nil {
+			view.ListPath = JSONPath(v.List)
+			prefix = view.ListPath + "[*]"
+			view.Columns = append(view.Columns, ColumnLineage{Name: o.ColumnName("LISTITEM_ID"), Field: "LISTITEM_ID", Type: "GUID", JSONPath: prefix + ".LISTITEM_ID"})
+		}
+		if v.TopLevel {
+			view.Columns = append(view.Columns,
+				ColumnLineage{Name: o.ColumnName("_DELETED"), JSONPath: "$.$DELETED", SourceColumn: "DELETED"},
+				ColumnLineage{Name: o.ColumnName("_AUTHOR"), JSONPath: "$.$AUTHOR_ID", SourceColumn: "AUTHOR"},
+				ColumnLineage{Name: o.ColumnName("_VERSION"), JSONPath: "$.$VERSION", SourceColumn: "VERSION"},
+				ColumnLineage{Name: o.ColumnName("_DATE"), JSONPath: "$.$DATE", SourceColumn: "DATE"},
+			)
+		}
+
+		for _, f := range v.Fields {
+			view.Columns = append(view.Columns, ColumnLineage{
+				Name:       f.Column,
+				Field:      f.Name,
+				Type:       f.Type,
+				JSONPath:   strings.Join(append([]string{prefix}, f.Path...), "."),
+				References: f.DocumentType,
+			})
+		}
+		lineage = append(lineage, view)
+	}
+
+	sort.Slice(lineage, func(i, j int) bool { return lineage[i].Name < lineage[j].Name })
+	return lineage
+}
@@ -0,0 +1,105 @@
+package sqlgen
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+)
+
+// maxColumnName is the longest column name every warehouse accepts.
+const maxColumnName = 128
+
+// ColumnName styles a name execute-sync itself gives to a view or column,
+// i.e. DOCUMENT_ID or _DELETED, in the Naming style: "upper" keeps Execute's
+// field names, "lower" lower cases them and "snake" derives lower_snake_case
+// names from the fields' display names.  "display" keeps the views' names
+// but uses the display names, as they are, for columns.  The documents table
+// and its _LATEST views are named the same way whatever the style.
+func (o Options) ColumnName(name string) string {
+	switch o.Naming {
+	case "lower", "snake":
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// fieldName returns the name of the column or view generated for a field.
+// Fields without a usable display name fall back to their styled field name.
+func (o Options) fieldName(field string, metadata execute.FieldMetadata) string {
+	if o.Naming == "snake" {
+		if name := snakeCase(metadata.Name); name != "" {
+			return name
+		}
+	}
+	return o.ColumnName(field)
+}
+
+// uniqueName returns name unless it's already in used, when it falls back to
+// the styled field name, and marks the name as used.  Some warehouses ignore
+// the case of quoted names, so neither does uniqueName.
+func (o Options) uniqueName(used map[string]bool, name string, field string) string {
+	if name == "" || used[strings.ToUpper(name)] {
+		name = o.ColumnName(field)
+	}
+	used[strings.ToUpper(name)] = true
+	return name
+}
+
+// viewName returns the unique name of the view for a record field.
+func (o Options) viewName(used map[string]bool, field string, metadata execute.FieldMetadata) string {
+	return o.uniqueName(used, o.fieldName(field, metadata), field)
+}
+
+// columnName returns the unique name of the column for a field.  Columns
+// named in snake_case which start with a digit are prefixed with an
+// underscore, as most warehouses require of unquoted names.
+func (o Options) columnName(used map[string]bool, field string, metadata execute.FieldMetadata) string {
+	name := o.fieldName(field, metadata)
+	if o.Naming == "display" {
+		name = displayName(metadata.Name)
+	} else if name != "" && unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return o.uniqueName(used, name, field)
+}
+
+// displayName makes a display name such as "Approved Amount" safe to use as a
+// quoted column name: quotes, brackets and control characters are dropped,
+// runs of spaces collapsed, and the result cut to maxColumnName characters.
+func displayName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune("\"'`[]", r):
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > maxColumnName {
+		name = strings.TrimSpace(string(runes[:maxColumnName]))
+	}
+	return name
+}
+
+// snakeCase converts a display name such as "Total Cost ($)" to total_cost.
+func snakeCase(name string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			separate = b.Len() > 0
+			continue
+		}
+		if separate {
+			b.WriteByte('_')
+			separate = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
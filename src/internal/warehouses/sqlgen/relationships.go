@@ -0,0 +1,88 @@
+package sqlgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/charmbracelet/log"
+)
+
+// RelationshipsView lists every Relationship between the helper views, so BI
+// tools (and people) can work out how to join them.
+const RelationshipsView = "EXECUTE_RELATIONSHIPS"
+
+// Relationship is a helper view column holding the DOCUMENT_ID of a document
+// shown in another helper view: either a DOCUMENT field, or the DOCUMENT_ID of
+// a record or list view pointing back at its document.
+type Relationship struct {
+	View             string
+	Column           string
+	DocumentType     string
+	ReferencedView   string
+	ReferencedColumn string
+}
+
+// Relationships returns the relationships between the schema's helper views.
+// References to document types without views of their own are left out.
+func (o Options) Relationships(root execute.RootSchema) []Relationship {
+	var relationships []Relationship
+	for _, v := range o.Views(root) {
+		if !v.TopLevel {
+			relationships = append(relationships, Relationship{
+				View:             v.Name,
+				Column:           o.ColumnName("DOCUMENT_ID"),
+				DocumentType:     v.DocType,
+				ReferencedView:   o.ColumnName(v.DocType),
+				ReferencedColumn: o.ColumnName("DOCUMENT_ID"),
+			})
+		}
+		for _, f := range v.Fields {
+			if _, ok := root[f.DocumentType]; !ok || f.DocumentType == "" {
+				continue
+			}
+			relationships = append(relationships, Relationship{
+				View:             v.Name,
+				Column:           f.Column,
+				DocumentType:     f.DocumentType,
+				ReferencedView:   o.ColumnName(f.DocumentType),
+				ReferencedColumn: o.ColumnName("DOCUMENT_ID"),
+			})
+		}
+	}
+	return relationships
+}
+
+// RelationshipsQuery returns the SELECT listing the schema's relationships.
+func RelationshipsQuery(d Dialect, root execute.RootSchema) string {
+	names := []string{"VIEW_NAME", "COLUMN_NAME", "DOCUMENT_TYPE", "REFERENCED_VIEW", "REFERENCED_COLUMN"}
+	row := func(values ...string) string {
+		columns := make([]string, len(values))
+		for i, value := range values {
+			columns[i] = fmt.Sprintf("'%s' AS %s", strings.ReplaceAll(value, "'", "''"), d.Column(d.Options().ColumnName(names[i])))
+		}
+		return "SELECT " + strings.Join(columns, ", ")
+	}
+
+	relationships := d.Options().Relationships(root)
+	if len(relationships) == 0 {
+		return row("", "", "", "", "") + " WHERE 1 = 0"
+	}
+	rows := make([]string, len(relationships))
+	for i, r := range relationships {
+		rows[i] = row(r.View, r.Column, r.DocumentType, r.ReferencedView, r.ReferencedColumn)
+	}
+	return strings.Join(rows, "\nUNION ALL ")
+}
+
+// CreateRelationships creates the RelationshipsView for the whole schema.
+func CreateRelationships(d Dialect, root execute.RootSchema, exec func(query string) error) error {
+	log.Infof("Creating %s", RelationshipsView)
+	for _, cmd := range d.CreateView(RelationshipsView, RelationshipsQuery(d, root)) {
+		if err := exec(cmd); err != nil {
+			log.Debug(cmd)
+			return fmt.Errorf("error creating %s: %v", RelationshipsView, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,717 @@
+// Package sqlgen generates the SQL shared by the relational warehouse
+// adapters: the _LATEST_ALL_VERSIONS/_LATEST views over the documents table
+// and the per-type helper views.  Warehouses describe their SQL flavour with a
+// Dialect and embed a Warehouse, leaving the adapter itself responsible only
+// for bootstrapping and loading data.
+package sqlgen
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/charmbracelet/log"
+)
+
+// Dialect captures the warehouse-specific parts of view generation.
+type Dialect interface {
+	// Object returns the quoted (and, where configured, qualified) name of a
+	// table or view.
+	Object(name string) string
+	// Column returns the quoted name of a view column.
+	Column(name string) string
+	// CreateView returns the statement(s) which create, or replace, a view.
+	CreateView(name string, query string) []string
+	// ViewQuery returns the SELECT for a helper view over the given documents
+	// table.  Most dialects simply return Select(d, table, v).
+	ViewQuery(table string, v View) string
+	// Options returns the settings the views are generated with.
+	Options() Options
+}
+
+// JSONDialect is a Dialect which can read individual fields out of the
+// document JSON and expand record lists into rows, which is all Select
+// needs to build a helper view.
+type JSONDialect interface {
+	Dialect
+	// Extract returns an expression reading the field at path from the JSON
+	// expression source, cast to the SQL type matching the Execute field type.
+	Extract(source string, path []string, fieldType string) string
+	// Flatten returns the FROM clause suffix which expands the record list at
+	// path into one row per item, along with the expression for an item.
+	Flatten(source string, path []string) (clause string, item string)
+}
+
+// Field is a scalar column of a helper view.
+type Field struct {
+	Name         string   // Execute field name
+	Column       string   // view column name, in the Options' Naming style
+	Path         []string // relative to the document, or the list item for list views
+	Type         string   // Execute field type
+	Size         *int     // Execute field size, i.e. the decimal places of a DECIMAL
+	DocumentType string   // referenced document type, for DOCUMENT fields
+}
+
+// View describes a helper view over the latest version of one document type:
+// either the document itself, a nested record, or the items of a record list.
+type View struct {
+	Name     string
+	DocType  string
+	TopLevel bool     // document views carry the _DELETED/_AUTHOR/_VERSION/_DATE columns
+	List     []string // path of the flattened record list, nil when not a list view
+	Fields   []Field
+}
+
+// Options are the settings, from the configuration, which shape the helper
+// views.  Each Dialect carries those of its warehouse.
+type Options struct {
+	// InactiveViews moves inactive fields out of the helper views and into
+	// matching <VIEW>_INACTIVE views, keeping the data captured in them
+	// queryable without cluttering the views analysts use day to day.
+	InactiveViews bool
+	// ChangedViews adds a <TYPE>_CHANGED view for every document type,
+	// listing the documents loaded by the type's most recent batch (see
+	// Changed).
+	ChangedViews bool
+	// NativeBooleans casts BOOLEAN fields to the warehouse's boolean type in
+	// Snowflake's views, which otherwise cast them to integers as they always
+	// have.  The other warehouses already use their boolean type, apart from
+	// SQL Server and Teradata which don't have one and so keep BIT and
+	// BYTEINT.
+	NativeBooleans bool
+	// ExactDecimals casts DECIMAL fields to DECIMAL(38, SIZE) in the helper
+	// views, rather than to floating point which can't hold every monetary
+	// value exactly.
+	ExactDecimals bool
+	// Naming is the style of the views' names and columns (see ColumnName),
+	// "upper" when empty.
+	Naming string
+}
+
+// NewOptions returns the Options set by the configuration.
+func NewOptions(cfg config.Config) Options {
+	return Options{
+		InactiveViews:  cfg.InactiveViews,
+		ChangedViews:   cfg.ChangedViews,
+		NativeBooleans: cfg.NativeBooleans,
+		ExactDecimals:  cfg.ExactDecimals,
+		Naming:         cfg.NamingStyle,
+	}
+}
+
+// defaultScale is the number of decimal places kept by ExactDecimals for
+// DECIMAL fields whose schema doesn't give a SIZE.
+const defaultScale = 10
+
+// Decimal returns the exact type of a DECIMAL field, and whether
+// ExactDecimals applies to the field at all.
+func (o Options) Decimal(f Field) (string, bool) {
+	if !o.ExactDecimals || f.Type != "DECIMAL" {
+		return "", false
+	}
+	scale := defaultScale
+	if f.Size != nil {
+		scale = min(max(*f.Size, 0), 37)
+	}
+	return fmt.Sprintf("DECIMAL(38, %d)", scale), true
+}
+
+// Views walks the schema and returns every helper view it describes.  Views
+// and their columns are in alphabetical order, so the generated SQL is the
+// same from one run to the next.
+func (o Options) Views(root execute.RootSchema) []View {
+	var views []View
+	for _, docType := range sortedKeys(root) {
+		views = o.collect(views, docType, o.ColumnName(docType), true, root[docType], nil, nil, false)
+	}
+	return views
+}
+
+// sortedKeys returns the keys of a map in alphabetical order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// collect adds the views for a record, and those nested within it.  Every
+// field of an inactive record is itself treated as inactive.
+func (o Options) collect(views []View, docType string, name string, topLevel bool, record execute.DocumentSchema, list []string, path []string, inactive bool) []View {
+	view := View{Name: name, DocType: docType, TopLevel: topLevel, List: list}
+	hidden := View{Name: name + o.ColumnName("_INACTIVE"), DocType: docType, TopLevel: topLevel, List: list}
+	if inactive {
+		view.Name = hidden.Name
+	}
+	var children []View
+
+	// Display names needn't be unique, so track the names taken by columns
+	// (including the metadata columns) and nested views
+	columns, nested := map[string]bool{}, map[string]bool{}
+	for _, column := range []string{"DOCUMENT_ID", "LISTITEM_ID", "_DELETED", "_AUTHOR", "_VERSION", "_DATE"} {
+		columns[strings.ToUpper(column)] = true
+	}
+
+	for _, field := range sortedKeys(record) {
+		metadata := record[field]
+		if field == "DOCUMENT_ID" || (list != nil && field == "LISTITEM_ID") {
+			continue
+		}
+		target, childInactive := &view, inactive
+		if o.InactiveViews && !inactive && !metadata.Active {
+			target, childInactive = &hidden, true
+		}
+		fieldPath := append(append([]string{}, path...), field)
+		switch metadata.Type {
+		case "TEXT", "GUID", "UWI", "INTEGER", "DECIMAL", "BOOLEAN", "DATETIME":
+			target.Fields = append(target.Fields, Field{Name: field, Column: o.columnName(columns, field, metadata), Path: fieldPath, Type: metadata.Type, Size: metadata.Size})
+		case "DOCUMENT":
+			f := Field{Name: field, Column: o.columnName(columns, field, metadata), Path: append(fieldPath, "DOCUMENT_ID"), Type: metadata.Type}
+			if metadata.DocumentType != nil {
+				f.DocumentType = *metadata.DocumentType
+			}
+			target.Fields = append(target.Fields, f)
+		case "RECORD":
+			children = o.collect(children, docType, fmt.Sprintf("%s_%s", name, o.viewName(nested, field, metadata)), false, metadata.RecordType, list, fieldPath, childInactive)
+		case "RECORD LIST":
+			// Don't support LIST in LIST
+			if list != nil {
+				continue
+			}
+			children = o.collect(children, docType, fmt.Sprintf("%s_%s", name, o.viewName(nested, field, metadata)), false, metadata.RecordType, fieldPath, nil, childInactive)
+		default:
+			log.Infof("Skipping %s:%s of unknown type %s", name, field, metadata.Type)
+		}
+	}
+
+	views = append(views, view)
+	if len(hidden.Fields) > 0 {
+		views = append(views, hidden)
+	}
+	return append(views, children...)
+}
+
+// JSONPath renders a path as a JSONPath expression, i.e. $.RECORD.FIELD.
+func JSONPath(path []string) string {
+	return strings.Join(append([]string{"$"}, path...), ".")
+}
+
+// Metadata returns the columns identifying each row of a helper view: the
+// DOCUMENT_ID, plus the document metadata for top-level views.
+func Metadata(d Dialect, alias string, v View) []string {
+	o := d.Options()
+	columns := []string{fmt.Sprintf("%s.id AS %s", alias, d.Column(o.ColumnName("DOCUMENT_ID")))}
+	if v.TopLevel {
+		columns = append(columns, fmt.Sprintf("%s.deleted AS %s", alias, d.Column(o.ColumnName("_DELETED"))))
+		columns = append(columns, fmt.Sprintf("%s.author AS %s", alias, d.Column(o.ColumnName("_AUTHOR"))))
+		columns = append(columns, fmt.Sprintf("%s.version AS %s", alias, d.Column(o.ColumnName("_VERSION"))))
+		columns = append(columns, fmt.Sprintf("%s.date AS %s", alias, d.Column(o.ColumnName("_DATE"))))
+	}
+	return columns
+}
+
+// Comment returns the trailing comment documenting a field, if any.
+func Comment(f Field) string {
+	if f.Type == "DOCUMENT" && f.DocumentType != "" {
+		return fmt.Sprintf(" /* References %s.DOCUMENT_ID */", f.DocumentType)
+	}
+	return ""
+}
+
+// Select builds a helper view query from per-column JSON extraction.  Rows
+// come from the _LATEST view, restricted to chunk 0 unless a record list
+// (whose items may be spread over several chunks) is being flattened.
+func Select(d JSONDialect, table string, v View) string {
+	columns := Metadata(d, "ed", v)
+	from := d.Object(table+"_LATEST") + " ed"
+	source := "ed.data"
+
+	if v.List != nil {
+		clause, item := d.Flatten(source, v.List)
+		from += clause
+		source = item
+		columns = append(columns[:1], append([]string{fmt.Sprintf("%s AS %s", d.Extract(source, []string{"LISTITEM_ID"}, "TEXT"), d.Column(d.Options().ColumnName("LISTITEM_ID")))}, columns[1:]...)...)
+	}
+
+	for _, f := range v.Fields {
+		fieldType := f.Type
+		if fieldType == "DOCUMENT" {
+			fieldType = "TEXT"
+		}
+		value := d.Extract(source, f.Path, fieldType)
+		if decimal, ok := d.Options().Decimal(f); ok {
+			// Read the number as text so it never passes through a float
+			value = fmt.Sprintf("CAST(%s AS %s)", d.Extract(source, f.Path, "TEXT"), decimal)
+		}
+		columns = append(columns, fmt.Sprintf("%s AS %s%s", value, d.Column(f.Column), Comment(f)))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE ed.type = '%s'", strings.Join(columns, ", "), from, v.DocType)
+	if v.List == nil {
+		query += " AND ed.chunk = 0"
+	}
+	return query
+}
+
+// LatestViewer is implemented by dialects which build the latest views their
+// own way, rather than with the joins of LatestViews.
+type LatestViewer interface {
+	// LatestViews returns the statements creating, or replacing, the
+	// _LATEST_ALL_VERSIONS and _LATEST views over the documents table.
+	LatestViews(table string) []string
+}
+
+// LatestViews returns the statements creating the _LATEST_ALL_VERSIONS view
+// (the most recent batch of every version) and the _LATEST view (the most
+// recent version of every document) over the documents table.
+func LatestViews(d Dialect, table string) []string {
+	if viewer, ok := d.(LatestViewer); ok {
+		return viewer.LatestViews(table)
+	}
+	base := d.Object(table)
+	allVersions := d.Object(table + "_LATEST_ALL_VERSIONS")
+
+	statements := d.CreateView(table+"_LATEST_ALL_VERSIONS", fmt.Sprintf(`
+	SELECT ed.*
+	FROM %s ed
+	INNER JOIN (
+		SELECT type, id, version, MAX(batch_date) AS batch_date
+		FROM %s
+		GROUP BY type, id, version
+	) latest
+	ON ed.type = latest.type
+	   AND ed.id = latest.id
+	   AND ed.version = latest.version
+	   AND ed.batch_date = latest.batch_date
+	`, base, base))
+
+	return append(statements, d.CreateView(table+"_LATEST", fmt.Sprintf(`
+	SELECT ed.*
+	FROM %s ed
+	INNER JOIN (
+		SELECT type, id, MAX(version) AS version
+		FROM %s
+		GROUP BY type, id
+	) latest
+	ON ed.type = latest.type
+	   AND ed.id = latest.id
+	   AND ed.version = latest.version
+	`, allVersions, base))...)
+}
+
+// CreateHelperViews creates every helper view described by the schema using
+// exec.  Failures are logged and skipped so that one bad view doesn't prevent
+// the others from being created.
+func CreateHelperViews(d Dialect, table string, root execute.RootSchema, exec func(query string) error) {
+	o := d.Options()
+	for _, view := range o.Views(root) {
+		if view.TopLevel {
+			log.Infof("Creating Helper Views for `%s`", view.DocType)
+		}
+		if !createView(d, view.Name, d.ViewQuery(table, view), exec) {
+			continue
+		}
+		if o.ChangedViews && view.TopLevel {
+			createView(d, o.ChangedName(view), Changed(d, table, view), exec)
+		}
+	}
+}
+
+// createView runs the statements creating one view, logging rather than
+// returning any failure, and reports whether it was created.
+func createView(d Dialect, name string, query string, exec func(query string) error) bool {
+	for _, cmd := range d.CreateView(name, query) {
+		if err := exec(cmd); err != nil {
+			log.Errorf("Error creating %s: %v", name, err)
+			log.Debug(cmd)
+			return false
+		}
+	}
+	return true
+}
+
+// ChangedName returns the name of a document view's _CHANGED view.
+func (o Options) ChangedName(v View) string {
+	return v.Name + o.ColumnName("_CHANGED")
+}
+
+// Changed builds the query of a document view's _CHANGED view: the rows of
+// the view whose documents were loaded by the most recent batch holding the
+// type, along with that BATCH_DATE and the document's previous one.  Since
+// each sync only loads what changed, that's what the last sync changed;
+// documents without a previous batch are NEW, the rest UPDATED.  Rows come
+// from the helper view itself, so the columns are the same however the
+// dialect builds it.
+func Changed(d Dialect, table string, v View) string {
+	o := d.Options()
+	base := d.Object(table)
+	batchDate, docType, id, chunk := d.Column("BATCH_DATE"), d.Column("TYPE"), d.Column("ID"), d.Column("CHUNK")
+
+	return fmt.Sprintf(`
+	SELECT v.*,
+		CASE WHEN c.previous_batch_date IS NULL THEN 'NEW' ELSE 'UPDATED' END AS %s,
+		c.batch_date AS %s,
+		c.previous_batch_date AS %s
+	FROM %s v
+	INNER JOIN (
+		SELECT ed.%s AS document_id,
+			MAX(ed.%s) AS batch_date,
+			MAX(CASE WHEN ed.%s < latest.batch_date THEN ed.%s END) AS previous_batch_date
+		FROM %s ed
+		CROSS JOIN (SELECT MAX(%s) AS batch_date FROM %s WHERE %s = '%s') latest
+		WHERE ed.%s = '%s' AND ed.%s = 0
+		GROUP BY ed.%s, latest.batch_date
+		HAVING MAX(ed.%s) = latest.batch_date
+	) c
+	ON v.%s = c.document_id
+	`, d.Column(o.ColumnName("_CHANGE")), d.Column(o.ColumnName("_BATCH_DATE")), d.Column(o.ColumnName("_PREVIOUS_BATCH_DATE")),
+		d.Object(v.Name),
+		id, batchDate, batchDate, batchDate,
+		base, batchDate, base, docType, v.DocType,
+		docType, v.DocType, chunk,
+		id, batchDate,
+		d.Column(o.ColumnName("DOCUMENT_ID")))
+}
+
+// CreateViews creates the latest views followed by every helper view.
+func CreateViews(d Dialect, table string, root execute.RootSchema, exec func(query string) error) error {
+	for _, cmd := range LatestViews(d, table) {
+		if err := exec(cmd); err != nil {
+			log.Debug(cmd)
+			return fmt.Errorf("error creating latest views: %v", err)
+		}
+	}
+	CreateHelperViews(d, table, root, exec)
+	return nil
+}
+
+// Sampler is implemented by dialects without LIMIT, which read a single row
+// of a view some other way.
+type Sampler interface {
+	// Sample returns a query reading at most one row of object.
+	Sample(object string) string
+}
+
+// ValidateViews reads a row from the latest views and every helper view
+// described by the schema, so that views failing on a bad cast or JSON path
+// are found when they're created rather than by the next dashboard to query
+// them.  query must run the SELECT and read its rows.  The error of every
+// broken view is returned, keyed by name.
+func ValidateViews(d Dialect, table string, root execute.RootSchema, query func(query string) error) map[string]error {
+	o := d.Options()
+	names := []string{table + "_LATEST_ALL_VERSIONS", table + "_LATEST"}
+	for _, view := range o.Views(root) {
+		names = append(names, view.Name)
+		if o.ChangedViews && view.TopLevel {
+			names = append(names, o.ChangedName(view))
+		}
+	}
+
+	broken := map[string]error{}
+	for _, name := range names {
+		sample := fmt.Sprintf("SELECT * FROM %s LIMIT 1", d.Object(name))
+		if sampler, ok := d.(Sampler); ok {
+			sample = sampler.Sample(d.Object(name))
+		}
+		if err := query(sample); err != nil {
+			log.Debug(sample)
+			broken[name] = err
+		}
+	}
+	return broken
+}
+
+// QueryRows returns a query function for ValidateViews which runs the query on
+// db and reads every row, as some drivers only report errors while rows are
+// being fetched.
+func QueryRows(db *sql.DB) func(query string) error {
+	return func(query string) error {
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+		}
+		return rows.Err()
+	}
+}
+
+// Reconcile compares the control records of the most recent batches in the
+// documents table against the documents and chunks actually loaded for them.
+// Batches which have since been pruned will naturally come up short.
+func Reconcile(d Dialect, table string, db *sql.DB, batches int) ([]documents.Batch, error) {
+	base := d.Object(table)
+	batchDate, docType, chunk := d.Column("BATCH_DATE"), d.Column("TYPE"), d.Column("CHUNK")
+
+	rows, err := db.Query(fmt.Sprintf(`
+	SELECT c.%s, c.%s,
+		(SELECT COUNT(*) FROM %s ed WHERE ed.%s = c.%s AND ed.%s <> '%s' AND ed.%s = 0),
+		(SELECT COUNT(*) FROM %s ed WHERE ed.%s = c.%s AND ed.%s <> '%s')
+	FROM %s c
+	WHERE c.%s = '%s'
+	ORDER BY c.%s DESC
+	`, batchDate, d.Column("DATA"),
+		base, batchDate, batchDate, docType, documents.ControlType, chunk,
+		base, batchDate, batchDate, docType, documents.ControlType,
+		base, docType, documents.ControlType, batchDate))
+	if err != nil {
+		return nil, fmt.Errorf("error querying control records: %v", err)
+	}
+	defer rows.Close()
+
+	var result []documents.Batch
+	for rows.Next() {
+		var date interface{}
+		var data string
+		var loadedDocuments, loadedChunks int
+		if err := rows.Scan(&date, &data, &loadedDocuments, &loadedChunks); err != nil {
+			return nil, fmt.Errorf("error reading control record: %v", err)
+		}
+		var control struct {
+			Documents int `json:"DOCUMENTS"`
+			Chunks    int `json:"CHUNKS"`
+		}
+		if err := json.Unmarshal([]byte(data), &control); err != nil {
+			return nil, fmt.Errorf("error parsing control record: %v", err)
+		}
+
+		// A batch uploaded in several parts has a control record per part
+		key := formatBatchDate(date)
+		if n := len(result); n > 0 && result[n-1].BatchDate == key {
+			result[n-1].ExpectedDocuments += control.Documents
+			result[n-1].ExpectedChunks += control.Chunks
+			continue
+		}
+		if len(result) == batches {
+			break
+		}
+		result = append(result, documents.Batch{
+			BatchDate:         key,
+			ExpectedDocuments: control.Documents,
+			ExpectedChunks:    control.Chunks,
+			Documents:         loadedDocuments,
+			Chunks:            loadedChunks,
+		})
+	}
+	return result, rows.Err()
+}
+
+// BatchExists reports whether the documents table already holds a control
+// record for batchDate, i.e. whether another run has loaded that batch.
+func BatchExists(d Dialect, table string, db *sql.DB, batchDate string) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s = '%s' AND %s = '%s'`,
+		d.Object(table), d.Column("TYPE"), documents.ControlType, d.Column("ID"), strings.ReplaceAll(batchDate, "'", "''"))).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for batch: %v", err)
+	}
+	return count > 0, nil
+}
+
+// formatBatchDate renders a BATCH_DATE however the driver returned it.
+func formatBatchDate(date interface{}) string {
+	switch v := date.(type) {
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05Z")
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ObjectNames returns the names of every object generated over the documents
+// table: the table itself, the latest views, the helper views and the
+// relationships between them.
+func (o Options) ObjectNames(table string, root execute.RootSchema) []string {
+	names := []string{table, table + "_LATEST_ALL_VERSIONS", table + "_LATEST", StatsTable(table), RelationshipsView}
+	for _, view := range o.Views(root) {
+		names = append(names, view.Name)
+		if o.ChangedViews && view.TopLevel {
+			names = append(names, o.ChangedName(view))
+		}
+	}
+	return names
+}
+
+// Definitions runs query, which must return the name and SQL definition of
+// objects in the warehouse, and keeps those generated over the documents
+// table.  Names are matched without regard to case, as some warehouses fold
+// them, and the results keyed by the names from ObjectNames.
+func Definitions(d Dialect, db *sql.DB, query string, table string, root execute.RootSchema) (map[string]string, error) {
+	wanted := map[string]string{}
+	for _, name := range d.Options().ObjectNames(table, root) {
+		wanted[strings.ToUpper(name)] = name
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying object definitions: %v", err)
+	}
+	defer rows.Close()
+
+	definitions := map[string]string{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return nil, fmt.Errorf("error reading object definition: %v", err)
+		}
+		if original, ok := wanted[strings.ToUpper(name)]; ok {
+			definitions[original] = strings.TrimSpace(definition)
+		}
+	}
+	return definitions, rows.Err()
+}
+
+// Rows returns the given columns of every row of a table or view, rendered
+// as text (NULL as an empty string) and ordered by the first column, i.e. for
+// `selftest` to check what was loaded.
+func Rows(d Dialect, db *sql.DB, name string, columns []string) ([][]string, error) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.Column(column)
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(quoted, ", "), d.Object(name), quoted[0]))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %v", name, err)
+	}
+	defer rows.Close()
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", name, err)
+		}
+		row := make([]string, len(columns))
+		for i, value := range values {
+			if value != nil {
+				row[i] = formatBatchDate(value)
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// Report summarises the documents table by document type.  size is an
+// expression giving the length of the DATA column in the warehouse's SQL.
+func Report(d Dialect, table string, db *sql.DB, size string) ([]documents.TypeStats, error) {
+	base := d.Object(table)
+	batchDate, docType, id, version, chunk := d.Column("BATCH_DATE"), d.Column("TYPE"), d.Column("ID"), d.Column("VERSION"), d.Column("CHUNK")
+
+	rows, err := db.Query(fmt.Sprintf(`
+	SELECT v.%s, COUNT(DISTINCT v.%s), COUNT(*), SUM(v.row_count), SUM(v.chunk_count), SUM(v.byte_count)
+	FROM (
+		SELECT %s, %s, %s, COUNT(*) AS row_count,
+			SUM(CASE WHEN %s > 0 THEN 1 ELSE 0 END) AS chunk_count,
+			SUM(%s) AS byte_count
+		FROM %s
+		WHERE %s <> '%s'
+		GROUP BY %s, %s, %s
+	) v
+	GROUP BY v.%s
+	ORDER BY v.%s
+	`, docType, id,
+		docType, id, version,
+		chunk,
+		size,
+		base,
+		docType, documents.ControlType,
+		docType, id, version,
+		docType, docType))
+	if err != nil {
+		return nil, fmt.Errorf("error querying document counts: %v", err)
+	}
+	defer rows.Close()
+
+	var stats []documents.TypeStats
+	index := map[string]int{}
+	for rows.Next() {
+		var s documents.TypeStats
+		var bytes sql.NullInt64
+		if err := rows.Scan(&s.Type, &s.Documents, &s.Versions, &s.Rows, &s.Chunks, &bytes); err != nil {
+			return nil, fmt.Errorf("error reading document counts: %v", err)
+		}
+		s.Bytes = bytes.Int64
+		index[s.Type] = len(stats)
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Compare the rows loaded by the two most recent batches
+	rows, err = db.Query(fmt.Sprintf(`
+	SELECT ed.%s,
+		SUM(CASE WHEN ed.%s = b.latest THEN 1 ELSE 0 END),
+		SUM(CASE WHEN ed.%s = b.previous THEN 1 ELSE 0 END)
+	FROM %s ed
+	CROSS JOIN (
+		SELECT l.latest, (SELECT MAX(p.%s) FROM %s p WHERE p.%s < l.latest) AS previous
+		FROM (SELECT MAX(%s) AS latest FROM %s) l
+	) b
+	WHERE ed.%s <> '%s'
+	GROUP BY ed.%s
+	`, docType,
+		batchDate,
+		batchDate,
+		base,
+		batchDate, base, batchDate,
+		batchDate, base,
+		docType, documents.ControlType,
+		docType))
+	if err != nil {
+		return nil, fmt.Errorf("error querying batch counts: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var latest, previous int
+		if err := rows.Scan(&name, &latest, &previous); err != nil {
+			return nil, fmt.Errorf("error reading batch counts: %v", err)
+		}
+		if i, ok := index[name]; ok {
+			stats[i].Latest = latest
+			stats[i].Previous = previous
+		}
+	}
+	return stats, rows.Err()
+}
+
+// LatestVersions returns the latest version loaded of every document of a
+// type, by document ID, for comparing two warehouses.
+func LatestVersions(d Dialect, table string, db *sql.DB, docType string) (map[string]int64, error) {
+	id, version := d.Column("ID"), d.Column("VERSION")
+	rows, err := db.Query(fmt.Sprintf(`SELECT %s, MAX(%s) FROM %s WHERE %s = '%s' GROUP BY %s`,
+		id, version, d.Object(table), d.Column("TYPE"), strings.ReplaceAll(docType, "'", "''"), id))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s versions: %v", docType, err)
+	}
+	defer rows.Close()
+
+	versions := map[string]int64{}
+	for rows.Next() {
+		var documentID string
+		var latest int64
+		if err := rows.Scan(&documentID, &latest); err != nil {
+			return nil, fmt.Errorf("error reading %s versions: %v", docType, err)
+		}
+		versions[documentID] = latest
+	}
+	return versions, rows.Err()
+}
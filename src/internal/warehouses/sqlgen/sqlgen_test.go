@@ -0,0 +1,316 @@
+package sqlgen
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+)
+
+func testSchema(t *testing.T) execute.RootSchema {
+	t.Helper()
+	var root execute.RootSchema
+	err := json.Unmarshal([]byte(`{
+		"AFE": {
+			"DOCUMENT_ID": {"TYPE": "GUID"},
+			"NAME": {"TYPE": "TEXT"},
+			"WELL": {"TYPE": "DOCUMENT", "DOCUMENT_TYPE": "WELL"},
+			"BUDGET": {"TYPE": "RECORD", "RECORD_TYPE": {"TOTAL": {"TYPE": "DECIMAL"}}},
+			"PARTNERS": {"TYPE": "RECORD LIST", "RECORD_TYPE": {
+				"LISTITEM_ID": {"TYPE": "GUID"},
+				"SHARE": {"TYPE": "DECIMAL"},
+				"ADDRESS": {"TYPE": "RECORD", "RECORD_TYPE": {"CITY": {"TYPE": "TEXT"}}},
+				"NESTED": {"TYPE": "RECORD LIST", "RECORD_TYPE": {}}
+			}}
+		}
+	}`), &root)
+	if err != nil {
+		t.Fatalf("parsing schema: %v", err)
+	}
+	return root
+}
+
+func TestViewsWalksRecordsAndLists(t *testing.T) {
+	views := map[string]View{}
+	for _, v := range (Options{}).Views(testSchema(t)) {
+		views[v.Name] = v
+	}
+
+	if len(views) != 4 {
+		t.Fatalf("expected 4 views, got %d: %v", len(views), views)
+	}
+
+	afe := views["AFE"]
+	if !afe.TopLevel || afe.List != nil || len(afe.Fields) != 2 {
+		t.Fatalf("unexpected document view: %+v", afe)
+	}
+
+	budget := views["AFE_BUDGET"]
+	if budget.TopLevel || !reflect.DeepEqual(budget.Fields[0].Path, []string{"BUDGET", "TOTAL"}) {
+		t.Fatalf("unexpected record view: %+v", budget)
+	}
+
+	partners := views["AFE_PARTNERS"]
+	if !reflect.DeepEqual(partners.List, []string{"PARTNERS"}) || len(partners.Fields) != 1 {
+		t.Fatalf("unexpected list view: %+v", partners)
+	}
+
+	address := views["AFE_PARTNERS_ADDRESS"]
+	if !reflect.DeepEqual(address.List, []string{"PARTNERS"}) || !reflect.DeepEqual(address.Fields[0].Path, []string{"ADDRESS", "CITY"}) {
+		t.Fatalf("unexpected record-in-list view: %+v", address)
+	}
+}
+
+func TestViewsPointsDocumentFieldsAtTheirID(t *testing.T) {
+	for _, v := range (Options{}).Views(testSchema(t)) {
+		for _, f := range v.Fields {
+			if f.Name == "WELL" {
+				if !reflect.DeepEqual(f.Path, []string{"WELL", "DOCUMENT_ID"}) || f.DocumentType != "WELL" {
+					t.Fatalf("unexpected document field: %+v", f)
+				}
+				return
+			}
+		}
+	}
+	t.Fatal("document field not found")
+}
+
+func TestLineageMapsListColumnsToItemPaths(t *testing.T) {
+	for _, v := range (Options{}).Lineage("EXECUTE_DOCUMENTS", testSchema(t)) {
+		if v.Name != "AFE_PARTNERS_ADDRESS" {
+			continue
+		}
+		if v.ListPath != "$.PARTNERS" || v.Source != "EXECUTE_DOCUMENTS_LATEST" {
+			t.Fatalf("unexpected view lineage: %+v", v)
+		}
+		last := v.Columns[len(v.Columns)-1]
+		if last.Field != "CITY" || last.JSONPath != "$.PARTNERS[*].ADDRESS.CITY" {
+			t.Fatalf("unexpected column lineage: %+v", last)
+		}
+		return
+	}
+	t.Fatal("view not found")
+}
+
+func TestViewsMovesInactiveFieldsToTheirOwnViews(t *testing.T) {
+	options := Options{InactiveViews: true}
+
+	var root execute.RootSchema
+	err := json.Unmarshal([]byte(`{
+		"AFE": {
+			"NAME": {"TYPE": "TEXT", "ACTIVE": true},
+			"OLD_CODE": {"TYPE": "TEXT"},
+			"BUDGET": {"TYPE": "RECORD", "ACTIVE": true, "RECORD_TYPE": {
+				"TOTAL": {"TYPE": "DECIMAL", "ACTIVE": true},
+				"OLD_TOTAL": {"TYPE": "DECIMAL"}
+			}},
+			"LEGACY": {"TYPE": "RECORD", "RECORD_TYPE": {"CODE": {"TYPE": "TEXT", "ACTIVE": true}}}
+		}
+	}`), &root)
+	if err != nil {
+		t.Fatalf("parsing schema: %v", err)
+	}
+
+	fields := map[string][]string{}
+	for _, v := range options.Views(root) {
+		for _, f := range v.Fields {
+			fields[v.Name] = append(fields[v.Name], f.Name)
+		}
+	}
+	expected := map[string][]string{
+		"AFE":                 {"NAME"},
+		"AFE_INACTIVE":        {"OLD_CODE"},
+		"AFE_BUDGET":          {"TOTAL"},
+		"AFE_BUDGET_INACTIVE": {"OLD_TOTAL"},
+		"AFE_LEGACY_INACTIVE": {"CODE"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected views: %v", fields)
+	}
+}
+
+func TestViewsAreInAlphabeticalOrder(t *testing.T) {
+	var names, columns []string
+	for _, v := range (Options{}).Views(testSchema(t)) {
+		names = append(names, v.Name)
+		if v.Name == "AFE" {
+			for _, f := range v.Fields {
+				columns = append(columns, f.Name)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"AFE", "AFE_BUDGET", "AFE_PARTNERS", "AFE_PARTNERS_ADDRESS"}) {
+		t.Fatalf("unexpected view order: %v", names)
+	}
+	if !reflect.DeepEqual(columns, []string{"NAME", "WELL"}) {
+		t.Fatalf("unexpected column order: %v", columns)
+	}
+}
+
+func TestViewsNamedInSnakeCase(t *testing.T) {
+	options := Options{Naming: "snake"}
+
+	var root execute.RootSchema
+	err := json.Unmarshal([]byte(`{
+		"AFE": {
+			"TOTAL": {"NAME": "Total Cost ($)", "TYPE": "DECIMAL"},
+			"TOTAL2": {"NAME": "Total Cost", "TYPE": "DECIMAL"},
+			"CODE": {"TYPE": "TEXT"},
+			"ID": {"NAME": "Document ID", "TYPE": "TEXT"},
+			"YEAR": {"NAME": "2024", "TYPE": "INTEGER"},
+			"BUDGET": {"NAME": "2024 Budget", "TYPE": "RECORD", "RECORD_TYPE": {"AMOUNT": {"NAME": "Amount", "TYPE": "DECIMAL"}}}
+		}
+	}`), &root)
+	if err != nil {
+		t.Fatalf("parsing schema: %v", err)
+	}
+
+	fields := map[string][]string{}
+	for _, v := range options.Views(root) {
+		fields[v.Name] = nil
+		for _, f := range v.Fields {
+			fields[v.Name] = append(fields[v.Name], f.Column)
+		}
+	}
+	expected := map[string][]string{
+		"afe":             {"code", "id", "total_cost", "total2", "_2024"},
+		"afe_2024_budget": {"amount"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected views: %v", fields)
+	}
+}
+
+func TestViewsNamedWithDisplayNames(t *testing.T) {
+	options := Options{Naming: "display"}
+
+	var root execute.RootSchema
+	err := json.Unmarshal([]byte(`{
+		"AFE": {
+			"APPROVED": {"NAME": "Approved  \"Amount\"", "TYPE": "DECIMAL"},
+			"APPROVED2": {"NAME": "approved amount", "TYPE": "DECIMAL"},
+			"ID": {"NAME": "Document Id", "TYPE": "TEXT"},
+			"CODE": {"TYPE": "TEXT"}
+		}
+	}`), &root)
+	if err != nil {
+		t.Fatalf("parsing schema: %v", err)
+	}
+
+	var columns []string
+	for _, f := range options.Views(root)[0].Fields {
+		columns = append(columns, f.Column)
+	}
+	if !reflect.DeepEqual(columns, []string{"Approved Amount", "APPROVED2", "CODE", "Document Id"}) {
+		t.Fatalf("unexpected columns: %q", columns)
+	}
+}
+
+func TestRelationshipsJoinChildViewsAndReferences(t *testing.T) {
+	root := testSchema(t)
+	root["WELL"] = execute.DocumentSchema{"NAME": {Type: "TEXT"}}
+
+	var found []string
+	for _, r := range (Options{}).Relationships(root) {
+		found = append(found, r.View+"."+r.Column+" -> "+r.ReferencedView+"."+r.ReferencedColumn)
+	}
+	expected := []string{
+		"AFE.WELL -> WELL.DOCUMENT_ID",
+		"AFE_BUDGET.DOCUMENT_ID -> AFE.DOCUMENT_ID",
+		"AFE_PARTNERS.DOCUMENT_ID -> AFE.DOCUMENT_ID",
+		"AFE_PARTNERS_ADDRESS.DOCUMENT_ID -> AFE.DOCUMENT_ID",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("unexpected relationships: %v", found)
+	}
+}
+
+func TestDecimalUsesSchemaSize(t *testing.T) {
+	size := 4
+	f := Field{Name: "TOTAL", Type: "DECIMAL", Size: &size}
+	if _, ok := (Options{}).Decimal(f); ok {
+		t.Fatal("expected floating point without ExactDecimals")
+	}
+
+	options := Options{ExactDecimals: true}
+	if decimal, _ := options.Decimal(f); decimal != "DECIMAL(38, 4)" {
+		t.Fatalf("unexpected type: %s", decimal)
+	}
+	if decimal, _ := options.Decimal(Field{Type: "DECIMAL"}); decimal != "DECIMAL(38, 10)" {
+		t.Fatalf("unexpected default type: %s", decimal)
+	}
+	if _, ok := options.Decimal(Field{Type: "INTEGER"}); ok {
+		t.Fatal("expected only DECIMAL fields to be affected")
+	}
+}
+
+// plainDialect is a Dialect producing unqualified, double-quoted SQL.
+type plainDialect struct {
+	options Options
+}
+
+func (d plainDialect) Options() Options { return d.options }
+
+func (plainDialect) Object(name string) string { return name }
+func (plainDialect) Column(name string) string { return `"` + name + `"` }
+func (plainDialect) CreateView(name string, query string) []string {
+	return []string{"CREATE VIEW " + name + " AS " + query}
+}
+func (plainDialect) ViewQuery(table string, v View) string { return "SELECT FROM " + table + "_LATEST" }
+
+func TestChangedViewsFollowDocumentViews(t *testing.T) {
+	var created []string
+	exec := func(query string) error {
+		created = append(created, strings.Fields(query)[2])
+		return nil
+	}
+
+	CreateHelperViews(plainDialect{}, "EXECUTE_DOCUMENTS", testSchema(t), exec)
+	if strings.Join(created, ",") != "AFE,AFE_BUDGET,AFE_PARTNERS,AFE_PARTNERS_ADDRESS" {
+		t.Fatalf("expected no _CHANGED views by default, got %v", created)
+	}
+
+	d := plainDialect{Options{ChangedViews: true}}
+	created = nil
+	CreateHelperViews(d, "EXECUTE_DOCUMENTS", testSchema(t), exec)
+	if strings.Join(created, ",") != "AFE,AFE_CHANGED,AFE_BUDGET,AFE_PARTNERS,AFE_PARTNERS_ADDRESS" {
+		t.Fatalf("unexpected views: %v", created)
+	}
+	if names := d.options.ObjectNames("EXECUTE_DOCUMENTS", testSchema(t)); !slices.Contains(names, "AFE_CHANGED") {
+		t.Fatalf("expected AFE_CHANGED among %v", names)
+	}
+
+	query := Changed(d, "EXECUTE_DOCUMENTS", View{Name: "AFE", DocType: "AFE", TopLevel: true})
+	for _, want := range []string{
+		`FROM AFE v`,
+		`(SELECT MAX("BATCH_DATE") AS batch_date FROM EXECUTE_DOCUMENTS WHERE "TYPE" = 'AFE') latest`,
+		`HAVING MAX(ed."BATCH_DATE") = latest.batch_date`,
+		`ON v."DOCUMENT_ID" = c.document_id`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in:\n%s", want, query)
+		}
+	}
+}
+
+func TestValidateViewsReportsBrokenViews(t *testing.T) {
+	var sampled []string
+	broken := ValidateViews(plainDialect{}, "EXECUTE_DOCUMENTS", testSchema(t), func(query string) error {
+		sampled = append(sampled, query)
+		if strings.Contains(query, "AFE_BUDGET") {
+			return errors.New("invalid cast")
+		}
+		return nil
+	})
+
+	if len(sampled) != 6 || sampled[0] != "SELECT * FROM EXECUTE_DOCUMENTS_LATEST_ALL_VERSIONS LIMIT 1" {
+		t.Fatalf("unexpected queries: %v", sampled)
+	}
+	if len(broken) != 1 || broken["AFE_BUDGET"] == nil {
+		t.Fatalf("expected only AFE_BUDGET broken, got %v", broken)
+	}
+}
@@ -0,0 +1,126 @@
+package sqlgen
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+)
+
+// TableDialect is a Dialect which can create tables from queries, i.e. the
+// stats table.
+type TableDialect interface {
+	Dialect
+	// CreateTableAs returns the statement creating the table name with the
+	// columns, and rows, of query.
+	CreateTableAs(name string, query string) string
+}
+
+// StatsTable is the name of the table holding the statistics of each batch
+// loaded into table.
+func StatsTable(table string) string {
+	return table + "_STATS"
+}
+
+// statsQuery returns the SELECT summarising the latest batch by document
+// type, in the shape of the stats table.
+func statsQuery(d Dialect, table string) string {
+	base := d.Object(table)
+	batchDate, docType := d.Column("BATCH_DATE"), d.Column("TYPE")
+	return fmt.Sprintf(`SELECT %s AS %s, %s AS %s, COUNT(*) AS %s,
+		SUM(CASE WHEN %s > 0 THEN 1 ELSE 0 END) AS %s,
+		COUNT(DISTINCT %s) AS %s,
+		MIN(%s) AS %s, MAX(%s) AS %s
+	FROM %s
+	WHERE %s = (SELECT MAX(%s) FROM %s) AND %s <> '%s'
+	GROUP BY %s, %s`,
+		batchDate, batchDate, docType, docType, d.Column("ROW_COUNT"),
+		d.Column("CHUNK"), d.Column("CHUNK_COUNT"),
+		d.Column("ID"), d.Column("DOCUMENT_COUNT"),
+		d.Column("DATE"), d.Column("MIN_DATE"), d.Column("DATE"), d.Column("MAX_DATE"),
+		base,
+		batchDate, batchDate, base, docType, documents.ControlType,
+		batchDate, docType)
+}
+
+// CreateStatsTable returns the statement creating the (empty) stats table of
+// table.
+func CreateStatsTable(d TableDialect, table string) string {
+	return d.CreateTableAs(d.Object(StatsTable(table)), fmt.Sprintf(`SELECT * FROM (%s) s WHERE 1 = 0`, statsQuery(d, table)))
+}
+
+// CollectStats records the statistics of the latest batch loaded into table:
+// the rows, chunks and distinct documents of each document type, and the
+// range of their $DATEs.  The stats table is created the first time, and a
+// batch collected again replaces its statistics.
+func CollectStats(d TableDialect, table string, db *sql.DB) error {
+	stats := d.Object(StatsTable(table))
+	query := statsQuery(d, table)
+
+	// Tables can't be created IF NOT EXISTS everywhere, but they can all be
+	// queried for nothing
+	if _, err := db.Exec(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE 1 = 0`, stats)); err != nil {
+		if readonly.SkipBootstrap() {
+			return fmt.Errorf("stats table %s doesn't exist (create it from gen bootstrap-sql): %v", stats, err)
+		}
+		if _, err := db.Exec(CreateStatsTable(d, table)); err != nil {
+			return fmt.Errorf("error creating stats table: %v", err)
+		}
+	}
+
+	batchDate := d.Column("BATCH_DATE")
+	if _, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s = (SELECT MAX(%s) FROM %s)`, stats, batchDate, batchDate, d.Object(table))); err != nil {
+		return fmt.Errorf("error replacing stats: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`INSERT INTO %s %s`, stats, query)); err != nil {
+		return fmt.Errorf("error collecting stats: %v", err)
+	}
+	return nil
+}
+
+// Stats summarises the stats table by document type: the rows and chunks
+// every batch loaded (superseded rows included, even once they've been
+// pruned), the rows loaded by the two most recent batches, and when each
+// type was last loaded along with the newest $DATE it held.
+func Stats(d Dialect, table string, db *sql.DB) ([]documents.TypeStats, error) {
+	stats := d.Object(StatsTable(table))
+	batchDate, rowCount := d.Column("BATCH_DATE"), d.Column("ROW_COUNT")
+
+	rows, err := db.Query(fmt.Sprintf(`
+	SELECT s.%s, SUM(s.%s), SUM(s.%s),
+		SUM(CASE WHEN s.%s = b.latest THEN s.%s ELSE 0 END),
+		SUM(CASE WHEN s.%s = b.previous THEN s.%s ELSE 0 END),
+		MAX(s.%s), MAX(s.%s)
+	FROM %s s
+	CROSS JOIN (
+		SELECT l.latest, (SELECT MAX(p.%s) FROM %s p WHERE p.%s < l.latest) AS previous
+		FROM (SELECT MAX(%s) AS latest FROM %s) l
+	) b
+	GROUP BY s.%s
+	ORDER BY s.%s
+	`, d.Column("TYPE"), rowCount, d.Column("CHUNK_COUNT"),
+		batchDate, rowCount,
+		batchDate, rowCount,
+		batchDate, d.Column("MAX_DATE"),
+		stats,
+		batchDate, stats, batchDate,
+		batchDate, stats,
+		d.Column("TYPE"), d.Column("TYPE")))
+	if err != nil {
+		return nil, fmt.Errorf("error querying stats (have any been collected?): %v", err)
+	}
+	defer rows.Close()
+
+	var result []documents.TypeStats
+	for rows.Next() {
+		var s documents.TypeStats
+		var lastLoaded, newest interface{}
+		if err := rows.Scan(&s.Type, &s.Rows, &s.Chunks, &s.Latest, &s.Previous, &lastLoaded, &newest); err != nil {
+			return nil, fmt.Errorf("error reading stats: %v", err)
+		}
+		s.LastLoaded, s.Newest = formatBatchDate(lastLoaded), formatBatchDate(newest)
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,148 @@
+package sqlgen
+
+import (
+	"database/sql"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/charmbracelet/log"
+)
+
+// Warehouse implements the adapter methods which only need the documents
+// table's Dialect and a connection: creating and checking the views, and
+// reading back what was loaded.  Adapters embed it, implementing just the
+// bootstrapping and loading themselves.
+type Warehouse struct {
+	Dialect TableDialect
+	Table   string
+	// Size is an expression giving the length of the DATA column (see
+	// Report).
+	Size string
+	// Connect connects to the warehouse, first bootstrapping the documents
+	// table if bootstrap is set.  The connection is closed after each call
+	// unless Shared, when it belongs to the adapter.
+	Connect func(bootstrap bool) (*sql.DB, error)
+	Shared  bool
+}
+
+// connect returns a connection, and the function releasing it.
+func (w Warehouse) connect(bootstrap bool) (*sql.DB, func(), error) {
+	db, err := w.Connect(bootstrap)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.Shared {
+		return db, func() {}, nil
+	}
+	return db, func() { db.Close() }, nil
+}
+
+// exec returns a function running statements on db.
+func exec(db *sql.DB) func(query string) error {
+	return func(query string) error {
+		log.Debug("Executing", "query", query)
+		_, err := db.Exec(query)
+		return err
+	}
+}
+
+// CreateViews creates the latest views followed by every helper view.
+func (w Warehouse) CreateViews(root execute.RootSchema) error {
+	db, release, err := w.connect(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return CreateViews(w.Dialect, w.Table, root, exec(db))
+}
+
+// CreateRelationships creates the view listing how the helper views reference
+// one another.
+func (w Warehouse) CreateRelationships(root execute.RootSchema) error {
+	db, release, err := w.connect(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return CreateRelationships(w.Dialect, root, exec(db))
+}
+
+// ValidateViews reads a row from each view, reporting those which fail.
+func (w Warehouse) ValidateViews(root execute.RootSchema) (map[string]error, error) {
+	db, release, err := w.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return ValidateViews(w.Dialect, w.Table, root, QueryRows(db)), nil
+}
+
+// Reconcile compares the most recent batches' control records against the
+// documents and chunks actually loaded.
+func (w Warehouse) Reconcile(batches int) ([]documents.Batch, error) {
+	db, release, err := w.connect(true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return Reconcile(w.Dialect, w.Table, db, batches)
+}
+
+// BatchExists reports whether a batch has already been loaded with batchDate.
+func (w Warehouse) BatchExists(batchDate string) (bool, error) {
+	db, release, err := w.connect(true)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	return BatchExists(w.Dialect, w.Table, db, batchDate)
+}
+
+// Report summarises the documents table by document type.
+func (w Warehouse) Report() ([]documents.TypeStats, error) {
+	db, release, err := w.connect(true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return Report(w.Dialect, w.Table, db, w.Size)
+}
+
+// LatestVersions returns the latest version of every document of a type.
+func (w Warehouse) LatestVersions(docType string) (map[string]int64, error) {
+	db, release, err := w.connect(true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return LatestVersions(w.Dialect, w.Table, db, docType)
+}
+
+// CollectStats records the statistics of the latest batch in the stats table.
+func (w Warehouse) CollectStats() error {
+	db, release, err := w.connect(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return CollectStats(w.Dialect, w.Table, db)
+}
+
+// Stats summarises the stats table by document type.
+func (w Warehouse) Stats() ([]documents.TypeStats, error) {
+	db, release, err := w.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return Stats(w.Dialect, w.Table, db)
+}
@@ -0,0 +1,48 @@
+package sqlite
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates SQLite's JSON1 flavour of SQL.  SQLite is dynamically
+// typed, so extracted values are left as json_extract returns them.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (dialect) Object(name string) string {
+	return name
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf(`"%s"`, name)
+}
+
+func (dialect) CreateView(name string, query string) []string {
+	return []string{
+		fmt.Sprintf("DROP VIEW IF EXISTS %s", name),
+		fmt.Sprintf("CREATE VIEW %s AS %s", name, query),
+	}
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE TABLE %s AS %s", name, query)
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	return sqlgen.Select(d, table, v)
+}
+
+func (dialect) Extract(source string, path []string, fieldType string) string {
+	return fmt.Sprintf("json_extract(%s, '%s')", source, sqlgen.JSONPath(path))
+}
+
+func (dialect) Flatten(source string, path []string) (string, string) {
+	return fmt.Sprintf(", json_each(%s, '%s') AS list", source, sqlgen.JSONPath(path)), "list.value"
+}
@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// scratch is a database file of its own, SQLite's nearest thing to a schema.
+type scratch struct {
+	*SQLite
+}
+
+// Scratch creates an empty database file called name in the temp directory.
+func (s *SQLite) Scratch(name string) (registry.Scratch, error) {
+	path := filepath.Join(os.TempDir(), name+".db")
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	scratchDB, err := NewSQLite(s.provider, path, s.chunkSize, s.Dialect.Options())
+	if err != nil {
+		return nil, err
+	}
+	return scratch{scratchDB}, nil
+}
+
+func (s scratch) Rows(name string, columns ...string) ([][]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return sqlgen.Rows(s.Dialect, db, name, columns)
+}
+
+func (s scratch) Drop() error {
+	return os.Remove(s.dsn)
+}
@@ -0,0 +1,89 @@
+package sqlserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates T-SQL.  Helper views read every field in a single
+// OPENJSON ... WITH table operator, which (unlike JSON_VALUE) isn't limited to
+// 4000 character values.  Every object lives in the configured schema.
+type dialect struct {
+	schema  string
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (d dialect) Object(name string) string {
+	return fmt.Sprintf("[%s].[%s]", d.schema, name)
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf("[%s]", name)
+}
+
+func (d dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("CREATE OR ALTER VIEW %s AS %s", d.Object(name), query)}
+}
+
+// Sample reads a row with TOP, as there's no LIMIT.
+func (dialect) Sample(object string) string {
+	return fmt.Sprintf("SELECT TOP 1 * FROM %s", object)
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("SELECT * INTO %s FROM (%s) q", name, query)
+}
+
+// sqlType returns the OPENJSON column type used for a field.
+func sqlType(options sqlgen.Options, f sqlgen.Field) string {
+	if decimal, ok := options.Decimal(f); ok {
+		return decimal
+	}
+	switch f.Type {
+	case "INTEGER":
+		return "INT"
+	case "DECIMAL":
+		return "FLOAT"
+	case "BOOLEAN":
+		return "BIT"
+	case "DATETIME":
+		return "DATETIME2"
+	case "DOCUMENT":
+		return "NVARCHAR(255)"
+	default:
+		return "NVARCHAR(MAX)"
+	}
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	columns := sqlgen.Metadata(d, "ed", v)
+	from := d.Object(table+"_LATEST") + " ed"
+	source := "ed.data"
+
+	if v.List != nil {
+		from += fmt.Sprintf(" CROSS APPLY OPENJSON(ed.data, '%s') AS list", sqlgen.JSONPath(v.List))
+		source = "list.value"
+		columns = append(columns, "CAST(JSON_VALUE(list.value, '$.LISTITEM_ID') AS NVARCHAR(50)) AS "+d.Column(d.options.ColumnName("LISTITEM_ID")))
+	}
+
+	var with []string
+	for _, f := range v.Fields {
+		with = append(with, fmt.Sprintf("[obj_%s] %s '%s'", f.Name, sqlType(d.options, f), sqlgen.JSONPath(f.Path)))
+		columns = append(columns, fmt.Sprintf("obj.[obj_%s] AS [%s]%s", f.Name, f.Column, sqlgen.Comment(f)))
+	}
+	if len(with) > 0 {
+		from += fmt.Sprintf(" OUTER APPLY OPENJSON(%s) WITH (%s) AS obj", source, strings.Join(with, ", "))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE ed.type = '%s'", strings.Join(columns, ", "), from, v.DocType)
+	if v.List == nil {
+		query += " AND ed.chunk = 0"
+	}
+	return query
+}
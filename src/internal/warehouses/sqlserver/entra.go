@@ -0,0 +1,130 @@
+package sqlserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/denisenkom/go-mssqldb/msdsn"
+)
+
+// The fedauth DSN parameter's values, as go-mssqldb's azuread driver and
+// Microsoft's own drivers name them.
+const (
+	activeDirectoryDefault          = "ActiveDirectoryDefault"
+	activeDirectoryManagedIdentity  = "ActiveDirectoryManagedIdentity"
+	activeDirectoryMSI              = "ActiveDirectoryMSI"
+	activeDirectoryServicePrincipal = "ActiveDirectoryServicePrincipal"
+	activeDirectoryApplication      = "ActiveDirectoryApplication"
+)
+
+// entraConnector returns a connector authenticating with Azure AD (Entra ID)
+// when the DSN has a fedauth parameter, or nil for a SQL login.
+//
+//   - ActiveDirectoryDefault tries environment variables, workload identity,
+//     managed identity and the Azure CLI in turn, as DefaultAzureCredential
+//     does.
+//   - ActiveDirectoryManagedIdentity (or ActiveDirectoryMSI) uses the
+//     system-assigned identity, or the user-assigned identity whose client ID
+//     is the user id.
+//   - ActiveDirectoryServicePrincipal (or ActiveDirectoryApplication) uses the
+//     client ID and secret given as the user id and password.  The user id may
+//     be client-id@tenant-id; otherwise the tenant is the server's.
+//
+// The credential is created once, so its tokens are cached and refreshed
+// across connections.
+func entraConnector(dsn string) (driver.Connector, error) {
+	config, params, err := msdsn.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	fedauth := params["fedauth"]
+	if fedauth == "" {
+		return nil, nil
+	}
+
+	// The user id and password identify the credential, not a SQL login
+	user, password := config.User, config.Password
+	config.User, config.Password = "", ""
+
+	var credential func(tenantID string) (azcore.TokenCredential, error)
+	var workflow byte = mssql.FedAuthADALWorkflowPassword
+	switch {
+	case strings.EqualFold(fedauth, activeDirectoryDefault):
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", activeDirectoryDefault, err)
+		}
+		credential = func(string) (azcore.TokenCredential, error) { return cred, nil }
+	case strings.EqualFold(fedauth, activeDirectoryManagedIdentity), strings.EqualFold(fedauth, activeDirectoryMSI):
+		var options azidentity.ManagedIdentityCredentialOptions
+		if user != "" {
+			options.ID = azidentity.ClientID(user)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(&options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", activeDirectoryManagedIdentity, err)
+		}
+		workflow = mssql.FedAuthADALWorkflowMSI
+		credential = func(string) (azcore.TokenCredential, error) { return cred, nil }
+	case strings.EqualFold(fedauth, activeDirectoryServicePrincipal), strings.EqualFold(fedauth, activeDirectoryApplication):
+		clientID, tenantID, _ := strings.Cut(user, "@")
+		if clientID == "" || password == "" {
+			return nil, fmt.Errorf("%s needs the client ID as the user id and its secret as the password", activeDirectoryServicePrincipal)
+		}
+		var mu sync.Mutex
+		creds := map[string]azcore.TokenCredential{}
+		credential = func(serverTenantID string) (azcore.TokenCredential, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			tenant := tenantID
+			if tenant == "" {
+				tenant = serverTenantID
+			}
+			if cred, ok := creds[tenant]; ok {
+				return cred, nil
+			}
+			cred, err := azidentity.NewClientSecretCredential(tenant, clientID, password, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", activeDirectoryServicePrincipal, err)
+			}
+			creds[tenant] = cred
+			return cred, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported fedauth %q (use %s, %s or %s)", fedauth, activeDirectoryDefault, activeDirectoryManagedIdentity, activeDirectoryServicePrincipal)
+	}
+
+	connector, err := mssql.NewActiveDirectoryTokenConnector(config, workflow, func(ctx context.Context, serverSPN, stsURL string) (string, error) {
+		cred, err := credential(tenantFromSTS(stsURL))
+		if err != nil {
+			return "", err
+		}
+		token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{strings.TrimSuffix(serverSPN, "/") + "/.default"}})
+		if err != nil {
+			return "", fmt.Errorf("getting Azure AD token: %v", err)
+		}
+		return token.Token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connector, nil
+}
+
+// tenantFromSTS returns the tenant ID from the security token service URL
+// the server sends (i.e. https://login.windows.net/<tenant-id>).
+func tenantFromSTS(stsURL string) string {
+	u, err := url.Parse(stsURL)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
@@ -0,0 +1,80 @@
+package sqlserver
+
+import (
+	"fmt"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// scratch keeps its objects in a schema of its own in the same database.
+type scratch struct {
+	*SQLServer
+}
+
+// Scratch creates an empty schema called name.
+func (s *SQLServer) Scratch(name string) (registry.Scratch, error) {
+	scratchDB, err := NewSQLServer(s.dsn, name, s.chunkSize, s.Dialect.Options())
+	if err != nil {
+		return nil, err
+	}
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// CREATE SCHEMA must be alone in its batch
+	if _, err := db.Exec(fmt.Sprintf("CREATE SCHEMA [%s]", name)); err != nil {
+		return nil, fmt.Errorf("error creating schema %s: %v", name, err)
+	}
+	return scratch{scratchDB}, nil
+}
+
+func (s scratch) Rows(name string, columns ...string) ([][]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return sqlgen.Rows(s.Dialect, db, name, columns)
+}
+
+// Drop removes the schema's views and tables and then the schema, as SQL
+// Server has no DROP SCHEMA ... CASCADE.
+func (s scratch) Drop() error {
+	db, err := s.connect(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT name, CASE type WHEN 'V' THEN 'VIEW' ELSE 'TABLE' END
+FROM sys.objects
+WHERE schema_id = SCHEMA_ID(@p1) AND type IN ('V', 'U')
+ORDER BY CASE type WHEN 'V' THEN 0 ELSE 1 END`, s.schema)
+	if err != nil {
+		return fmt.Errorf("error listing objects: %v", err)
+	}
+	var drops []string
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			rows.Close()
+			return fmt.Errorf("error listing objects: %v", err)
+		}
+		drops = append(drops, fmt.Sprintf("DROP %s [%s].[%s]", kind, s.schema, name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error listing objects: %v", err)
+	}
+
+	for _, query := range append(drops, fmt.Sprintf("DROP SCHEMA [%s]", s.schema)) {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("error dropping schema %s: %v", s.schema, err)
+		}
+	}
+	return nil
+}
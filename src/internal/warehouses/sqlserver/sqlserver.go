@@ -2,84 +2,176 @@ package sqlserver
 
 import (
 	"database/sql"
-	"encoding/json"
+	"database/sql/driver"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
 	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
 	"github.com/charmbracelet/log"
 	_ "github.com/denisenkom/go-mssqldb"
 )
 
 const TableName string = "EXECUTE_DOCUMENTS"
 
+// DefaultSchema is used when no schema is configured.
+const DefaultSchema string = "dbo"
+
 type SQLServer struct {
+	sqlgen.Warehouse
 	dsn       string
+	schema    string
 	chunkSize int
+	connector driver.Connector // Azure AD auth, when the DSN has fedauth
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"SQLSERVER", "MSSQL"},
+		Description: "Microsoft SQL Server, in DATABASE_SCHEMA (dbo by default)",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewSQLServer(cfg.DatabaseDSN, cfg.DatabaseSchema, cfg.ChunkSize, sqlgen.NewOptions(cfg))
+		},
+	})
 }
 
-func NewSQLServer(dsn string, chunkSize int) (*SQLServer, error) {
-	return &SQLServer{
+// NewSQLServer creates a SQL Server adapter which keeps all of its objects in
+// the given schema (dbo when empty).  Giving each Execute instance its own
+// schema lets several of them share one database.
+func NewSQLServer(dsn string, schema string, chunkSize int, options sqlgen.Options) (*SQLServer, error) {
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	if strings.ContainsAny(schema, "[]'") {
+		return nil, fmt.Errorf("invalid schema name %q", schema)
+	}
+	connector, err := entraConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_DSN: %v", err)
+	}
+	s := &SQLServer{
 		dsn:       dsn,
+		schema:    schema,
 		chunkSize: chunkSize,
-	}, nil
+		connector: connector,
+	}
+	s.Warehouse = sqlgen.Warehouse{Dialect: dialect{schema: schema, options: options}, Table: TableName, Size: `CAST(DATALENGTH(DATA) AS BIGINT)`, Connect: s.connect}
+	return s, nil
 }
 
-// bootstrap initializes the SQL Server database with the required objects
-func bootstrap(db *sql.DB) error {
-	// Create the main table if it doesn't exist
-	_, err := db.Exec(fmt.Sprintf(`
-	IF NOT EXISTS (SELECT * FROM sys.objects WHERE object_id = OBJECT_ID(N'[%s]') AND type in (N'U'))
-	BEGIN
-		CREATE TABLE [%s] (
-			BATCH_DATE DATETIME2 NOT NULL,
-			TYPE NVARCHAR(50) NOT NULL,
-			ID NVARCHAR(50) NOT NULL,
-			VERSION INT NOT NULL,
-			CHUNK INT NOT NULL,
-			AUTHOR NVARCHAR(50),
-			DATE DATETIME2 NOT NULL,
-			DELETED BIT NOT NULL,
-			DATA NVARCHAR(MAX) NOT NULL,
-			CONSTRAINT [PK_%s] PRIMARY KEY CLUSTERED (BATCH_DATE, TYPE, ID, VERSION, CHUNK)
-		)
-	END
-	`, TableName, TableName, TableName))
+// open connects to the database, with a SQL login or Azure AD.
+func (s *SQLServer) open() (*sql.DB, error) {
+	if s.connector != nil {
+		return audit.OpenDB(s.connector), nil
+	}
+	return audit.Open("sqlserver", s.dsn)
+}
 
+// connect opens the database, creating the schema and documents table first
+// if bootstrap is set.
+func (s *SQLServer) connect(bootstrap bool) (*sql.DB, error) {
+	db, err := s.open()
 	if err != nil {
-		return fmt.Errorf("error creating table: %v", err)
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	if !bootstrap {
+		return db, nil
+	}
+	if err = s.bootstrap(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error bootstrapping database: %w", err)
 	}
+	return db, nil
+}
 
-	return nil
+// table returns the schema-qualified documents table
+func (s *SQLServer) table() string {
+	return fmt.Sprintf("[%s].[%s]", s.schema, TableName)
+}
+
+// bootstrapSQL returns the statements creating the schema and documents table
+// if they don't exist.
+func (s *SQLServer) bootstrapSQL() []string {
+	return []string{
+		// CREATE SCHEMA must be alone in its batch
+		fmt.Sprintf(`IF SCHEMA_ID(N'%s') IS NULL
+	EXEC(N'CREATE SCHEMA [%s]')`, s.schema, s.schema),
+		fmt.Sprintf(`IF NOT EXISTS (SELECT * FROM sys.objects WHERE object_id = OBJECT_ID(N'%s') AND type in (N'U'))
+BEGIN
+	CREATE TABLE %s (
+		BATCH_DATE DATETIME2 NOT NULL,
+		TYPE NVARCHAR(50) NOT NULL,
+		ID NVARCHAR(50) NOT NULL,
+		VERSION BIGINT NOT NULL,
+		CHUNK INT NOT NULL,
+		AUTHOR NVARCHAR(50),
+		DATE DATETIME2 NOT NULL,
+		DELETED BIT NOT NULL,
+		DATA NVARCHAR(MAX) NOT NULL,
+		CONSTRAINT [PK_%s] PRIMARY KEY CLUSTERED (BATCH_DATE, TYPE, ID, VERSION, CHUNK)
+	)
+END`, s.table(), s.table(), TableName),
+		// Tables created before versions were 64-bit have an INT VERSION,
+		// which is part of the primary key
+		fmt.Sprintf(`IF EXISTS (SELECT * FROM sys.columns WHERE object_id = OBJECT_ID(N'%s') AND name = N'VERSION' AND system_type_id = TYPE_ID(N'int'))
+BEGIN
+	ALTER TABLE %s DROP CONSTRAINT [PK_%s];
+	ALTER TABLE %s ALTER COLUMN VERSION BIGINT NOT NULL;
+	ALTER TABLE %s ADD CONSTRAINT [PK_%s] PRIMARY KEY CLUSTERED (BATCH_DATE, TYPE, ID, VERSION, CHUNK);
+END`, s.table(), s.table(), TableName, s.table(), s.table(), TableName),
+	}
+}
+
+// BootstrapSQL returns the statements creating the warehouse's objects, for
+// creating them ahead of time.
+func (s *SQLServer) BootstrapSQL() []string {
+	return append(s.bootstrapSQL(), sqlgen.CreateStatsTable(s.Dialect, TableName))
+}
+
+// bootstrap initializes the SQL Server database with the required objects
+func (s *SQLServer) bootstrap(db *sql.DB) error {
+	return readonly.Bootstrap("sqlserver:"+s.dsn+":"+s.schema, func() error {
+		for _, query := range s.bootstrapSQL() {
+			if _, err := db.Exec(query); err != nil {
+				return fmt.Errorf("error creating objects: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
 // Prune removes old data that is no longer needed
 func (s *SQLServer) Prune() error {
-	db, err := sql.Open("sqlserver", s.dsn)
+	db, err := s.connect(true)
 	if err != nil {
-		return fmt.Errorf("error connecting to database: %v", err)
-	}
-	if err = bootstrap(db); err != nil {
-		return fmt.Errorf("error bootstrapping database: %v", err)
+		return err
 	}
 	defer db.Close()
 
 	// Delete records that are not the latest version for each TYPE, ID, VERSION
 	_, err = db.Exec(fmt.Sprintf(`
-	DELETE FROM [%s]
+	DELETE t1 FROM %s t1
 	WHERE NOT EXISTS (
-		SELECT 1 FROM [%s] t2
-		WHERE [%s].TYPE = t2.TYPE
-		  AND [%s].ID = t2.ID
-		  AND [%s].VERSION = t2.VERSION
-		  AND [%s].BATCH_DATE = (
-			SELECT MAX(BATCH_DATE) FROM [%s] t3
+		SELECT 1 FROM %s t2
+		WHERE t1.TYPE = t2.TYPE
+		  AND t1.ID = t2.ID
+		  AND t1.VERSION = t2.VERSION
+		  AND t1.BATCH_DATE = (
+			SELECT MAX(BATCH_DATE) FROM %s t3
 			WHERE t3.TYPE = t2.TYPE
 			  AND t3.ID = t2.ID
 			  AND t3.VERSION = t2.VERSION
 		)
 	)
-	`, TableName, TableName, TableName, TableName, TableName, TableName, TableName))
+	`, s.table(), s.table(), s.table()))
 
 	if err != nil {
 		return fmt.Errorf("error pruning data: %v", err)
@@ -88,14 +180,26 @@ func (s *SQLServer) Prune() error {
 	return nil
 }
 
-// Upload uploads records to SQL Server
-func (s *SQLServer) Upload(batch_date string, nextRecord func() (map[string]interface{}, error)) (int, error) {
-	db, err := sql.Open("sqlserver", s.dsn)
+// PruneBefore removes every row loaded in a batch before cutoff.
+func (s *SQLServer) PruneBefore(cutoff time.Time) error {
+	db, err := s.connect(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE BATCH_DATE < '%s'`, s.table(), cutoff.UTC().Format("2006-01-02T15:04:05")))
 	if err != nil {
-		return 0, fmt.Errorf("error connecting to database: %v", err)
+		return fmt.Errorf("error pruning data: %v", err)
 	}
-	if err = bootstrap(db); err != nil {
-		return 0, fmt.Errorf("error bootstrapping database: %v", err)
+	return nil
+}
+
+// Upload uploads records to SQL Server
+func (s *SQLServer) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	db, err := s.connect(true)
+	if err != nil {
+		return 0, err
 	}
 	defer db.Close()
 
@@ -107,11 +211,11 @@ func (s *SQLServer) Upload(batch_date string, nextRecord func() (map[string]inte
 
 	// Prepare insert statement
 	stmt, err := tx.Prepare(fmt.Sprintf(`
-	INSERT INTO [%s] (
+	INSERT INTO %s (
 		BATCH_DATE, TYPE, ID, VERSION, CHUNK, AUTHOR, DATE, DELETED, DATA
 	) VALUES (
 		@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9
-	)`, TableName))
+	)`, s.table()))
 
 	if err != nil {
 		tx.Rollback()
@@ -121,60 +225,27 @@ func (s *SQLServer) Upload(batch_date string, nextRecord func() (map[string]inte
 
 	count := 0
 
+	docs := stream.Prepare(s.chunkSize)
+	defer docs.Close()
 	for {
-		data, err := nextRecord()
-
-		// Terminate at EOF
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-		}
-
-		// Skip empty records
-		if data == nil {
-			continue
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
 		}
-
-		// Apply chunking
-		var chunks []map[string]interface{}
-
-		// Iterate through the top-level keys
-		for key, value := range data {
-			// Is this a list key?
-			if list, ok := value.([]interface{}); ok {
-				// Does this list have #items > chunk size?
-				if len(list) > s.chunkSize {
-					for i := 0; i < len(list); i += s.chunkSize {
-						end := i + s.chunkSize
-						if end > len(list) {
-							end = len(list)
-						}
-
-						chunks = append(chunks, map[string]interface{}{
-							"DOCUMENT_ID": data["DOCUMENT_ID"].(string),
-							key:           list[i:end],
-						})
-					}
-
-					// Remove the large list from the original document
-					delete(data, key)
-				}
-			}
+		if err != nil {
+			tx.Rollback()
+			return count, err
 		}
+		data := doc.Data
 
-		// Add the modified original document back to the result
-		chunks = append([]map[string]interface{}{data}, chunks...)
-
-		for i := 0; i < len(chunks); i++ {
-			chunkBytes, _ := json.Marshal(chunks[i])
+		for i, chunkBytes := range doc.JSON {
 			_, err = stmt.Exec(
 				batch_date,
 				data["$TYPE"].(string),
 				data["DOCUMENT_ID"].(string),
-				int(data["$VERSION"].(float64)),
+				documents.Version(data),
 				i,
-				data["$AUTHOR_ID"].(string),
+				documents.SQLValue(documents.Author(data)),
 				data["$DATE"].(string),
 				data["$DELETED"].(bool),
 				string(chunkBytes))
@@ -199,155 +270,52 @@ func (s *SQLServer) Upload(batch_date string, nextRecord func() (map[string]inte
 	return count, nil
 }
 
-func (s *SQLServer) CreateViews(data execute.RootSchema) error {
-	db, err := sql.Open("sqlserver", s.dsn)
+// Definitions returns the SQL of the documents table and its views.  Views
+// come from OBJECT_DEFINITION, while the table's definition is rebuilt from
+// its columns as SQL Server doesn't keep the original CREATE TABLE.
+func (s *SQLServer) Definitions(root execute.RootSchema) (map[string]string, error) {
+	db, err := s.connect(false)
 	if err != nil {
-		return fmt.Errorf("error connecting to database: %v", err)
-	}
-	if err = bootstrap(db); err != nil {
-		return fmt.Errorf("error bootstrapping database: %v", err)
+		return nil, err
 	}
 	defer db.Close()
 
-	// Drop and create _LATEST_ALL_VERSIONS view
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE OR ALTER VIEW %s_LATEST_ALL_VERSIONS AS
-	SELECT ed.*
-	FROM %s ed
-	INNER JOIN (
-		SELECT TYPE, ID, VERSION, MAX(BATCH_DATE) AS BATCH_DATE
-		FROM %s
-		GROUP BY TYPE, ID, VERSION
-	) latest
-	ON ed.TYPE = latest.TYPE
-	   AND ed.ID = latest.ID
-	   AND ed.VERSION = latest.VERSION
-	   AND ed.BATCH_DATE = latest.BATCH_DATE;
-	`, TableName, TableName, TableName))
-	if err != nil {
-		return fmt.Errorf("error creating batch latest view: %v", err)
-	}
-
-	// Drop and create _LATEST view
-	_, err = db.Exec(fmt.Sprintf(`
-	CREATE OR ALTER VIEW %s_LATEST AS
-	SELECT ed.*
-	FROM %s_LATEST_ALL_VERSIONS ed
-	INNER JOIN (
-		SELECT TYPE, ID, MAX(VERSION) AS VERSION
-		FROM %s
-		GROUP BY TYPE, ID
-	) latest
-	ON ed.TYPE = latest.TYPE
-	   AND ed.ID = latest.ID
-	   AND ed.VERSION = latest.VERSION;
-	`, TableName, TableName, TableName))
-	if err != nil {
-		return fmt.Errorf("error creating latest view: %v", err)
-	}
-
-	for key, value := range data {
-		log.Infof("Creating Helper Views for `%s`", key)
-		create_view(db, key, key, "", value, "data", "$", "")
-	}
-
-	return nil
+	return sqlgen.Definitions(s.Dialect, db, fmt.Sprintf(`
+	SELECT v.name, OBJECT_DEFINITION(v.object_id)
+	FROM sys.views v
+	WHERE v.schema_id = SCHEMA_ID(N'%s')
+	UNION ALL
+	SELECT t.name, 'CREATE TABLE [%s].[' + t.name + '] (' + CHAR(10) +
+		STRING_AGG(CAST('    [' + c.name + '] ' + TYPE_NAME(c.user_type_id) +
+			CASE WHEN c.max_length = -1 THEN '(MAX)' ELSE '' END +
+			CASE WHEN c.is_nullable = 0 THEN ' NOT NULL' ELSE '' END AS NVARCHAR(MAX)), ',' + CHAR(10))
+			WITHIN GROUP (ORDER BY c.column_id) + CHAR(10) + ')'
+	FROM sys.tables t
+	JOIN sys.columns c ON c.object_id = t.object_id
+	WHERE t.schema_id = SCHEMA_ID(N'%s')
+	GROUP BY t.name
+	`, s.schema, s.schema, s.schema), TableName, root)
 }
 
-func create_view(db *sql.DB, docType string, tableName string, parentTable string, record execute.DocumentSchema, dataField string, root string, flatten string) {
-
-	var withClauses []string
-
-	// Build the WITH clause for OPENJSON for all scalar fields
-	for field, metadata := range record {
-		if field == "DOCUMENT_ID" || field == "LISTITEM_ID" {
-			continue
-		}
-		jsonPath := root + "." + field
-		var sqlType string
-		switch metadata.Type {
-		case "TEXT", "GUID", "UWI":
-			sqlType = "NVARCHAR(MAX)"
-		case "INTEGER":
-			sqlType = "INT"
-		case "DECIMAL":
-			sqlType = "FLOAT"
-		case "BOOLEAN":
-			sqlType = "BIT"
-		case "DATETIME":
-			sqlType = "DATETIME2"
-		case "DOCUMENT":
-			withClauses = append(withClauses, fmt.Sprintf("[obj_%s] NVARCHAR(255) '%s.DOCUMENT_ID'", field, jsonPath))
-			continue
-		case "RECORD":
-			create_view(db, docType, fmt.Sprintf("%s_%s", tableName, field), tableName, metadata.RecordType, dataField, jsonPath, flatten)
-			continue
-		case "RECORD LIST":
-			if dataField == "value" {
-				continue
-			}
-			// Recurse for the list items, using CROSS APPLY OPENJSON
-			create_view(db, docType, fmt.Sprintf("%s_%s", tableName, field), tableName, metadata.RecordType, "value", "$", fmt.Sprintf(" CROSS APPLY OPENJSON(%s, '%s.%s') AS value", dataField, root, field))
-			continue
-		default:
-			log.Infof("Skipping %s:%s of unknown type %s", tableName, field, metadata.Type)
-			continue
-		}
-		withClauses = append(withClauses, fmt.Sprintf("[obj_%s] %s '$.%s'", field, sqlType, field))
-	}
-
-	columns := []string{"id as DOCUMENT_ID"}
-	if dataField == "value" {
-		columns = append(columns, "CAST(JSON_VALUE(value, '$.LISTITEM_ID') as nvarchar) as LISTITEM_ID")
-	}
-	if parentTable == "" {
-		columns = append(columns, "deleted as [_DELETED]")
-		columns = append(columns, "author as [_AUTHOR]")
-		columns = append(columns, "version as [_VERSION]")
-		columns = append(columns, "date as [_DATE]")
-	}
-
-	var fromClause string
-	if len(withClauses) > 0 {
-		fromClause = fmt.Sprintf("%s_LATEST%s OUTER APPLY OPENJSON(%s, '%s') WITH (%s) AS obj", TableName, flatten, dataField, root, strings.Join(withClauses, ", "))
-	} else {
-		// No scalar fields, do not OUTER APPLY OPENJSON; just select from the parent table
-		fromClause = fmt.Sprintf("%s_LATEST%s", TableName, flatten)
-	}
-
-	selectFields := strings.Join(columns, ", ")
-	if len(withClauses) > 0 {
-		var objFields []string
-		for _, field := range getFieldNames(withClauses) {
-			objFields = append(objFields, fmt.Sprintf("[obj_%s] as %s", field, field))
-		}
-		selectFields += ", " + strings.Join(objFields, ", ")
+// Preflight warns when connected as a sysadmin or database owner, which have
+// far more privileges than execute-sync needs.
+func (s *SQLServer) Preflight() ([]string, error) {
+	db, err := s.connect(false)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	cmd := fmt.Sprintf("create or alter view [%s] as select %s from %s where %s_LATEST.type='%s'", tableName, selectFields, fromClause, TableName, docType)
-	if flatten == "" {
-		cmd = cmd + " and chunk=0"
+	var sysadmin, owner sql.NullInt64
+	if err := db.QueryRow(`SELECT IS_SRVROLEMEMBER('sysadmin'), IS_ROLEMEMBER('db_owner')`).Scan(&sysadmin, &owner); err != nil {
+		return nil, err
 	}
-
-	_, err := db.Exec(cmd)
-	if err != nil {
-		log.Errorf("Error creating %s: %v", tableName, err)
-		log.Debug(cmd)
+	var problems []string
+	if sysadmin.Int64 == 1 {
+		problems = append(problems, "connected as a member of the sysadmin server role")
 	}
-
-	// Helper to get field names for SELECT
-}
-
-func getFieldNames(withClauses []string) []string {
-	var fields []string
-	for _, clause := range withClauses {
-		parts := strings.Fields(clause)
-		if len(parts) > 0 {
-			// Remove the 'obj_' prefix for the output column
-			field := parts[0]
-			field = strings.TrimPrefix(strings.Trim(field, "[]"), "obj_")
-			fields = append(fields, field)
-		}
+	if owner.Int64 == 1 {
+		problems = append(problems, "connected as a member of the db_owner database role")
 	}
-	return fields
+	return problems, nil
 }
@@ -0,0 +1,142 @@
+package teradata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+// dialect generates Teradata SQL.  Fields are read with JSONExtractValue,
+// while record lists are expanded with the JSON_TABLE table operator, which
+// Teradata can't express as a lateral join.
+type dialect struct {
+	options sqlgen.Options
+}
+
+func (d dialect) Options() sqlgen.Options {
+	return d.options
+}
+
+func (dialect) Object(name string) string {
+	return fmt.Sprintf(`"%s"`, name)
+}
+
+func (dialect) Column(name string) string {
+	return fmt.Sprintf(`"%s"`, name)
+}
+
+func (d dialect) CreateView(name string, query string) []string {
+	return []string{fmt.Sprintf("REPLACE VIEW %s AS %s", d.Object(name), query)}
+}
+
+// LatestViews picks the latest rows with QUALIFY rather than joining the
+// table to itself.
+func (d dialect) LatestViews(table string) []string {
+	return append(d.CreateView(table+"_LATEST_ALL_VERSIONS", fmt.Sprintf(`
+	SELECT *
+	FROM %s
+	QUALIFY RANK() OVER (PARTITION BY "TYPE", ID, "VERSION" ORDER BY BATCH_DATE DESC) = 1
+	`, d.Object(table))), d.CreateView(table+"_LATEST", fmt.Sprintf(`
+	SELECT *
+	FROM %s
+	QUALIFY RANK() OVER (PARTITION BY "TYPE", ID ORDER BY "VERSION" DESC) = 1
+	`, d.Object(table+"_LATEST_ALL_VERSIONS")))...)
+}
+
+// Sample reads a row with TOP, as there's no LIMIT.
+func (dialect) Sample(object string) string {
+	return fmt.Sprintf("SELECT TOP 1 * FROM %s", object)
+}
+
+func (dialect) CreateTableAs(name string, query string) string {
+	return fmt.Sprintf("CREATE MULTISET TABLE %s AS (%s) WITH DATA", name, query)
+}
+
+// sqlType returns the Teradata type used to present a field.
+func sqlType(options sqlgen.Options, f sqlgen.Field) string {
+	if decimal, ok := options.Decimal(f); ok {
+		return decimal
+	}
+	switch f.Type {
+	case "INTEGER":
+		return "INTEGER"
+	case "DECIMAL":
+		return "FLOAT"
+	case "BOOLEAN":
+		return "BYTEINT"
+	case "DATETIME":
+		return "TIMESTAMP(6) WITH TIME ZONE"
+	default:
+		return "VARCHAR(4000) CHARACTER SET UNICODE"
+	}
+}
+
+// timestampFormat reads the RFC 3339 timestamps Execute writes, once a Z
+// suffix has been spelled out as +00:00.  Without it Teradata expects a space
+// rather than the T, and exactly six fractional digits.
+const timestampFormat = `YYYY-MM-DDTHH:MI:SS.S(F)Z`
+
+// cast converts a field's JSON text to its Teradata type.  BYTEINT can't parse
+// true and false, so booleans are mapped to 1 and 0 first.
+func cast(options sqlgen.Options, value string, f sqlgen.Field) string {
+	switch f.Type {
+	case "BOOLEAN":
+		return fmt.Sprintf("CAST(CASE WHEN %s = 'true' THEN 1 WHEN %s = 'false' THEN 0 END AS BYTEINT)", value, value)
+	case "DATETIME":
+		return fmt.Sprintf("CAST(OREPLACE(%s, 'Z', '+00:00') AS %s FORMAT '%s')", value, sqlType(options, f), timestampFormat)
+	default:
+		return fmt.Sprintf("CAST(%s AS %s)", value, sqlType(options, f))
+	}
+}
+
+func (d dialect) ViewQuery(table string, v sqlgen.View) string {
+	if v.List != nil {
+		return d.listQuery(table, v)
+	}
+
+	columns := []string{`ID AS ` + d.Column(d.options.ColumnName("DOCUMENT_ID"))}
+	if v.TopLevel {
+		columns = append(columns, metadata(d)...)
+	}
+	for _, f := range v.Fields {
+		columns = append(columns, fmt.Sprintf(`%s AS "%s"%s`, cast(d.options, fmt.Sprintf(`DATA.JSONExtractValue('%s')`, sqlgen.JSONPath(f.Path)), f), f.Column, sqlgen.Comment(f)))
+	}
+	return fmt.Sprintf(`SELECT %s FROM %s_LATEST WHERE "TYPE" = '%s' AND CHUNK = 0`, strings.Join(columns, ", "), table, v.DocType)
+}
+
+// metadata returns the document metadata columns of top-level views.  The
+// reserved column names have to be quoted, so sqlgen.Metadata can't be used.
+func metadata(d dialect) []string {
+	return []string{
+		`DELETED AS ` + d.Column(d.options.ColumnName("_DELETED")),
+		`AUTHOR AS ` + d.Column(d.options.ColumnName("_AUTHOR")),
+		`"VERSION" AS ` + d.Column(d.options.ColumnName("_VERSION")),
+		`"DATE" AS ` + d.Column(d.options.ColumnName("_DATE")),
+	}
+}
+
+// listQuery expands a record list with JSON_TABLE, which returns every
+// column as text that's then cast to its proper type.
+func (d dialect) listQuery(table string, v sqlgen.View) string {
+	columns := []string{`jt.ID AS ` + d.Column(d.options.ColumnName("DOCUMENT_ID")), `jt.LISTITEM_ID AS ` + d.Column(d.options.ColumnName("LISTITEM_ID"))}
+	aliases := []string{`"ID"`, `"LISTITEM_ID"`}
+	jsonColumns := []string{`{"jsonpath":"$.LISTITEM_ID","type":"VARCHAR(50)"}`}
+
+	for _, f := range v.Fields {
+		aliases = append(aliases, fmt.Sprintf(`"%s"`, f.Name))
+		jsonColumns = append(jsonColumns, fmt.Sprintf(`{"jsonpath":"%s","type":"VARCHAR(4000)"}`, sqlgen.JSONPath(f.Path)))
+		columns = append(columns, fmt.Sprintf(`%s AS "%s"%s`, cast(d.options, fmt.Sprintf(`jt."%s"`, f.Name), f), f.Column, sqlgen.Comment(f)))
+	}
+
+	return fmt.Sprintf(`SELECT %s FROM JSON_TABLE(
+		ON (SELECT ID, DATA FROM %s_LATEST WHERE "TYPE" = '%s')
+		USING rowexpr('%s[*]') colexpr('[%s]')
+	) AS jt(%s)`,
+		strings.Join(columns, ", "),
+		table,
+		v.DocType,
+		sqlgen.JSONPath(v.List),
+		strings.Join(jsonColumns, ","),
+		strings.Join(aliases, ", "))
+}
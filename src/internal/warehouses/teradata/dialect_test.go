@@ -0,0 +1,36 @@
+package teradata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+)
+
+func TestViewsConvertBooleansAndTimestamps(t *testing.T) {
+	fields := []sqlgen.Field{
+		{Name: "ACTIVE", Column: "ACTIVE", Path: []string{"ACTIVE"}, Type: "BOOLEAN"},
+		{Name: "APPROVED", Column: "APPROVED", Path: []string{"APPROVED"}, Type: "DATETIME"},
+	}
+	d := dialect{}
+
+	query := d.ViewQuery(TableName, sqlgen.View{Name: "AFE", DocType: "AFE", TopLevel: true, Fields: fields})
+	for _, want := range []string{
+		`CAST(CASE WHEN DATA.JSONExtractValue('$.ACTIVE') = 'true' THEN 1 WHEN DATA.JSONExtractValue('$.ACTIVE') = 'false' THEN 0 END AS BYTEINT) AS "ACTIVE"`,
+		`CAST(OREPLACE(DATA.JSONExtractValue('$.APPROVED'), 'Z', '+00:00') AS TIMESTAMP(6) WITH TIME ZONE FORMAT 'YYYY-MM-DDTHH:MI:SS.S(F)Z') AS "APPROVED"`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in:\n%s", want, query)
+		}
+	}
+
+	query = d.ViewQuery(TableName, sqlgen.View{Name: "AFE_PARTNERS", DocType: "AFE", List: []string{"PARTNERS"}, Fields: fields})
+	for _, want := range []string{
+		`CAST(CASE WHEN jt."ACTIVE" = 'true' THEN 1 WHEN jt."ACTIVE" = 'false' THEN 0 END AS BYTEINT) AS "ACTIVE"`,
+		`CAST(OREPLACE(jt."APPROVED", 'Z', '+00:00') AS TIMESTAMP(6) WITH TIME ZONE FORMAT 'YYYY-MM-DDTHH:MI:SS.S(F)Z') AS "APPROVED"`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in:\n%s", want, query)
+		}
+	}
+}
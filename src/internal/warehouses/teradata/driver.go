@@ -0,0 +1,14 @@
+//go:build teradata
+
+package teradata
+
+// Link Teradata's database/sql driver.  It's kept behind the `teradata` build
+// tag so that the default build doesn't carry the dependency.  It's not
+// listed in go.mod either, as the module proxy won't serve it: add it from
+// GitHub with
+//
+//	GOPRIVATE=github.com/Teradata/gosql-driver go get github.com/Teradata/gosql-driver/teradatasql
+//
+// and build with `go build -tags teradata ./src` to enable
+// DATABASE_TYPE=TERADATA (see the README).
+import _ "github.com/Teradata/gosql-driver/teradatasql"
@@ -0,0 +1,291 @@
+package teradata
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/memory"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+	"github.com/afenav/execute-sync/src/internal/warehouses/sqlgen"
+	"github.com/charmbracelet/log"
+)
+
+const TableName string = "EXECUTE_DOCUMENTS"
+
+// driverName is the database/sql driver registered by Teradata's gosql-driver.
+// The driver is only linked into builds made with `-tags teradata` (see
+// driver.go), which keeps the default build free of the dependency.
+const driverName = "teradatasql"
+
+// batchRows is the number of rows bound per batched INSERT.  Batches are sent
+// with the teradata_try_fastload escape so large loads use FastLoad when the
+// session allows it, falling back to regular batched inserts otherwise.
+const batchRows = 10000
+
+// batchBytes caps the document JSON held per batch, so that wide documents
+// don't exhaust memory long before batchRows is reached.
+const batchBytes = 256 * 1024 * 1024
+
+type Teradata struct {
+	sqlgen.Warehouse
+	dsn       string
+	chunkSize int
+}
+
+func init() {
+	registry.Register(registry.Adapter{
+		Names:       []string{"TERADATA"},
+		Description: "Teradata (only in builds with -tags teradata)",
+		Requires:    []string{"DATABASE_DSN"},
+		New: func(cfg config.Config) (registry.Database, error) {
+			return NewTeradata(cfg.DatabaseDSN, cfg.ChunkSize, sqlgen.NewOptions(cfg))
+		},
+	})
+}
+
+// NewTeradata creates a Teradata adapter.  The DSN is the JSON connection
+// string understood by gosql-driver, i.e.
+// {"host":"tdhost","user":"execute","password":"...","database":"EXECUTE"}.
+func NewTeradata(dsn string, chunkSize int, options sqlgen.Options) (*Teradata, error) {
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return nil, fmt.Errorf("teradata support is not included in this build (rebuild with `-tags teradata`)")
+	}
+	t := &Teradata{
+		dsn:       dsn,
+		chunkSize: chunkSize,
+	}
+	t.Warehouse = sqlgen.Warehouse{Dialect: dialect{options}, Table: TableName, Size: `CAST(CHARACTER_LENGTH(CAST("DATA" AS CLOB)) AS BIGINT)`, Connect: t.connect}
+	return t, nil
+}
+
+// bootstrapSQL returns the statement creating the documents table.
+func bootstrapSQL() string {
+	return fmt.Sprintf(`CREATE MULTISET TABLE %s (
+	BATCH_DATE TIMESTAMP(0) NOT NULL,
+	"TYPE" VARCHAR(50) CHARACTER SET UNICODE NOT NULL,
+	ID VARCHAR(50) CHARACTER SET UNICODE NOT NULL,
+	"VERSION" BIGINT NOT NULL,
+	CHUNK INTEGER NOT NULL,
+	AUTHOR VARCHAR(50) CHARACTER SET UNICODE,
+	"DATE" TIMESTAMP(6) WITH TIME ZONE NOT NULL,
+	DELETED BYTEINT NOT NULL,
+	DATA JSON(16776192) CHARACTER SET UNICODE NOT NULL
+) PRIMARY INDEX (ID)`, TableName)
+}
+
+// BootstrapSQL returns the statements creating the warehouse's objects, for
+// creating them ahead of time.
+func (t *Teradata) BootstrapSQL() []string {
+	return []string{bootstrapSQL(), sqlgen.CreateStatsTable(t.Dialect, TableName)}
+}
+
+// connect opens a session, creating the documents table first if bootstrap
+// is set.
+func (t *Teradata) connect(bootstrap bool) (*sql.DB, error) {
+	db, err := audit.Open(driverName, t.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	if !bootstrap {
+		return db, nil
+	}
+	if err = t.bootstrap(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error bootstrapping database: %w", err)
+	}
+	return db, nil
+}
+
+// bootstrap creates the documents table when it doesn't exist.  Teradata has
+// no CREATE ... IF NOT EXISTS so we check the data dictionary first.
+func (t *Teradata) bootstrap(db *sql.DB) error {
+	return readonly.Bootstrap("teradata:"+t.dsn, func() error {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM DBC.TablesV WHERE DatabaseName = DATABASE AND TableName = ?`, TableName).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("error checking for table: %v", err)
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if _, err := db.Exec(bootstrapSQL()); err != nil {
+			return fmt.Errorf("error creating table: %v", err)
+		}
+		return nil
+	})
+}
+
+func (t *Teradata) Prune() error {
+	db, err := t.connect(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`
+	DELETE FROM %s
+	WHERE EXISTS (
+		SELECT 1 FROM %s t2
+		WHERE t2."TYPE" = %s."TYPE"
+		  AND t2.ID = %s.ID
+		  AND t2."VERSION" = %s."VERSION"
+		  AND t2.BATCH_DATE > %s.BATCH_DATE
+	)
+	`, TableName, TableName, TableName, TableName, TableName, TableName))
+	if err != nil {
+		return fmt.Errorf("error pruning data: %v", err)
+	}
+	return nil
+}
+
+// PruneBefore removes every row loaded in a batch before cutoff.
+func (t *Teradata) PruneBefore(cutoff time.Time) error {
+	db, err := t.connect(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE BATCH_DATE < TIMESTAMP '%s'`, TableName, cutoff.UTC().Format("2006-01-02 15:04:05")))
+	if err != nil {
+		return fmt.Errorf("error pruning data: %v", err)
+	}
+	return nil
+}
+
+func (t *Teradata) Upload(batch_date string, stream *documents.Stream) (int, error) {
+	db, err := t.connect(true)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	batchDate, err := time.Parse(time.RFC3339, batch_date)
+	if err != nil {
+		return 0, fmt.Errorf("invalid batch date %q: %v", batch_date, err)
+	}
+
+	insert := fmt.Sprintf(`{fn teradata_try_fastload}INSERT INTO %s (BATCH_DATE, "TYPE", ID, "VERSION", CHUNK, AUTHOR, "DATE", DELETED, DATA) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, TableName)
+
+	// Rows are bound as a slice of slices, which gosql-driver sends as a
+	// single batched request.
+	var rows [][]interface{}
+	pendingBytes := 0
+	maxBytes := int(memory.Budget(0.25, batchBytes))
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		log.Debug("Inserting batch into Teradata", "rows", len(rows))
+		if _, err := db.Exec(insert, rows); err != nil {
+			return fmt.Errorf("error inserting batch: %v", err)
+		}
+		rows = rows[:0]
+		pendingBytes = 0
+		return nil
+	}
+
+	document_count := 0
+	docs := stream.Prepare(t.chunkSize)
+	defer docs.Close()
+	for {
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		data := doc.Data
+
+		docDate, err := time.Parse(time.RFC3339, data["$DATE"].(string))
+		if err != nil {
+			log.Infof("Skipping document %s with invalid $DATE: %v", data["DOCUMENT_ID"], err)
+			continue
+		}
+		deleted := 0
+		if data["$DELETED"].(bool) {
+			deleted = 1
+		}
+
+		for i, chunkBytes := range doc.JSON {
+			rows = append(rows, []interface{}{
+				batchDate,
+				data["$TYPE"].(string),
+				data["DOCUMENT_ID"].(string),
+				documents.Version(data),
+				i,
+				documents.SQLValue(documents.Author(data)),
+				docDate,
+				deleted,
+				string(chunkBytes),
+			})
+			pendingBytes += len(chunkBytes)
+			if len(rows) >= batchRows || pendingBytes >= maxBytes {
+				if err := flush(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		document_count += 1
+	}
+
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return document_count, nil
+}
+
+// Definitions returns the DDL of the documents table and its views, as
+// reported by SHOW TABLE/SHOW VIEW.  Objects which don't exist are skipped.
+func (t *Teradata) Definitions(root execute.RootSchema) (map[string]string, error) {
+	db, err := t.connect(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	definitions := map[string]string{}
+	for _, name := range t.Dialect.Options().ObjectNames(TableName, root) {
+		var kind string
+		err := db.QueryRow(`SELECT TRIM(TableKind) FROM DBC.TablesV WHERE DatabaseName = DATABASE AND TableName = ?`, name).Scan(&kind)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error looking up %s: %v", name, err)
+		}
+		show := "SHOW TABLE"
+		if kind == "V" {
+			show = "SHOW VIEW"
+		}
+
+		// SHOW may split long definitions over several rows
+		rows, err := db.Query(fmt.Sprintf(`%s "%s"`, show, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading definition of %s: %v", name, err)
+		}
+		var ddl strings.Builder
+		for rows.Next() {
+			var part string
+			if err := rows.Scan(&part); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error reading definition of %s: %v", name, err)
+			}
+			ddl.WriteString(part)
+		}
+		rows.Close()
+		definitions[name] = strings.TrimSpace(strings.ReplaceAll(ddl.String(), "\r", "\n"))
+	}
+	return definitions, nil
+}
@@ -1,64 +1,194 @@
-/**
- * Package warehouses provides an abstraction for interacting with different types of databases.
- *
- * The `Database` interface includes the following methods:
- * - `Bootstrap`: Prepares the database for use, such as setting up initial configurations.
- * - `Prune`: Cleans up old or unnecessary data from the database.
- * - `Upload`: Uploads data to the database in chunks, using a callback function to fetch the next record.
- * - `CreateViews`: Creates database views based on the provided schema.
- *
- * The `NewDatabase` function is a factory method that returns a `Database` implementation based on the provided configuration.
- * Currently, it supports the following database types:
- * - "SNOWFLAKE": Returns a Snowflake database implementation.
- *
- * If an unsupported database type is specified, the `New` function returns an error.
- */
+// Package warehouses provides the interface execute-sync loads documents
+// through, whatever the target: the Database every adapter implements, and
+// the optional interfaces (i.e. Reconciler or SelfTester) of those which can
+// do more.
+//
+// The adapters live in subpackages, each registering its DATABASE_TYPE names
+// with the registry when linked in by the imports below.  ListSupported
+// returns those in the build, so it, rather than this comment, is the list of
+// supported types.
 package warehouses
 
 import (
-	"errors"
+	"time"
 
 	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
 	"github.com/afenav/execute-sync/src/internal/execute"
-	"github.com/afenav/execute-sync/src/internal/warehouses/databricks"
-	"github.com/afenav/execute-sync/src/internal/warehouses/snowflake"
-	"github.com/afenav/execute-sync/src/internal/warehouses/sqlite"
-	"github.com/afenav/execute-sync/src/internal/warehouses/sqlserver"
+	"github.com/afenav/execute-sync/src/internal/warehouses/registry"
+
+	// Each adapter registers itself when linked in
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/amqp"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/archive"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/databricks"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/duckdb"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/filedrop"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/firebolt"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/greenplum"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/iceberg"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/kafka"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/lake"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/plugin"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/pubsub"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/snowflake"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/sqlite"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/sqlserver"
+	_ "github.com/afenav/execute-sync/src/internal/warehouses/teradata"
 )
 
-type Database interface {
-	Prune() error
-	Upload(batch_date string, nextRecord func() (map[string]interface{}, error)) (int, error)
-	CreateViews(root execute.RootSchema) error
-}
-
-/**
- * NewDatabase creates a new instance of a `Database` implementation based on the provided configuration.
- *
- * Supported Database Types:
- * - "SNOWFLAKE": Returns a Snowflake database implementation.
- * - "SQLITE": Returns a Snowflake database implementation.
- *
- * Parameters:
- * - `cfg` (config.Config): The configuration object
- *
- * Returns:
- * - (Database): A `Database` implementation matching the specified type.
- * - (error): An error if the `DatabaseType` is unsupported or if initialization fails.
- */
+// Database is implemented by every warehouse.  It's defined alongside the
+// registry the adapters add themselves to.
+type Database = registry.Database
+
+// Adapter describes a supported DATABASE_TYPE.
+type Adapter = registry.Adapter
+
+// ListSupported returns every warehouse adapter linked into this build,
+// sorted by name, i.e. for help text.
+func ListSupported() []Adapter {
+	return registry.Adapters()
+}
+
+// SupportedTypes returns every valid DATABASE_TYPE, including aliases.
+func SupportedTypes() []string {
+	return registry.Names()
+}
+
+// StageCleaner is implemented by warehouses which load data through a stage
+// (i.e. Snowflake), allowing staged files to be cleaned up without pruning
+// the documents table.
+type StageCleaner interface {
+	// CleanStage removes staged files older than the given age and returns
+	// the number of files removed.
+	CleanStage(olderThan time.Duration) (int, error)
+}
+
+// ScopedPruner is implemented by warehouses whose Prune does more than remove
+// superseded rows from the documents table (i.e. Snowflake also empties its
+// stage), allowing each part to be run on its own.
+type ScopedPruner interface {
+	// PruneTable removes superseded rows from the documents table only.
+	PruneTable() error
+	// PruneStage removes staged files only.
+	PruneStage() error
+}
+
+// RetentionPruner is implemented by warehouses which can remove old batches
+// outright, for retention schedules (prune --older-than).
+type RetentionPruner interface {
+	// PruneBefore removes every row loaded in a batch before cutoff, whether
+	// or not it's been superseded.
+	PruneBefore(cutoff time.Time) error
+}
+
+// Reconciler is implemented by warehouses which store a control record (see
+// documents.Control) with each batch, allowing what was loaded to be checked
+// against what was sent.
+type Reconciler interface {
+	// Reconcile returns the most recent batches along with their expected and
+	// actual document and chunk counts.
+	Reconcile(batches int) ([]documents.Batch, error)
+	// BatchExists reports whether a batch has already been loaded with the
+	// given batch_date.
+	BatchExists(batch_date string) (bool, error)
+}
+
+// RelationshipCreator is implemented by warehouses which can describe how
+// their helper views reference one another in a view of their own.  It's
+// given the whole schema, even when only some helper views are rebuilt.
+type RelationshipCreator interface {
+	// CreateRelationships creates, or replaces, the relationships view.
+	CreateRelationships(root execute.RootSchema) error
+}
+
+// ViewValidator is implemented by warehouses which can check their views
+// once they've been created.
+type ViewValidator interface {
+	// ValidateViews reads a row from each view described by the schema, and
+	// returns the error of every view which fails, keyed by name.
+	ValidateViews(root execute.RootSchema) (map[string]error, error)
+}
+
+// Reporter is implemented by warehouses which can summarise the documents
+// table for capacity planning.
+type Reporter interface {
+	// Report returns counts and approximate storage for each document type.
+	Report() ([]documents.TypeStats, error)
+}
+
+// VersionLister is implemented by warehouses which can list the documents
+// they hold, so that `compare` can find where two warehouses differ.
+type VersionLister interface {
+	// LatestVersions returns the latest version of every document of a type,
+	// by document ID.
+	LatestVersions(docType string) (map[string]int64, error)
+}
+
+// StatsCollector is implemented by warehouses which can keep statistics of
+// each batch in a stats table (COLLECT_STATS), so that reports don't have to
+// scan the documents table.
+type StatsCollector interface {
+	// CollectStats records the statistics of the latest batch.
+	CollectStats() error
+	// Stats summarises the stats table by document type.
+	Stats() ([]documents.TypeStats, error)
+}
+
+// QueryTagger is implemented by warehouses which can tag the statements
+// execute-sync issues (i.e. Snowflake's QUERY_TAG), so that warehouse cost
+// dashboards can attribute spend to it.
+type QueryTagger interface {
+	// SetQueryTag tags subsequent statements with a key and value, such as
+	// the command being run or the sync's run ID.
+	SetQueryTag(name string, value string) error
+}
+
+// Preflighter is implemented by warehouses which can check the privileges
+// they're connected with, i.e. to catch an administrative DSN.
+type Preflighter interface {
+	// Preflight returns a description of each privilege held beyond what
+	// execute-sync needs.
+	Preflight() ([]string, error)
+}
+
+// Bootstrapper is implemented by warehouses whose objects can be created ahead
+// of time, i.e. by a DBA where execute-sync isn't allowed to create them.
+type Bootstrapper interface {
+	// BootstrapSQL returns the statements creating the documents table and
+	// whatever else loading it needs, in the order they must be run.
+	BootstrapSQL() []string
+}
+
+// Exporter is implemented by warehouses which can report the definitions of
+// the objects generated in them, i.e. for tracking in source control.
+type Exporter interface {
+	// Definitions returns the SQL definition of the documents table and each
+	// of its views described by the schema which exists, keyed by name.
+	Definitions(root execute.RootSchema) (map[string]string, error)
+}
+
+// SelfTester is implemented by warehouses which can run `selftest`: load a
+// few documents into a disposable schema, check them and drop it again.
+type SelfTester interface {
+	// Scratch creates an empty schema called name, and returns a connection
+	// which creates its objects and loads documents there.
+	Scratch(name string) (Scratch, error)
+}
+
+// Scratch is a connection to a schema created by a SelfTester.
+type Scratch = registry.Scratch
+
+// NewDatabase returns the adapter registered under cfg's DATABASE_TYPE (see
+// ListSupported), or an error if there's none or it fails to initialise.
+// With ReadOnly set, operations which would change the warehouse are refused.
 func NewDatabase(cfg config.Config) (Database, error) {
-	switch cfg.DatabaseType {
-	case "SNOWFLAKE":
-		return snowflake.NewSnowflake(cfg.DatabaseDSN, cfg.ChunkSize)
-	case "SQLSERVER", "MSSQL":
-		return sqlserver.NewSQLServer(cfg.DatabaseDSN, cfg.ChunkSize)
-	case "GOSQLITE":
-		return sqlite.NewSQLite("sqlite", cfg.DatabaseDSN, cfg.ChunkSize)
-	case "SQLITE":
-		return sqlite.NewSQLite("sqlite3", cfg.DatabaseDSN, cfg.ChunkSize)
-	case "DATABRICKS":
-		return databricks.NewDatabricks(cfg.DatabaseDSN, cfg.ChunkSize)
-	default:
-		return nil, errors.New("unsupported database type")
+	db, err := newDatabase(cfg)
+	if err != nil || !cfg.ReadOnly {
+		return db, err
 	}
+	return readOnly{db}, nil
+}
+
+func newDatabase(cfg config.Config) (Database, error) {
+	return registry.New(cfg)
 }
@@ -12,9 +12,19 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/logsink"
+	"github.com/afenav/execute-sync/src/internal/memory"
+	"github.com/afenav/execute-sync/src/internal/netconfig"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/throttle"
 	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/afenav/execute-sync/src/internal/warehouses/audit"
+	"github.com/afenav/execute-sync/src/internal/warehouses/readonly"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v2"
 )
@@ -64,8 +74,12 @@ func checkLatestVersion() {
 
 func main() {
 
+	// The valid DATABASE_TYPEs are whichever adapters are linked in
+	config.Enums["DATABASE_TYPE"] = warehouses.SupportedTypes()
+
 	app := &cli.App{
-		Usage: "Blast Execute data into a data warehouse",
+		Usage:       "Blast Execute data into a data warehouse",
+		Description: databaseTypesHelp(),
 		Action: func(cCtx *cli.Context) error {
 			return cli.ShowAppHelp(cCtx)
 		},
@@ -84,54 +98,95 @@ func main() {
 			default:
 			}
 
-			var logger *log.Logger
-			var logFile *os.File
+			cCtx.App.Metadata = map[string]interface{}{}
+			var out io.Writer = os.Stderr
 			if cfg.LogFile != "" {
-				var err error
-				logFile, err = os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				logFile, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "Failed to open log file %s: %v\n", cfg.LogFile, err)
-					logger = log.NewWithOptions(os.Stderr, log.Options{
-						ReportCaller:    logCaller,
-						ReportTimestamp: true,
-						Level:           logLevel,
-					})
 				} else {
-					multi := io.MultiWriter(os.Stderr, logFile)
-					logger = log.NewWithOptions(multi, log.Options{
-						ReportCaller:    logCaller,
-						ReportTimestamp: true,
-						Level:           logLevel,
-					})
+					out = io.MultiWriter(out, logFile)
 					// Store logFile in context for After hook
-					cCtx.App.Metadata = map[string]interface{}{"logFile": logFile}
+					cCtx.App.Metadata["logFile"] = logFile
+				}
+			}
+			if cfg.LogSink != "none" {
+				sink, err := logsink.Open(cfg.LogSink)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to open %s log: %v\n", cfg.LogSink, err)
+				} else {
+					out = io.MultiWriter(out, sink)
+					cCtx.App.Metadata["logSink"] = sink
 				}
-			} else {
-				logger = log.NewWithOptions(os.Stderr, log.Options{
-					ReportCaller:    logCaller,
-					ReportTimestamp: true,
-					Level:           logLevel,
-				})
 			}
+			logger := log.NewWithOptions(out, log.Options{
+				ReportCaller:    logCaller,
+				ReportTimestamp: true,
+				Level:           logLevel,
+			})
 
 			log.SetDefault(logger)
+			if err := netconfig.Configure(cfg.CABundle, cfg.ProxyURL); err != nil {
+				return failure.Wrap(failure.Config, err)
+			}
 			checkLatestVersion()
+
+			throttle.Limit = int64(cfg.UploadLimit) * 1024
+			documents.EmptyAsNull = cfg.EmptyAsNull
+			readonly.Enabled = cfg.ReadOnly
+			readonly.NoBootstrap = cfg.NoBootstrap
+			if cfg.InactiveViews && cfg.HideInactiveFields {
+				log.Warn("HIDE_INACTIVE_FIELDS is set, so there are no inactive fields for INACTIVE_FIELD_VIEWS to move")
+			}
+			if err := audit.Start(cfg.AuditLog); err != nil {
+				return failure.Wrap(failure.Config, err)
+			}
+
+			// Small batches may be held in memory, but only up to a quarter
+			// of the memory budget
+			memory.SetMax(int64(cfg.MaxMemory) * 1024 * 1024)
+			spool.MemoryLimit = memory.Budget(0.25, int64(cfg.SpoolMemory)*1024*1024)
+			spool.VerifyUploads = cfg.VerifyUploads
+
+			// Remove our temp files if we're interrupted, and sweep up any
+			// left behind by a previous crash
+			spool.HandleSignals()
+			if cfg.SpoolMaxAge > 0 {
+				if removed := spool.Sweep(time.Duration(cfg.SpoolMaxAge) * time.Hour); removed > 0 {
+					log.Info("Removed stale spool files", "files", removed)
+				}
+			}
 			return nil
 		},
 		After: func(cCtx *cli.Context) error {
+			audit.Stop()
 			if lf, ok := cCtx.App.Metadata["logFile"]; ok {
 				if logFile, ok := lf.(*os.File); ok {
 					logFile.Close()
 				}
 			}
+			if sink, ok := cCtx.App.Metadata["logSink"].(*logsink.Sink); ok {
+				sink.Close()
+			}
 			return nil
 		},
 		Commands: []*cli.Command{
 			ConfigCommand(),
 			SyncCommand(),
 			PushCommand(),
+			LoadCommand(),
 			CreateViewsCommand(),
+			ExportSQLCommand(),
+			LineageCommand(),
+			BIModelCommand(),
 			PruneCommand(),
+			CleanStageCommand(),
+			ReconcileCommand(),
+			ReportCommand(),
+			CompareCommand(),
+			OrphansCommand(),
+			PreflightCommand(),
+			SelftestCommand(),
 			CloneCommand(),
 			GenCommand(),
 			UpgradeCommand(),
@@ -148,19 +203,107 @@ func main() {
 		},
 	}
 
+	// Failures are logged with their code, and exit with the code's exit
+	// status (see failure.Kind)
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		kind := failure.KindOf(err)
+		log.Error(err, "code", kind)
+		os.Exit(kind.ExitCode())
+	}
+
+}
+
+// databaseTypesHelp describes each supported DATABASE_TYPE for --help.
+func databaseTypesHelp() string {
+	var b strings.Builder
+	b.WriteString("Supported DATABASE_TYPEs:\n")
+	for _, adapter := range warehouses.ListSupported() {
+		fmt.Fprintf(&b, "\n   %-22s %s", strings.Join(adapter.Names, ", "), adapter.Description)
+		if len(adapter.Requires) > 0 {
+			fmt.Fprintf(&b, " (requires %s)", strings.Join(adapter.Requires, ", "))
+		}
 	}
+	return b.String()
+}
+
+// needsExecute lists the warehouse commands which also fetch from Execute.
+var needsExecute = map[string]bool{
+	"sync":         true,
+	"push":         true,
+	"clone":        true,
+	"create_views": true,
+	"export-sql":   true,
+	"orphans":      true,
+}
 
+// deployCommands create or maintain the warehouse's objects, so connect with
+// DATABASE_DEPLOY_DSN when it's set.
+var deployCommands = map[string]bool{
+	"create_views": true,
+	"prune":        true,
+	"clean-stage":  true,
+	"selftest":     true,
+	// Only generates the DDL, but with the deploy DSN's names
+	"bootstrap-sql": true,
 }
 
 // Helper function to resolve configuration and initialize the database
 func withDatabase(cCtx *cli.Context, action func(db warehouses.Database, cfg config.Config) error) error {
-	cfg := config.ResolveConfig(cCtx)
-	db, err := warehouses.NewDatabase(cfg)
+	needs := []string{config.NeedsDatabase}
+	if needsExecute[cCtx.Command.Name] {
+		needs = append(needs, config.NeedsExecute)
+	}
+	cfg := config.ResolveConfig(cCtx, needs...)
+	audit.Command = cCtx.Command.Name
+	if cfg.DatabaseDeployDSN != "" {
+		if deployCommands[cCtx.Command.Name] {
+			cfg.DatabaseDSN = cfg.DatabaseDeployDSN
+		} else {
+			readonly.LoadOnly = true
+		}
+	}
+	db, err := openDatabase(cfg, cCtx.Command.Name)
 	if err != nil {
-		log.Errorf("Failed to initialize database: %v", err)
+		log.Error("Failed to initialize database", "error", err, "code", failure.KindOf(err))
 		return err
 	}
 	return action(db, cfg)
 }
+
+// openDeployDatabase connects with DATABASE_DEPLOY_DSN, for a sync which
+// needs to prune or rebuild views.  Without one it's db itself.
+func openDeployDatabase(cfg config.Config, db warehouses.Database, command string) (warehouses.Database, error) {
+	if cfg.DatabaseTargets != "" {
+		// Each of the targets may have a deploy DSN of its own
+		return openDatabase(cfg, command)
+	}
+	if cfg.DatabaseDeployDSN == "" {
+		return db, nil
+	}
+	cfg.DatabaseDSN = cfg.DatabaseDeployDSN
+	return openDatabase(cfg, command)
+}
+
+// openDatabase connects to the warehouse, tagging its statements (where it
+// supports it) so their cost can be attributed to the command.  Syncs tag
+// each run separately.  With DATABASE_TARGETS, the commands which load or
+// maintain the warehouse get every target (see fanOut), each tagged with its
+// label.
+func openDatabase(cfg config.Config, command string, tags ...string) (warehouses.Database, error) {
+	if cfg.DatabaseTargets != "" && fanOutCommands[command] {
+		return openFanOut(cfg, command, tags...)
+	}
+	db, err := warehouses.NewDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tagger, ok := db.(warehouses.QueryTagger); ok {
+		tags = append([]string{"command", command, "run", newRunID()}, tags...)
+		for i := 0; i+1 < len(tags); i += 2 {
+			if err := tagger.SetQueryTag(tags[i], tags[i+1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
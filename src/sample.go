@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/charmbracelet/log"
+)
+
+const sampleFile = "sample.json"
+
+// sampler loads a subset of each document type into a development
+// warehouse: those whose ID hashes under SAMPLE_RATE, and/or the first SAMPLE
+// documents of each type.  Either way the same documents are picked run after
+// run, so later changes to them keep being loaded.  The documents picked by
+// SAMPLE are kept in STATE_DIR, as is the sample's size, so a warehouse
+// holding a sample can be told from one holding everything.
+type sampler struct {
+	Limit int                 `json:"limit,omitempty"`
+	Rate  float64             `json:"rate,omitempty"`
+	IDs   map[string][]string `json:"ids,omitempty"` // picked by Limit, by type
+
+	stateDir string
+	picked   map[string]map[string]bool
+	kept     int
+	skipped  int
+}
+
+// newSampler returns the sampler for SAMPLE and SAMPLE_RATE, or nil when
+// every document is to be loaded.  A full refresh starts a new sample.
+func newSampler(cfg config.Config, fromScratch bool) (*sampler, error) {
+	rate := 0.0
+	if cfg.SampleRate != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(cfg.SampleRate, 64); err != nil || rate <= 0 || rate > 1 {
+			return nil, failure.Errorf(failure.Config, "SAMPLE_RATE %q isn't a fraction between 0 and 1 (i.e. 0.01)", cfg.SampleRate)
+		}
+	}
+	if cfg.Sample < 0 {
+		return nil, failure.Errorf(failure.Config, "SAMPLE can't be negative")
+	}
+	path := filepath.Join(cfg.StateDir, sampleFile)
+
+	previous := &sampler{}
+	data, err := os.ReadFile(path)
+	sampled := err == nil
+	if sampled && !fromScratch {
+		if err := json.Unmarshal(data, previous); err != nil {
+			return nil, failure.Errorf(failure.State, "reading %s: %v", sampleFile, err)
+		}
+	}
+
+	if cfg.Sample == 0 && rate == 0 {
+		if sampled && fromScratch {
+			os.Remove(path)
+		} else if sampled {
+			log.Warn("The warehouse holds a sample of the documents, push --force to load the rest")
+		}
+		return nil, nil
+	}
+	if !fromScratch && (!sampled || previous.Limit != cfg.Sample || previous.Rate != rate) {
+		log.Warn("The sample has changed, push --force to reload the warehouse with the new one", "sample", cfg.Sample, "rate", rate)
+	}
+
+	s := &sampler{Limit: cfg.Sample, Rate: rate, IDs: previous.IDs, stateDir: cfg.StateDir, picked: map[string]map[string]bool{}}
+	if s.IDs == nil {
+		s.IDs = map[string][]string{}
+	}
+	for docType, ids := range s.IDs {
+		s.picked[docType] = map[string]bool{}
+		for _, id := range ids {
+			s.picked[docType][id] = true
+		}
+	}
+	return s, nil
+}
+
+// keep reports whether a document is in the sample.
+func (s *sampler) keep(record map[string]interface{}) bool {
+	docType, _ := record["$TYPE"].(string)
+	id, _ := record["DOCUMENT_ID"].(string)
+	if s.Rate > 0 && !underRate(id, s.Rate) {
+		s.skipped++
+		return false
+	}
+	if s.Limit > 0 {
+		picked := s.picked[docType]
+		if picked == nil {
+			picked = map[string]bool{}
+			s.picked[docType] = picked
+		}
+		if !picked[id] {
+			if len(picked) >= s.Limit {
+				s.skipped++
+				return false
+			}
+			picked[id] = true
+			s.IDs[docType] = append(s.IDs[docType], id)
+		}
+	}
+	s.kept++
+	return true
+}
+
+// underRate reports whether a document ID hashes into the first rate of IDs.
+// FNV's high bits hardly change between IDs differing only in their last
+// characters (i.e. sequential ones), so the hash is mixed before comparing.
+func underRate(id string, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return float64(x) < rate*math.MaxUint64
+}
+
+// save records the sample, once the documents picked have been loaded.
+func (s *sampler) save() {
+	data, _ := json.Marshal(s)
+	path := filepath.Join(s.stateDir, sampleFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		log.Warnf("Error saving sample: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Warnf("Error saving sample: %v", err)
+	}
+}
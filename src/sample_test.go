@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/failure"
+)
+
+func doc(docType string, id string) map[string]interface{} {
+	return map[string]interface{}{"$TYPE": docType, "DOCUMENT_ID": id}
+}
+
+func TestSamplerKeepsLimitPerType(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), Sample: 2}
+	s, err := newSampler(cfg, true)
+	if err != nil || s == nil {
+		t.Fatalf("expected a sampler, got %v, %v", s, err)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if keep := s.keep(doc("AFE", id)); keep != (id != "3") {
+			t.Errorf("keep(AFE %s) = %v", id, keep)
+		}
+	}
+	// Another version of a picked document is kept, as are other types'
+	if !s.keep(doc("AFE", "1")) || !s.keep(doc("WELL", "3")) {
+		t.Fatal("expected picked documents and other types to be kept")
+	}
+	if s.kept != 4 || s.skipped != 1 {
+		t.Fatalf("expected 4 kept and 1 skipped, got %d and %d", s.kept, s.skipped)
+	}
+}
+
+func TestSamplerStateSurvivesReload(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), Sample: 2}
+	s, err := newSampler(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keep(doc("AFE", "1"))
+	s.keep(doc("AFE", "2"))
+	s.save()
+
+	// The next run keeps the same documents, not the first it's handed
+	s, err = newSampler(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.keep(doc("AFE", "3")) {
+		t.Fatal("expected a new document to be skipped once the sample is full")
+	}
+	if !s.keep(doc("AFE", "2")) || !s.keep(doc("AFE", "1")) {
+		t.Fatal("expected the documents picked by the last run to be kept")
+	}
+
+	// A full refresh starts a new sample
+	s, err = newSampler(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.keep(doc("AFE", "3")) {
+		t.Fatal("expected a full refresh to start a new sample")
+	}
+
+	// As does turning sampling off, which forgets the sample
+	cfg.Sample = 0
+	if s, err := newSampler(cfg, true); s != nil || err != nil {
+		t.Fatalf("expected no sampler, got %v, %v", s, err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, sampleFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the sample to be forgotten, got %v", err)
+	}
+}
+
+func TestSamplerRateIsStable(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), SampleRate: "0.25"}
+	s, err := newSampler(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	picked := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("doc-%d", i)
+		picked[id] = s.keep(doc("AFE", id))
+	}
+	if s.kept < 200 || s.kept > 300 {
+		t.Fatalf("expected about a quarter of the documents, got %d", s.kept)
+	}
+
+	// The same documents are picked run after run
+	s, err = newSampler(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, keep := range picked {
+		if s.keep(doc("AFE", id)) != keep {
+			t.Fatalf("document %s was sampled differently on the second run", id)
+		}
+	}
+}
+
+func TestSamplerRejectsInvalidSettings(t *testing.T) {
+	for _, cfg := range []config.Config{{SampleRate: "0"}, {SampleRate: "1.5"}, {SampleRate: "a tenth"}, {Sample: -1}} {
+		cfg.StateDir = t.TempDir()
+		if _, err := newSampler(cfg, false); failure.KindOf(err) != failure.Config {
+			t.Errorf("expected SAMPLE=%d SAMPLE_RATE=%q to be rejected, got %v", cfg.Sample, cfg.SampleRate, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/afenav/execute-sync/src/internal/documents"
+	"github.com/afenav/execute-sync/src/internal/spool"
+	"github.com/afenav/execute-sync/src/internal/warehouses"
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// upload loads one part of a batch, closing it with a control record on
+// warehouses which can be reconciled.  It returns the number of documents
+// loaded, not counting the control record.  The warehouse chunks and
+// marshals the documents on workers goroutines (0 for one per CPU) while the
+// next are read.
+func upload(db warehouses.Database, batch_date string, runID string, part int, chunkSize int, workers int, nextRecord func() (map[string]interface{}, error)) (int, error) {
+	var control *documents.Control
+	if _, ok := db.(warehouses.Reconciler); ok {
+		control = documents.NewControl(batch_date, runID, part, chunkSize, nextRecord)
+		nextRecord = control.Next
+	}
+
+	// Upload all documents in this batch.  Note that we're passing in a
+	// stream read through a callback so that we're not assembling all these
+	// documents in memory since this can easily become very large.
+	cnt, err := db.Upload(batch_date, documents.NewStream(nextRecord, workers))
+	if err != nil {
+		return 0, err
+	}
+	if control != nil && control.Sent() {
+		cnt--
+	}
+	return cnt, nil
+}
+
+// typeStream is one document type's share of a batch, spooled until it's
+// uploaded.
+type typeStream struct {
+	docType string
+	file    *spool.File
+	part    int
+}
+
+// uploadByType splits a batch by document type and uploads each type as a
+// separate part, up to streams at a time, so that warehouse loaders work on
+// several files at once.  The records are first spooled to a file per type;
+// the largest types are then uploaded first.  part is advanced past the parts
+// used.
+func uploadByType(db warehouses.Database, batch_date string, runID string, part *int, chunkSize int, workers int, streams int, nextRecord func() (map[string]interface{}, error)) (int, error) {
+	files := map[string]*typeStream{}
+	defer func() {
+		for _, s := range files {
+			s.file.Close()
+		}
+	}()
+
+	// Documents are only spooled here; each part is chunked and marshaled
+	// for the warehouse once it's read back
+	for {
+		data, err := nextRecord()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+		if data == nil {
+			continue
+		}
+		docType, _ := data["$TYPE"].(string)
+		s, ok := files[docType]
+		if !ok {
+			file, err := spool.New("documents_*.ndjson")
+			if err != nil {
+				return 0, fmt.Errorf("creating spool file: %w", err)
+			}
+			s = &typeStream{docType: docType, file: file}
+			files[docType] = s
+		}
+		line, err := json.Marshal(data)
+		if err != nil {
+			return 0, fmt.Errorf("spooling document %s: %v", data["DOCUMENT_ID"], err)
+		}
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			return 0, fmt.Errorf("spooling document %s: %v", data["DOCUMENT_ID"], err)
+		}
+	}
+
+	var ordered []*typeStream
+	for _, s := range files {
+		ordered = append(ordered, s)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].file.Size() != ordered[j].file.Size() {
+			return ordered[i].file.Size() > ordered[j].file.Size()
+		}
+		return ordered[i].docType < ordered[j].docType
+	})
+	for _, s := range ordered {
+		*part++
+		s.part = *part
+	}
+
+	// Each part counts its own documents, so the total is only added up once
+	// they've all finished
+	counts := make([]int, len(ordered))
+	var g errgroup.Group
+	g.SetLimit(streams)
+	for i, s := range ordered {
+		g.Go(func() error {
+			log.Debug("Uploading document type", "type", s.docType, "part", s.part, "bytes", s.file.Size())
+			cnt, err := uploadStream(db, batch_date, runID, chunkSize, workers, s)
+			if err != nil {
+				return fmt.Errorf("uploading %s: %v", s.docType, err)
+			}
+			counts[i] = cnt
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, cnt := range counts {
+		total += cnt
+	}
+	return total, nil
+}
+
+// uploadStream uploads one spooled document type.  A spool which can't be
+// read back in full fails the upload, rather than quietly loading part of
+// the type.
+func uploadStream(db warehouses.Database, batch_date string, runID string, chunkSize int, workers int, s *typeStream) (int, error) {
+	r, err := s.file.Reader()
+	if err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	return upload(db, batch_date, runID, s.part, chunkSize, workers, func() (map[string]interface{}, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		lineNumber++
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("reading spooled %s documents: %w", s.docType, err)
+		}
+		var record map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("reading spooled %s document %d: %w", s.docType, lineNumber, err)
+		}
+		if err := documents.ParseVersion(record); err != nil {
+			return nil, fmt.Errorf("reading spooled %s document %d: %w", s.docType, lineNumber, err)
+		}
+		return record, nil
+	})
+}
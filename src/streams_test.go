@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/afenav/execute-sync/src/internal/spool"
+)
+
+// spooled returns a document type's stream spooled with the given lines.
+func spooled(t *testing.T, lines string) *typeStream {
+	t.Helper()
+	file, err := spool.New("documents_*.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	if _, err := file.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+	return &typeStream{docType: "AFE", file: file}
+}
+
+func spooledDocument(id string, version string) string {
+	return `{"$TYPE": "AFE", "DOCUMENT_ID": "` + id + `", "$VERSION": ` + version + `, "$DATE": "2024-01-01T00:00:00Z", "$DELETED": false}`
+}
+
+func TestUploadStreamReadsSpool(t *testing.T) {
+	db := &recordingTarget{}
+	s := spooled(t, spooledDocument("1", "1")+"\n"+spooledDocument("2", `"9007199254740993"`)+"\n")
+	if n, err := uploadStream(db, "2024-01-01T00:00:00Z", "test", 0, 1, s); err != nil || n != 2 {
+		t.Fatalf("upload: %d, %v", n, err)
+	}
+	if !reflect.DeepEqual(db.batches, []string{"1,2"}) {
+		t.Fatalf("expected both documents loaded, got %v", db.batches)
+	}
+}
+
+func TestUploadStreamFailsOnCorruptSpool(t *testing.T) {
+	for name, lines := range map[string]string{
+		"corrupt line":     spooledDocument("1", "1") + "\n{\"$TYPE\": \"AFE\", \"DOC\n" + spooledDocument("3", "1") + "\n",
+		"truncated":        spooledDocument("1", "1") + "\n" + spooledDocument("2", "1")[:20],
+		"invalid $VERSION": spooledDocument("1", "1") + "\n" + spooledDocument("2", `"two"`) + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := &recordingTarget{}
+			_, err := uploadStream(db, "2024-01-01T00:00:00Z", "test", 0, 1, spooled(t, lines))
+			if err == nil || !strings.Contains(err.Error(), "spooled AFE document 2") {
+				t.Fatalf("expected the second document to fail the upload, got %v", err)
+			}
+			if len(db.batches) > 0 {
+				t.Fatalf("expected nothing loaded, got %v", db.batches)
+			}
+		})
+	}
+}
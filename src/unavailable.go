@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/config"
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/notify"
+	"github.com/charmbracelet/log"
+)
+
+const outageFile = "outage.json"
+
+// outage tracks Execute being down for maintenance, so that planned patching
+// is waited out quietly rather than reported as a run of failed syncs.  Syncs
+// back off for longer each time, up to UNAVAILABLE_MAX_WAIT, and people are
+// only notified once the outage has lasted UNAVAILABLE_NOTIFY_AFTER (and then
+// again when it's over).  It's kept in STATE_DIR, so that restarts and
+// scheduled pushes carry on timing the same outage.
+type outage struct {
+	Since    time.Time `json:"since"`
+	Attempts int       `json:"attempts"`
+	Notified bool      `json:"notified"`
+
+	stateDir    string
+	notifyAfter time.Duration
+	maxWait     time.Duration
+	cfg         config.Config
+}
+
+// loadOutage returns the outage in progress, if any, to carry on timing.
+func loadOutage(cfg config.Config) (*outage, error) {
+	notifyAfter, err := time.ParseDuration(cfg.UnavailableNotifyAfter)
+	if err != nil {
+		return nil, fmt.Errorf("UNAVAILABLE_NOTIFY_AFTER %q isn't a duration (i.e. 2h)", cfg.UnavailableNotifyAfter)
+	}
+	o := &outage{
+		stateDir:    cfg.StateDir,
+		notifyAfter: notifyAfter,
+		maxWait:     time.Duration(cfg.UnavailableMaxWait) * time.Second,
+		cfg:         cfg,
+	}
+	if data, err := os.ReadFile(filepath.Join(cfg.StateDir, outageFile)); err == nil {
+		if err := json.Unmarshal(data, o); err != nil {
+			log.Warnf("Ignoring unreadable outage: %v", err)
+		}
+	}
+	return o, nil
+}
+
+// record notes how a sync went.  A sync which found Execute unavailable
+// starts, or continues, the outage, notifying it once it has gone on long
+// enough; anything else ends it.
+func (o *outage) record(err error) {
+	now := time.Now().UTC()
+	if _, unavailable := execute.Unavailable(err); !unavailable {
+		if o.Since.IsZero() {
+			return
+		}
+		log.Info("Execute is available again", "after", now.Sub(o.Since).Round(time.Second))
+		if o.Notified {
+			o.notify(fmt.Sprintf("Execute is available again after %s", now.Sub(o.Since).Round(time.Minute)), true, now)
+		}
+		o.Since, o.Attempts, o.Notified = time.Time{}, 0, false
+		if err := os.Remove(filepath.Join(o.stateDir, outageFile)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Error removing outage: %v", err)
+		}
+		return
+	}
+
+	if o.Since.IsZero() {
+		o.Since = now
+	}
+	o.Attempts++
+	if !o.Notified && now.Sub(o.Since) >= o.notifyAfter {
+		o.notify(fmt.Sprintf("Execute has been unavailable for %s", now.Sub(o.Since).Round(time.Minute)), false, now)
+		o.Notified = true
+	}
+	o.save()
+}
+
+// wait returns how long to wait before the next sync: WAIT, doubled for
+// every sync since the outage began, up to UNAVAILABLE_MAX_WAIT, or however
+// long Execute asked for if that's longer (though still no more than
+// UNAVAILABLE_MAX_WAIT).
+func (o *outage) wait(err error, wait time.Duration) time.Duration {
+	retryAfter, unavailable := execute.Unavailable(err)
+	if !unavailable || wait >= o.maxWait {
+		return wait
+	}
+	for i := 1; i < o.Attempts && wait < o.maxWait; i++ {
+		wait *= 2
+	}
+	return min(max(wait, retryAfter), o.maxWait)
+}
+
+// notify sends the outage, or its end, through DIGEST_URL and/or SMTP, when
+// they're configured.
+func (o *outage) notify(what string, recovered bool, now time.Time) {
+	notifier, err := newNotifier(o.cfg)
+	if notifier == nil || err != nil {
+		return
+	}
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("execute-sync on %s: %s", host, what)
+	text := fmt.Sprintf("%s\nUnavailable since %s, %d syncs attempted.", subject, o.Since.Format(time.RFC3339), o.Attempts)
+	data := map[string]interface{}{"since": o.Since, "attempts": o.Attempts, "duration_ns": now.Sub(o.Since), "recovered": recovered}
+	if err := notifier.Notify(notify.Message{Kind: notify.Unavailable, Subject: subject, Text: text, Data: data}); err != nil {
+		log.Warnf("Error sending outage notification: %v", err)
+	}
+}
+
+func (o *outage) save() {
+	data, _ := json.MarshalIndent(o, "", "  ")
+	if err := os.WriteFile(filepath.Join(o.stateDir, outageFile), data, 0644); err != nil {
+		log.Warnf("Error saving outage: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/afenav/execute-sync/src/internal/execute"
+	"github.com/afenav/execute-sync/src/internal/failure"
+	"github.com/afenav/execute-sync/src/internal/timing"
+	"github.com/charmbracelet/log"
+)
+
+// runsDir is the directory under STATE_DIR holding each sync run's workspace.
+const runsDir = "runs"
+
+const manifestFile = "manifest.json"
+
+// Statuses of a run recorded in its manifest.
+const (
+	runRunning     = "running"
+	runFailed      = "failed"
+	runInterrupted = "interrupted" // found still running by a later run
+	runUnavailable = "unavailable" // Execute was down for maintenance
+)
+
+// runManifest describes a sync run, so that a workspace left behind can be
+// matched up with the logs and the warehouse.
+type runManifest struct {
+	RunID     string           `json:"run_id"`
+	Version   string           `json:"version"`
+	PID       int              `json:"pid"`
+	Source    string           `json:"source,omitempty"`
+	Status    string           `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	ErrorCode failure.Kind     `json:"error_code,omitempty"`
+	BatchDate string           `json:"batch_date,omitempty"`
+	Types     map[string]int   `json:"types,omitempty"` // documents fetched of each type
+	Timings   timing.Breakdown `json:"timings"`
+	Started   time.Time        `json:"started"`
+	Finished  time.Time        `json:"finished,omitzero"`
+}
+
+// workspace is the directory a sync run keeps its working files in: its
+// checkpoint, the spool files of the batch being loaded and its manifest.
+// It's removed when the run succeeds; failed runs keep theirs for debugging,
+// up to KEEP_FAILED_RUNS of them.
+type workspace struct {
+	dir      string
+	manifest runManifest
+}
+
+// openWorkspace creates the workspace for a run.
+func openWorkspace(stateDir string, runID string, source string) (*workspace, error) {
+	dir := filepath.Join(stateDir, runsDir, runID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, failure.Errorf(failure.State, "creating run directory: %v", err)
+	}
+	ws := &workspace{dir: dir, manifest: runManifest{
+		RunID:   runID,
+		Version: version,
+		PID:     os.Getpid(),
+		Source:  source,
+		Status:  runRunning,
+		Types:   map[string]int{},
+		Started: time.Now().UTC(),
+	}}
+	ws.save()
+	return ws, nil
+}
+
+func (ws *workspace) save() {
+	writeManifest(ws.dir, ws.manifest)
+}
+
+// finish removes the workspace of a successful run, or records why the run
+// failed (or found Execute unavailable) and leaves its workspace for
+// debugging.
+func (ws *workspace) finish(err error) {
+	if err == nil {
+		if err := os.RemoveAll(ws.dir); err != nil {
+			log.Warnf("Error removing run directory: %v", err)
+		}
+		return
+	}
+	ws.manifest.Status = runFailed
+	if _, unavailable := execute.Unavailable(err); unavailable {
+		ws.manifest.Status = runUnavailable
+	}
+	ws.manifest.Error = err.Error()
+	ws.manifest.ErrorCode = failure.KindOf(err)
+	ws.manifest.Finished = time.Now().UTC()
+	ws.save()
+	if ws.manifest.Status == runFailed {
+		log.Info("Keeping the failed run's files", "dir", ws.dir)
+	}
+}
+
+func writeManifest(dir string, m runManifest) {
+	data, _ := json.MarshalIndent(m, "", "  ")
+	path := filepath.Join(dir, manifestFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		log.Warnf("Error saving run manifest: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Warnf("Error saving run manifest: %v", err)
+	}
+}
+
+func readManifest(dir string) (runManifest, bool) {
+	var m runManifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return m, false
+	}
+	return m, json.Unmarshal(data, &m) == nil
+}
+
+// markInterrupted records that a run still marked as running in its manifest
+// has been recovered from by a later one, so it can be pruned like a failed
+// run.
+func markInterrupted(dir string) {
+	m, ok := readManifest(dir)
+	if !ok || m.Status != runRunning {
+		return
+	}
+	m.Status = runInterrupted
+	writeManifest(dir, m)
+}
+
+// pruneWorkspaces removes all but the newest keep workspaces left by failed
+// or interrupted runs.  Workspaces still marked as running may belong to
+// another process, so they're left alone.
+func pruneWorkspaces(stateDir string, keep int) {
+	dirs, err := filepath.Glob(filepath.Join(stateDir, runsDir, "*"))
+	if err != nil {
+		return
+	}
+	type run struct {
+		dir     string
+		started time.Time
+	}
+	var finished []run
+	for _, dir := range dirs {
+		if m, ok := readManifest(dir); ok && m.Status != runRunning {
+			finished = append(finished, run{dir, m.Started})
+		}
+	}
+	if len(finished) <= keep {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].started.After(finished[j].started)
+	})
+	for _, r := range finished[max(keep, 0):] {
+		if err := os.RemoveAll(r.dir); err != nil {
+			log.Warn("Unable to remove old run directory", "dir", r.dir, "error", err)
+			continue
+		}
+		log.Debug("Removed old run directory", "dir", r.dir)
+	}
+}